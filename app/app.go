@@ -35,6 +35,10 @@ type Application struct {
 	// tsdb      tstorage.Storage
 	cwmpTable *CwmpEventTable
 	transDB   *bolt.DB
+	cluster   *cluster
+
+	informPool *informPool
+	cpeWriter  *cpeWriter
 }
 
 func GApp() *Application {
@@ -98,15 +102,33 @@ func (a *Application) Init(cfg *config.AppConfig) {
 		panic("not support database type")
 	}
 	common.Must(err)
+	// the cluster sets the node of the generated IDs before any record is created
+	a.startCluster()
 	go a.checkSuper()
 	go a.checkSettings()
+	go a.checkVirtualParams()
 	// init default node
 	a.checkDefaultPNode()
 	a.cwmpTable = NewCwmpEventTable()
+	a.startCpeWriter()
 	a.initJob()
 	a.RenderTranslateFiles()
 }
 
+// migrateStatements Indexes and columns AutoMigrate can't express, run after it
+var migrateStatements = []string{
+	// an ODP port holds one drop cable, 0 assigns the ODP without a port
+	"create unique index if not exists idx_net_cpe_odp_port on net_cpe (odp_id, odp_port) where odp_port > 0",
+	// the CPE coordinates as numbers for the map bounding boxes, null when not a number
+	`alter table net_cpe add column if not exists geo_lat double precision generated always as
+		(case when latitude ~ '^\s*[-+]?([0-9]+\.?[0-9]*|\.[0-9]+)\s*$' then latitude::double precision end) stored`,
+	`alter table net_cpe add column if not exists geo_lng double precision generated always as
+		(case when longitude ~ '^\s*[-+]?([0-9]+\.?[0-9]*|\.[0-9]+)\s*$' then longitude::double precision end) stored`,
+	"create index if not exists idx_net_cpe_geo on net_cpe (geo_lat, geo_lng)",
+	// the ONU serial numbers are matched upper case on the serial_number index
+	"update olt_onu_data set serial_number = upper(serial_number) where serial_number <> upper(serial_number)",
+}
+
 func (a *Application) MigrateDB(track bool) (err error) {
 	defer func() {
 		if err1 := recover(); err1 != nil {
@@ -125,6 +147,11 @@ func (a *Application) MigrateDB(track bool) (err error) {
 	} else {
 		_ = a.gormDB.Migrator().AutoMigrate(models.Tables...)
 	}
+	for _, stmt := range migrateStatements {
+		if err = a.gormDB.Exec(stmt).Error; err != nil {
+			log.Errorf("migrate %s error, %s", stmt, err.Error())
+		}
+	}
 	return nil
 }
 
@@ -211,8 +238,24 @@ func (a *Application) checkAppVersion() {
 	}
 }
 
+// Release Stop the jobs, leave the cluster and close the storages
 func Release() {
-	app.sched.Stop()
-	_ = app.transDB.Close()
+	app.stopInformPool(time.Second * 30)
+	app.stopCpeWriter()
+	if app.sched != nil {
+		// wait for the running jobs
+		select {
+		case <-app.sched.Stop().Done():
+		case <-time.After(time.Second * 30):
+			log.Error("cron jobs still running, stop anyway")
+		}
+	}
+	app.stopCluster()
+	if app.transDB != nil {
+		_ = app.transDB.Close()
+	}
+	if sqlDB, err := app.gormDB.DB(); err == nil {
+		_ = sqlDB.Close()
+	}
 	zaplog.Release()
 }
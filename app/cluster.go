@@ -0,0 +1,446 @@
+package app
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/ca17/teamsacs/common"
+	"github.com/ca17/teamsacs/common/zaplog/log"
+	"github.com/ca17/teamsacs/events"
+	"github.com/jackc/pgx/v5/stdlib"
+	"github.com/spf13/cast"
+)
+
+// Several ACS instances share one Postgres database: RPC queues are tables woken up
+// with LISTEN/NOTIFY, supervise events are forwarded through NOTIFY and the
+// instance holding the leader advisory lock runs the cluster wide jobs.
+
+const (
+	clusterQueueChannel = "teamsacs_cwmp_queue"
+	clusterEventChannel = "teamsacs_events"
+	// clusterLeaderLock Advisory lock key of the leader instance
+	clusterLeaderLock int64 = 0x7465616d73616373
+	// clusterEventMaxPayload NOTIFY payloads are limited to 8000 bytes
+	clusterEventMaxPayload = 7900
+	// clusterNodeLock Advisory lock class of the ID node numbers, the second key is the node
+	clusterNodeLock int32 = 0x74616373
+	// clusterMaxNodeId Snowflake node IDs are 10 bits
+	clusterMaxNodeId = 1023
+	// clusterEventQueue Supervise events waiting to be forwarded
+	clusterEventQueue = 1000
+)
+
+type cluster struct {
+	node       string
+	leader     atomic.Bool
+	leaderLock sync.Mutex
+	leaderConn *sql.Conn
+	waitLock   sync.Mutex
+	waiters    map[string][]chan struct{}
+	cancel     context.CancelFunc
+	nodeId     int64
+	nodeConn   *sql.Conn
+	events     chan string
+}
+
+type clusterEvent struct {
+	Node  string        `json:"node"`
+	Topic string        `json:"topic"`
+	Args  []interface{} `json:"args"`
+}
+
+// startCluster Join the cluster, the first leader election is done before returning
+func (a *Application) startCluster() {
+	hostname, _ := os.Hostname()
+	ctx, cancel := context.WithCancel(context.Background())
+	a.cluster = &cluster{
+		node:    fmt.Sprintf("%s-%d-%s", hostname, os.Getpid(), common.UUID()[:8]),
+		waiters: make(map[string][]chan struct{}),
+		cancel:  cancel,
+		events:  make(chan string, clusterEventQueue),
+	}
+	a.claimNodeId(ctx)
+	events.Forwarder = a.forwardEvent
+	a.electLeader(ctx)
+	go a.leaderLoop(ctx)
+	go a.listenLoop(ctx)
+	go a.eventLoop(ctx)
+	log.Infof("Cluster node %s started, leader=%v", a.cluster.node, a.IsLeader())
+}
+
+// stopCluster Leave the cluster, the leader lock is released for the other instances
+func (a *Application) stopCluster() {
+	if a.cluster == nil {
+		return
+	}
+	a.cluster.cancel()
+	a.cluster.leaderLock.Lock()
+	defer a.cluster.leaderLock.Unlock()
+	if a.cluster.nodeConn != nil {
+		_, _ = a.cluster.nodeConn.ExecContext(context.Background(), "select pg_advisory_unlock($1, $2)",
+			clusterNodeLock, a.cluster.nodeId)
+		_ = a.cluster.nodeConn.Close()
+		a.cluster.nodeConn = nil
+	}
+	if a.cluster.leaderConn != nil {
+		_, _ = a.cluster.leaderConn.ExecContext(context.Background(), "select pg_advisory_unlock($1)", clusterLeaderLock)
+		_ = a.cluster.leaderConn.Close()
+		a.cluster.leaderConn = nil
+	}
+	a.cluster.leader.Store(false)
+}
+
+// NodeName Name of this ACS instance in the cluster
+func (a *Application) NodeName() string {
+	if a.cluster == nil {
+		return ""
+	}
+	return a.cluster.node
+}
+
+// IsLeader This instance runs the cluster wide jobs
+func (a *Application) IsLeader() bool {
+	return a.cluster != nil && a.cluster.leader.Load()
+}
+
+// leaderJob Job function that only runs on the leader instance
+func (a *Application) leaderJob(job func()) func() {
+	return func() {
+		if a.IsLeader() {
+			job()
+		}
+	}
+}
+
+// AddLeaderJob Schedule a job running on the leader instance only
+func (a *Application) AddLeaderJob(spec string, job func()) error {
+	_, err := a.sched.AddFunc(spec, a.leaderJob(job))
+	return err
+}
+
+func (a *Application) leaderLoop(ctx context.Context) {
+	ticker := time.NewTicker(10 * time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			a.checkNodeId(ctx)
+			a.electLeader(ctx)
+		}
+	}
+}
+
+// claimNodeId Set the node of the generated int64 IDs: the configured one, or the first
+// free one held with an advisory lock so that two running instances never share it
+func (a *Application) claimNodeId(ctx context.Context) {
+	c := a.cluster
+	if id := a.appConfig.System.NodeId; id > 0 {
+		c.nodeId = int64(id)
+		common.Must(common.SetSnowflakeNode(c.nodeId))
+		log.Infof("cluster node %s uses the configured ID node %d", c.node, id)
+		return
+	}
+	sqlDB, err := a.gormDB.DB()
+	if err != nil {
+		return
+	}
+	conn, err := sqlDB.Conn(ctx)
+	if err != nil {
+		log.Errorf("cluster node ID connection error %s", err.Error())
+		return
+	}
+	// node 0 is left to the instances before their first claim
+	for id := int64(1); id <= clusterMaxNodeId; id++ {
+		var locked bool
+		err = conn.QueryRowContext(ctx, "select pg_try_advisory_lock($1, $2)", clusterNodeLock, id).Scan(&locked)
+		if err != nil {
+			log.Errorf("cluster node ID claim error %s", err.Error())
+			_ = conn.Close()
+			return
+		}
+		if locked {
+			c.nodeId = id
+			c.nodeConn = conn
+			common.Must(common.SetSnowflakeNode(id))
+			log.Infof("cluster node %s claimed the ID node %d", c.node, id)
+			return
+		}
+	}
+	_ = conn.Close()
+	log.Errorf("cluster node %s found no free ID node", c.node)
+}
+
+// checkNodeId Claim the ID node again when the connection holding its lock is lost
+func (a *Application) checkNodeId(ctx context.Context) {
+	c := a.cluster
+	if a.appConfig.System.NodeId > 0 {
+		return
+	}
+	c.leaderLock.Lock()
+	defer c.leaderLock.Unlock()
+	if c.nodeConn != nil {
+		if err := c.nodeConn.PingContext(ctx); err == nil {
+			return
+		}
+		log.Errorf("cluster node %s lost the lock of the ID node %d", c.node, c.nodeId)
+		_ = c.nodeConn.Close()
+		c.nodeConn = nil
+	}
+	if ctx.Err() == nil {
+		a.claimNodeId(ctx)
+	}
+}
+
+// electLeader Try to take the leader lock, or check the connection holding it is alive.
+// The lock belongs to the database session, it is released when the connection is lost.
+func (a *Application) electLeader(ctx context.Context) {
+	c := a.cluster
+	c.leaderLock.Lock()
+	defer c.leaderLock.Unlock()
+	if ctx.Err() != nil {
+		return
+	}
+	if c.leaderConn == nil {
+		sqlDB, err := a.gormDB.DB()
+		if err != nil {
+			return
+		}
+		if c.leaderConn, err = sqlDB.Conn(ctx); err != nil {
+			log.Errorf("cluster leader connection error %s", err.Error())
+			return
+		}
+	}
+	if c.leader.Load() {
+		if err := c.leaderConn.PingContext(ctx); err != nil {
+			log.Errorf("cluster node %s lost leadership: %s", c.node, err.Error())
+			c.leader.Store(false)
+			_ = c.leaderConn.Close()
+			c.leaderConn = nil
+		}
+		return
+	}
+	var locked bool
+	err := c.leaderConn.QueryRowContext(ctx, "select pg_try_advisory_lock($1)", clusterLeaderLock).Scan(&locked)
+	if err != nil {
+		log.Errorf("cluster leader election error %s", err.Error())
+		_ = c.leaderConn.Close()
+		c.leaderConn = nil
+		return
+	}
+	if locked {
+		c.leader.Store(true)
+		log.Infof("cluster node %s is the leader", c.node)
+	}
+}
+
+// listenLoop Receive the cluster notifications on a dedicated connection
+func (a *Application) listenLoop(ctx context.Context) {
+	sqlDB, err := a.gormDB.DB()
+	if err != nil {
+		return
+	}
+	for ctx.Err() == nil {
+		err = a.listen(ctx, sqlDB)
+		if ctx.Err() != nil {
+			return
+		}
+		log.Errorf("cluster listen error %v, reconnecting", err)
+		// notifications may have been missed, let the waiters check their queue
+		a.wakeAll()
+		time.Sleep(3 * time.Second)
+	}
+}
+
+func (a *Application) listen(ctx context.Context, sqlDB *sql.DB) error {
+	conn, err := sqlDB.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	return conn.Raw(func(driverConn interface{}) error {
+		pgconn := driverConn.(*stdlib.Conn).Conn()
+		for _, channel := range []string{clusterQueueChannel, clusterEventChannel} {
+			if _, err := pgconn.Exec(ctx, "listen "+channel); err != nil {
+				return err
+			}
+		}
+		// UNLISTEN before the connection goes back to the pool
+		defer pgconn.Exec(context.Background(), "unlisten *")
+		for {
+			n, err := pgconn.WaitForNotification(ctx)
+			if err != nil {
+				return err
+			}
+			switch n.Channel {
+			case clusterQueueChannel:
+				a.wakeQueue(n.Payload)
+			case clusterEventChannel:
+				a.receiveEvent(n.Payload)
+			}
+		}
+	})
+}
+
+func (a *Application) notify(channel, payload string) {
+	if err := a.gormDB.Exec("select pg_notify(?, ?)", channel, payload).Error; err != nil {
+		log.Errorf("cluster notify %s error %s", channel, err.Error())
+	}
+}
+
+// waitQueue Wait for a new RPC queued for the device by any instance
+func (a *Application) waitQueue(sn string, timeout time.Duration) {
+	if timeout <= 0 {
+		return
+	}
+	ch := make(chan struct{}, 1)
+	c := a.cluster
+	c.waitLock.Lock()
+	c.waiters[sn] = append(c.waiters[sn], ch)
+	c.waitLock.Unlock()
+	defer func() {
+		c.waitLock.Lock()
+		list := c.waiters[sn]
+		for i, w := range list {
+			if w == ch {
+				list = append(list[:i], list[i+1:]...)
+				break
+			}
+		}
+		if len(list) == 0 {
+			delete(c.waiters, sn)
+		} else {
+			c.waiters[sn] = list
+		}
+		c.waitLock.Unlock()
+	}()
+	select {
+	case <-ch:
+	case <-time.After(timeout):
+	}
+}
+
+func (a *Application) wakeQueue(sn string) {
+	c := a.cluster
+	c.waitLock.Lock()
+	defer c.waitLock.Unlock()
+	for _, ch := range c.waiters[sn] {
+		select {
+		case ch <- struct{}{}:
+		default:
+		}
+	}
+}
+
+func (a *Application) wakeAll() {
+	c := a.cluster
+	c.waitLock.Lock()
+	var sns []string
+	for sn := range c.waiters {
+		sns = append(sns, sn)
+	}
+	c.waitLock.Unlock()
+	for _, sn := range sns {
+		a.wakeQueue(sn)
+	}
+}
+
+// forwardEvent Send a locally published supervise event to the other instances
+func (a *Application) forwardEvent(topic string, args ...interface{}) {
+	// int64 IDs are sent as strings, JSON numbers lose their precision
+	var values = make([]interface{}, len(args))
+	for i, arg := range args {
+		switch v := arg.(type) {
+		case int64:
+			values[i] = strconv.FormatInt(v, 10)
+		default:
+			values[i] = v
+		}
+	}
+	payload, err := encodeClusterEvent(clusterEvent{Node: a.cluster.node, Topic: topic, Args: values}, clusterEventMaxPayload)
+	if err != nil {
+		log.Errorf("cluster %s event not forwarded, %s", topic, err.Error())
+		return
+	}
+	// sent by one worker so that the other instances receive the events in order
+	select {
+	case a.cluster.events <- payload:
+	default:
+		log.Errorf("cluster event queue full, %s event dropped", topic)
+	}
+}
+
+// encodeClusterEvent JSON of the event within limit bytes, the longest string
+// argument is shortened until the encoded event fits
+func encodeClusterEvent(ev clusterEvent, limit int) (string, error) {
+	for {
+		var buf bytes.Buffer
+		enc := json.NewEncoder(&buf)
+		enc.SetEscapeHTML(false)
+		if err := enc.Encode(ev); err != nil {
+			return "", err
+		}
+		if buf.Len() <= limit {
+			return buf.String(), nil
+		}
+		longest, longestLen := -1, 0
+		for i, arg := range ev.Args {
+			if s, ok := arg.(string); ok && len(s) > longestLen {
+				longest, longestLen = i, len(s)
+			}
+		}
+		if longest < 0 || longestLen <= 16 {
+			return "", fmt.Errorf("event of %d bytes over the %d bytes limit", buf.Len(), limit)
+		}
+		// escaping makes the encoded excess larger than the bytes to remove,
+		// at least half of the string goes
+		s := strings.TrimSuffix(ev.Args[longest].(string), "...")
+		keep := min(len(s)-(buf.Len()-limit), len(s)/2)
+		ev.Args[longest] = strings.ToValidUTF8(s[:max(keep, 0)], "") + "..."
+	}
+}
+
+// eventLoop Forward the supervise events in publication order
+func (a *Application) eventLoop(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case payload := <-a.cluster.events:
+			a.notify(clusterEventChannel, payload)
+		}
+	}
+}
+
+// receiveEvent Publish locally a supervise event of another instance
+func (a *Application) receiveEvent(payload string) {
+	var ev clusterEvent
+	if err := json.Unmarshal([]byte(payload), &ev); err != nil || ev.Node == a.cluster.node {
+		return
+	}
+	args := ev.Args
+	switch ev.Topic {
+	case events.EventSuperviseLog:
+		if len(args) == 4 {
+			events.PublishRemote(ev.Topic, cast.ToInt64(args[0]), cast.ToString(args[1]), cast.ToString(args[2]), cast.ToString(args[3]))
+		}
+	case events.EventSuperviseStatus:
+		if len(args) == 3 {
+			events.PublishRemote(ev.Topic, cast.ToInt64(args[0]), cast.ToString(args[1]), cast.ToString(args[2]))
+		}
+	case events.EventCwmpSuperviseStatus:
+		if len(args) == 4 {
+			events.PublishRemote(ev.Topic, cast.ToString(args[0]), cast.ToString(args[1]), cast.ToString(args[2]), cast.ToString(args[3]))
+		}
+	}
+}
@@ -0,0 +1,45 @@
+package app
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestEncodeClusterEvent(t *testing.T) {
+	tests := []struct {
+		name    string
+		args    []interface{}
+		wantCut bool
+		wantErr bool
+	}{
+		{"small", []interface{}{"123", "info", "done"}, false, false},
+		{"long message", []interface{}{"123", "info", strings.Repeat("x", 20000)}, true, false},
+		{"escaped message", []interface{}{"123", "info", strings.Repeat("\n\x01", 3000)}, true, false},
+		{"multibyte message", []interface{}{"123", "info", strings.Repeat("设备", 3000)}, true, false},
+		{"no strings", []interface{}{make([]int, 5000)}, false, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			payload, err := encodeClusterEvent(clusterEvent{Node: "node1", Topic: "topic", Args: tt.args}, clusterEventMaxPayload)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("encodeClusterEvent() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if len(payload) > clusterEventMaxPayload || !utf8.ValidString(payload) {
+				t.Fatalf("encodeClusterEvent() payload of %d bytes", len(payload))
+			}
+			var ev clusterEvent
+			if err = json.Unmarshal([]byte(payload), &ev); err != nil {
+				t.Fatal(err)
+			}
+			msg := ev.Args[len(ev.Args)-1].(string)
+			if strings.HasSuffix(msg, "...") != tt.wantCut {
+				t.Errorf("encodeClusterEvent() message cut = %v, want %v", !tt.wantCut, tt.wantCut)
+			}
+		})
+	}
+}
@@ -0,0 +1,260 @@
+package app
+
+import (
+	"fmt"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/ca17/teamsacs/common"
+	"github.com/ca17/teamsacs/common/sftpc"
+	"github.com/ca17/teamsacs/common/sshc"
+	"github.com/ca17/teamsacs/models"
+	"gorm.io/gorm/clause"
+)
+
+// Console run status
+const (
+	ConsoleRunRunning = "running"
+	ConsoleRunSuccess = "success"
+	ConsoleRunFailure = "failure"
+)
+
+const (
+	consoleDefaultTimeout = 30
+	consoleMaxOutput      = 256 * 1024
+)
+
+// SshAccess Stored SSH access of the device, empty when never saved
+func (a *Application) SshAccess(sn string) models.NetCpeSsh {
+	var item models.NetCpeSsh
+	a.gormDB.Where("sn = ?", sn).Find(&item)
+	return item
+}
+
+// SaveSshAccess Save the SSH access of a device, an empty password, private key or
+// key passphrase keeps the stored one
+func (a *Application) SaveSshAccess(sn, address string, port int, username, password, privateKey, keyPassphrase string) error {
+	columns := []string{"address", "port", "username", "updated_at"}
+	item := models.NetCpeSsh{
+		ID:        common.UUIDint64(),
+		Sn:        sn,
+		Address:   address,
+		Port:      port,
+		Username:  username,
+		CreatedAt: time.Now(),
+		UpdatedAt: time.Now(),
+	}
+	if password != "" {
+		item.Password = a.encryptConnReqPassword(password)
+		columns = append(columns, "password")
+	}
+	if privateKey != "" {
+		item.PrivateKey = a.encryptConnReqPassword(privateKey)
+		columns = append(columns, "private_key")
+	}
+	if keyPassphrase != "" {
+		item.KeyPassphrase = a.encryptConnReqPassword(keyPassphrase)
+		columns = append(columns, "key_passphrase")
+	}
+	return a.gormDB.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "sn"}},
+		DoUpdates: clause.AssignmentColumns(columns),
+	}).Create(&item).Error
+}
+
+// sshAccess SSH client of the device with the decrypted access, not connected
+func (a *Application) sshAccess(dev models.NetCpe) (*sshc.Client, error) {
+	item := a.SshAccess(dev.Sn)
+	if item.Username == "" {
+		return nil, fmt.Errorf("device %s has no SSH access", dev.Sn)
+	}
+	host, err := deviceHost(dev, item.Address)
+	if err != nil {
+		return nil, err
+	}
+	return &sshc.Client{
+		User:            item.Username,
+		Password:        a.decryptConnReqPassword(item.Password),
+		PrivateKey:      a.decryptConnReqPassword(item.PrivateKey),
+		Host:            host,
+		Port:            common.If(item.Port > 0, item.Port, 22).(int),
+		HostKeyCallback: sshc.PinHostKey(item.HostKey, a.pinSshHostKey(dev.Sn)),
+		// the accesses saved before the separate passphrase used the password
+		KeyPassphrase: a.decryptConnReqPassword(common.IfEmptyStr(item.KeyPassphrase, item.Password)),
+	}, nil
+}
+
+// pinSshHostKey Keep the key of the first connection, a connection racing with
+// another one must present the key the other one pinned
+func (a *Application) pinSshHostKey(sn string) func(key string) error {
+	return func(key string) error {
+		res := a.gormDB.Model(&models.NetCpeSsh{}).Where("sn = ? and host_key = ''", sn).Update("host_key", key)
+		if res.Error != nil {
+			return res.Error
+		}
+		if res.RowsAffected == 0 && a.SshAccess(sn).HostKey != key {
+			return fmt.Errorf("host key of %s changed while pinning", sn)
+		}
+		return nil
+	}
+}
+
+// ResetSshHostKey Forget the pinned host key, the next connection pins the new key
+func (a *Application) ResetSshHostKey(sn string) error {
+	return a.gormDB.Model(&models.NetCpeSsh{}).Where("sn = ?", sn).Update("host_key", "").Error
+}
+
+// DialSsh Connect to the device over SSH, the result is kept as the SSH status
+func (a *Application) DialSsh(dev models.NetCpe) (*sshc.Client, error) {
+	client, err := a.sshAccess(dev)
+	if err != nil {
+		return nil, err
+	}
+	err = client.Connect()
+	a.updateSshStatus(dev.Sn, err)
+	if err != nil {
+		return nil, fmt.Errorf("SSH %s:%d: %s", client.Host, client.Port, err.Error())
+	}
+	return client, nil
+}
+
+// SftpClient SFTP client of the device
+func (a *Application) SftpClient(dev models.NetCpe) (*sftpc.Client, error) {
+	client, err := a.sshAccess(dev)
+	if err != nil {
+		return nil, err
+	}
+	return &sftpc.Client{User: client.User, Password: client.Password, PrivateKey: client.PrivateKey,
+		Host: client.Host, Port: client.Port, HostKeyCallback: client.HostKeyCallback, KeyPassphrase: client.KeyPassphrase}, nil
+}
+
+func (a *Application) updateSshStatus(sn string, err error) {
+	values := map[string]interface{}{
+		"last_status": ConnReqSuccess,
+		"last_error":  "",
+		"last_time":   time.Now(),
+	}
+	if err != nil {
+		values["last_status"] = ConnReqFailure
+		values["last_error"] = err.Error()
+	}
+	a.gormDB.Model(&models.NetCpeSsh{}).Where("sn = ?", sn).Updates(values)
+}
+
+// RenderDeviceTemplate Command set or script with the device variables, the
+// replacer masks the expanded secrets in what is kept of the run
+func (a *Application) RenderDeviceTemplate(sn, src string) (string, *strings.Replacer, error) {
+	if _, err := template.New("device_template").Parse(src); err != nil {
+		return "", nil, err
+	}
+	content, secrets := a.injectCwmpConfigVars(sn, src, nil)
+	var oldnew []string
+	for _, v := range secrets {
+		oldnew = append(oldnew, v, "******")
+	}
+	return content, strings.NewReplacer(oldnew...), nil
+}
+
+// RunDeviceCommands Run the commands of the content on the device, one per line,
+// the output of all commands is kept in a ConsoleRun
+func (a *Application) RunDeviceCommands(dev models.NetCpe, content string, timeout int, jobId int64, session, operator string) (models.ConsoleRun, error) {
+	run := models.ConsoleRun{
+		ID:        common.UUIDint64(),
+		JobId:     jobId,
+		Session:   session,
+		Sn:        dev.Sn,
+		Status:    ConsoleRunRunning,
+		Operator:  operator,
+		StartTime: time.Now(),
+	}
+	// the template is kept, the rendered commands hold the device secrets
+	run.Commands = content
+	commands, mask, terr := a.RenderDeviceTemplate(dev.Sn, content)
+	if err := a.gormDB.Create(&run).Error; err != nil {
+		return run, err
+	}
+	if terr != nil {
+		return run, a.finishConsoleRun(&run, "", terr)
+	}
+	if timeout <= 0 {
+		timeout = consoleDefaultTimeout
+	}
+
+	client, err := a.DialSsh(dev)
+	if err != nil {
+		return run, a.finishConsoleRun(&run, "", err)
+	}
+	defer client.Close()
+
+	var output strings.Builder
+	var failed error
+	for _, cmd := range strings.Split(commands, "\n") {
+		cmd = strings.TrimSpace(cmd)
+		if cmd == "" || strings.HasPrefix(cmd, "#") {
+			continue
+		}
+		output.WriteString("> " + cmd + "\n")
+		out, err := execSshCommand(client, cmd, time.Duration(timeout)*time.Second)
+		output.WriteString(out)
+		if !strings.HasSuffix(out, "\n") {
+			output.WriteString("\n")
+		}
+		if err != nil {
+			output.WriteString("! " + err.Error() + "\n")
+			if failed == nil {
+				failed = fmt.Errorf("%s: %s", mask.Replace(cmd), mask.Replace(err.Error()))
+			}
+		}
+		if output.Len() > consoleMaxOutput {
+			break
+		}
+	}
+	return run, a.finishConsoleRun(&run, mask.Replace(output.String()), failed)
+}
+
+// execSshCommand Run a command in its own session, the session is closed on timeout
+func execSshCommand(client *sshc.Client, cmd string, timeout time.Duration) (string, error) {
+	session, err := client.SSHClient().NewSession()
+	if err != nil {
+		return "", err
+	}
+	defer session.Close()
+	type result struct {
+		out string
+		err error
+	}
+	done := make(chan result, 1)
+	go func() {
+		out, err := client.ExecCommand(session, cmd)
+		done <- result{out, err}
+	}()
+	select {
+	case r := <-done:
+		return r.out, r.err
+	case <-time.After(timeout):
+		session.Close()
+		return "", fmt.Errorf("timeout after %s", timeout)
+	}
+}
+
+func (a *Application) finishConsoleRun(run *models.ConsoleRun, output string, err error) error {
+	if len(output) > consoleMaxOutput {
+		output = output[:consoleMaxOutput] + "\n... output truncated"
+	}
+	// postgres text columns take valid UTF-8 without NUL only
+	run.Output = strings.ReplaceAll(strings.ToValidUTF8(output, ""), "\x00", "")
+	run.Status = ConsoleRunSuccess
+	run.EndTime = time.Now()
+	if err != nil {
+		run.Status = ConsoleRunFailure
+		run.LastError = err.Error()
+	}
+	a.gormDB.Model(&models.ConsoleRun{}).Where("id = ?", run.ID).Updates(map[string]interface{}{
+		"output":     run.Output,
+		"status":     run.Status,
+		"last_error": run.LastError,
+		"end_time":   run.EndTime,
+	})
+	return err
+}
@@ -5,6 +5,8 @@ const (
 	ConfigSystemTheme         = "SystemTheme"
 	ConfigSystemLoginRemark   = "SystemLoginRemark"
 	ConfigSystemLoginSubtitle = "SystemLoginSubtitle"
+	ConfigSystemMetricsToken  = "MetricsToken"
+	ConfigSystemMetricsShard  = "MetricsShardSize"
 
 	ConfigTR069AccessAddress           = "TR069AccessAddress"
 	ConfigTR069AccessPassword          = "TR069AccessPassword"
@@ -14,6 +16,18 @@ const (
 	ConfigOntWebAdminPassword          = "OntWebAdminPassword"
 	ConfigOntWebUserUsername           = "OntWebUserUsername"
 	ConfigOntWebUserPassword           = "OntWebUserPassword"
+	ConfigCpeParamHistoryDays          = "CpeParamHistoryDays"
+	ConfigCpeDiscoveryRpcBudget        = "CpeDiscoveryRpcBudget"
+	ConfigCpeStunEnable                = "CpeStunEnable"
+	ConfigCpeXmppConnection            = "CpeXmppConnection"
+	ConfigMikrotikApiUsername          = "MikrotikApiUsername"
+	ConfigMikrotikApiPassword          = "MikrotikApiPassword"
+	ConfigOpticalOltTxPower            = "OpticalOltTxPower"
+	ConfigOpticalFiberLoss             = "OpticalFiberLoss"
+	ConfigOpticalConnectorLoss         = "OpticalConnectorLoss"
+	ConfigOpticalRxTolerance           = "OpticalRxTolerance"
+
+	ConfigRadiusSessionDays = "RadiusSessionDays"
 )
 
 // Device type constants
@@ -30,6 +44,8 @@ var ConfigConstants = []string{
 	ConfigSystemTheme,
 	ConfigSystemLoginRemark,
 	ConfigSystemLoginSubtitle,
+	ConfigSystemMetricsToken,
+	ConfigSystemMetricsShard,
 	ConfigTR069AccessAddress,
 	ConfigTR069AccessPassword,
 	ConfigCpeConnectionRequestPassword,
@@ -38,4 +54,15 @@ var ConfigConstants = []string{
 	ConfigOntWebAdminPassword,
 	ConfigOntWebUserUsername,
 	ConfigOntWebUserPassword,
+	ConfigCpeParamHistoryDays,
+	ConfigCpeDiscoveryRpcBudget,
+	ConfigCpeStunEnable,
+	ConfigCpeXmppConnection,
+	ConfigMikrotikApiUsername,
+	ConfigMikrotikApiPassword,
+	ConfigOpticalOltTxPower,
+	ConfigOpticalFiberLoss,
+	ConfigOpticalConnectorLoss,
+	ConfigOpticalRxTolerance,
+	ConfigRadiusSessionDays,
 }
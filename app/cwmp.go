@@ -4,22 +4,25 @@ import (
 	"bytes"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"os"
 	"path"
 	"sort"
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"text/template"
 	"time"
 
 	"github.com/ca17/teamsacs/assets"
 	"github.com/ca17/teamsacs/common"
 	"github.com/ca17/teamsacs/common/cwmp"
+	"github.com/ca17/teamsacs/common/datamodel"
 	"github.com/ca17/teamsacs/common/timeutil"
-	"github.com/ca17/teamsacs/common/web"
 	"github.com/ca17/teamsacs/common/zaplog/log"
 	"github.com/ca17/teamsacs/models"
+	"gorm.io/gorm"
 )
 
 type CwmpEventTable struct {
@@ -31,15 +34,21 @@ type CwmpCpe struct {
 	Sn              string `json:"sn"`
 	OUI             string `json:"oui"`
 	taskTags        []string
-	SoftwareVersion string `json:"software_version"`
-	Manufacturer    string `json:"manufacturer"`
-	ProductClass    string `json:"product_class"`
-	cwmpQueueMap    chan models.CwmpEventData
-	cwmpHPQueueMap  chan models.CwmpEventData
+	SoftwareVersion string       `json:"software_version"`
+	Manufacturer    string       `json:"manufacturer"`
+	ProductClass    string       `json:"product_class"`
 	LastInform      *cwmp.Inform `json:"latest_message"`
 	LastUpdate      time.Time    `json:"last_update"`
 	LastDataNotify  time.Time    `json:"last_data_notify"`
 	IsRegister      bool         `json:"is_register"`
+	loadedAt        time.Time
+	dataModel       string
+	dataModelLock   sync.Mutex
+	session         string // session cookie of LastInform
+	sessionLock     sync.Mutex
+
+	// provisionCheck Unix time of the last subscriber provisioning attempt
+	provisionCheck atomic.Int64
 }
 
 func NewCwmpEventTable() *CwmpEventTable {
@@ -50,19 +59,17 @@ func NewCwmpEventTable() *CwmpEventTable {
 	return et
 }
 
+// Size Number of online CPEs, in all ACS instances
 func (c *CwmpEventTable) Size() int {
-	c.cpeLock.Lock()
-	defer c.cpeLock.Unlock()
-	return len(c.cpeTable)
+	var count int64
+	app.gormDB.Model(&models.NetCpe{}).Where("cwmp_status = 'online'").Count(&count)
+	return int(count)
 }
 
+// ListSn Online CPEs, in all ACS instances
 func (c *CwmpEventTable) ListSn() []string {
-	c.cpeLock.Lock()
-	defer c.cpeLock.Unlock()
 	var snlist = make([]string, 0)
-	for s, _ := range c.cpeTable {
-		snlist = append(snlist, s)
-	}
+	app.gormDB.Model(&models.NetCpe{}).Where("cwmp_status = 'online'").Pluck("sn", &snlist)
 	return snlist
 }
 
@@ -74,22 +81,37 @@ func (c *CwmpEventTable) GetCwmpCpe(key string) *CwmpCpe {
 	defer c.cpeLock.Unlock()
 	cpe, ok := c.cpeTable[key]
 	if !ok {
-		var count int64 = 0
-		app.gormDB.Model(models.NetCpe{}).Where("sn=?", key).Count(&count)
 		cpe = &CwmpCpe{
 			Sn:             key,
 			LastUpdate:     timeutil.EmptyTime,
 			LastDataNotify: timeutil.EmptyTime,
-			cwmpQueueMap:   make(chan models.CwmpEventData, 512),
-			cwmpHPQueueMap: make(chan models.CwmpEventData, 1),
 			LastInform:     nil,
-			IsRegister:     count > 0,
 		}
+		cpe.loadState()
 		c.cpeTable[key] = cpe
+	} else if time.Since(cpe.LastUpdate) > time.Minute && time.Since(cpe.loadedAt) > time.Minute {
+		// the device informs another instance, the cached state may be outdated
+		cpe.loadState()
 	}
 	return cpe
 }
 
+// loadState Load the CPE state saved by the ACS instances
+func (c *CwmpCpe) loadState() {
+	var dev models.NetCpe
+	err := app.gormDB.Select("sn", "oui", "product_class", "software_version", "manufacturer").
+		Where("sn = ?", c.Sn).First(&dev).Error
+	c.IsRegister = err == nil
+	if err == nil {
+		c.OUI = dev.Oui
+		c.ProductClass = dev.ProductClass
+		c.SoftwareVersion = dev.SoftwareVersion
+		c.Manufacturer = dev.Manufacturer
+	}
+	c.taskTags = nil
+	c.loadedAt = time.Now()
+}
+
 func (c *CwmpEventTable) ClearCwmpCpe(key string) {
 	c.cpeLock.Lock()
 	defer c.cpeLock.Unlock()
@@ -107,7 +129,9 @@ func (c *CwmpEventTable) UpdateCwmpCpe(key string, msg *cwmp.Inform) {
 }
 
 func (c *CwmpCpe) UpdateStatus(msg *cwmp.Inform) {
+	c.sessionLock.Lock()
 	c.LastInform = msg
+	c.sessionLock.Unlock()
 	c.LastUpdate = time.Now()
 	if msg.ProductClass != "" {
 		c.ProductClass = msg.ProductClass
@@ -121,6 +145,9 @@ func (c *CwmpCpe) UpdateStatus(msg *cwmp.Inform) {
 	if msg.GetSoftwareVersion() != "" {
 		c.SoftwareVersion = msg.GetSoftwareVersion()
 	}
+	if model := datamodel.DetectModel(msg.Params); model != "" {
+		c.setDataModel(model)
+	}
 }
 
 func (c *CwmpCpe) NotifyDataUpdate(force bool) {
@@ -141,14 +168,6 @@ func (c *CwmpCpe) NotifyDataUpdate(force bool) {
 	}
 }
 
-func (c *CwmpCpe) getQueue(hp bool) chan models.CwmpEventData {
-	var que = c.cwmpQueueMap
-	if hp {
-		que = c.cwmpHPQueueMap
-	}
-	return que
-}
-
 func (c *CwmpCpe) TaskTags() (tags []string) {
 	if c.taskTags != nil {
 		return c.taskTags
@@ -173,30 +192,105 @@ func setMapValue(vmap map[string]interface{}, name string, value interface{}) {
 	}
 }
 
-// RecvCwmpEventData 接收一个 Cwmp 事件
+// RecvCwmpEventData 接收一个 Cwmp 事件, the RPCs are queued in the database by any ACS instance
 func (c *CwmpCpe) RecvCwmpEventData(timeoutMsec int, hp bool) (data *models.CwmpEventData, err error) {
-	select {
-	case _data := <-c.getQueue(hp):
-		return &_data, nil
-	case <-time.After(time.Millisecond * time.Duration(timeoutMsec)):
-		return nil, errors.New("read cwmp event channel timeout")
+	deadline := time.Now().Add(time.Millisecond * time.Duration(timeoutMsec))
+	for {
+		var items []models.CwmpQueueItem
+		err = app.gormDB.Raw(`delete from cwmp_queue_item where id = (
+			select id from cwmp_queue_item where sn = ? and hp = ? order by id limit 1 for update skip locked
+		) returning *`, c.Sn, hp).Scan(&items).Error
+		if err != nil {
+			return nil, err
+		}
+		if len(items) > 0 {
+			return c.decodeQueueItem(items[0])
+		}
+		remaining := time.Until(deadline)
+		if remaining <= 0 {
+			return nil, errors.New("read cwmp event channel timeout")
+		}
+		app.waitQueue(c.Sn, remaining)
 	}
 }
 
+func (c *CwmpCpe) decodeQueueItem(item models.CwmpQueueItem) (*models.CwmpEventData, error) {
+	if item.Encrypted {
+		item.Content = app.decryptConnReqPassword(item.Content)
+	}
+	var msg cwmp.Message = &cwmp.RawMessage{ID: item.MessageId, Name: item.Name, Content: item.Content}
+	if item.Name == "SetParameterValues" {
+		// parsed to track the values set on the device
+		_msg, err := cwmp.ParseXML([]byte(item.Content))
+		if err != nil {
+			return nil, err
+		}
+		if spv, ok := _msg.(*cwmp.SetParameterValues); ok {
+			c.TrackSetParameterValues(spv, ParamSourceSPV)
+			msg = spv
+		}
+	}
+	return &models.CwmpEventData{Session: item.Session, Sn: item.Sn, Message: msg}, nil
+}
+
 // GetCwmpPresetEventData 获取一个 Cwmp 预设任务执行
 func (c *CwmpCpe) GetCwmpPresetEventData() (data *models.CwmpEventData, err error) {
 
 	return nil, err
 }
 
-// SendCwmpEventData 发送一个 Cwmp 事件，
+// SendCwmpEventData 发送一个 Cwmp 事件, queued in the database for the instance serving the CPE session
 func (c *CwmpCpe) SendCwmpEventData(data models.CwmpEventData, timeoutMsec int, hp bool) error {
-	select {
-	case c.getQueue(hp) <- data:
-		return nil
-	case <-time.After(time.Millisecond * time.Duration(timeoutMsec)):
-		return errors.New("cwmp event channel full, write timeout")
+	var capacity int64 = 512
+	if hp {
+		capacity = 1
+	}
+	item := &models.CwmpQueueItem{
+		ID:        common.UUIDint64(),
+		Sn:        c.Sn,
+		Hp:        hp,
+		Session:   data.Session,
+		Name:      data.Message.GetName(),
+		MessageId: data.Message.GetID(),
+		Content:   string(data.Message.CreateXML()),
+		CreatedAt: time.Now(),
+	}
+	// the passwords set on the device are not kept in clear in the queue
+	if spv, ok := data.Message.(*cwmp.SetParameterValues); ok && hasSecretParam(spv.Params) {
+		item.Content = app.encryptConnReqPassword(item.Content)
+		item.Encrypted = true
+	}
+	deadline := time.Now().Add(time.Millisecond * time.Duration(timeoutMsec))
+	for {
+		var full bool
+		// the producers of the device queue are serialised so that the
+		// capacity check and the insert are atomic across the instances
+		err := app.gormDB.Transaction(func(tx *gorm.DB) error {
+			if err := tx.Exec("select pg_advisory_xact_lock(hashtext(?))", "cwmp_queue:"+c.Sn).Error; err != nil {
+				return err
+			}
+			var count int64
+			if err := tx.Model(&models.CwmpQueueItem{}).Where("sn = ? and hp = ?", c.Sn, hp).Count(&count).Error; err != nil {
+				return err
+			}
+			if full = count >= capacity; full {
+				return nil
+			}
+			return tx.Create(item).Error
+		})
+		if err != nil {
+			return err
+		}
+		if !full {
+			break
+		}
+		if time.Now().After(deadline) {
+			return errors.New("cwmp event channel full, write timeout")
+		}
+		time.Sleep(time.Millisecond * 500)
 	}
+	app.notify(clusterQueueChannel, c.Sn)
+	return nil
 }
 
 // CheckRegister 检查设备注册情况
@@ -260,25 +354,18 @@ func (c *CwmpCpe) CheckRegister(ip string, msg *cwmp.Inform) {
 }
 
 func (c *CwmpCpe) UpdateManagementAuthInfo(session string, timeout int, hp bool) error {
-	// Detect data model from LastInform parameters
-	prefix := "Device.ManagementServer."
-	if c.LastInform != nil {
-		for paramName := range c.LastInform.Params {
-			if strings.HasPrefix(paramName, "InternetGatewayDevice.") {
-				prefix = "InternetGatewayDevice.ManagementServer."
-				break
-			}
-		}
-	}
+	// Written against TR-181 names, translated to the device data model
+	prefix := c.TranslatePath("Device.ManagementServer.")
+	username, password := c.nextConnReqCredential(session)
 
 	params := map[string]cwmp.ValueStruct{
 		prefix + "ConnectionRequestUsername": {
 			Type:  "xsd:string",
-			Value: c.Sn,
+			Value: username,
 		},
 		prefix + "ConnectionRequestPassword": {
 			Type:  "xsd:string",
-			Value: app.GetTr069SettingsStringValue("CpeConnectionRequestPassword"),
+			Value: password,
 		},
 		prefix + "PeriodicInformEnable": {
 			Type:  "xsd:boolean",
@@ -310,15 +397,7 @@ func (c *CwmpCpe) UpdateManagementAuthInfo(session string, timeout int, hp bool)
 
 // PushPeriodicInform pushes periodic inform settings to the device
 func (c *CwmpCpe) PushPeriodicInform(session string, timeout int, hp bool) error {
-	prefix := "Device.ManagementServer."
-	if c.LastInform != nil {
-		for paramName := range c.LastInform.Params {
-			if strings.HasPrefix(paramName, "InternetGatewayDevice.") {
-				prefix = "InternetGatewayDevice.ManagementServer."
-				break
-			}
-		}
-	}
+	prefix := c.TranslatePath("Device.ManagementServer.")
 
 	interval := app.GetTr069SettingsStringValue("CpePeriodicInformInterval")
 	if interval == "" {
@@ -349,7 +428,7 @@ func (c *CwmpCpe) PushPeriodicInform(session string, timeout int, hp bool) error
 }
 
 // PushWebCredentials pushes ONT web admin and user credentials to the device
-// Uses vendor-specific TR-069 parameter paths based on device manufacturer
+// Uses the vendor-specific paths of the VirtualParameters.Web* definitions
 func (c *CwmpCpe) PushWebCredentials(session string, timeout int, hp bool) error {
 	adminUser := app.GetTr069SettingsStringValue(ConfigOntWebAdminUsername)
 	adminPass := app.GetTr069SettingsStringValue(ConfigOntWebAdminPassword)
@@ -360,30 +439,26 @@ func (c *CwmpCpe) PushWebCredentials(session string, timeout int, hp bool) error
 		return nil
 	}
 
+	// Vendor-specific paths are resolved through the virtual parameter definitions
+	creds := []struct {
+		name  string
+		value string
+	}{
+		{VirtualParamPrefix + "WebAdminUsername", adminUser},
+		{VirtualParamPrefix + "WebAdminPassword", adminPass},
+		{VirtualParamPrefix + "WebUserUsername", userUser},
+		{VirtualParamPrefix + "WebUserPassword", userPass},
+	}
 	params := make(map[string]cwmp.ValueStruct)
-	m := strings.ToLower(c.Manufacturer)
-
-	// Determine vendor-specific paths — only use paths known to work per vendor
-	switch {
-	case strings.Contains(m, "zte"):
-		// ZTE only supports X_ZTE-COM_UserInterface paths
-		if adminPass != "" {
-			params["InternetGatewayDevice.X_ZTE-COM_UserInterface.X_ZTE-COM_WebUserInfo.AdminPassword"] = cwmp.ValueStruct{Type: "xsd:string", Value: adminPass}
-		}
-		if userUser != "" {
-			params["InternetGatewayDevice.X_ZTE-COM_UserInterface.X_ZTE-COM_WebUserInfo.UserName"] = cwmp.ValueStruct{Type: "xsd:string", Value: userUser}
-		}
-		if userPass != "" {
-			params["InternetGatewayDevice.X_ZTE-COM_UserInterface.X_ZTE-COM_WebUserInfo.UserPassword"] = cwmp.ValueStruct{Type: "xsd:string", Value: userPass}
-		}
-	default:
-		// CDATA/CDTC and other TR-098 devices — use X_CT-COM_TeleComAccount
-		if adminUser != "" {
-			params["InternetGatewayDevice.DeviceInfo.X_CT-COM_TeleComAccount.Username"] = cwmp.ValueStruct{Type: "xsd:string", Value: adminUser}
+	for _, cred := range creds {
+		if cred.value == "" {
+			continue
 		}
-		if adminPass != "" {
-			params["InternetGatewayDevice.DeviceInfo.X_CT-COM_TeleComAccount.Password"] = cwmp.ValueStruct{Type: "xsd:string", Value: adminPass}
+		path, vtype, err := c.ResolveVirtualParamSet(cred.name)
+		if err != nil {
+			continue
 		}
+		params[path] = cwmp.ValueStruct{Type: cwmp.NormalizeType(vtype), Value: cred.value}
 	}
 
 	if len(params) == 0 {
@@ -413,18 +488,10 @@ func (c *CwmpCpe) ProcessParameterNamesResponse(msg *cwmp.GetParameterNamesRespo
 	}
 }
 
-// getInformParam gets a parameter value from Inform, trying TR-181 path first, then TR-098 path
-func getInformParam(msg *cwmp.Inform, tr181Path, tr098Path string) string {
-	v := msg.GetParam(tr181Path)
-	if v == "" && tr098Path != "" {
-		v = msg.GetParam(tr098Path)
-	}
-	return v
-}
-
 // parseWifiSsids extracts WiFi SSIDs, passwords, and enable status from parameters map
+// using the VirtualParameters.Wifi*.{i} definitions
 // Returns JSON string: [{"ssid":"name","password":"pass","enable":"true"}, ...]
-func parseWifiSsids(params map[string]string) string {
+func (c *CwmpCpe) parseWifiSsids(params map[string]string) string {
 	type wifiEntry struct {
 		Idx      int    `json:"idx"`
 		SSID     string `json:"ssid"`
@@ -432,102 +499,20 @@ func parseWifiSsids(params map[string]string) string {
 		Enable   string `json:"enable"`
 		Channel  string `json:"channel,omitempty"`
 	}
-	entries := make(map[int]*wifiEntry)
-
-	// TR-098 prefix
-	const tr098Prefix = "InternetGatewayDevice.LANDevice.1.WLANConfiguration."
-	// TR-181 prefixes
-	const tr181SsidPrefix = "Device.WiFi.SSID."
-	const tr181ApPrefix = "Device.WiFi.AccessPoint."
-
-	for name, value := range params {
-		// TR-098: InternetGatewayDevice.LANDevice.1.WLANConfiguration.N.XXX
-		if strings.HasPrefix(name, tr098Prefix) {
-			rest := name[len(tr098Prefix):] // e.g. "1.SSID" or "1.KeyPassphrase" or "1.Enable"
-			parts := strings.SplitN(rest, ".", 2)
-			if len(parts) != 2 {
-				continue
-			}
-			idx, err := strconv.Atoi(parts[0])
-			if err != nil {
-				continue
-			}
-			field := parts[1]
-			if _, ok := entries[idx]; !ok {
-				entries[idx] = &wifiEntry{}
-			}
-			switch field {
-			case "SSID":
-				entries[idx].SSID = value
-			case "KeyPassphrase":
-				entries[idx].Password = value
-			case "Enable":
-				entries[idx].Enable = value
-			case "PreSharedKey.1.KeyPassphrase":
-				if entries[idx].Password == "" {
-					entries[idx].Password = value
-				}
-			case "Channel":
-				entries[idx].Channel = value
-			case "ChannelsInUse":
-				if entries[idx].Channel == "" || entries[idx].Channel == "0" {
-					entries[idx].Channel = value
-				}
-			}
-		}
-		// TR-181: Device.WiFi.SSID.N.XXX
-		if strings.HasPrefix(name, tr181SsidPrefix) {
-			rest := name[len(tr181SsidPrefix):]
-			parts := strings.SplitN(rest, ".", 2)
-			if len(parts) != 2 {
-				continue
-			}
-			idx, err := strconv.Atoi(parts[0])
-			if err != nil {
-				continue
-			}
-			field := parts[1]
-			if _, ok := entries[idx]; !ok {
-				entries[idx] = &wifiEntry{}
-			}
-			switch field {
-			case "SSID":
-				entries[idx].SSID = value
-			case "Enable":
-				entries[idx].Enable = value
-			}
-		}
-		// TR-181: Device.WiFi.AccessPoint.N.Security.KeyPassphrase
-		if strings.HasPrefix(name, tr181ApPrefix) {
-			rest := name[len(tr181ApPrefix):]
-			parts := strings.SplitN(rest, ".", 2)
-			if len(parts) != 2 {
-				continue
-			}
-			idx, err := strconv.Atoi(parts[0])
-			if err != nil {
-				continue
-			}
-			if parts[1] == "Security.KeyPassphrase" {
-				if _, ok := entries[idx]; !ok {
-					entries[idx] = &wifiEntry{}
-				}
-				entries[idx].Password = value
-			}
-		}
-	}
 
-	if len(entries) == 0 {
-		return ""
-	}
-
-	// Sort by index and build list
 	var result []wifiEntry
-	for i := 1; i <= 16; i++ {
-		if e, ok := entries[i]; ok && e.SSID != "" {
-			e.Idx = i
-			result = append(result, *e)
+	for i := 1; i <= virtualParamMaxIdx; i++ {
+		ssid := c.GetVirtualParamValue(fmt.Sprintf("%sWifiSSID.%d", VirtualParamPrefix, i), params)
+		if ssid == "" {
+			continue
 		}
+		result = append(result, wifiEntry{
+			Idx:      i,
+			SSID:     ssid,
+			Password: c.GetVirtualParamValue(fmt.Sprintf("%sWifiPassword.%d", VirtualParamPrefix, i), params),
+			Enable:   c.GetVirtualParamValue(fmt.Sprintf("%sWifiEnable.%d", VirtualParamPrefix, i), params),
+			Channel:  c.GetVirtualParamValue(fmt.Sprintf("%sWifiChannel.%d", VirtualParamPrefix, i), params),
+		})
 	}
 	if len(result) == 0 {
 		return ""
@@ -882,123 +867,72 @@ func parseHostDevices(params map[string]string) string {
 	return string(data)
 }
 
+// deviceInfoParamFields maps the generic NetCpe fields to the virtual parameters
+// resolving them on TR-098 and TR-181 devices
+var deviceInfoParamFields = []struct {
+	field  string
+	vparam string
+}{
+	{"cwmp_url", VirtualParamPrefix + "ConnectionRequestURL"},
+	{"software_version", VirtualParamPrefix + "SoftwareVersion"},
+	{"hardware_version", VirtualParamPrefix + "HardwareVersion"},
+	{"model", VirtualParamPrefix + "ModelName"},
+	{"uptime", VirtualParamPrefix + "Uptime"},
+	{"cpu_usage", VirtualParamPrefix + "CpuUsage"},
+	{"memory_total", VirtualParamPrefix + "MemoryTotal"},
+	{"memory_free", VirtualParamPrefix + "MemoryFree"},
+}
+
+// applyDeviceInfoParams extracts the generic device parameters from a param map
+func (c *CwmpCpe) applyDeviceInfoParams(valmap map[string]interface{}, params map[string]string) {
+	for _, f := range deviceInfoParamFields {
+		setMapValue(valmap, f.field, c.GetVirtualParamValue(f.vparam, params))
+	}
+}
+
+// vendorParamFields maps NetCpe fields to the virtual parameters they are read from
+var vendorParamFields = []struct {
+	field  string
+	vparam string
+}{
+	{"arch_name", VirtualParamPrefix + "ArchName"},
+	{"system_name", VirtualParamPrefix + "SystemName"},
+	{"fiber_rx_power", VirtualParamPrefix + "RxPower"},
+	{"fiber_tx_power", VirtualParamPrefix + "TxPower"},
+	{"pon_sn_hex", VirtualParamPrefix + "PonSerialNumber"},
+	{"olt_uplink", VirtualParamPrefix + "OltUplink"},
+}
+
 // applyVendorSpecificParams extracts vendor-specific parameters from an Inform message
 func (c *CwmpCpe) applyVendorSpecificParams(valmap map[string]interface{}, msg *cwmp.Inform) {
-	m := strings.ToLower(c.Manufacturer)
-	switch {
-	case strings.Contains(m, "mikrotik"):
-		// Mikrotik-specific parameters
-		setMapValue(valmap, "arch_name", msg.GetParam("Device.DeviceInfo.X_MIKROTIK_ArchName"))
-		setMapValue(valmap, "system_name", msg.GetParam("Device.DeviceInfo.X_MIKROTIK_SystemIdentity"))
-	default:
-		// ONT / generic parameters — try TR-181 then TR-098 paths
-		setMapValue(valmap, "system_name", getInformParam(msg,
-			"Device.DeviceInfo.ModelName",
-			"InternetGatewayDevice.DeviceInfo.ModelName"))
-		// Optical signal parameters — TR-181 (Device.Optical) or TR-098 (XponInterface)
-		rxPower := getInformParam(msg,
-			"Device.Optical.Interface.1.RxPower",
-			"InternetGatewayDevice.DeviceInfo.XponInterface.RXPower")
-		setMapValue(valmap, "fiber_rx_power", rxPower)
-		txPower := getInformParam(msg,
-			"Device.Optical.Interface.1.TxPower",
-			"InternetGatewayDevice.DeviceInfo.XponInterface.TXPower")
-		setMapValue(valmap, "fiber_tx_power", txPower)
-		setMapValue(valmap, "pon_sn_hex", getInformParam(msg,
-			"Device.DeviceInfo.SerialNumber",
-			"InternetGatewayDevice.DeviceInfo.SerialNumber"))
-		setMapValue(valmap, "olt_uplink", getInformParam(msg,
-			"Device.Optical.Interface.1.UpperLayers",
-			"InternetGatewayDevice.DeviceInfo.XponInterface.OLTInfo"))
-		// ZTE fallback for optical power
-		if rxPower == "" {
-			rxPower = getInformParam(msg,
-				"InternetGatewayDevice.WANDevice.1.X_ZTE-COM_WANPONInterfaceConfig.RXPower",
-				"InternetGatewayDevice.WANDevice.2.X_ZTE-COM_WANPONInterfaceConfig.RXPower")
-			setMapValue(valmap, "fiber_rx_power", rxPower)
-		}
-		if txPower == "" {
-			txPower = getInformParam(msg,
-				"InternetGatewayDevice.WANDevice.1.X_ZTE-COM_WANPONInterfaceConfig.TXPower",
-				"InternetGatewayDevice.WANDevice.2.X_ZTE-COM_WANPONInterfaceConfig.TXPower")
-			setMapValue(valmap, "fiber_tx_power", txPower)
-		}
-		// Detect ONT from optical parameters if device_type was not set
-		if rxPower != "" || txPower != "" {
-			setMapValue(valmap, "device_type", DeviceTypeONT)
-		}
+	for _, f := range vendorParamFields {
+		setMapValue(valmap, f.field, c.GetVirtualParamValue(f.vparam, msg.Params))
+	}
+	// Detect ONT from optical parameters if device_type was not set
+	if valmap["fiber_rx_power"] != nil || valmap["fiber_tx_power"] != nil {
+		setMapValue(valmap, "device_type", DeviceTypeONT)
 	}
 }
 
 // applyVendorSpecificParamsFromMap extracts vendor-specific parameters from a param map
 func (c *CwmpCpe) applyVendorSpecificParamsFromMap(valmap map[string]interface{}, params map[string]string) {
-	var getParam = func(name string) string {
-		v, ok := params[name]
-		if ok {
-			return v
-		}
-		return ""
+	for _, f := range vendorParamFields {
+		setMapValue(valmap, f.field, c.GetVirtualParamValue(f.vparam, params))
 	}
-	m := strings.ToLower(c.Manufacturer)
-	switch {
-	case strings.Contains(m, "mikrotik"):
-		setMapValue(valmap, "arch_name", getParam("Device.DeviceInfo.X_MIKROTIK_ArchName"))
-		setMapValue(valmap, "system_name", getParam("Device.DeviceInfo.X_MIKROTIK_SystemIdentity"))
-	default:
-		setMapValue(valmap, "system_name", getParam("Device.DeviceInfo.ModelName"))
-		setMapValue(valmap, "fiber_rx_power", getParam("Device.Optical.Interface.1.RxPower"))
-		setMapValue(valmap, "fiber_tx_power", getParam("Device.Optical.Interface.1.TxPower"))
-		setMapValue(valmap, "pon_sn_hex", getParam("Device.DeviceInfo.SerialNumber"))
-		setMapValue(valmap, "olt_uplink", getParam("Device.Optical.Interface.1.UpperLayers"))
-		// Uptime & CPU (TR-098 fallback)
-		uptime := getParam("InternetGatewayDevice.DeviceInfo.UpTime")
-		if uptime != "" {
-			setMapValue(valmap, "uptime", uptime)
-		}
-		cpuUsage := getParam("InternetGatewayDevice.DeviceInfo.ProcessStatus.CPUUsage")
-		if cpuUsage == "" {
-			cpuUsage = getParam("InternetGatewayDevice.DeviceInfo.X_CMS_CPUUsage")
-		}
-		if cpuUsage != "" {
-			setMapValue(valmap, "cpu_usage", cpuUsage)
-		}
-		// TR-098 fallbacks
-		if valmap["fiber_rx_power"] == nil || valmap["fiber_rx_power"] == "" {
-			setMapValue(valmap, "fiber_rx_power", getParam("InternetGatewayDevice.DeviceInfo.XponInterface.RXPower"))
-		}
-		if valmap["fiber_tx_power"] == nil || valmap["fiber_tx_power"] == "" {
-			setMapValue(valmap, "fiber_tx_power", getParam("InternetGatewayDevice.DeviceInfo.XponInterface.TXPower"))
-		}
-		// ZTE fallback
-		if valmap["fiber_rx_power"] == nil || valmap["fiber_rx_power"] == "" {
-			rx := getParam("InternetGatewayDevice.WANDevice.1.X_ZTE-COM_WANPONInterfaceConfig.RXPower")
-			if rx == "" {
-				rx = getParam("InternetGatewayDevice.WANDevice.2.X_ZTE-COM_WANPONInterfaceConfig.RXPower")
-			}
-			setMapValue(valmap, "fiber_rx_power", rx)
-		}
-		if valmap["fiber_tx_power"] == nil || valmap["fiber_tx_power"] == "" {
-			tx := getParam("InternetGatewayDevice.WANDevice.1.X_ZTE-COM_WANPONInterfaceConfig.TXPower")
-			if tx == "" {
-				tx = getParam("InternetGatewayDevice.WANDevice.2.X_ZTE-COM_WANPONInterfaceConfig.TXPower")
-			}
-			setMapValue(valmap, "fiber_tx_power", tx)
-		}
-		// WiFi SSIDs (multi-SSID with passwords)
-		wifiJson := parseWifiSsids(params)
-		if wifiJson != "" {
-			setMapValue(valmap, "wifi_ssid", wifiJson)
-		}
-		// WAN connections
-		wanJson := parseWanConnections(params)
-		if wanJson != "" {
-			setMapValue(valmap, "wan_info", wanJson)
-		}
-		// Connected LAN/WiFi devices
-		hostsJson := parseHostDevices(params)
-		if hostsJson != "" {
-			setMapValue(valmap, "lan_clients", hostsJson)
-		}
+	// WiFi SSIDs (multi-SSID with passwords)
+	wifiJson := c.parseWifiSsids(params)
+	if wifiJson != "" {
+		setMapValue(valmap, "wifi_ssid", wifiJson)
+	}
+	// WAN connections
+	wanJson := parseWanConnections(params)
+	if wanJson != "" {
+		setMapValue(valmap, "wan_info", wanJson)
+	}
+	// Connected LAN/WiFi devices
+	hostsJson := parseHostDevices(params)
+	if hostsJson != "" {
+		setMapValue(valmap, "lan_clients", hostsJson)
 	}
 }
 
@@ -1020,30 +954,14 @@ func (c *CwmpCpe) OnInformUpdate() {
 	setMapValue(valmap, "oui", msg.OUI)
 	setMapValue(valmap, "cwmp_status", "online")
 	setMapValue(valmap, "cwmp_last_inform", time.Now())
-	setMapValue(valmap, "cwmp_url", getInformParam(msg,
-		"Device.ManagementServer.ConnectionRequestURL",
-		"InternetGatewayDevice.ManagementServer.ConnectionRequestURL"))
-	setMapValue(valmap, "software_version", getInformParam(msg,
-		"Device.DeviceInfo.SoftwareVersion",
-		"InternetGatewayDevice.DeviceInfo.SoftwareVersion"))
-	setMapValue(valmap, "hardware_version", getInformParam(msg,
-		"Device.DeviceInfo.HardwareVersion",
-		"InternetGatewayDevice.DeviceInfo.HardwareVersion"))
-	setMapValue(valmap, "model", getInformParam(msg,
-		"Device.DeviceInfo.ModelName",
-		"InternetGatewayDevice.DeviceInfo.ModelName"))
-	setMapValue(valmap, "uptime", getInformParam(msg,
-		"Device.DeviceInfo.UpTime",
-		"InternetGatewayDevice.DeviceInfo.UpTime"))
-	setMapValue(valmap, "cpu_usage", getInformParam(msg,
-		"Device.DeviceInfo.ProcessStatus.CPUUsage",
-		"InternetGatewayDevice.DeviceInfo.ProcessStatus.CPUUsage"))
-	setMapValue(valmap, "memory_total", getInformParam(msg,
-		"Device.DeviceInfo.MemoryStatus.Free",
-		"InternetGatewayDevice.DeviceInfo.MemoryStatus.Free"))
-	setMapValue(valmap, "memory_free", getInformParam(msg,
-		"Device.DeviceInfo.MemoryStatus.Total",
-		"InternetGatewayDevice.DeviceInfo.MemoryStatus.Total"))
+	setMapValue(valmap, "data_model", datamodel.DetectModel(msg.Params))
+	c.applyDeviceInfoParams(valmap, msg.Params)
+	// TR-111 address reported by the CPE after its STUN Binding Request
+	if udpAddr := msg.GetParam(c.TranslatePath("Device.ManagementServer.UDPConnectionRequestAddress")); udpAddr != "" {
+		valmap["udp_connreq_addr"] = udpAddr
+		stunBindings.Store(c.Sn, udpAddr)
+	}
+	setMapValue(valmap, "xmpp_jid", msg.GetParam("Device.ManagementServer.ConnReqJabberID"))
 	// Vendor-specific parameters
 	c.applyVendorSpecificParams(valmap, msg)
 
@@ -1053,43 +971,24 @@ func (c *CwmpCpe) OnInformUpdate() {
 	}
 
 	if len(valmap) > 0 {
-		err := app.gormDB.Model(&models.NetCpe{}).Where("sn=?", c.Sn).Updates(valmap)
-		if err.Error != nil {
-			log.Error("EventCwmpInformUpdate error: ", err)
-		}
+		app.QueueCpeUpdate(c.Sn, valmap)
 	}
+	app.UpdateCwmpCpeRundata(c.Sn, msg.Params, msg.ParamTypes, ParamSourceInform, msg.ID)
 }
 
 func (c *CwmpCpe) OnInformUpdateOnline() {
-	err := app.gormDB.Model(&models.NetCpe{}).Where("sn=?", c.Sn).Updates(map[string]interface{}{
+	app.QueueCpeUpdate(c.Sn, map[string]interface{}{
 		"cwmp_status":      "online",
 		"cwmp_last_inform": time.Now(),
-	}).Error
-	if err != nil {
-		log.Error("EventCwmpInformUpdateOnline error: ", err)
-	}
+	})
 }
 
-func (c *CwmpCpe) OnParamsUpdate(params map[string]string) {
-	var getParam = func(name string) string {
-		v, ok := params[name]
-		if ok {
-			return v
-		}
-		return ""
-	}
+func (c *CwmpCpe) OnParamsUpdate(params map[string]string, types map[string]string, session string) {
 	valmap := map[string]interface{}{}
 	// Generic TR-069 parameters (all vendors)
 	setMapValue(valmap, "cwmp_last_inform", time.Now())
 	setMapValue(valmap, "cwmp_status", "online")
-	setMapValue(valmap, "cwmp_url", getParam("Device.ManagementServer.ConnectionRequestURL"))
-	setMapValue(valmap, "software_version", getParam("Device.DeviceInfo.SoftwareVersion"))
-	setMapValue(valmap, "hardware_version", getParam("Device.DeviceInfo.HardwareVersion"))
-	setMapValue(valmap, "model", getParam("Device.DeviceInfo.ModelName"))
-	setMapValue(valmap, "uptime", getParam("Device.DeviceInfo.UpTime"))
-	setMapValue(valmap, "cpu_usage", getParam("Device.DeviceInfo.ProcessStatus.CPUUsage"))
-	setMapValue(valmap, "memory_total", getParam("Device.DeviceInfo.MemoryStatus.Free"))
-	setMapValue(valmap, "memory_free", getParam("Device.DeviceInfo.MemoryStatus.Total"))
+	c.applyDeviceInfoParams(valmap, params)
 	// Vendor-specific parameters
 	c.applyVendorSpecificParamsFromMap(valmap, params)
 
@@ -1101,10 +1000,12 @@ func (c *CwmpCpe) OnParamsUpdate(params map[string]string) {
 			log.Info("OnParamsUpdate success")
 		}
 	}
-	app.UpdateCwmpCpeRundata(c.Sn, params)
+	app.UpdateCwmpCpeRundata(c.Sn, params, types, ParamSourceGPV, session)
 }
 
-func (a *Application) UpdateCwmpCpeRundata(sn string, vmap map[string]string) {
+// UpdateCwmpCpeRundata Save parameter values and their xsd types, writable is kept.
+// Value changes are recorded in the parameter history with the source and session.
+func (a *Application) UpdateCwmpCpeRundata(sn string, vmap map[string]string, types map[string]string, source, session string) {
 	var pids []string
 	var params []models.NetCpeParam
 	for k, v := range vmap {
@@ -1155,18 +1056,35 @@ func (a *Application) UpdateCwmpCpeRundata(sn string, vmap map[string]string) {
 			Tag:       tag,
 			Name:      k,
 			Value:     v,
+			Type:      a.parameterType(k, types[k]),
+			CreatedAt: time.Now(),
 			UpdatedAt: time.Now(),
 		})
 	}
-	err := a.gormDB.Model(&models.NetCpeParam{}).Save(&params).Error
-	if err != nil {
-		log.Errorf("UpdateCwmpCPERundata: %s", err.Error())
-	} else {
-		log.Infof("UpdateCwmpCPERundata for %s success, total %d", sn, len(pids))
+	if len(params) == 0 {
+		return
+	}
+	var changes = make([]paramChange, 0, len(params))
+	for _, p := range params {
+		changes = append(changes, paramChange{NetCpeParam: p, source: source, session: session})
+	}
+	// Inform values are batched with the history check, the RPC responses are read back by the callers
+	if source == ParamSourceInform {
+		a.QueueCpeParams(changes)
+		return
 	}
+	a.recordParamHistory(changes)
+	a.upsertCpeParams(params)
+	log.Infof("UpdateCwmpCPERundata for %s success, total %d", sn, len(pids))
 }
 
 func (a *Application) InjectCwmpConfigVars(sn string, src string, extvars map[string]string) string {
+	content, _ := a.injectCwmpConfigVars(sn, src, extvars)
+	return content
+}
+
+// injectCwmpConfigVars Also returns the secrets the template can expand
+func (a *Application) injectCwmpConfigVars(sn string, src string, extvars map[string]string) (string, []string) {
 	var cpe models.NetCpe
 	err := a.gormDB.Model(&models.NetCpe{}).Where("sn=?", sn).First(&cpe).Error
 	if err != nil {
@@ -1176,26 +1094,34 @@ func (a *Application) InjectCwmpConfigVars(sn string, src string, extvars map[st
 	var bs []byte
 	buff := bytes.NewBuffer(bs)
 
-	token, _ := web.CreateToken(a.appConfig.Tr069.Secret, "cpe", "api", time.Hour*24*365)
+	token, _ := a.CreateDeviceApiToken(sn)
+	// scripts set the connection request password the ACS uses for this device
+	_, connReqPassword := a.ConnReqCredential(sn)
 
 	vars := map[string]interface{}{
 		"cpe":                              cpe,
 		"TeamsacsApiToken":                 token,
 		ConfigTR069AccessAddress:           a.GetTr069SettingsStringValue(ConfigTR069AccessAddress),
 		ConfigTR069AccessPassword:          a.GetTr069SettingsStringValue(ConfigTR069AccessPassword),
-		ConfigCpeConnectionRequestPassword: a.GetTr069SettingsStringValue(ConfigCpeConnectionRequestPassword),
+		ConfigCpeConnectionRequestPassword: connReqPassword,
 	}
 
 	for k, v := range extvars {
 		vars[k] = v
 	}
+	var secrets []string
+	for _, v := range []string{token, a.GetTr069SettingsStringValue(ConfigTR069AccessPassword), connReqPassword} {
+		if v != "" {
+			secrets = append(secrets, v)
+		}
+	}
 
 	err = tx.Execute(buff, vars)
 	if err != nil {
 		log.Errorf("InjectCwmpConfigVars: %s", err.Error())
-		return src
+		return src, secrets
 	}
-	return buff.String()
+	return buff.String(), secrets
 }
 
 func (a *Application) GetCacrtContent() string {
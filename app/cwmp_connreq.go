@@ -0,0 +1,191 @@
+package app
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"time"
+
+	"github.com/ca17/teamsacs/common"
+	"github.com/ca17/teamsacs/common/aes"
+	"github.com/ca17/teamsacs/common/zaplog/log"
+	"github.com/ca17/teamsacs/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Connection request status
+const (
+	ConnReqSuccess = "success"
+	ConnReqFailure = "failure"
+)
+
+// connReqKey AES key of the stored connection request passwords
+func (a *Application) connReqKey() string {
+	return common.Md5Hash(a.appConfig.Tr069.Secret)
+}
+
+func (a *Application) encryptConnReqPassword(password string) string {
+	v, err := aes.EncryptToB64(password, a.connReqKey())
+	if err != nil {
+		log.Errorf("encryptConnReqPassword: %s", err.Error())
+	}
+	return v
+}
+
+func (a *Application) decryptConnReqPassword(password string) string {
+	if password == "" {
+		return ""
+	}
+	v, err := aes.DecryptFromB64(password, a.connReqKey())
+	if err != nil {
+		log.Errorf("decryptConnReqPassword: %s", err.Error())
+	}
+	return v
+}
+
+// defaultConnReqCredential Credentials pushed to devices without stored ones
+func (a *Application) defaultConnReqCredential(sn string) (string, string) {
+	return sn, a.GetTr069SettingsStringValue(ConfigCpeConnectionRequestPassword)
+}
+
+// ConnReqCredential Connection request credentials confirmed by the device,
+// the defaults when the device never confirmed a push
+func (a *Application) ConnReqCredential(sn string) (string, string) {
+	var item models.NetCpeConnReq
+	err := a.gormDB.Where("sn = ?", sn).First(&item).Error
+	if err != nil || item.Username == "" {
+		return a.defaultConnReqCredential(sn)
+	}
+	return item.Username, a.decryptConnReqPassword(item.Password)
+}
+
+// connReqPasswords Connection request passwords the device may use, also for STUN
+// and XMPP: the password being pushed first, then the confirmed one or the default
+func (a *Application) connReqPasswords(sn string) []string {
+	var item models.NetCpeConnReq
+	a.gormDB.Where("sn = ?", sn).Find(&item)
+	var passwords []string
+	if item.PendingUsername != "" {
+		passwords = append(passwords, a.decryptConnReqPassword(item.PendingPassword))
+	}
+	if item.Username != "" {
+		passwords = append(passwords, a.decryptConnReqPassword(item.Password))
+	} else {
+		_, password := a.defaultConnReqCredential(sn)
+		passwords = append(passwords, password)
+	}
+	return passwords
+}
+
+// nextConnReqCredential Credentials to push in the session: a pending rotation,
+// the confirmed ones or the defaults, they are confirmed by the SetParameterValuesResponse
+func (c *CwmpCpe) nextConnReqCredential(session string) (string, string) {
+	var item models.NetCpeConnReq
+	app.gormDB.Where("sn = ?", c.Sn).Find(&item)
+	username, password := app.defaultConnReqCredential(c.Sn)
+	switch {
+	case item.PendingUsername != "":
+		username, password = item.PendingUsername, app.decryptConnReqPassword(item.PendingPassword)
+	case item.Username != "":
+		username, password = item.Username, app.decryptConnReqPassword(item.Password)
+	}
+	err := app.gormDB.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "sn"}},
+		DoUpdates: clause.AssignmentColumns([]string{"pending_username", "pending_password", "pending_session", "updated_at"}),
+	}).Create(&models.NetCpeConnReq{
+		ID:              common.UUIDint64(),
+		Sn:              c.Sn,
+		PendingUsername: username,
+		PendingPassword: app.encryptConnReqPassword(password),
+		PendingSession:  session,
+		CreatedAt:       time.Now(),
+		UpdatedAt:       time.Now(),
+	}).Error
+	if err != nil {
+		log.Errorf("nextConnReqCredential %s: %s", c.Sn, err.Error())
+	}
+	return username, password
+}
+
+// confirmConnReqCredential The device applied the credentials pushed in the session
+func (c *CwmpCpe) confirmConnReqCredential(session string) {
+	err := app.gormDB.Model(&models.NetCpeConnReq{}).
+		Where("sn = ? and pending_session = ? and pending_username <> ''", c.Sn, session).
+		Updates(map[string]interface{}{
+			"username":         gorm.Expr("pending_username"),
+			"password":         gorm.Expr("pending_password"),
+			"pending_username": "",
+			"pending_password": "",
+			"pending_session":  "",
+			"confirmed_at":     time.Now(),
+			"updated_at":       time.Now(),
+		}).Error
+	if err != nil {
+		log.Errorf("confirmConnReqCredential %s: %s", c.Sn, err.Error())
+	}
+}
+
+// RotateConnReqCredential Generate a new connection request password for the device,
+// it is pushed with the management auth info and used once the device confirms it
+func (a *Application) RotateConnReqCredential(sn string) error {
+	b := make([]byte, 12)
+	if _, err := rand.Read(b); err != nil {
+		return err
+	}
+	return a.gormDB.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "sn"}},
+		DoUpdates: clause.AssignmentColumns([]string{"pending_username", "pending_password", "pending_session", "updated_at"}),
+	}).Create(&models.NetCpeConnReq{
+		ID:              common.UUIDint64(),
+		Sn:              sn,
+		PendingUsername: sn,
+		PendingPassword: a.encryptConnReqPassword(hex.EncodeToString(b)),
+		PendingSession:  "",
+		CreatedAt:       time.Now(),
+		UpdatedAt:       time.Now(),
+	}).Error
+}
+
+// RecordConnReqResult Update the connection request health of the device
+func (a *Application) RecordConnReqResult(sn, method string, success bool, latency time.Duration, reason string) {
+	ms := latency.Milliseconds()
+	PromConnReq.WithLabelValues(method, common.If(success, ConnReqSuccess, ConnReqFailure).(string)).Inc()
+	values := map[string]interface{}{
+		"method":       method,
+		"last_latency": ms,
+		"last_time":    time.Now(),
+		"updated_at":   time.Now(),
+	}
+	if success {
+		values["last_status"] = ConnReqSuccess
+		values["avg_latency"] = gorm.Expr("(avg_latency * success + ?) / (success + 1)", ms)
+		values["success"] = gorm.Expr("success + 1")
+	} else {
+		values["last_status"] = ConnReqFailure
+		values["last_error"] = reason
+		values["failure"] = gorm.Expr("failure + 1")
+	}
+	result := a.gormDB.Model(&models.NetCpeConnReq{}).Where("sn = ?", sn).Updates(values)
+	if result.Error != nil {
+		log.Errorf("RecordConnReqResult %s: %s", sn, result.Error.Error())
+		return
+	}
+	if result.RowsAffected == 0 {
+		item := models.NetCpeConnReq{
+			ID:          common.UUIDint64(),
+			Sn:          sn,
+			Method:      method,
+			LastStatus:  ConnReqFailure,
+			LastError:   reason,
+			LastLatency: ms,
+			Failure:     1,
+			LastTime:    time.Now(),
+			CreatedAt:   time.Now(),
+			UpdatedAt:   time.Now(),
+		}
+		if success {
+			item.LastStatus, item.LastError, item.AvgLatency, item.Success, item.Failure = ConnReqSuccess, "", ms, 1, 0
+		}
+		a.gormDB.Clauses(clause.OnConflict{DoNothing: true}).Create(&item)
+	}
+}
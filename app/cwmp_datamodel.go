@@ -0,0 +1,97 @@
+package app
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/ca17/teamsacs/assets"
+	"github.com/ca17/teamsacs/common"
+	"github.com/ca17/teamsacs/common/datamodel"
+	"github.com/ca17/teamsacs/common/zaplog/log"
+	"github.com/ca17/teamsacs/models"
+)
+
+var (
+	dataModelSchema *datamodel.Schema
+	dataModelOnce   sync.Once
+)
+
+// DataModelSchema Returns the builtin TR-098/TR-181 schema definitions
+func DataModelSchema() *datamodel.Schema {
+	dataModelOnce.Do(func() {
+		s, err := datamodel.Load(assets.Tr069DataModel)
+		if err != nil {
+			log.Errorf("load tr069 data model error: %s", err)
+			s = &datamodel.Schema{}
+		}
+		dataModelSchema = s
+	})
+	return dataModelSchema
+}
+
+// GetDataModel Returns the data model of the device, TR-098 or TR-181, empty if unknown
+func (c *CwmpCpe) GetDataModel() string {
+	c.dataModelLock.Lock()
+	defer c.dataModelLock.Unlock()
+	if c.dataModel != "" {
+		return c.dataModel
+	}
+	if inform := c.LastInform; inform != nil {
+		c.dataModel = datamodel.DetectModel(inform.Params)
+	}
+	if c.dataModel == "" {
+		app.gormDB.Model(&models.NetCpe{}).Where("sn = ?", c.Sn).
+			Limit(1).Pluck("data_model", &c.dataModel)
+	}
+	return c.dataModel
+}
+
+func (c *CwmpCpe) setDataModel(model string) {
+	c.dataModelLock.Lock()
+	c.dataModel = model
+	c.dataModelLock.Unlock()
+}
+
+// DataModelRoot Returns the root object of the device data model, empty if unknown
+func (c *CwmpCpe) DataModelRoot() string {
+	return datamodel.RootOf(c.GetDataModel())
+}
+
+// TranslatePath Translate a TR-181 or TR-098 path into the data model of the device,
+// for the paths known to be mapped. Unmapped paths are returned unchanged, use MapPath
+// for the paths given by the admin.
+func (c *CwmpCpe) TranslatePath(path string) string {
+	result, _ := c.MapPath(path)
+	return common.IfEmptyStr(result, path)
+}
+
+// MapPath Translate a path into the data model of the device, an error
+// is returned when the data model has no mapping of the path
+func (c *CwmpCpe) MapPath(path string) (string, error) {
+	model := c.GetDataModel()
+	if model == "" {
+		return path, nil
+	}
+	result, ok := DataModelSchema().Translate(path, model)
+	if !ok {
+		return "", fmt.Errorf("%s has no mapping in the %s data model of %s", path, model, c.Sn)
+	}
+	return result, nil
+}
+
+// TranslatePaths Translate paths into the data model of the device, the unmapped
+// paths and duplicates are removed. Lists may mix TR-181 and TR-098 paths for
+// the objects without a common mapping.
+func (c *CwmpCpe) TranslatePaths(paths []string) []string {
+	var result = make([]string, 0, len(paths))
+	var exists = make(map[string]bool)
+	for _, p := range paths {
+		tp, err := c.MapPath(p)
+		if err != nil || exists[tp] {
+			continue
+		}
+		exists[tp] = true
+		result = append(result, tp)
+	}
+	return result
+}
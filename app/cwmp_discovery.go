@@ -0,0 +1,188 @@
+package app
+
+import (
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/ca17/teamsacs/common"
+	"github.com/ca17/teamsacs/common/cwmp"
+	"github.com/ca17/teamsacs/common/zaplog/log"
+	"github.com/ca17/teamsacs/models"
+	"github.com/spf13/cast"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// DiscoverySessionPrefix Session prefix of the GetParameterNames sent by the discovery job
+const DiscoverySessionPrefix = "discovery-"
+
+// Discovery node status
+const (
+	DiscoveryPending = "pending"
+	DiscoveryRunning = "running"
+	DiscoveryDone    = "done"
+	DiscoveryFailure = "failure"
+	DiscoveryCancel  = "cancel"
+)
+
+// discoveryMaxAttempts An object is given up after this many unanswered requests
+const discoveryMaxAttempts = 3
+
+// discoveryRequestTimeout A running object without response after this time is requeued
+const discoveryRequestTimeout = time.Minute * 5
+
+func paramNodeId(sn, path string) string {
+	return common.Md5Hash(sn + path)
+}
+
+// StartParamDiscovery Start walking the full parameter tree of the device from the data model root.
+// The walk is breadth-first, the pending objects are kept in the database so that
+// an interrupted walk is resumed on the next session.
+func (c *CwmpCpe) StartParamDiscovery() error {
+	root := c.DataModelRoot()
+	if root == "" {
+		return errors.New("unknown device data model, wait for the device to inform")
+	}
+	err := app.gormDB.Where("sn = ?", c.Sn).Delete(&models.NetCpeParamNode{}).Error
+	if err != nil {
+		return err
+	}
+	app.gormDB.Model(&models.CwmpCpeSession{}).Where("sn = ?", c.Sn).Update("discovery_rpcs", 0)
+	return app.gormDB.Create(&models.NetCpeParamNode{
+		ID:        paramNodeId(c.Sn, root),
+		Sn:        c.Sn,
+		Path:      root,
+		Object:    true,
+		Status:    DiscoveryPending,
+		CreatedAt: time.Now(),
+		UpdatedAt: time.Now(),
+	}).Error
+}
+
+// CancelParamDiscovery Stop the walk, the nodes already found are kept
+func (c *CwmpCpe) CancelParamDiscovery() error {
+	return app.gormDB.Model(&models.NetCpeParamNode{}).
+		Where("sn = ? and status in ?", c.Sn, []string{DiscoveryPending, DiscoveryRunning}).
+		Update("status", DiscoveryCancel).Error
+}
+
+// RequeueParamDiscovery Requeues the objects whose request was not answered in time,
+// an object is given up after discoveryMaxAttempts requests
+func (a *Application) RequeueParamDiscovery() {
+	err := a.gormDB.Model(&models.NetCpeParamNode{}).
+		Where("status = ? and updated_at < ?", DiscoveryRunning, time.Now().Add(-discoveryRequestTimeout)).
+		Updates(map[string]interface{}{
+			"status":     DiscoveryPending,
+			"attempts":   gorm.Expr("attempts + 1"),
+			"updated_at": time.Now(),
+		}).Error
+	if err != nil {
+		log.Errorf("RequeueParamDiscovery: %s", err.Error())
+		return
+	}
+	a.gormDB.Model(&models.NetCpeParamNode{}).
+		Where("status = ? and attempts >= ?", DiscoveryPending, discoveryMaxAttempts).
+		Update("status", DiscoveryFailure)
+}
+
+// NextDiscoveryRequest Returns the GetParameterNames of the next pending object,
+// nil when the walk is finished or the RPC budget of the session is used up.
+func (c *CwmpCpe) NextDiscoveryRequest() *cwmp.GetParameterNames {
+	budget := cast.ToInt(app.GetTr069SettingsStringValue(ConfigCpeDiscoveryRpcBudget))
+	if budget <= 0 {
+		budget = 20
+	}
+	var node models.NetCpeParamNode
+	err := app.gormDB.Where("sn = ? and status = ?", c.Sn, DiscoveryPending).
+		Order("depth asc, path asc").Limit(1).Find(&node).Error
+	if err != nil || node.ID == "" {
+		return nil
+	}
+	// the budget is shared by the ACS instances serving the session
+	if !c.takeDiscoveryRpc(budget) {
+		return nil
+	}
+	session := DiscoverySessionPrefix + common.UUID()
+	err = app.gormDB.Model(&node).Updates(map[string]interface{}{
+		"status":     DiscoveryRunning,
+		"session":    session,
+		"updated_at": time.Now(),
+	}).Error
+	if err != nil {
+		log.Errorf("NextDiscoveryRequest: %s", err.Error())
+		return nil
+	}
+	return &cwmp.GetParameterNames{
+		ID:            session,
+		Name:          "GetParameterNames",
+		ParameterPath: node.Path,
+		NextLevel:     "true",
+	}
+}
+
+// OnDiscoveryResponse Save the children of the requested object, objects are queued for the next level
+func (c *CwmpCpe) OnDiscoveryResponse(msg *cwmp.GetParameterNamesResponse) {
+	var node models.NetCpeParamNode
+	app.gormDB.Where("sn = ? and session = ?", c.Sn, msg.GetID()).Limit(1).Find(&node)
+	if node.ID == "" {
+		return
+	}
+	var nodes []models.NetCpeParamNode
+	for _, param := range msg.Params {
+		if param.Name == node.Path || !strings.HasPrefix(param.Name, node.Path) {
+			continue
+		}
+		object := strings.HasSuffix(param.Name, ".")
+		nodes = append(nodes, models.NetCpeParamNode{
+			ID:        paramNodeId(c.Sn, param.Name),
+			Sn:        c.Sn,
+			Path:      param.Name,
+			Parent:    node.Path,
+			Object:    object,
+			Writable:  param.Writable,
+			Depth:     node.Depth + 1,
+			Status:    common.If(object, DiscoveryPending, DiscoveryDone).(string),
+			CreatedAt: time.Now(),
+			UpdatedAt: time.Now(),
+		})
+	}
+	if len(nodes) > 0 {
+		err := app.gormDB.Clauses(clause.OnConflict{
+			Columns:   []clause.Column{{Name: "id"}},
+			DoUpdates: clause.AssignmentColumns([]string{"parent", "object", "writable", "depth", "updated_at"}),
+		}).CreateInBatches(nodes, 100).Error
+		if err != nil {
+			log.Errorf("OnDiscoveryResponse: %s", err.Error())
+			return
+		}
+	}
+	app.gormDB.Model(&node).Updates(map[string]interface{}{
+		"status":     DiscoveryDone,
+		"updated_at": time.Now(),
+	})
+	c.ProcessParameterNamesResponse(msg)
+}
+
+// DiscoveryStatus Number of tree nodes by status
+func (c *CwmpCpe) DiscoveryStatus() map[string]int64 {
+	type statusCount struct {
+		Status string
+		Total  int64
+	}
+	var counts []statusCount
+	app.gormDB.Model(&models.NetCpeParamNode{}).
+		Select("status, count(*) as total").
+		Where("sn = ?", c.Sn).Group("status").Scan(&counts)
+	var result = map[string]int64{
+		DiscoveryPending: 0,
+		DiscoveryRunning: 0,
+		DiscoveryDone:    0,
+		DiscoveryFailure: 0,
+		DiscoveryCancel:  0,
+	}
+	for _, sc := range counts {
+		result[sc.Status] = sc.Total
+	}
+	return result
+}
@@ -0,0 +1,78 @@
+package app
+
+import (
+	"github.com/ca17/teamsacs/common/devquery"
+	"github.com/ca17/teamsacs/common/zaplog/log"
+	"github.com/ca17/teamsacs/models"
+	"gorm.io/gorm"
+)
+
+// DeviceGroupFields Device fields usable in device group expressions
+var DeviceGroupFields = map[string]devquery.Field{
+	"sn":               {Column: "net_cpe.sn"},
+	"name":             {Column: "net_cpe.name"},
+	"manufacturer":     {Column: "net_cpe.manufacturer"},
+	"product_class":    {Column: "net_cpe.product_class"},
+	"oui":              {Column: "net_cpe.oui"},
+	"model":            {Column: "net_cpe.model"},
+	"software_version": {Column: "net_cpe.software_version"},
+	"hardware_version": {Column: "net_cpe.hardware_version"},
+	"system_name":      {Column: "net_cpe.system_name"},
+	"arch_name":        {Column: "net_cpe.arch_name"},
+	"status":           {Column: "net_cpe.status"},
+	"device_type":      {Column: "net_cpe.device_type"},
+	"task_tags":        {Column: "net_cpe.task_tags"},
+	"cwmp_status":      {Column: "net_cpe.cwmp_status"},
+	"data_model":       {Column: "net_cpe.data_model"},
+	"pon_mode":         {Column: "net_cpe.pon_mode"},
+	"pon_sn_hex":       {Column: "net_cpe.pon_sn_hex"},
+	"fiber_rx_power":   {Column: "net_cpe.fiber_rx_power"},
+	"fiber_tx_power":   {Column: "net_cpe.fiber_tx_power"},
+	"olt_uplink":       {Column: "net_cpe.olt_uplink"},
+	"remark":           {Column: "net_cpe.remark"},
+	"uptime":           {Column: "net_cpe.uptime", Numeric: true},
+	"cpu_usage":        {Column: "net_cpe.cpu_usage", Numeric: true},
+	"memory_total":     {Column: "net_cpe.memory_total", Numeric: true},
+	"memory_free":      {Column: "net_cpe.memory_free", Numeric: true},
+	"node_id":          {Column: "net_cpe.node_id", Numeric: true},
+	"odp_id":           {Column: "net_cpe.odp_id", Numeric: true},
+	"node":             {Column: "(SELECT net_node.name FROM net_node WHERE net_node.id = net_cpe.node_id)"},
+}
+
+// DeviceFilterQuery Devices matching a filter expression
+func (a *Application) DeviceFilterQuery(expr string) (*gorm.DB, error) {
+	sql, args, err := devquery.Compile(expr, DeviceGroupFields)
+	if err != nil {
+		return nil, err
+	}
+	return a.gormDB.Model(&models.NetCpe{}).Where(sql, args...), nil
+}
+
+// DeviceGroupQuery Devices of a device group
+func (a *Application) DeviceGroupQuery(groupId int64) (*gorm.DB, error) {
+	var group models.NetCpeGroup
+	err := a.gormDB.Where("id = ?", groupId).First(&group).Error
+	if err != nil {
+		return nil, err
+	}
+	return a.DeviceFilterQuery(group.Expression)
+}
+
+// MatchDeviceGroup Check device is in the group, groupId 0 matches all devices
+func (a *Application) MatchDeviceGroup(sn string, groupId int64) bool {
+	if groupId == 0 {
+		return true
+	}
+	query, err := a.DeviceGroupQuery(groupId)
+	if err != nil {
+		log.Errorf("MatchDeviceGroup %d: %s", groupId, err.Error())
+		return false
+	}
+	var count int64
+	query.Where("net_cpe.sn = ?", sn).Count(&count)
+	return count > 0
+}
+
+func (c *CwmpCpe) MatchDeviceGroup(groupId int64) bool {
+	return app.MatchDeviceGroup(c.Sn, groupId)
+}
@@ -0,0 +1,203 @@
+package app
+
+import (
+	"encoding/json"
+	"strings"
+	"time"
+
+	"github.com/ca17/teamsacs/common"
+	"github.com/ca17/teamsacs/common/cwmp"
+	"github.com/ca17/teamsacs/common/zaplog/log"
+	"github.com/ca17/teamsacs/models"
+	"github.com/spf13/cast"
+	"gorm.io/gorm/clause"
+)
+
+// Parameter change sources
+const (
+	ParamSourceInform = "inform"
+	ParamSourceGPV    = "gpv"
+	ParamSourceSPV    = "spv"
+	ParamSourcePreset = "preset"
+)
+
+// pendingSetTimeout SetParameterValues without a response are dropped after this time
+const pendingSetTimeout = time.Minute * 30
+
+// paramChange A new parameter value, compared with the stored value before it is saved
+type paramChange struct {
+	models.NetCpeParam
+	source  string
+	session string
+}
+
+// recordParamHistory Save the parameters whose value differs from the stored value,
+// the stored values are read by primary key in batches. Parameters seen for the
+// first time are not recorded.
+func (a *Application) recordParamHistory(changes []paramChange) {
+	for i := 0; i < len(changes); i += cpeWriterBatchSize {
+		batch := changes[i:min(i+cpeWriterBatchSize, len(changes))]
+		var ids = make([]string, 0, len(batch))
+		for _, c := range batch {
+			ids = append(ids, c.ID)
+		}
+		var olds []models.NetCpeParam
+		err := a.gormDB.Model(&models.NetCpeParam{}).Select("id", "value", "type").
+			Where("id in ?", ids).Find(&olds).Error
+		if err != nil {
+			log.Errorf("recordParamHistory: %s", err.Error())
+			continue
+		}
+		var oldmap = make(map[string]models.NetCpeParam, len(olds))
+		for _, old := range olds {
+			oldmap[old.ID] = old
+		}
+		histories := paramHistories(batch, oldmap)
+		if len(histories) == 0 {
+			continue
+		}
+		if err = a.gormDB.CreateInBatches(histories, 100).Error; err != nil {
+			log.Errorf("recordParamHistory: %s", err.Error())
+		}
+	}
+}
+
+// paramHistories The history records of the changed values, secrets are never recorded
+func paramHistories(changes []paramChange, oldmap map[string]models.NetCpeParam) []models.NetCpeParamHistory {
+	var histories []models.NetCpeParamHistory
+	for _, c := range changes {
+		old, ok := oldmap[c.ID]
+		if !ok || c.Value == old.Value || isSecretParam(c.Name) {
+			continue
+		}
+		histories = append(histories, models.NetCpeParamHistory{
+			ID:        common.UUIDint64(),
+			Sn:        c.Sn,
+			Name:      c.Name,
+			OldValue:  old.Value,
+			NewValue:  c.Value,
+			Type:      common.IfEmptyStr(c.Type, old.Type),
+			Source:    c.source,
+			Session:   c.session,
+			CreatedAt: time.Now(),
+		})
+	}
+	return histories
+}
+
+// ClearCpeParamHistory Delete parameter change records older than the retention days
+func (a *Application) ClearCpeParamHistory() {
+	days := cast.ToInt(a.GetTr069SettingsStringValue(ConfigCpeParamHistoryDays))
+	if days <= 0 {
+		days = 90
+	}
+	err := a.gormDB.Where("created_at < ?", time.Now().AddDate(0, 0, -days)).
+		Delete(&models.NetCpeParamHistory{}).Error
+	if err != nil {
+		log.Errorf("ClearCpeParamHistory: %s", err.Error())
+	}
+}
+
+// isSecretParam Passwords and WiFi keys
+func isSecretParam(name string) bool {
+	for _, suffix := range []string{"Password", "KeyPassphrase", "PreSharedKey", "WEPKey"} {
+		if strings.HasSuffix(name, suffix) {
+			return true
+		}
+	}
+	return false
+}
+
+func hasSecretParam(params map[string]cwmp.ValueStruct) bool {
+	for name := range params {
+		if isSecretParam(name) {
+			return true
+		}
+	}
+	return false
+}
+
+// TrackSetParameterValues Remember a SetParameterValues sent to the device, the values
+// are recorded when the device confirms them, the response may reach another instance
+func (c *CwmpCpe) TrackSetParameterValues(msg *cwmp.SetParameterValues, source string) {
+	if msg == nil || len(msg.Params) == 0 {
+		return
+	}
+	var params = make(map[string]cwmp.ValueStruct, len(msg.Params))
+	for name, v := range msg.Params {
+		// secrets are not kept in the parameter values and history
+		if isSecretParam(name) {
+			continue
+		}
+		params[name] = v
+	}
+	if len(params) == 0 {
+		return
+	}
+	data, _ := json.Marshal(params)
+	err := app.gormDB.Clauses(clause.OnConflict{UpdateAll: true}).Create(&models.CwmpPendingSet{
+		ID:        msg.GetID(),
+		Sn:        c.Sn,
+		Source:    source,
+		Params:    string(data),
+		CreatedAt: time.Now(),
+	}).Error
+	if err != nil {
+		log.Errorf("TrackSetParameterValues: %s", err.Error())
+	}
+}
+
+// trackPresetTask Remember the SetParameterValues request of a preset task
+func (c *CwmpCpe) trackPresetTask(task *models.CwmpPresetTask) {
+	if task.Name != "SetParameterValues" || task.Request == "" {
+		return
+	}
+	msg, err := cwmp.ParseXML([]byte(task.Request))
+	if err != nil {
+		log.Errorf("trackPresetTask: %s", err.Error())
+		return
+	}
+	if spv, ok := msg.(*cwmp.SetParameterValues); ok {
+		c.TrackSetParameterValues(spv, ParamSourcePreset)
+	}
+}
+
+// OnSetParameterValuesResponse Save the values confirmed by the device
+func (c *CwmpCpe) OnSetParameterValuesResponse(resp *cwmp.SetParameterValuesResponse) {
+	var items []models.CwmpPendingSet
+	err := app.gormDB.Raw("delete from cwmp_pending_set where id = ? and sn = ? returning *", resp.GetID(), c.Sn).
+		Scan(&items).Error
+	if err != nil {
+		log.Errorf("OnSetParameterValuesResponse: %s", err.Error())
+	}
+	// Status 0: applied, 1: applied and committed after reboot
+	if resp.Status > 1 {
+		return
+	}
+	c.confirmConnReqCredential(resp.GetID())
+	c.confirmSubscriberProvision(resp.GetID())
+	if len(items) == 0 || time.Since(items[0].CreatedAt) > pendingSetTimeout {
+		return
+	}
+	var params map[string]cwmp.ValueStruct
+	if err = json.Unmarshal([]byte(items[0].Params), &params); err != nil {
+		log.Errorf("OnSetParameterValuesResponse: %s", err.Error())
+		return
+	}
+	var values = make(map[string]string, len(params))
+	var types = make(map[string]string, len(params))
+	for name, v := range params {
+		values[name] = v.Value
+		types[name] = v.Type
+	}
+	app.UpdateCwmpCpeRundata(c.Sn, values, types, items[0].Source, resp.GetID())
+}
+
+// ClearPendingSets Delete the SetParameterValues never answered
+func (a *Application) ClearPendingSets() {
+	err := a.gormDB.Where("created_at < ?", time.Now().Add(-pendingSetTimeout)).
+		Delete(&models.CwmpPendingSet{}).Error
+	if err != nil {
+		log.Errorf("ClearPendingSets: %s", err.Error())
+	}
+}
@@ -0,0 +1,43 @@
+package app
+
+import (
+	"testing"
+
+	"github.com/ca17/teamsacs/models"
+)
+
+func TestParamHistories(t *testing.T) {
+	change := func(name, value string) paramChange {
+		return paramChange{NetCpeParam: models.NetCpeParam{ID: name, Sn: "sn1", Name: name, Value: value}, source: ParamSourceGPV}
+	}
+	oldmap := map[string]models.NetCpeParam{}
+	for _, name := range []string{
+		"Device.DeviceInfo.SoftwareVersion",
+		"Device.DeviceInfo.HardwareVersion",
+		"Device.WiFi.AccessPoint.1.Security.KeyPassphrase",
+		"Device.WiFi.AccessPoint.1.Security.PreSharedKey",
+		"Device.WiFi.AccessPoint.1.Security.WEPKey",
+		"Device.PPP.Interface.1.Password",
+		"InternetGatewayDevice.WANDevice.1.WANConnectionDevice.1.WANPPPConnection.1.Password",
+	} {
+		oldmap[name] = models.NetCpeParam{ID: name, Name: name, Value: "old"}
+	}
+	changes := []paramChange{
+		change("Device.DeviceInfo.SoftwareVersion", "new"),
+		change("Device.DeviceInfo.HardwareVersion", "old"),
+		change("Device.DeviceInfo.ModelName", "first seen"),
+		change("Device.WiFi.AccessPoint.1.Security.KeyPassphrase", "secret1"),
+		change("Device.WiFi.AccessPoint.1.Security.PreSharedKey", "secret2"),
+		change("Device.WiFi.AccessPoint.1.Security.WEPKey", "secret3"),
+		change("Device.PPP.Interface.1.Password", "secret4"),
+		change("InternetGatewayDevice.WANDevice.1.WANConnectionDevice.1.WANPPPConnection.1.Password", "secret5"),
+	}
+	histories := paramHistories(changes, oldmap)
+	if len(histories) != 1 {
+		t.Fatalf("paramHistories() = %d records, want 1: %+v", len(histories), histories)
+	}
+	h := histories[0]
+	if h.Name != "Device.DeviceInfo.SoftwareVersion" || h.OldValue != "old" || h.NewValue != "new" || h.Source != ParamSourceGPV {
+		t.Errorf("paramHistories() = %+v", h)
+	}
+}
@@ -0,0 +1,156 @@
+package app
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/ca17/teamsacs/common/cwmp"
+	"github.com/ca17/teamsacs/common/zaplog/log"
+)
+
+// Inform overload policies
+const (
+	InformOverloadReject = "reject"
+	InformOverloadWait   = "wait"
+)
+
+// InformTask Inform waiting to be processed by the worker pool
+type InformTask struct {
+	Ip     string
+	Inform *cwmp.Inform
+}
+
+// InformPoolStats Inform worker pool state
+type InformPoolStats struct {
+	Workers   int   `json:"workers"`
+	Busy      int32 `json:"busy"`
+	Queued    int   `json:"queued"`
+	Capacity  int   `json:"capacity"`
+	Processed int64 `json:"processed"`
+	Rejected  int64 `json:"rejected"`
+}
+
+// informPool Bounded pool processing the Informs, a boot storm fills the queue
+// instead of starting a goroutine and several database writes per device
+type informPool struct {
+	tasks     chan InformTask
+	handler   func(task InformTask)
+	workers   int
+	busy      atomic.Int32
+	processed atomic.Int64
+	rejected  atomic.Int64
+	wg        sync.WaitGroup
+	lock      sync.RWMutex // held by the senders, the queue is closed once
+	closed    bool
+}
+
+// StartInformPool Start the Inform workers with the Inform processing function
+func (a *Application) StartInformPool(handler func(task InformTask)) {
+	workers := a.appConfig.Tr069.InformWorkers
+	if workers <= 0 {
+		workers = 32
+	}
+	size := a.appConfig.Tr069.InformQueue
+	if size <= 0 {
+		size = 2000
+	}
+	p := &informPool{
+		tasks:   make(chan InformTask, size),
+		handler: handler,
+		workers: workers,
+	}
+	for i := 0; i < workers; i++ {
+		p.wg.Add(1)
+		go p.work()
+	}
+	a.informPool = p
+	log.Infof("Start inform worker pool, workers=%d queue=%d", workers, size)
+}
+
+func (p *informPool) work() {
+	defer p.wg.Done()
+	for task := range p.tasks {
+		p.busy.Add(1)
+		p.handler(task)
+		p.busy.Add(-1)
+		p.processed.Add(1)
+	}
+}
+
+// SubmitInform Queue an Inform, false when the queue is full and the overload policy rejects it
+func (a *Application) SubmitInform(ctx context.Context, task InformTask) bool {
+	p := a.informPool
+	if p == nil {
+		return false
+	}
+	p.lock.RLock()
+	defer p.lock.RUnlock()
+	if p.closed {
+		return false
+	}
+	if a.appConfig.Tr069.InformOverload == InformOverloadWait {
+		select {
+		case p.tasks <- task:
+			return true
+		case <-ctx.Done():
+		}
+	} else {
+		select {
+		case p.tasks <- task:
+			return true
+		default:
+		}
+	}
+	p.rejected.Add(1)
+	return false
+}
+
+// InformRetryAfter Retry-After seconds of the Informs rejected on overload
+func (a *Application) InformRetryAfter() int {
+	if a.appConfig.Tr069.InformRetryAfter <= 0 {
+		return 60
+	}
+	return a.appConfig.Tr069.InformRetryAfter
+}
+
+// InformPoolStats Current state of the Inform worker pool
+func (a *Application) InformPoolStats() InformPoolStats {
+	p := a.informPool
+	if p == nil {
+		return InformPoolStats{}
+	}
+	return InformPoolStats{
+		Workers:   p.workers,
+		Busy:      p.busy.Load(),
+		Queued:    len(p.tasks),
+		Capacity:  cap(p.tasks),
+		Processed: p.processed.Load(),
+		Rejected:  p.rejected.Load(),
+	}
+}
+
+// stopInformPool Process the queued Informs before the shutdown
+func (a *Application) stopInformPool(timeout time.Duration) {
+	p := a.informPool
+	if p == nil {
+		return
+	}
+	p.lock.Lock()
+	if !p.closed {
+		p.closed = true
+		close(p.tasks)
+	}
+	p.lock.Unlock()
+	done := make(chan struct{})
+	go func() {
+		p.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(timeout):
+		log.Errorf("inform worker pool stop timeout, %d informs not processed", len(p.tasks))
+	}
+}
@@ -0,0 +1,55 @@
+package app
+
+import (
+	"fmt"
+
+	"github.com/ca17/teamsacs/common/cwmp"
+	"github.com/ca17/teamsacs/models"
+)
+
+// parameterType Returns the reported xsd type, falling back to the schema definition
+func (a *Application) parameterType(name, reported string) string {
+	if reported != "" {
+		return reported
+	}
+	if d := DataModelSchema().Lookup(name); d != nil && d.Type != "" {
+		return cwmp.NormalizeType(d.Type)
+	}
+	return ""
+}
+
+// CheckParameterValue Validate a write against the stored type and writability
+// of the parameter and returns the type to send in SetParameterValues.
+// The type reported by the device wins over the schema and the requested type.
+func (c *CwmpCpe) CheckParameterValue(name, vtype, value string) (string, error) {
+	var param models.NetCpeParam
+	app.gormDB.Where("sn = ? and name = ?", c.Sn, name).Limit(1).Find(&param)
+	switch param.Writable {
+	case "false", "0":
+		return "", fmt.Errorf("parameter %s is read only", name)
+	}
+	ptype := param.Type
+	if ptype == "" {
+		ptype = app.parameterType(name, "")
+	}
+	if ptype == "" {
+		ptype = vtype
+	}
+	ptype = cwmp.NormalizeType(ptype)
+	if err := cwmp.ValidateValue(ptype, value); err != nil {
+		return "", fmt.Errorf("parameter %s: %s", name, err)
+	}
+	return ptype, nil
+}
+
+// PrepareParameterValues Validate SetParameterValues params and fill in the correct types
+func (c *CwmpCpe) PrepareParameterValues(params map[string]cwmp.ValueStruct) error {
+	for name, v := range params {
+		ptype, err := c.CheckParameterValue(name, v.Type, v.Value)
+		if err != nil {
+			return err
+		}
+		params[name] = cwmp.ValueStruct{Type: ptype, Value: v.Value}
+	}
+	return nil
+}
@@ -28,6 +28,7 @@ func (c *CwmpCpe) GetLatestCwmpPresetTask() (*models.CwmpPresetTask, error) {
 		return nil, err
 	}
 	app.gormDB.Model(&task).Update("status", "running")
+	c.trackPresetTask(&task)
 	return &task, nil
 }
 
@@ -114,7 +115,7 @@ func (c *CwmpCpe) CreateCwmpPresetEventTask(event string, pid string) (err error
 	batch := common.UUID()
 
 	for _, preset := range presets {
-		if !c.MatchTaskTags(preset.TaskTags) {
+		if !c.MatchTaskTags(preset.TaskTags) || !c.MatchDeviceGroup(preset.DeviceGroup) {
 			continue
 		}
 		var content models.CwmpPresetContent
@@ -192,7 +193,8 @@ func (c *CwmpCpe) creatFactoryResetConfigDownloadTask(presetId int64, freset *mo
 		return err
 	}
 
-	if !c.MatchDevice(fconfig.Oui, fconfig.ProductClass, fconfig.SoftwareVersion) {
+	if !c.MatchDevice(fconfig.Oui, fconfig.ProductClass, fconfig.SoftwareVersion) ||
+		!c.MatchDeviceGroup(fconfig.DeviceGroup) {
 		return fmt.Errorf("device not match CwmpFactoryResetConfig")
 	}
 
@@ -249,7 +251,8 @@ func (c *CwmpCpe) creatFirmwareConfigDownloadTask(presetId int64, fconfig *model
 		return err
 	}
 
-	if !c.MatchDevice(firmwareCfg.Oui, firmwareCfg.ProductClass, firmwareCfg.SoftwareVersion) {
+	if !c.MatchDevice(firmwareCfg.Oui, firmwareCfg.ProductClass, firmwareCfg.SoftwareVersion) ||
+		!c.MatchDeviceGroup(firmwareCfg.DeviceGroup) {
 		return fmt.Errorf("device not match CwmpFirmwareConfig")
 	}
 
@@ -304,7 +307,8 @@ func (c *CwmpCpe) creatDownloadTask(presetId int64, download models.CwmpPresetDo
 		return err
 	}
 
-	if !c.MatchDevice(script.Oui, script.ProductClass, script.SoftwareVersion) {
+	if !c.MatchDevice(script.Oui, script.ProductClass, script.SoftwareVersion) ||
+		!c.MatchDeviceGroup(script.DeviceGroup) {
 		return fmt.Errorf("device not match CwmpConfig")
 	}
 
@@ -395,11 +399,35 @@ func (c *CwmpCpe) creatUploadTask(presetId int64, upload models.CwmpPresetUpload
 func (c *CwmpCpe) creatSetParameterValuesTask(presetId int64, values []models.CwmpPresetParameterValue, batch, event string) error {
 	params := map[string]cwmp.ValueStruct{}
 	for _, value := range values {
-		params[value.Name] = cwmp.ValueStruct{
-			Type:  "xsd:" + value.Type,
+		var err error
+		name, vtype := value.Name, value.Type
+		if IsVirtualParam(name) {
+			path, ptype, err := c.ResolveVirtualParamSet(name)
+			if err != nil {
+				log.Errorf("creatSetParameterValuesTask: %s", err)
+				continue
+			}
+			name = path
+			if vtype == "" {
+				vtype = ptype
+			}
+		} else if name, err = c.MapPath(name); err != nil {
+			log.Errorf("creatSetParameterValuesTask: %s", err)
+			continue
+		}
+		ptype, err := c.CheckParameterValue(name, vtype, value.Value)
+		if err != nil {
+			log.Errorf("creatSetParameterValuesTask: %s", err)
+			continue
+		}
+		params[name] = cwmp.ValueStruct{
+			Type:  ptype,
 			Value: value.Value,
 		}
 	}
+	if len(params) == 0 {
+		return nil
+	}
 	session := "PresetTask-" + common.UUID()
 	msg := &cwmp.SetParameterValues{
 		ID:     session,
@@ -429,6 +457,25 @@ func (c *CwmpCpe) creatSetParameterValuesTask(presetId int64, values []models.Cw
 
 // 获取参数值任务
 func (c *CwmpCpe) creatGetParameterValuesTask(names []string) error {
+	var paramNames = make([]string, 0, len(names))
+	for _, name := range names {
+		var err error
+		if IsVirtualParam(name) {
+			path, err := c.ResolveVirtualParamGet(name)
+			if err != nil {
+				log.Errorf("creatGetParameterValuesTask: %s", err)
+				continue
+			}
+			name = path
+		} else if name, err = c.MapPath(name); err != nil {
+			log.Errorf("creatGetParameterValuesTask: %s", err)
+			continue
+		}
+		paramNames = append(paramNames, name)
+	}
+	if len(paramNames) == 0 {
+		return nil
+	}
 	session := common.UUID()
 	err := c.SendCwmpEventData(models.CwmpEventData{
 		Session: session,
@@ -436,7 +483,7 @@ func (c *CwmpCpe) creatGetParameterValuesTask(names []string) error {
 		Message: &cwmp.GetParameterValues{
 			ID:             session,
 			NoMore:         0,
-			ParameterNames: names,
+			ParameterNames: paramNames,
 		},
 	}, 30000, false)
 	return err
@@ -492,12 +539,14 @@ func UpdateCwmpPresetTaskStatus(msg cwmp.Message) (err error) {
 			return
 		}
 
+		status := common.If(tc.FaultCode == 0, "success", "failure").(string)
 		err = app.gormDB.Model(&models.CwmpPresetTask{}).Where("session = ?", tc.CommandKey).Updates(map[string]interface{}{
-			"status":    common.If(tc.FaultCode == 0, "success", "failure"),
+			"status":    status,
 			"response":  string(tc.CreateXML()),
 			"exec_time": tc.StartTime,
 			"resp_time": tc.CompleteTime,
 		}).Error
+		PromPresetTasks.WithLabelValues(status).Inc()
 
 		if tc.FaultCode > 0 && task.Batch != "" && task.Onfail == "cancel" {
 			err = app.gormDB.Model(&models.CwmpPresetTask{}).
@@ -511,11 +560,15 @@ func UpdateCwmpPresetTaskStatus(msg cwmp.Message) (err error) {
 		sm := msg.(*cwmp.SetParameterValuesResponse)
 		if strings.HasPrefix(msg.GetID(), "PresetTask") {
 			// 尝试更新预设任务状态
-			_ = app.gormDB.Model(&models.CwmpPresetTask{}).Where("session = ?", sm.GetID()).Updates(map[string]interface{}{
-				"status":    common.If(sm.Status == 0, "success", "failure"),
+			status := common.If(sm.Status == 0, "success", "failure").(string)
+			result := app.gormDB.Model(&models.CwmpPresetTask{}).Where("session = ?", sm.GetID()).Updates(map[string]interface{}{
+				"status":    status,
 				"response":  string(sm.CreateXML()),
 				"resp_time": time.Now(),
 			})
+			if result.RowsAffected > 0 {
+				PromPresetTasks.WithLabelValues(status).Inc()
+			}
 		}
 	}
 	return
@@ -27,7 +27,7 @@ func (c *CwmpCpe) CreateCwmpSchedEventTask(schedKey string) (err error) {
 	batch := common.UUID()
 
 	for _, preset := range presets {
-		if !c.MatchTaskTags(preset.TaskTags) {
+		if !c.MatchTaskTags(preset.TaskTags) || !c.MatchDeviceGroup(preset.DeviceGroup) {
 			continue
 		}
 		var content models.CwmpPresetSched
@@ -105,7 +105,7 @@ func (c *CwmpCpe) ActiveCwmpSchedEventTask() (err error) {
 	}
 
 	for _, preset := range presets {
-		if !c.MatchTaskTags(preset.TaskTags) {
+		if !c.MatchTaskTags(preset.TaskTags) || !c.MatchDeviceGroup(preset.DeviceGroup) {
 			continue
 		}
 		var content models.CwmpPresetSched
@@ -156,7 +156,7 @@ func CreateCwmpScheduledTask(schedPolicy string) (err error) {
 		batch := common.UUID()
 
 		for _, preset := range schedPresets {
-			if !c.MatchTaskTags(preset.TaskTags) {
+			if !c.MatchTaskTags(preset.TaskTags) || !c.MatchDeviceGroup(preset.DeviceGroup) {
 				continue
 			}
 			var content models.CwmpPresetSched
@@ -0,0 +1,88 @@
+package app
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/ca17/teamsacs/common"
+	"github.com/ca17/teamsacs/common/cwmp"
+	"github.com/ca17/teamsacs/common/datamodel"
+	"github.com/ca17/teamsacs/common/zaplog/log"
+	"github.com/ca17/teamsacs/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// StartSession A CPE opened a CWMP session with an Inform. The Inform is saved in the
+// database so that the next requests of the session can be served by any ACS instance,
+// the returned session ID is sent to the CPE in a cookie.
+func (c *CwmpCpe) StartSession(msg *cwmp.Inform) string {
+	session := common.UUID()
+	c.sessionLock.Lock()
+	c.LastInform = msg
+	c.session = session
+	c.sessionLock.Unlock()
+	data, err := json.Marshal(msg)
+	if err != nil {
+		log.Errorf("StartSession %s: %s", c.Sn, err.Error())
+		return session
+	}
+	err = app.gormDB.Clauses(clause.OnConflict{UpdateAll: true}).Create(&models.CwmpCpeSession{
+		Sn:            c.Sn,
+		Session:       session,
+		Inform:        string(data),
+		DiscoveryRpcs: 0,
+		UpdatedAt:     time.Now(),
+	}).Error
+	if err != nil {
+		log.Errorf("StartSession %s: %s", c.Sn, err.Error())
+	}
+	return session
+}
+
+// SessionInform The Inform of the session, loaded from the database when the
+// session was opened on another ACS instance, nil when the session is unknown
+func (c *CwmpCpe) SessionInform(session string) *cwmp.Inform {
+	c.sessionLock.Lock()
+	defer c.sessionLock.Unlock()
+	if c.LastInform != nil && (session == "" || session == c.session) {
+		return c.LastInform
+	}
+	var item models.CwmpCpeSession
+	err := app.gormDB.Where("sn = ?", c.Sn).Limit(1).Find(&item).Error
+	if err != nil || item.Sn == "" || (session != "" && item.Session != session) {
+		return c.LastInform
+	}
+	var msg cwmp.Inform
+	if err = json.Unmarshal([]byte(item.Inform), &msg); err != nil {
+		log.Errorf("SessionInform %s: %s", c.Sn, err.Error())
+		return c.LastInform
+	}
+	c.LastInform = &msg
+	c.session = item.Session
+	if model := datamodel.DetectModel(msg.Params); model != "" {
+		c.setDataModel(model)
+	}
+	return c.LastInform
+}
+
+// takeDiscoveryRpc Count a discovery request of the session, false when the budget is used up
+func (c *CwmpCpe) takeDiscoveryRpc(budget int) bool {
+	result := app.gormDB.Model(&models.CwmpCpeSession{}).
+		Where("sn = ? and discovery_rpcs < ?", c.Sn, budget).
+		Update("discovery_rpcs", gorm.Expr("discovery_rpcs + 1"))
+	if result.Error != nil {
+		log.Errorf("takeDiscoveryRpc %s: %s", c.Sn, result.Error.Error())
+		return false
+	}
+	return result.RowsAffected > 0
+}
+
+// ClearCpeSessions Delete the session state of the CPEs without Inform for a day
+func (a *Application) ClearCpeSessions() {
+	err := a.gormDB.Where("updated_at < ?", time.Now().Add(-time.Hour*24)).
+		Delete(&models.CwmpCpeSession{}).Error
+	if err != nil {
+		log.Errorf("ClearCpeSessions: %s", err.Error())
+	}
+}
@@ -0,0 +1,110 @@
+package app
+
+import (
+	"net"
+	"net/url"
+	"strconv"
+	"sync"
+
+	"github.com/ca17/teamsacs/common/cwmp"
+	"github.com/ca17/teamsacs/common/stun"
+	"github.com/ca17/teamsacs/common/zaplog/log"
+	"github.com/ca17/teamsacs/models"
+)
+
+// stunBindings Last UDPConnectionRequestAddress saved per device, CPEs repeat
+// their Binding Requests every keepalive period and only changes are written
+var stunBindings sync.Map
+
+// CheckStunIntegrity Verify the MESSAGE-INTEGRITY of a Binding Request with the STUN
+// password of the device, requests without it are rejected
+func (a *Application) CheckStunIntegrity(sn string, data []byte) bool {
+	if sn == "" {
+		return false
+	}
+	for _, password := range a.connReqPasswords(sn) {
+		if password != "" && stun.CheckIntegrity(data, []byte(password)) {
+			return true
+		}
+	}
+	return false
+}
+
+// UpdateStunBinding Save the public address of a TR-111 Binding Request,
+// the STUN username is the device SN pushed by PushStunConfig
+func (a *Application) UpdateStunBinding(sn string, addr *net.UDPAddr, changed bool) {
+	if sn == "" {
+		return
+	}
+	address := addr.String()
+	if v, ok := stunBindings.Load(sn); ok && v.(string) == address && !changed {
+		return
+	}
+	result := a.gormDB.Model(&models.NetCpe{}).Where("sn = ?", sn).Update("udp_connreq_addr", address)
+	if result.Error != nil {
+		log.Errorf("UpdateStunBinding %s: %s", sn, result.Error.Error())
+		return
+	}
+	if result.RowsAffected == 0 {
+		log.Debugf("UpdateStunBinding: unknown device %s from %s", sn, address)
+		return
+	}
+	stunBindings.Store(sn, address)
+	log.Infof("UpdateStunBinding: device %s UDP connection request address %s", sn, address)
+}
+
+// stunServerAddress Host of the TR069 access address, CPEs reach the STUN server there
+func (a *Application) stunServerAddress() string {
+	u, err := url.Parse(a.GetTr069SettingsStringValue(ConfigTR069AccessAddress))
+	if err != nil {
+		return ""
+	}
+	return u.Hostname()
+}
+
+// PushStunConfig Enable TR-111 STUN on the device when CpeStunEnable is enabled,
+// sent apart from the management auth info as many CPEs do not support STUN.
+// The STUN password is the connection request password pushed to the device.
+func (c *CwmpCpe) PushStunConfig(session string, timeout int, hp bool) error {
+	if app.GetTr069SettingsStringValue(ConfigCpeStunEnable) != "enabled" || app.appConfig.Tr069.StunPort == 0 {
+		return nil
+	}
+	host := app.stunServerAddress()
+	if host == "" {
+		return nil
+	}
+	prefix := c.TranslatePath("Device.ManagementServer.")
+	params := map[string]cwmp.ValueStruct{
+		prefix + "STUNEnable": {
+			Type:  "xsd:boolean",
+			Value: "true",
+		},
+		prefix + "STUNServerAddress": {
+			Type:  "xsd:string",
+			Value: host,
+		},
+		prefix + "STUNServerPort": {
+			Type:  "xsd:unsignedInt",
+			Value: strconv.Itoa(app.appConfig.Tr069.StunPort),
+		},
+		prefix + "STUNUsername": {
+			Type:  "xsd:string",
+			Value: c.Sn,
+		},
+		prefix + "STUNPassword": {
+			Type:  "xsd:string",
+			Value: app.connReqPasswords(c.Sn)[0],
+		},
+	}
+
+	return c.SendCwmpEventData(models.CwmpEventData{
+		Session: session,
+		Sn:      c.Sn,
+		Message: &cwmp.SetParameterValues{
+			ID:     session,
+			Name:   "",
+			NoMore: 0,
+			Params: params,
+		},
+	}, timeout, hp)
+}
@@ -0,0 +1,472 @@
+package app
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ca17/teamsacs/common"
+	"github.com/ca17/teamsacs/models"
+)
+
+const (
+	VirtualParamPrefix = "VirtualParameters."
+	virtualParamIndex  = "{i}"
+	virtualParamMaxIdx = 16
+
+	// ontVendors Manufacturer keywords of the ONTs exposing the PON parameters
+	ontVendors = "zte,huawei,fiberhome,an5506,nokia,alcatel,bdcom,cdtc,cdata"
+)
+
+// vparam defines a builtin virtual parameter, written once into the database
+func vparam(name, manufacturer, vtype string, getPaths, setPaths []string) models.CwmpVirtualParam {
+	return models.CwmpVirtualParam{
+		Name:         VirtualParamPrefix + name,
+		Manufacturer: manufacturer,
+		ProductClass: "any",
+		GetPaths:     strings.Join(getPaths, "\n"),
+		SetPaths:     strings.Join(setPaths, "\n"),
+		ValueType:    vtype,
+		Remark:       "builtin",
+	}
+}
+
+// defaultVirtualParams Builtin virtual parameters, new vendors are added as data
+var defaultVirtualParams = []models.CwmpVirtualParam{
+	// Device information
+	vparam("ConnectionRequestURL", "any", "xsd:string", []string{
+		"Device.ManagementServer.ConnectionRequestURL",
+		"InternetGatewayDevice.ManagementServer.ConnectionRequestURL",
+	}, nil),
+	vparam("SoftwareVersion", "any", "xsd:string", []string{
+		"Device.DeviceInfo.SoftwareVersion",
+		"InternetGatewayDevice.DeviceInfo.SoftwareVersion",
+	}, nil),
+	vparam("HardwareVersion", "any", "xsd:string", []string{
+		"Device.DeviceInfo.HardwareVersion",
+		"InternetGatewayDevice.DeviceInfo.HardwareVersion",
+	}, nil),
+	vparam("ModelName", "any", "xsd:string", []string{
+		"Device.DeviceInfo.ModelName",
+		"InternetGatewayDevice.DeviceInfo.ModelName",
+	}, nil),
+	vparam("SystemName", "any", "xsd:string", []string{
+		"Device.DeviceInfo.ModelName",
+		"InternetGatewayDevice.DeviceInfo.ModelName",
+	}, nil),
+	vparam("SystemName", "mikrotik", "xsd:string", []string{
+		"Device.DeviceInfo.X_MIKROTIK_SystemIdentity",
+	}, nil),
+	vparam("ArchName", "mikrotik", "xsd:string", []string{
+		"Device.DeviceInfo.X_MIKROTIK_ArchName",
+	}, nil),
+	vparam("Uptime", "any", "xsd:unsignedInt", []string{
+		"Device.DeviceInfo.UpTime",
+		"InternetGatewayDevice.DeviceInfo.UpTime",
+	}, nil),
+	vparam("CpuUsage", "any", "xsd:unsignedInt", []string{
+		"Device.DeviceInfo.ProcessStatus.CPUUsage",
+		"InternetGatewayDevice.DeviceInfo.ProcessStatus.CPUUsage",
+		"InternetGatewayDevice.DeviceInfo.X_CMS_CPUUsage",
+	}, nil),
+	vparam("MemoryTotal", "any", "xsd:unsignedInt", []string{
+		"Device.DeviceInfo.MemoryStatus.Total",
+		"InternetGatewayDevice.DeviceInfo.MemoryStatus.Total",
+	}, nil),
+	vparam("MemoryFree", "any", "xsd:unsignedInt", []string{
+		"Device.DeviceInfo.MemoryStatus.Free",
+		"InternetGatewayDevice.DeviceInfo.MemoryStatus.Free",
+	}, nil),
+	// Optical
+	vparam("RxPower", ontVendors, "xsd:int", []string{
+		"Device.Optical.Interface.1.RxPower",
+		"InternetGatewayDevice.DeviceInfo.XponInterface.RXPower",
+		"InternetGatewayDevice.WANDevice.1.X_ZTE-COM_WANPONInterfaceConfig.RXPower",
+		"InternetGatewayDevice.WANDevice.2.X_ZTE-COM_WANPONInterfaceConfig.RXPower",
+	}, nil),
+	vparam("TxPower", ontVendors, "xsd:int", []string{
+		"Device.Optical.Interface.1.TxPower",
+		"InternetGatewayDevice.DeviceInfo.XponInterface.TXPower",
+		"InternetGatewayDevice.WANDevice.1.X_ZTE-COM_WANPONInterfaceConfig.TXPower",
+		"InternetGatewayDevice.WANDevice.2.X_ZTE-COM_WANPONInterfaceConfig.TXPower",
+	}, nil),
+	vparam("PonSerialNumber", ontVendors, "xsd:string", []string{
+		"Device.DeviceInfo.SerialNumber",
+		"InternetGatewayDevice.DeviceInfo.SerialNumber",
+	}, nil),
+	vparam("OltUplink", ontVendors, "xsd:string", []string{
+		"Device.Optical.Interface.1.UpperLayers",
+		"InternetGatewayDevice.DeviceInfo.XponInterface.OLTInfo",
+	}, nil),
+	// WiFi
+	vparam("WifiSSID.{i}", "any", "xsd:string", []string{
+		"InternetGatewayDevice.LANDevice.1.WLANConfiguration.{i}.SSID",
+		"Device.WiFi.SSID.{i}.SSID",
+	}, []string{
+		"InternetGatewayDevice.LANDevice.1.WLANConfiguration.{i}.SSID",
+		"Device.WiFi.SSID.{i}.SSID",
+	}),
+	vparam("WifiPassword.{i}", "any", "xsd:string", []string{
+		"InternetGatewayDevice.LANDevice.1.WLANConfiguration.{i}.KeyPassphrase",
+		"InternetGatewayDevice.LANDevice.1.WLANConfiguration.{i}.PreSharedKey.1.KeyPassphrase",
+		"Device.WiFi.AccessPoint.{i}.Security.KeyPassphrase",
+	}, []string{
+		"InternetGatewayDevice.LANDevice.1.WLANConfiguration.{i}.KeyPassphrase",
+		"Device.WiFi.AccessPoint.{i}.Security.KeyPassphrase",
+	}),
+	vparam("WifiEnable.{i}", "any", "xsd:boolean", []string{
+		"InternetGatewayDevice.LANDevice.1.WLANConfiguration.{i}.Enable",
+		"Device.WiFi.SSID.{i}.Enable",
+	}, []string{
+		"InternetGatewayDevice.LANDevice.1.WLANConfiguration.{i}.Enable",
+		"Device.WiFi.SSID.{i}.Enable",
+	}),
+	// TR-181 channels belong to the radio, the SSID index is taken as the radio index
+	vparam("WifiChannel.{i}", "any", "xsd:unsignedInt", []string{
+		"InternetGatewayDevice.LANDevice.1.WLANConfiguration.{i}.Channel",
+		"InternetGatewayDevice.LANDevice.1.WLANConfiguration.{i}.ChannelsInUse",
+		"Device.WiFi.Radio.{i}.Channel",
+	}, []string{
+		"InternetGatewayDevice.LANDevice.1.WLANConfiguration.{i}.Channel",
+		"Device.WiFi.Radio.{i}.Channel",
+	}),
+	vparam("WifiAutoChannel.{i}", "any", "xsd:boolean", []string{
+		"InternetGatewayDevice.LANDevice.1.WLANConfiguration.{i}.AutoChannelEnable",
+		"Device.WiFi.Radio.{i}.AutoChannelEnable",
+	}, []string{
+		"InternetGatewayDevice.LANDevice.1.WLANConfiguration.{i}.AutoChannelEnable",
+		"Device.WiFi.Radio.{i}.AutoChannelEnable",
+	}),
+	// TR-181 uses Security.ModeEnabled with other values, no mapping
+	vparam("WifiBeaconType.{i}", "any", "xsd:string", []string{
+		"InternetGatewayDevice.LANDevice.1.WLANConfiguration.{i}.BeaconType",
+	}, []string{
+		"InternetGatewayDevice.LANDevice.1.WLANConfiguration.{i}.BeaconType",
+	}),
+	// WAN
+	vparam("PPPoEUsername", "any", "xsd:string", []string{
+		"InternetGatewayDevice.WANDevice.1.WANConnectionDevice.1.WANPPPConnection.1.Username",
+		"Device.PPP.Interface.1.Username",
+	}, []string{
+		"InternetGatewayDevice.WANDevice.1.WANConnectionDevice.1.WANPPPConnection.1.Username",
+		"Device.PPP.Interface.1.Username",
+	}),
+	vparam("PPPoEPassword", "any", "xsd:string", []string{
+		"InternetGatewayDevice.WANDevice.1.WANConnectionDevice.1.WANPPPConnection.1.Password",
+		"Device.PPP.Interface.1.Password",
+	}, []string{
+		"InternetGatewayDevice.WANDevice.1.WANConnectionDevice.1.WANPPPConnection.1.Password",
+		"Device.PPP.Interface.1.Password",
+	}),
+	vparam("WanVlanId", "any", "xsd:unsignedInt", []string{
+		"InternetGatewayDevice.WANDevice.1.WANConnectionDevice.1.X_CT-COM_WANGponLinkConfig.VLANIDMark",
+		"Device.Ethernet.VLANTermination.1.VLANID",
+	}, []string{
+		"InternetGatewayDevice.WANDevice.1.WANConnectionDevice.1.X_CT-COM_WANGponLinkConfig.VLANIDMark",
+		"Device.Ethernet.VLANTermination.1.VLANID",
+	}),
+	// ONT web credentials, CDATA/CDTC and other TR-098 devices use X_CT-COM_TeleComAccount
+	vparam("WebAdminUsername", "any", "xsd:string", nil, []string{
+		"InternetGatewayDevice.DeviceInfo.X_CT-COM_TeleComAccount.Username",
+	}),
+	vparam("WebAdminPassword", "any", "xsd:string", nil, []string{
+		"InternetGatewayDevice.DeviceInfo.X_CT-COM_TeleComAccount.Password",
+	}),
+	// ZTE only supports X_ZTE-COM_UserInterface paths
+	vparam("WebAdminUsername", "zte", "xsd:string", nil, nil),
+	vparam("WebAdminPassword", "zte", "xsd:string", nil, []string{
+		"InternetGatewayDevice.X_ZTE-COM_UserInterface.X_ZTE-COM_WebUserInfo.AdminPassword",
+	}),
+	vparam("WebUserUsername", "zte", "xsd:string", nil, []string{
+		"InternetGatewayDevice.X_ZTE-COM_UserInterface.X_ZTE-COM_WebUserInfo.UserName",
+	}),
+	vparam("WebUserPassword", "zte", "xsd:string", nil, []string{
+		"InternetGatewayDevice.X_ZTE-COM_UserInterface.X_ZTE-COM_WebUserInfo.UserPassword",
+	}),
+}
+
+// VirtualParamValue A resolved virtual parameter of one device
+type VirtualParamValue struct {
+	Name    string `json:"name"`
+	Value   string `json:"value"`
+	Path    string `json:"path"`
+	SetPath string `json:"set_path"`
+	Type    string `json:"type"`
+}
+
+type virtualParamCache struct {
+	sync.RWMutex
+	items  []models.CwmpVirtualParam
+	loaded bool
+}
+
+var vparamCache = &virtualParamCache{}
+
+// checkVirtualParams Sync the builtin virtual parameters with the database: missing ones
+// are written, the ones no longer defined are removed and the unedited ones follow the
+// definitions. Builtin rows edited by the admin are kept.
+func (a *Application) checkVirtualParams() {
+	var items []models.CwmpVirtualParam
+	a.gormDB.Find(&items)
+	key := func(vp models.CwmpVirtualParam) string {
+		return vp.Name + "|" + vp.Manufacturer + "|" + vp.ProductClass
+	}
+	existing := make(map[string]models.CwmpVirtualParam, len(items))
+	for _, vp := range items {
+		existing[key(vp)] = vp
+	}
+	builtins := make(map[string]bool, len(defaultVirtualParams))
+	for _, vp := range defaultVirtualParams {
+		builtins[key(vp)] = true
+		old, ok := existing[key(vp)]
+		switch {
+		case !ok:
+			vp.ID = common.UUIDint64()
+			vp.CreatedAt = time.Now()
+			vp.UpdatedAt = vp.CreatedAt
+			a.gormDB.Create(&vp)
+		case old.Remark == "builtin" && !old.Edited &&
+			(old.GetPaths != vp.GetPaths || old.SetPaths != vp.SetPaths || old.ValueType != vp.ValueType):
+			// written by an earlier sync and never edited, the definition changed
+			a.gormDB.Model(&models.CwmpVirtualParam{}).Where("id = ?", old.ID).UpdateColumns(map[string]interface{}{
+				"get_paths": vp.GetPaths, "set_paths": vp.SetPaths, "value_type": vp.ValueType,
+			})
+		}
+	}
+	for _, vp := range items {
+		if vp.Remark == "builtin" && !builtins[key(vp)] {
+			a.gormDB.Delete(&models.CwmpVirtualParam{}, vp.ID)
+		}
+	}
+	_ = a.ReloadVirtualParams()
+}
+
+// ReloadVirtualParams Reload the virtual parameter definitions from the database
+func (a *Application) ReloadVirtualParams() error {
+	var items []models.CwmpVirtualParam
+	err := a.gormDB.Order("priority asc").Find(&items).Error
+	if err != nil {
+		return err
+	}
+	vparamCache.Lock()
+	vparamCache.items = items
+	vparamCache.loaded = true
+	vparamCache.Unlock()
+	return nil
+}
+
+func (a *Application) virtualParams() []models.CwmpVirtualParam {
+	vparamCache.RLock()
+	loaded := vparamCache.loaded
+	vparamCache.RUnlock()
+	if !loaded {
+		_ = a.ReloadVirtualParams()
+	}
+	vparamCache.RLock()
+	defer vparamCache.RUnlock()
+	return vparamCache.items
+}
+
+// IsVirtualParam Determine whether the name is a virtual parameter
+func IsVirtualParam(name string) bool {
+	return strings.HasPrefix(name, VirtualParamPrefix)
+}
+
+// matchVirtualParamName matches name against a pattern that may contain {i}, returns the index
+func matchVirtualParamName(pattern, name string) (string, bool) {
+	pos := strings.Index(pattern, virtualParamIndex)
+	if pos < 0 {
+		return "", pattern == name
+	}
+	prefix, suffix := pattern[:pos], pattern[pos+len(virtualParamIndex):]
+	if !strings.HasPrefix(name, prefix) || !strings.HasSuffix(name, suffix) ||
+		len(name) <= len(prefix)+len(suffix) {
+		return "", false
+	}
+	idx := name[len(prefix) : len(name)-len(suffix)]
+	if _, err := strconv.Atoi(idx); err != nil {
+		return "", false
+	}
+	return idx, true
+}
+
+// matchVendorScore returns -1 if not matched, otherwise the specificity of the match
+func matchVendorScore(vp *models.CwmpVirtualParam, manufacturer, productClass string) int {
+	var score int
+	if !common.InSlice(vp.Manufacturer, []string{"", "any", "N/A", "all"}) {
+		var matched bool
+		m := strings.ToLower(manufacturer)
+		for _, kw := range strings.Split(vp.Manufacturer, ",") {
+			kw = strings.ToLower(strings.TrimSpace(kw))
+			if kw != "" && strings.Contains(m, kw) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return -1
+		}
+		score += 2
+	}
+	if !common.InSlice(vp.ProductClass, []string{"", "any", "N/A", "all"}) {
+		if !common.InSlice(productClass, strings.Split(vp.ProductClass, ",")) {
+			return -1
+		}
+		score += 1
+	}
+	return score
+}
+
+func splitVirtualParamPaths(paths, idx string) []string {
+	var result []string
+	for _, p := range strings.Split(paths, "\n") {
+		p = strings.TrimSpace(p)
+		if p == "" {
+			continue
+		}
+		result = append(result, strings.ReplaceAll(p, virtualParamIndex, idx))
+	}
+	return result
+}
+
+// lookupVirtualParam Find the most specific definition of a virtual parameter for the device
+func (c *CwmpCpe) lookupVirtualParam(name string) (*models.CwmpVirtualParam, string, bool) {
+	var found *models.CwmpVirtualParam
+	var foundIdx string
+	var foundScore = -1
+	items := app.virtualParams()
+	for i := range items {
+		idx, ok := matchVirtualParamName(items[i].Name, name)
+		if !ok {
+			continue
+		}
+		score := matchVendorScore(&items[i], c.Manufacturer, c.ProductClass)
+		// items are sorted by priority, the first one wins on equal score
+		if score > foundScore {
+			found, foundIdx, foundScore = &items[i], idx, score
+		}
+	}
+	return found, foundIdx, found != nil
+}
+
+// GetVirtualParamValue Read a virtual parameter value from the params map
+func (c *CwmpCpe) GetVirtualParamValue(name string, params map[string]string) string {
+	_, value := c.getVirtualParamValue(name, params)
+	return value
+}
+
+func (c *CwmpCpe) getVirtualParamValue(name string, params map[string]string) (string, string) {
+	vp, idx, ok := c.lookupVirtualParam(name)
+	if !ok {
+		return "", ""
+	}
+	for _, path := range splitVirtualParamPaths(vp.GetPaths, idx) {
+		if v, ok := params[path]; ok && v != "" {
+			return path, v
+		}
+	}
+	return "", ""
+}
+
+// ResolveVirtualParamSet Resolve the writable path and value type of a virtual parameter
+func (c *CwmpCpe) ResolveVirtualParamSet(name string) (string, string, error) {
+	vp, idx, ok := c.lookupVirtualParam(name)
+	if !ok {
+		return "", "", fmt.Errorf("virtual parameter %s not defined", name)
+	}
+	root := c.DataModelRoot()
+	for _, path := range splitVirtualParamPaths(vp.SetPaths, idx) {
+		if root == "" || strings.HasPrefix(path, root) {
+			return path, vp.ValueType, nil
+		}
+	}
+	return "", "", fmt.Errorf("virtual parameter %s not supported by %s %s", name, c.Manufacturer, c.ProductClass)
+}
+
+// ResolveVirtualParamGet Resolve the read path of a virtual parameter,
+// a path already known for the device is preferred
+func (c *CwmpCpe) ResolveVirtualParamGet(name string) (string, error) {
+	vp, idx, ok := c.lookupVirtualParam(name)
+	if !ok {
+		return "", fmt.Errorf("virtual parameter %s not defined", name)
+	}
+	root := c.DataModelRoot()
+	var candidates []string
+	for _, path := range splitVirtualParamPaths(vp.GetPaths, idx) {
+		if root == "" || strings.HasPrefix(path, root) {
+			candidates = append(candidates, path)
+		}
+	}
+	if len(candidates) == 0 {
+		return "", fmt.Errorf("virtual parameter %s not supported by %s %s", name, c.Manufacturer, c.ProductClass)
+	}
+	var known []string
+	app.gormDB.Model(&models.NetCpeParam{}).
+		Where("sn = ? and name in ?", c.Sn, candidates).Pluck("name", &known)
+	for _, path := range candidates {
+		if common.InSlice(path, known) {
+			return path, nil
+		}
+	}
+	return candidates[0], nil
+}
+
+// ResolveVirtualParams Resolve all virtual parameters of the device from the params map
+func (c *CwmpCpe) ResolveVirtualParams(params map[string]string) []VirtualParamValue {
+	var names []string
+	for _, vp := range app.virtualParams() {
+		if !strings.Contains(vp.Name, virtualParamIndex) {
+			if !common.InSlice(vp.Name, names) {
+				names = append(names, vp.Name)
+			}
+			continue
+		}
+		for i := 1; i <= virtualParamMaxIdx; i++ {
+			name := strings.ReplaceAll(vp.Name, virtualParamIndex, strconv.Itoa(i))
+			if !common.InSlice(name, names) {
+				names = append(names, name)
+			}
+		}
+	}
+	sort.Strings(names)
+	var result = make([]VirtualParamValue, 0)
+	for _, name := range names {
+		vp, _, ok := c.lookupVirtualParam(name)
+		if !ok {
+			continue
+		}
+		path, value := c.getVirtualParamValue(name, params)
+		setPath, _, _ := c.ResolveVirtualParamSet(name)
+		if path == "" && (strings.Contains(vp.Name, virtualParamIndex) || setPath == "") {
+			continue
+		}
+		result = append(result, VirtualParamValue{
+			Name:    name,
+			Value:   value,
+			Path:    path,
+			SetPath: setPath,
+			Type:    vp.ValueType,
+		})
+	}
+	return result
+}
+
+// GetCpeParamMap Returns all known parameters of the device
+func (a *Application) GetCpeParamMap(sn string) map[string]string {
+	var params []models.NetCpeParam
+	a.gormDB.Where("sn = ?", sn).Find(&params)
+	var result = make(map[string]string, len(params))
+	for _, p := range params {
+		result[p.Name] = p.Value
+	}
+	cpe := a.cwmpTable.GetCwmpCpe(sn)
+	if cpe.LastInform != nil {
+		for k, v := range cpe.LastInform.Params {
+			result[k] = v
+		}
+	}
+	return result
+}
@@ -0,0 +1,229 @@
+package app
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ca17/teamsacs/common/zaplog/log"
+	"github.com/ca17/teamsacs/models"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	cpeWriterInterval  = time.Second
+	cpeWriterMaxParams = 10000
+	cpeWriterBatchSize = 1000
+)
+
+// cpeWriter Batches the database writes of the Informs: the NetCpe updates of a
+// device are merged until the next flush and the parameters are upserted in bulk
+type cpeWriter struct {
+	lock    sync.Mutex
+	updates map[string]map[string]interface{}
+	params  map[string]paramChange
+	flushMu sync.Mutex
+	kick    chan struct{}
+	stop    chan struct{}
+	done    chan struct{}
+}
+
+func (a *Application) startCpeWriter() {
+	a.cpeWriter = &cpeWriter{
+		updates: make(map[string]map[string]interface{}),
+		params:  make(map[string]paramChange),
+		kick:    make(chan struct{}, 1),
+		stop:    make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+	go a.cpeWriterLoop()
+}
+
+func (a *Application) cpeWriterLoop() {
+	w := a.cpeWriter
+	defer close(w.done)
+	ticker := time.NewTicker(cpeWriterInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-w.stop:
+			a.flushCpeWriter()
+			return
+		case <-ticker.C:
+			a.flushCpeWriter()
+		case <-w.kick:
+			a.flushCpeWriter()
+		}
+	}
+}
+
+// stopCpeWriter Write the pending updates
+func (a *Application) stopCpeWriter() {
+	if a.cpeWriter == nil {
+		return
+	}
+	close(a.cpeWriter.stop)
+	<-a.cpeWriter.done
+}
+
+// QueueCpeUpdate Update NetCpe columns at the next flush, later values win
+func (a *Application) QueueCpeUpdate(sn string, valmap map[string]interface{}) {
+	w := a.cpeWriter
+	if w == nil {
+		a.gormDB.Model(&models.NetCpe{}).Where("sn = ?", sn).Updates(valmap)
+		return
+	}
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	vals, ok := w.updates[sn]
+	if !ok {
+		vals = make(map[string]interface{}, len(valmap))
+		w.updates[sn] = vals
+	}
+	for k, v := range valmap {
+		vals[k] = v
+	}
+}
+
+// QueueCpeParams Record the value changes and upsert the parameters at the next flush
+func (a *Application) QueueCpeParams(changes []paramChange) {
+	w := a.cpeWriter
+	if w == nil {
+		a.saveCpeParams(changes)
+		return
+	}
+	w.lock.Lock()
+	for _, c := range changes {
+		w.params[c.ID] = c
+	}
+	full := len(w.params) >= cpeWriterMaxParams
+	w.lock.Unlock()
+	if full {
+		select {
+		case w.kick <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// CpeWriterPending Number of devices and parameters waiting for the flush
+func (a *Application) CpeWriterPending() (int, int) {
+	w := a.cpeWriter
+	if w == nil {
+		return 0, 0
+	}
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	return len(w.updates), len(w.params)
+}
+
+func (a *Application) flushCpeWriter() {
+	w := a.cpeWriter
+	w.flushMu.Lock()
+	defer w.flushMu.Unlock()
+
+	w.lock.Lock()
+	updates, params := w.updates, w.params
+	w.updates = make(map[string]map[string]interface{})
+	w.params = make(map[string]paramChange)
+	w.lock.Unlock()
+
+	// the online refresh of most Informs is a single statement per batch,
+	// each device keeps the time of its own Inform
+	var online []onlineUpdate
+	for sn, vals := range updates {
+		if isOnlineUpdate(vals) {
+			online = append(online, onlineUpdate{sn: sn, lastInform: vals["cwmp_last_inform"]})
+			continue
+		}
+		a.writeCpeUpdate(sn, vals)
+	}
+	for i := 0; i < len(online); i += cpeWriterBatchSize {
+		a.writeOnlineUpdates(online[i:min(i+cpeWriterBatchSize, len(online))])
+	}
+
+	if len(params) > 0 {
+		var changes = make([]paramChange, 0, len(params))
+		for _, c := range params {
+			changes = append(changes, c)
+		}
+		a.saveCpeParams(changes)
+	}
+}
+
+// FlushCpeUpdate Write the pending NetCpe update of a device now, used when the
+// following steps read the device record (bootstrap, first registration)
+func (a *Application) FlushCpeUpdate(sn string) {
+	w := a.cpeWriter
+	if w == nil {
+		return
+	}
+	// wait for a flush in progress, it may hold an older update of the device
+	w.flushMu.Lock()
+	defer w.flushMu.Unlock()
+	w.lock.Lock()
+	vals, ok := w.updates[sn]
+	delete(w.updates, sn)
+	w.lock.Unlock()
+	if ok {
+		a.writeCpeUpdate(sn, vals)
+	}
+}
+
+func (a *Application) writeCpeUpdate(sn string, vals map[string]interface{}) {
+	if err := a.gormDB.Model(&models.NetCpe{}).Where("sn = ?", sn).Updates(vals).Error; err != nil {
+		log.Errorf("flush cpe %s update error: %s", sn, err.Error())
+	}
+}
+
+type onlineUpdate struct {
+	sn         string
+	lastInform interface{}
+}
+
+// writeOnlineUpdates Set the devices online with their own last Inform time
+func (a *Application) writeOnlineUpdates(batch []onlineUpdate) {
+	var values = make([]string, 0, len(batch))
+	var args = make([]interface{}, 0, len(batch)*2)
+	for _, u := range batch {
+		values = append(values, "(?, ?::timestamptz)")
+		args = append(args, u.sn, u.lastInform)
+	}
+	err := a.gormDB.Exec("update net_cpe set cwmp_status = 'online', cwmp_last_inform = v.last_inform from (values "+
+		strings.Join(values, ",")+") as v(sn, last_inform) where net_cpe.sn = v.sn", args...).Error
+	if err != nil {
+		log.Errorf("flush cpe online update error: %s", err.Error())
+	}
+}
+
+// saveCpeParams Record the value changes then upsert the parameters
+func (a *Application) saveCpeParams(changes []paramChange) {
+	a.recordParamHistory(changes)
+	var items = make([]models.NetCpeParam, 0, len(changes))
+	for _, c := range changes {
+		items = append(items, c.NetCpeParam)
+	}
+	a.upsertCpeParams(items)
+}
+
+func isOnlineUpdate(vals map[string]interface{}) bool {
+	if len(vals) != 2 {
+		return false
+	}
+	_, ok := vals["cwmp_last_inform"]
+	return ok && vals["cwmp_status"] == "online"
+}
+
+func (a *Application) upsertCpeParams(params []models.NetCpeParam) {
+	for i := 0; i < len(params); i += cpeWriterBatchSize {
+		end := min(i+cpeWriterBatchSize, len(params))
+		batch := params[i:end]
+		err := a.gormDB.Model(&models.NetCpeParam{}).Clauses(clause.OnConflict{
+			Columns:   []clause.Column{{Name: "id"}},
+			DoUpdates: clause.AssignmentColumns([]string{"tag", "value", "type", "updated_at"}),
+		}).Create(&batch).Error
+		if err != nil {
+			log.Errorf("upsert cpe params error: %s", err.Error())
+		}
+	}
+}
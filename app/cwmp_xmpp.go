@@ -0,0 +1,132 @@
+package app
+
+import (
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/ca17/teamsacs/common/cwmp"
+	"github.com/ca17/teamsacs/common/datamodel"
+	"github.com/ca17/teamsacs/common/xmpp"
+	"github.com/ca17/teamsacs/common/zaplog/log"
+	"github.com/ca17/teamsacs/models"
+)
+
+var xmppServer *xmpp.Server
+
+// SetXmppServer Register the embedded XMPP server started by the tr069 package
+func (a *Application) SetXmppServer(s *xmpp.Server) {
+	xmppServer = s
+}
+
+// XmppDomain Domain of the embedded XMPP server, the host of the TR069 access address
+func (a *Application) XmppDomain() string {
+	return a.stunServerAddress()
+}
+
+// XmppAuthenticate CPEs log in with their SN and their connection request password
+func (a *Application) XmppAuthenticate(username, password string) bool {
+	var count int64
+	a.gormDB.Model(&models.NetCpe{}).Where("sn = ?", username).Count(&count)
+	if count == 0 {
+		log.Infof("XmppAuthenticate: unknown device %s", username)
+		return false
+	}
+	for _, expect := range a.connReqPasswords(username) {
+		if expect != "" && subtle.ConstantTimeCompare([]byte(password), []byte(expect)) == 1 {
+			return true
+		}
+	}
+	return false
+}
+
+// OnXmppOnline Map the JID of the XMPP session to the device
+func (a *Application) OnXmppOnline(jid string) {
+	sn := xmpp.Node(jid)
+	err := a.gormDB.Model(&models.NetCpe{}).Where("sn = ?", sn).Update("xmpp_jid", jid).Error
+	if err != nil {
+		log.Errorf("OnXmppOnline %s: %s", jid, err.Error())
+		return
+	}
+	log.Infof("OnXmppOnline: device %s online as %s", sn, jid)
+}
+
+// OnXmppOffline Forget the JID when the XMPP session of the device is closed,
+// a newer session of the device is kept
+func (a *Application) OnXmppOffline(jid string) {
+	sn := xmpp.Node(jid)
+	err := a.gormDB.Model(&models.NetCpe{}).Where("sn = ? and xmpp_jid = ?", sn, jid).Update("xmpp_jid", "").Error
+	if err != nil {
+		log.Errorf("OnXmppOffline %s: %s", jid, err.Error())
+		return
+	}
+	log.Infof("OnXmppOffline: device %s offline", sn)
+}
+
+// XmppConnectionRequest Send a TR-069 Annex K connection request to an online device
+func (a *Application) XmppConnectionRequest(jid, username, password string) error {
+	if xmppServer == nil {
+		return errors.New("xmpp server is disabled")
+	}
+	if _, ok := xmppServer.Online(jid); !ok {
+		return xmpp.ErrNotOnline
+	}
+	return xmppServer.ConnectionRequest(jid, username, password, 10*time.Second)
+}
+
+// PushXmppConfig Set up the XMPP connection named by CpeXmppConnection and use it
+// for connection requests, TR-181 only, the connection instance must exist on the device.
+// The XMPP password is the connection request password pushed to the device.
+func (c *CwmpCpe) PushXmppConfig(session string, timeout int, hp bool) error {
+	conn := strings.TrimSuffix(app.GetTr069SettingsStringValue(ConfigCpeXmppConnection), ".")
+	if conn == "" || app.appConfig.Tr069.XmppPort == 0 || c.GetDataModel() != datamodel.TR181 {
+		return nil
+	}
+	domain := app.XmppDomain()
+	if domain == "" {
+		return nil
+	}
+	params := map[string]cwmp.ValueStruct{
+		conn + ".Enable": {
+			Type:  "xsd:boolean",
+			Value: "true",
+		},
+		conn + ".Username": {
+			Type:  "xsd:string",
+			Value: c.Sn,
+		},
+		conn + ".Password": {
+			Type:  "xsd:string",
+			Value: app.connReqPasswords(c.Sn)[0],
+		},
+		conn + ".Domain": {
+			Type:  "xsd:string",
+			Value: domain,
+		},
+		conn + ".Resource": {
+			Type:  "xsd:string",
+			Value: "cwmp",
+		},
+		"Device.ManagementServer.ConnReqXMPPConnection": {
+			Type:  "xsd:string",
+			Value: conn,
+		},
+		"Device.ManagementServer.ConnReqAllowedJabberIDs": {
+			Type:  "xsd:string",
+			Value: fmt.Sprintf("%s@%s", xmpp.AcsNode, domain),
+		},
+	}
+
+	return c.SendCwmpEventData(models.CwmpEventData{
+		Session: session,
+		Sn:      c.Sn,
+		Message: &cwmp.SetParameterValues{
+			ID:     session,
+			Name:   "",
+			NoMore: 0,
+			Params: params,
+		},
+	}, timeout, hp)
+}
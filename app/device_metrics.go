@@ -0,0 +1,85 @@
+package app
+
+import (
+	"fmt"
+	"regexp"
+	"time"
+
+	"github.com/ca17/teamsacs/common/web"
+	"github.com/ca17/teamsacs/common/zaplog"
+	"github.com/nakabonne/tstorage"
+)
+
+// Metrics pushed by the device scripts (assets/mikrotik/router_status.rsc),
+// stored in the tsdb as device_<name> with a sn label
+const (
+	DeviceMetricSn        = "system.info.sn"
+	DeviceMetricTimestamp = "system.info.timestamp"
+	deviceMetricPrefix    = "device_"
+	deviceMetricMaxItems  = 64
+	// DeviceApiTokenLevel Level of the tokens injected in the device scripts
+	DeviceApiTokenLevel = "api"
+)
+
+// DeviceMetricNames Metrics of the router status script, in chart order
+var DeviceMetricNames = []string{
+	"system.cpu.load",
+	"system.memory.total",
+	"system.memory.free",
+	"system.disk.total",
+	"system.disk.free",
+	"system.firewall.connections",
+}
+
+var deviceMetricNameRe = regexp.MustCompile(`^[a-zA-Z0-9_.\-]{1,64}$`)
+
+// CreateDeviceApiToken Token of the device scripts, bound to the device sn
+func (a *Application) CreateDeviceApiToken(sn string) (string, error) {
+	return web.CreateToken(a.appConfig.Tr069.Secret, sn, DeviceApiTokenLevel, time.Hour*24*365)
+}
+
+// CheckDeviceApiToken The token must be valid and issued for the sn
+func (a *Application) CheckDeviceApiToken(token, sn string) error {
+	claims, err := web.ParseToken(a.appConfig.Tr069.Secret, token)
+	if err != nil {
+		return err
+	}
+	if claims["lvl"] != DeviceApiTokenLevel {
+		return fmt.Errorf("token level not allowed")
+	}
+	if sn == "" || claims["uid"] != sn {
+		return fmt.Errorf("token not issued for device %s", sn)
+	}
+	return nil
+}
+
+// AddDeviceMetrics Store the values of a device push, timestamp in unix seconds
+func (a *Application) AddDeviceMetrics(sn string, timestamp int64, values map[string]float64) error {
+	if len(values) > deviceMetricMaxItems {
+		return fmt.Errorf("too many metrics, max %d", deviceMetricMaxItems)
+	}
+	var rows = make([]tstorage.Row, 0, len(values))
+	for name, value := range values {
+		if !deviceMetricNameRe.MatchString(name) {
+			return fmt.Errorf("invalid metric name %s", name)
+		}
+		rows = append(rows, tstorage.Row{
+			Metric: deviceMetricPrefix + name,
+			Labels: []tstorage.Label{{Name: "sn", Value: sn}},
+			DataPoint: tstorage.DataPoint{
+				Value:     value,
+				Timestamp: timestamp,
+			},
+		})
+	}
+	if len(rows) == 0 {
+		return nil
+	}
+	return zaplog.TSDB().InsertRows(rows)
+}
+
+// QueryDeviceMetric Points of a device metric between start and end
+func QueryDeviceMetric(sn, name string, start, end time.Time) ([]*tstorage.DataPoint, error) {
+	return zaplog.TSDB().Select(deviceMetricPrefix+name,
+		[]tstorage.Label{{Name: "sn", Value: sn}}, start.Unix(), end.Unix())
+}
@@ -0,0 +1,293 @@
+package app
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/ca17/teamsacs/common/gis"
+	"github.com/ca17/teamsacs/models"
+)
+
+// GIS feature kinds
+const (
+	GisOlt   = "olt"
+	GisOdc   = "odc"
+	GisOdp   = "odp"
+	GisCpe   = "cpe"
+	GisCable = "cable"
+)
+
+var GisKinds = []string{GisOlt, GisOdc, GisOdp, GisCpe, GisCable}
+
+// GisStyles Styles of the exported features, the CPEs by status and RX power band
+var GisStyles = []gis.Style{
+	{ID: GisOlt, Color: "#1565c0", Scale: 1.4},
+	{ID: GisOdc, Color: "#8e24aa", Scale: 1.2},
+	{ID: GisOdp, Color: "#ef6c00"},
+	{ID: GisCable, Color: "#757575"},
+	{ID: "cpe-online", Color: "#2e7d32", Scale: 0.8},
+	{ID: "cpe-offline", Color: "#9e9e9e", Scale: 0.8},
+	{ID: "cpe-los", Color: "#d50000", Scale: 0.8},
+	{ID: "cpe-rx-good", Color: "#2e7d32", Scale: 0.8},
+	{ID: "cpe-rx-warning", Color: "#fbc02d", Scale: 0.8},
+	{ID: "cpe-rx-critical", Color: "#e65100", Scale: 0.8},
+}
+
+// RxPowerBand Band of the ONU RX power, good within -8 to -25 dBm,
+// warning down to the -27 dBm class B+ margin, critical beyond
+func RxPowerBand(rx float64) string {
+	switch {
+	case rx >= -25 && rx <= -8:
+		return "good"
+	case rx >= -27 && rx < -25:
+		return "warning"
+	default:
+		return "critical"
+	}
+}
+
+// ValidCoordinate Empty coordinates are allowed, set ones must be a valid pair
+func ValidCoordinate(lat, lng string) error {
+	if _, err := gis.ParsePoint(lat, lng); err != nil && !errors.Is(err, gis.ErrNoCoordinate) {
+		return err
+	}
+	return nil
+}
+
+// GisQuery Kinds and bounding box of an export, a nil box exports everything
+type GisQuery struct {
+	Kinds map[string]bool
+	BBox  *gis.BBox
+}
+
+// gisOnuStatesChunk Serial numbers of one ONU states query, below the bind parameter limit
+const gisOnuStatesChunk = 1000
+
+// GisFeatures OLTs, ODCs, ODPs and CPEs with valid coordinates and the cables between them.
+// The plant is loaded whole for the cable ends, the CPEs of the box are selected by the database.
+func (a *Application) GisFeatures(q GisQuery) ([]gis.Feature, error) {
+	var features []gis.Feature
+	located := func(lat, lng string) (gis.Point, bool) {
+		p, err := gis.ParsePoint(lat, lng)
+		return p, err == nil
+	}
+	cable := func(name string, from, to gis.Point) {
+		if q.Kinds[GisCable] && (q.BBox.Contains(from) || q.BBox.Contains(to)) {
+			features = append(features, gis.Feature{Name: name, Style: GisCable, Coordinates: []gis.Point{from, to}})
+		}
+	}
+	add := func(f gis.Feature, kind string) {
+		if q.Kinds[kind] && q.BBox.Contains(f.Coordinates[0]) {
+			features = append(features, f)
+		}
+	}
+
+	var olts []models.OltDevice
+	a.gormDB.Find(&olts)
+	oltPoints := make(map[int64]gis.Point)
+	for _, olt := range olts {
+		p, ok := located(olt.Latitude, olt.Longitude)
+		if !ok {
+			continue
+		}
+		oltPoints[olt.ID] = p
+		add(gis.Feature{ID: fmt.Sprintf("olt-%d", olt.ID), Name: olt.Name, Style: GisOlt, Coordinates: []gis.Point{p},
+			Properties: map[string]interface{}{"kind": GisOlt, "ip_address": olt.IPAddress, "model": olt.Model, "status": olt.Status}}, GisOlt)
+	}
+
+	var odcs []models.OdcDevice
+	a.gormDB.Find(&odcs)
+	odcPoints := make(map[int64]gis.Point)
+	for _, odc := range odcs {
+		p, ok := located(odc.Latitude, odc.Longitude)
+		if !ok {
+			continue
+		}
+		odcPoints[odc.ID] = p
+		add(gis.Feature{ID: fmt.Sprintf("odc-%d", odc.ID), Name: odc.Name, Style: GisOdc, Coordinates: []gis.Point{p},
+			Properties: map[string]interface{}{"kind": GisOdc, "address": odc.Address, "capacity": odc.Capacity,
+				"pon_port": odc.PonPort, "split_ratio": odc.SplitRatio}}, GisOdc)
+		if from, ok := oltPoints[odc.OltID]; ok {
+			cable("Feeder "+odc.Name, from, p)
+		}
+	}
+
+	var odps []models.OdpDevice
+	a.gormDB.Find(&odps)
+	odpPoints := make(map[int64]gis.Point)
+	var odpsInBox []int64
+	for _, odp := range odps {
+		p, ok := located(odp.Latitude, odp.Longitude)
+		if !ok {
+			continue
+		}
+		odpPoints[odp.ID] = p
+		if q.BBox.Contains(p) {
+			odpsInBox = append(odpsInBox, odp.ID)
+		}
+		add(gis.Feature{ID: fmt.Sprintf("odp-%d", odp.ID), Name: odp.Name, Style: GisOdp, Coordinates: []gis.Point{p},
+			Properties: map[string]interface{}{"kind": GisOdp, "address": odp.Address, "capacity": odp.Capacity,
+				"used_ports": odp.UsedPorts, "odc_port": odp.OdcPort}}, GisOdp)
+		if from, ok := odcPoints[odp.OdcID]; ok {
+			cable("Distribution "+odp.Name, from, p)
+		}
+	}
+
+	if !q.Kinds[GisCpe] && !q.Kinds[GisCable] {
+		return features, nil
+	}
+	var cpes []models.NetCpe
+	query := a.gormDB.Select("id", "sn", "name", "model", "cwmp_status", "fiber_rx_power", "pon_sn_hex",
+		"odp_id", "odp_port", "latitude", "longitude").Where("latitude <> ''")
+	if q.BBox != nil {
+		// the CPEs in the box and the ones dropped from an ODP in the box
+		where, args := q.BBox.Where("geo_lat", "geo_lng")
+		if q.Kinds[GisCable] && len(odpsInBox) > 0 {
+			query = query.Where("("+where+") or odp_id in ?", append(args, odpsInBox)...)
+		} else {
+			query = query.Where(where, args...)
+		}
+	}
+	if err := query.Find(&cpes).Error; err != nil {
+		return nil, err
+	}
+	onus, err := a.gisOnuStates(cpes)
+	if err != nil {
+		return nil, err
+	}
+	for _, cpe := range cpes {
+		p, ok := located(cpe.Latitude, cpe.Longitude)
+		if !ok {
+			continue
+		}
+		props := map[string]interface{}{"kind": GisCpe, "sn": cpe.Sn, "model": cpe.Model,
+			"status": cpe.CwmpStatus, "odp_port": cpe.OdpPort}
+		onu, hasOnu := onus[strings.ToUpper(cpe.PonSnHex)]
+		rx, hasRx := ParseDbm(cpe.FiberRxPower)
+		if (!hasRx || rx == 0) && hasOnu && onu.RxPower != 0 {
+			rx, hasRx = onu.RxPower, true
+		}
+		online := cpe.CwmpStatus == "online"
+		style := "cpe-offline"
+		if online {
+			style = "cpe-online"
+		}
+		if hasRx && rx != 0 {
+			props["rx_power"] = rx
+			props["rx_band"] = RxPowerBand(rx)
+			if online {
+				style = "cpe-rx-" + RxPowerBand(rx)
+			}
+		}
+		if hasOnu && strings.EqualFold(onu.PhaseState, "los") {
+			props["status"] = "los"
+			style = "cpe-los"
+		}
+		add(gis.Feature{ID: fmt.Sprintf("cpe-%d", cpe.ID), Name: cpe.Sn, Style: style, Coordinates: []gis.Point{p},
+			Properties: props}, GisCpe)
+		if from, ok := odpPoints[cpe.OdpID]; ok {
+			cable("Drop "+cpe.Sn, from, p)
+		}
+	}
+	return features, nil
+}
+
+// gisOnuStates ONU states polled from the OLTs by PON serial number, the
+// serial numbers are stored upper case
+func (a *Application) gisOnuStates(cpes []models.NetCpe) (map[string]models.OltOnuData, error) {
+	var sns []string
+	for _, cpe := range cpes {
+		if cpe.PonSnHex != "" {
+			sns = append(sns, strings.ToUpper(cpe.PonSnHex))
+		}
+	}
+	states := make(map[string]models.OltOnuData)
+	for start := 0; start < len(sns); start += gisOnuStatesChunk {
+		end := min(start+gisOnuStatesChunk, len(sns))
+		var onus []models.OltOnuData
+		err := a.gormDB.Select("serial_number", "phase_state", "rx_power").
+			Where("serial_number in ?", sns[start:end]).Order("updated_at").Find(&onus).Error
+		if err != nil {
+			return nil, err
+		}
+		for _, onu := range onus {
+			states[onu.SerialNumber] = onu
+		}
+	}
+	return states, nil
+}
+
+// ImportGisCoordinates Set the coordinates from CSV lines "kind,key,latitude,longitude",
+// the key is the SN of a CPE, the ID or the unique name of an OLT, ODC or ODP.
+// Invalid lines are skipped and reported.
+func (a *Application) ImportGisCoordinates(r io.Reader) (int, []string) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+	var updated int
+	var errs []string
+	for line := 1; ; line++ {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("line %d: %s", line, err.Error()))
+			continue
+		}
+		if line == 1 && strings.EqualFold(strings.TrimSpace(record[0]), "kind") {
+			continue
+		}
+		if len(record) != 4 {
+			errs = append(errs, fmt.Sprintf("line %d: expected kind,key,latitude,longitude", line))
+			continue
+		}
+		if err = a.setGisCoordinate(strings.ToLower(strings.TrimSpace(record[0])),
+			strings.TrimSpace(record[1]), record[2], record[3]); err != nil {
+			errs = append(errs, fmt.Sprintf("line %d: %s", line, err.Error()))
+			continue
+		}
+		updated++
+	}
+	return updated, errs
+}
+
+func (a *Application) setGisCoordinate(kind, key, lat, lng string) error {
+	p, err := gis.ParsePoint(lat, lng)
+	if err != nil {
+		return err
+	}
+	var model interface{}
+	where, args := "id = ? or name = ?", []interface{}{int64(0), key}
+	if id, err := strconv.ParseInt(key, 10, 64); err == nil {
+		args[0] = id
+	}
+	switch kind {
+	case GisCpe:
+		model, where, args = &models.NetCpe{}, "sn = ?", []interface{}{key}
+	case GisOlt:
+		model = &models.OltDevice{}
+	case GisOdc:
+		model = &models.OdcDevice{}
+	case GisOdp:
+		model = &models.OdpDevice{}
+	default:
+		return fmt.Errorf("unknown kind %q", kind)
+	}
+	var count int64
+	a.gormDB.Model(model).Where(where, args...).Count(&count)
+	if count == 0 {
+		return fmt.Errorf("%s %s not found", kind, key)
+	}
+	if count > 1 {
+		return fmt.Errorf("%s name %s is not unique, use the ID", kind, key)
+	}
+	return a.gormDB.Model(model).Where(where, args...).Updates(map[string]interface{}{
+		"latitude":  strconv.FormatFloat(p.Lat, 'f', -1, 64),
+		"longitude": strconv.FormatFloat(p.Lng, 'f', -1, 64),
+	}).Error
+}
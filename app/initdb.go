@@ -45,6 +45,10 @@ func (a *Application) checkSettings() {
 			checkConfig(sortid, "system", ConfigSystemLoginRemark, "Recommended browser: Chrome/Edge", "Login page description")
 		case ConfigSystemLoginSubtitle:
 			checkConfig(sortid, "system", ConfigSystemLoginSubtitle, "TeamsACS Community Edition", "Login form title")
+		case ConfigSystemMetricsToken:
+			checkConfig(sortid, "system", ConfigSystemMetricsToken, "", "Bearer token of the Prometheus scrapers on /metrics, empty requires an operator session")
+		case ConfigSystemMetricsShard:
+			checkConfig(sortid, "system", ConfigSystemMetricsShard, "5000", "Max devices per device metrics scrape target")
 		case ConfigCpeAutoRegister:
 			checkConfig(sortid, "tr069", ConfigCpeAutoRegister, "enabled", "Auto register CPE device")
 		case ConfigTR069AccessAddress:
@@ -61,6 +65,28 @@ func (a *Application) checkSettings() {
 			checkConfig(sortid, "tr069", ConfigOntWebUserUsername, "fiberstream", "ONT Web user username (pushed to all ONT devices)")
 		case ConfigOntWebUserPassword:
 			checkConfig(sortid, "tr069", ConfigOntWebUserPassword, "fiberstream.net.id", "ONT Web user password (pushed to all ONT devices)")
+		case ConfigCpeParamHistoryDays:
+			checkConfig(sortid, "tr069", ConfigCpeParamHistoryDays, "90", "CPE parameter change history retention days")
+		case ConfigCpeDiscoveryRpcBudget:
+			checkConfig(sortid, "tr069", ConfigCpeDiscoveryRpcBudget, "20", "Max GetParameterNames requests per CWMP session for parameter tree discovery")
+		case ConfigCpeStunEnable:
+			checkConfig(sortid, "tr069", ConfigCpeStunEnable, "disabled", "Push TR-111 STUN settings to CPE on bootstrap for UDP connection requests behind NAT")
+		case ConfigCpeXmppConnection:
+			checkConfig(sortid, "tr069", ConfigCpeXmppConnection, "", "XMPP connection instance set up on TR-181 CPE on bootstrap for XMPP connection requests, e.g. Device.XMPP.Connection.1, empty disables")
+		case ConfigMikrotikApiUsername:
+			checkConfig(sortid, "tr069", ConfigMikrotikApiUsername, "apimaster", "Mikrotik RouterOS API username of the devices without own API credentials (assets/mikrotik/checkapi.rsc)")
+		case ConfigMikrotikApiPassword:
+			checkConfig(sortid, "tr069", ConfigMikrotikApiPassword, "Api.2023!", "Mikrotik RouterOS API password of the devices without own API credentials")
+		case ConfigOpticalOltTxPower:
+			checkConfig(sortid, "tr069", ConfigOpticalOltTxPower, "3", "OLT PON port launch power in dBm of the OLTs without own value, for the ONU optical budget")
+		case ConfigOpticalFiberLoss:
+			checkConfig(sortid, "tr069", ConfigOpticalFiberLoss, "0.3", "Fibre attenuation in dB/km at the downstream wavelength, for the ONU optical budget")
+		case ConfigOpticalConnectorLoss:
+			checkConfig(sortid, "tr069", ConfigOpticalConnectorLoss, "0.5", "Loss in dB per connector of the fibre path (ODC in and out, ODP in and out, ONU)")
+		case ConfigOpticalRxTolerance:
+			checkConfig(sortid, "tr069", ConfigOpticalRxTolerance, "3", "ONU RX power deviation in dB from the optical budget flagged by the check")
+		case ConfigRadiusSessionDays:
+			checkConfig(sortid, "radius", ConfigRadiusSessionDays, "90", "RADIUS accounting retention days of the ended PPPoE sessions")
 		}
 	}
 
@@ -27,25 +27,63 @@ func (a *Application) initJob() {
 	_, err = a.sched.AddFunc("@every 30s", func() {
 		go a.SchedSystemMonitorTask()
 		go a.SchedProcessMonitorTask()
+		go a.SchedInformPoolMonitorTask()
 	})
 
-	_, err = a.sched.AddFunc("@every 60s", func() {
+	// the jobs below run on the cluster leader only
+	_, err = a.sched.AddFunc("@every 60s", a.leaderJob(func() {
 		a.SchedUpdateBatchCwmpStatus()
-	})
+	}))
 
 	// database backup
-	_, err = a.sched.AddFunc("@daily", func() {
+	_, err = a.sched.AddFunc("@daily", a.leaderJob(func() {
 		err := app.BackupDatabase()
 		if err != nil {
 			log.Errorf("database backup err %s", err.Error())
 		}
-	})
+	}))
 
-	_, err = a.sched.AddFunc("@daily", func() {
+	_, err = a.sched.AddFunc("@daily", a.leaderJob(func() {
 		a.gormDB.
 			Where("opt_time < ? ", time.Now().
 				Add(-time.Hour*24*365)).Delete(models.SysOprLog{})
-	})
+	}))
+
+	_, err = a.sched.AddFunc("@daily", a.leaderJob(func() {
+		a.ClearCpeParamHistory()
+	}))
+
+	_, err = a.sched.AddFunc("@daily", a.leaderJob(func() {
+		a.ClearCpeSessions()
+	}))
+
+	_, err = a.sched.AddFunc("@every 30m", a.leaderJob(func() {
+		a.ClearPendingSets()
+	}))
+
+	// parameter discovery requests never answered
+	_, err = a.sched.AddFunc("@every 1m", a.leaderJob(func() {
+		a.RequeueParamDiscovery()
+	}))
+
+	_, err = a.sched.AddFunc("@daily", a.leaderJob(func() {
+		a.ClearRadiusSessions()
+	}))
+
+	// ONU RX power against the optical budget of the fibre path
+	_, err = a.sched.AddFunc("@every 1h", a.leaderJob(func() {
+		a.SchedCheckOpticalBudget()
+	}))
+
+	// drift of the policies pushed to the devices
+	_, err = a.sched.AddFunc("@hourly", a.leaderJob(func() {
+		a.SchedCheckPolicyDrift()
+	}))
+
+	// RPCs never picked up by a CWMP session
+	_, err = a.sched.AddFunc("@daily", a.leaderJob(func() {
+		a.gormDB.Where("created_at < ?", time.Now().Add(-time.Hour*24)).Delete(models.CwmpQueueItem{})
+	}))
 
 	if err != nil {
 		log.Errorf("init job error %s", err.Error())
@@ -104,6 +142,33 @@ func (a *Application) SchedSystemMonitorTask() {
 	}
 }
 
+// SchedInformPoolMonitorTask inform worker pool and batch writer monitor
+func (a *Application) SchedInformPoolMonitorTask() {
+	defer func() {
+		if err := recover(); err != nil {
+			log.Error(err)
+		}
+	}()
+
+	timestamp := time.Now().Unix()
+	stats := a.InformPoolStats()
+	_, pending := a.CpeWriterPending()
+	var rows []tstorage.Row
+	for name, value := range map[string]float64{
+		MetricsTr069InformQueue:   float64(stats.Queued),
+		MetricsTr069InformBusy:    float64(stats.Busy),
+		MetricsTr069WriterPending: float64(pending),
+	} {
+		rows = append(rows, tstorage.Row{
+			Metric:    name,
+			DataPoint: tstorage.DataPoint{Value: value, Timestamp: timestamp},
+		})
+	}
+	if err := zaplog.TSDB().InsertRows(rows); err != nil {
+		log.Error("add timeseries data error:", err.Error())
+	}
+}
+
 // SchedProcessMonitorTask app process monitor
 func (a *Application) SchedProcessMonitorTask() {
 	defer func() {
@@ -165,41 +230,41 @@ func (a *Application) SchedUpdateBatchCwmpStatus() {
 
 func (a *Application) setupCwmpTask() {
 	var err error
-	_, err = a.sched.AddFunc("@every 5m", func() {
+	_, err = a.sched.AddFunc("@every 5m", a.leaderJob(func() {
 		_ = CreateCwmpScheduledTask("5m")
-	})
-	_, err = a.sched.AddFunc("@every 10m", func() {
+	}))
+	_, err = a.sched.AddFunc("@every 10m", a.leaderJob(func() {
 		_ = CreateCwmpScheduledTask("10m")
-	})
-	_, err = a.sched.AddFunc("@every 30m", func() {
+	}))
+	_, err = a.sched.AddFunc("@every 30m", a.leaderJob(func() {
 		_ = CreateCwmpScheduledTask("30m")
-	})
-	_, err = a.sched.AddFunc("@every 1h", func() {
+	}))
+	_, err = a.sched.AddFunc("@every 1h", a.leaderJob(func() {
 		_ = CreateCwmpScheduledTask("1h")
-	})
-	_, err = a.sched.AddFunc("@every 4h", func() {
+	}))
+	_, err = a.sched.AddFunc("@every 4h", a.leaderJob(func() {
 		_ = CreateCwmpScheduledTask("4h")
-	})
-	_, err = a.sched.AddFunc("@every 8h", func() {
+	}))
+	_, err = a.sched.AddFunc("@every 8h", a.leaderJob(func() {
 		_ = CreateCwmpScheduledTask("8h")
-	})
-	_, err = a.sched.AddFunc("@every 12", func() {
+	}))
+	_, err = a.sched.AddFunc("@every 12", a.leaderJob(func() {
 		_ = CreateCwmpScheduledTask("12")
-	})
+	}))
 
 	// Execute at 0:01 every morning
-	_, err = a.sched.AddFunc("0 1 0 * * *", func() {
+	_, err = a.sched.AddFunc("0 1 0 * * *", a.leaderJob(func() {
 		_ = CreateCwmpScheduledTask("daily@h0")
-	})
+	}))
 
 	// 1 to 23 cycles
 	for i := 1; i < 24; i++ {
 		log.Infof("add job daily@h%d cron( 0 0 %d * * * )", i, i)
 		key := fmt.Sprintf("daily@h%d", i)
 		cronkey := fmt.Sprintf("0 0 %d * * *", i)
-		_, err = a.sched.AddFunc(cronkey, func() {
+		_, err = a.sched.AddFunc(cronkey, a.leaderJob(func() {
 			_ = CreateCwmpScheduledTask(key)
-		})
+		}))
 	}
 
 	// 18:30 every day
@@ -0,0 +1,48 @@
+package app
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/ca17/teamsacs/common/zaplog"
+	"github.com/nakabonne/tstorage"
+)
+
+// OLT port time series, stored in the tsdb as olt_port_<name>
+// with the OLT ID and port name labels
+const oltPortMetricPrefix = "olt_port_"
+
+// OltPortMetricNames Series of the OLT port polls
+var OltPortMetricNames = []string{
+	"in_bps",
+	"out_bps",
+	"utilisation",
+	"tx_power",
+	"rx_power",
+	"onu_online",
+}
+
+func oltPortLabels(oltId int64, port string) []tstorage.Label {
+	return []tstorage.Label{{Name: "olt", Value: strconv.FormatInt(oltId, 10)}, {Name: "port", Value: port}}
+}
+
+// AddOltPortMetrics Store the values of an OLT port poll, timestamp in unix seconds
+func (a *Application) AddOltPortMetrics(oltId int64, port string, timestamp int64, values map[string]float64) error {
+	rows := make([]tstorage.Row, 0, len(values))
+	for name, value := range values {
+		rows = append(rows, tstorage.Row{
+			Metric:    oltPortMetricPrefix + name,
+			Labels:    oltPortLabels(oltId, port),
+			DataPoint: tstorage.DataPoint{Value: value, Timestamp: timestamp},
+		})
+	}
+	if len(rows) == 0 {
+		return nil
+	}
+	return zaplog.TSDB().InsertRows(rows)
+}
+
+// QueryOltPortMetric Points of an OLT port series between start and end
+func QueryOltPortMetric(oltId int64, port, name string, start, end time.Time) ([]*tstorage.DataPoint, error) {
+	return zaplog.TSDB().Select(oltPortMetricPrefix+name, oltPortLabels(oltId, port), start.Unix(), end.Unix())
+}
@@ -0,0 +1,876 @@
+package app
+
+import (
+	"fmt"
+	"net"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ca17/teamsacs/common"
+	"github.com/ca17/teamsacs/common/cwmp"
+	"github.com/ca17/teamsacs/common/datamodel"
+	"github.com/ca17/teamsacs/common/zaplog/log"
+	"github.com/ca17/teamsacs/models"
+	"github.com/spf13/cast"
+	"gorm.io/gorm/clause"
+)
+
+// Policy rule kinds
+const (
+	PolicyRuleFilter      = "filter"
+	PolicyRuleNat         = "nat"
+	PolicyRuleRoute       = "route"
+	PolicyRuleAddressList = "address_list"
+)
+
+// Policy push methods, state and drift state
+const (
+	PolicyMethodRouteros = "routeros"
+	PolicyMethodTr181    = "tr181"
+
+	PolicyPushed      = "pushed"
+	PolicyPushFailure = "failure"
+
+	PolicyInSync       = "insync"
+	PolicyDrift        = "drift"
+	PolicyDriftUnknown = "unknown"
+)
+
+// rule values end up in RouterOS scripts rendered as templates, no braces or line breaks
+var policyValueRe = regexp.MustCompile(`^[^\r\n{}]{0,255}$`)
+
+// RouterOS menus of the rule kinds, the address lists go first as the rules refer to them
+var routerosPolicyMenus = []struct {
+	Kind string
+	Menu string
+}{
+	{PolicyRuleAddressList, "/ip/firewall/address-list"},
+	{PolicyRuleFilter, "/ip/firewall/filter"},
+	{PolicyRuleNat, "/ip/firewall/nat"},
+	{PolicyRuleRoute, "/ip/route"},
+}
+
+// TR-181 tables of the rule kinds, each rule has its own instance created by AddObject
+var tr181PolicyObjects = map[string]string{
+	PolicyRuleFilter: "Device.Firewall.Chain.1.Rule.",
+	PolicyRuleNat:    "Device.NAT.PortMapping.",
+	PolicyRuleRoute:  "Device.Routing.Router.1.IPv4Forwarding.",
+}
+
+// policyDriftConcurrency Devices checked at once by the scheduled drift check
+const policyDriftConcurrency = 10
+
+// PolicySessionPrefix Prefix of the AddObject and DeleteObject requests of the policies
+const PolicySessionPrefix = "policy-"
+
+// policyDriftSessionPrefix Prefix of the GetParameterValues of the drift checks
+const policyDriftSessionPrefix = PolicySessionPrefix + "drift-"
+
+// CheckPolicy Validate a policy before it is saved
+func CheckPolicy(policy models.NetPolicy) error {
+	if policy.Name == "" || !policyValueRe.MatchString(policy.Name) {
+		return fmt.Errorf("invalid policy name")
+	}
+	return nil
+}
+
+// CheckPolicyRule Validate a rule before it is saved
+func CheckPolicyRule(rule models.NetPolicyRule) error {
+	values := map[string]string{
+		"chain": rule.Chain, "action": rule.Action, "protocol": rule.Protocol,
+		"src_address": rule.SrcAddress, "dst_address": rule.DstAddress,
+		"src_port": rule.SrcPort, "dst_port": rule.DstPort,
+		"in_interface": rule.InInterface, "out_interface": rule.OutInterface,
+		"to_addresses": rule.ToAddresses, "to_ports": rule.ToPorts,
+		"gateway": rule.Gateway, "list_name": rule.ListName, "comment": rule.Comment,
+	}
+	for name, value := range values {
+		if !policyValueRe.MatchString(value) {
+			return fmt.Errorf("invalid value of %s", name)
+		}
+	}
+	switch rule.Kind {
+	case PolicyRuleFilter, PolicyRuleNat:
+		if rule.Chain == "" || rule.Action == "" {
+			return fmt.Errorf("%s rule requires chain and action", rule.Kind)
+		}
+	case PolicyRuleRoute:
+		if rule.DstAddress == "" || rule.Gateway == "" {
+			return fmt.Errorf("route requires dst_address and gateway")
+		}
+	case PolicyRuleAddressList:
+		if rule.ListName == "" || rule.SrcAddress == "" {
+			return fmt.Errorf("address list entry requires list_name and src_address")
+		}
+	default:
+		return fmt.Errorf("unsupported rule kind %s", rule.Kind)
+	}
+	return nil
+}
+
+// PolicyRules Rules of the policy in push order
+func (a *Application) PolicyRules(policyId int64) ([]models.NetPolicyRule, error) {
+	var rules []models.NetPolicyRule
+	err := a.gormDB.Where("policy_id = ?", policyId).Order("sort asc, id asc").Find(&rules).Error
+	return rules, err
+}
+
+// PolicyDevices Devices the policy is bound to, directly or by a device group
+func (a *Application) PolicyDevices(policyId int64) ([]models.NetCpe, error) {
+	var bindings []models.NetPolicyBinding
+	if err := a.gormDB.Where("policy_id = ?", policyId).Find(&bindings).Error; err != nil {
+		return nil, err
+	}
+	var devs []models.NetCpe
+	var exists = make(map[int64]bool)
+	add := func(items []models.NetCpe) {
+		for _, dev := range items {
+			if !exists[dev.ID] {
+				exists[dev.ID] = true
+				devs = append(devs, dev)
+			}
+		}
+	}
+	var devids []int64
+	for _, b := range bindings {
+		if b.TargetType != "group" {
+			devids = append(devids, b.TargetId)
+			continue
+		}
+		query, err := a.DeviceGroupQuery(b.TargetId)
+		if err != nil {
+			log.Errorf("policy %d device group %d: %s", policyId, b.TargetId, err.Error())
+			continue
+		}
+		var items []models.NetCpe
+		if err = query.Find(&items).Error; err != nil {
+			return nil, err
+		}
+		add(items)
+	}
+	if len(devids) > 0 {
+		var items []models.NetCpe
+		if err := a.gormDB.Where("id in ?", devids).Find(&items).Error; err != nil {
+			return nil, err
+		}
+		add(items)
+	}
+	return devs, nil
+}
+
+// PolicyMethod How the policy is pushed to the device, RouterOS scripts for
+// Mikrotik devices and parameter sets for the other TR-181 devices
+func (a *Application) PolicyMethod(dev models.NetCpe) (string, error) {
+	if IsMikrotik(dev) {
+		return PolicyMethodRouteros, nil
+	}
+	if a.CwmpTable().GetCwmpCpe(dev.Sn).GetDataModel() == datamodel.TR181 {
+		return PolicyMethodTr181, nil
+	}
+	return "", fmt.Errorf("device %s is neither a Mikrotik nor a TR-181 device", dev.Sn)
+}
+
+// policyTag Comment prefix of the RouterOS entries managed by the policy
+func policyTag(policyId int64) string {
+	return fmt.Sprintf("teamsacs:%d:", policyId)
+}
+
+// routerosPolicyAttrs RouterOS attributes of a rule, in the order of the script
+func routerosPolicyAttrs(policyId int64, rule models.NetPolicyRule) [][2]string {
+	var attrs [][2]string
+	add := func(name, value string) {
+		if value != "" {
+			attrs = append(attrs, [2]string{name, value})
+		}
+	}
+	switch rule.Kind {
+	case PolicyRuleAddressList:
+		add("list", rule.ListName)
+		add("address", rule.SrcAddress)
+	case PolicyRuleRoute:
+		add("dst-address", rule.DstAddress)
+		add("gateway", rule.Gateway)
+		if rule.Distance > 0 {
+			add("distance", strconv.Itoa(rule.Distance))
+		}
+	default:
+		add("chain", rule.Chain)
+		add("action", rule.Action)
+		add("protocol", rule.Protocol)
+		add("src-address", rule.SrcAddress)
+		add("dst-address", rule.DstAddress)
+		add("src-port", rule.SrcPort)
+		add("dst-port", rule.DstPort)
+		add("in-interface", rule.InInterface)
+		add("out-interface", rule.OutInterface)
+		if rule.Kind == PolicyRuleNat {
+			add("to-addresses", rule.ToAddresses)
+			add("to-ports", rule.ToPorts)
+		}
+	}
+	add("disabled", common.If(rule.Disabled, "yes", "no").(string))
+	add("comment", policyTag(policyId)+rule.Comment)
+	return attrs
+}
+
+// RenderRouterosPolicy RouterOS script of the policy. The entries of the policy are found
+// by the comment tag, they are all removed and added again in the rule order, so rules
+// removed from the policy are removed from the device too. The filter and NAT rules are
+// placed before the static entries of the device, at the end of a chain they would come
+// after its final drop.
+func RenderRouterosPolicy(policy models.NetPolicy, rules []models.NetPolicyRule) string {
+	var b strings.Builder
+	tag := policyTag(policy.ID)
+	b.WriteString(fmt.Sprintf("# TeamsACS policy %s\n", policy.Name))
+	for _, m := range routerosPolicyMenus {
+		menu := "/" + strings.ReplaceAll(strings.TrimPrefix(m.Menu, "/"), "/", " ")
+		b.WriteString(fmt.Sprintf("%s remove [find where comment~%s]\n", menu, RouterosQuote("^"+tag)))
+		var adds []string
+		for _, rule := range rules {
+			if rule.Kind != m.Kind {
+				continue
+			}
+			add := menu + " add"
+			for _, attr := range routerosPolicyAttrs(policy.ID, rule) {
+				add += " " + attr[0] + "=" + RouterosQuote(attr[1])
+			}
+			adds = append(adds, add)
+		}
+		if len(adds) == 0 {
+			continue
+		}
+		if m.Kind != PolicyRuleFilter && m.Kind != PolicyRuleNat {
+			b.WriteString(strings.Join(adds, "\n") + "\n")
+			continue
+		}
+		// each rule goes before the same entry, the rules keep their order
+		b.WriteString("{\n")
+		b.WriteString(fmt.Sprintf(":local before ([%s find where dynamic=no]->0)\n", menu))
+		b.WriteString(":if ([:typeof $before] = \"id\") do={\n")
+		for _, add := range adds {
+			b.WriteString(add + " place-before=$before\n")
+		}
+		b.WriteString("} else={\n")
+		b.WriteString(strings.Join(adds, "\n") + "\n")
+		b.WriteString("}\n}\n")
+	}
+	return b.String()
+}
+
+// RouterosQuote Quoted RouterOS script string, $ would be expanded as a variable
+func RouterosQuote(s string) string {
+	r := strings.NewReplacer(`\`, `\\`, `"`, `\"`, `$`, `\$`)
+	return `"` + r.Replace(s) + `"`
+}
+
+// RenderTr181Policy Parameter values of the policy for a TR-181 device, on the instances
+// created for the rules. The rules without an instance yet are rendered on a {rule:ID}
+// placeholder, they are set once the AddObject is answered. TR-181 has no address lists
+// and no source NAT, the filter chain is the one of Firewall.Chain.1.
+func RenderTr181Policy(rules []models.NetPolicyRule, instances map[int64]int) (map[string]cwmp.ValueStruct, error) {
+	params := make(map[string]cwmp.ValueStruct)
+	var order int
+	for _, rule := range rules {
+		if rule.Kind == PolicyRuleFilter {
+			order++
+		}
+		instance := fmt.Sprintf("{rule:%d}", rule.ID)
+		if n := instances[rule.ID]; n > 0 {
+			instance = strconv.Itoa(n)
+		}
+		if err := renderTr181Rule(params, rule, instance, order); err != nil {
+			return nil, err
+		}
+	}
+	return params, nil
+}
+
+// tr181FilterOrder Order of the filter rule in the chain, its rank in the filter rules
+func tr181FilterOrder(rules []models.NetPolicyRule, ruleId int64) int {
+	var order int
+	for _, rule := range rules {
+		if rule.Kind == PolicyRuleFilter {
+			order++
+			if rule.ID == ruleId {
+				break
+			}
+		}
+	}
+	return order
+}
+
+// renderTr181Rule Parameter values of a rule on its object instance
+func renderTr181Rule(params map[string]cwmp.ValueStruct, rule models.NetPolicyRule, instance string, order int) error {
+	set := func(name, vtype, value string) {
+		params[name] = cwmp.ValueStruct{Type: vtype, Value: value}
+	}
+	enable := common.If(rule.Disabled, "false", "true").(string)
+	prefix := tr181PolicyObjects[rule.Kind] + instance + "."
+	switch rule.Kind {
+	case PolicyRuleFilter:
+		target, ok := map[string]string{"accept": "Accept", "drop": "Drop", "reject": "Reject", "return": "Return"}[rule.Action]
+		if !ok {
+			return fmt.Errorf("filter action %s is not supported by TR-181", rule.Action)
+		}
+		protocol, err := tr181Protocol(rule.Protocol)
+		if err != nil {
+			return err
+		}
+		set(prefix+"Enable", "xsd:boolean", enable)
+		set(prefix+"Order", "xsd:unsignedInt", strconv.Itoa(order))
+		set(prefix+"Description", "xsd:string", rule.Comment)
+		set(prefix+"Target", "xsd:string", target)
+		set(prefix+"Protocol", "xsd:int", protocol)
+		for _, p := range []struct{ field, value string }{{"Source", rule.SrcAddress}, {"Dest", rule.DstAddress}} {
+			ip, mask, err := tr181Address(p.value)
+			if err != nil {
+				return err
+			}
+			set(prefix+p.field+"IP", "xsd:string", ip)
+			set(prefix+p.field+"Mask", "xsd:string", mask)
+		}
+		for _, p := range []struct{ field, value string }{{"Source", rule.SrcPort}, {"Dest", rule.DstPort}} {
+			port, max, err := tr181PortRange(p.value)
+			if err != nil {
+				return err
+			}
+			set(prefix+p.field+"Port", "xsd:int", port)
+			set(prefix+p.field+"PortRangeMax", "xsd:int", max)
+		}
+	case PolicyRuleNat:
+		if rule.Action != "dst-nat" {
+			return fmt.Errorf("nat action %s is not supported by TR-181, only dst-nat port mappings", rule.Action)
+		}
+		protocol := strings.ToUpper(rule.Protocol)
+		if protocol != "TCP" && protocol != "UDP" {
+			return fmt.Errorf("port mapping protocol must be tcp or udp")
+		}
+		remote, _, err := tr181Address(rule.SrcAddress)
+		if err != nil {
+			return err
+		}
+		set(prefix+"Enable", "xsd:boolean", enable)
+		set(prefix+"Description", "xsd:string", rule.Comment)
+		set(prefix+"Protocol", "xsd:string", protocol)
+		set(prefix+"RemoteHost", "xsd:string", remote)
+		set(prefix+"ExternalPort", "xsd:unsignedInt", common.IfEmptyStr(rule.DstPort, "0"))
+		set(prefix+"InternalPort", "xsd:unsignedInt", common.IfEmptyStr(rule.ToPorts, rule.DstPort))
+		set(prefix+"InternalClient", "xsd:string", rule.ToAddresses)
+	case PolicyRuleRoute:
+		ip, mask, err := tr181Address(rule.DstAddress)
+		if err != nil {
+			return err
+		}
+		set(prefix+"Enable", "xsd:boolean", enable)
+		set(prefix+"DestIPAddress", "xsd:string", ip)
+		set(prefix+"DestSubnetMask", "xsd:string", mask)
+		set(prefix+"GatewayIPAddress", "xsd:string", rule.Gateway)
+		if rule.Distance > 0 {
+			set(prefix+"ForwardingMetric", "xsd:int", strconv.Itoa(rule.Distance))
+		}
+	case PolicyRuleAddressList:
+		return fmt.Errorf("address lists are not supported by TR-181")
+	}
+	return nil
+}
+
+func tr181Protocol(protocol string) (string, error) {
+	switch strings.ToLower(protocol) {
+	case "":
+		return "-1", nil
+	case "icmp":
+		return "1", nil
+	case "tcp":
+		return "6", nil
+	case "udp":
+		return "17", nil
+	}
+	if n, err := strconv.Atoi(protocol); err == nil && n >= 0 && n <= 255 {
+		return protocol, nil
+	}
+	return "", fmt.Errorf("protocol %s is not supported by TR-181", protocol)
+}
+
+// tr181Address Address and subnet mask of an address or a network
+func tr181Address(addr string) (string, string, error) {
+	if addr == "" {
+		return "", "", nil
+	}
+	if !strings.Contains(addr, "/") {
+		if net.ParseIP(addr).To4() == nil {
+			return "", "", fmt.Errorf("invalid IPv4 address %s", addr)
+		}
+		return addr, "255.255.255.255", nil
+	}
+	ip, ipnet, err := net.ParseCIDR(addr)
+	if err != nil || ip.To4() == nil {
+		return "", "", fmt.Errorf("invalid IPv4 network %s", addr)
+	}
+	return ip.String(), net.IP(ipnet.Mask).String(), nil
+}
+
+// tr181PortRange Port and range end of a port or a range, -1 for any
+func tr181PortRange(port string) (string, string, error) {
+	if port == "" {
+		return "-1", "-1", nil
+	}
+	first, last, isRange := strings.Cut(port, "-")
+	p1, err1 := strconv.Atoi(first)
+	if err1 != nil || p1 < 0 || p1 > 65535 {
+		return "", "", fmt.Errorf("port %s is not supported by TR-181", port)
+	}
+	if !isRange {
+		return first, "-1", nil
+	}
+	p2, err2 := strconv.Atoi(last)
+	if err2 != nil || p2 < p1 || p2 > 65535 {
+		return "", "", fmt.Errorf("port %s is not supported by TR-181", port)
+	}
+	return first, last, nil
+}
+
+// PolicyInstances Object instances of the policy rules on the device
+func (a *Application) PolicyInstances(policyId int64, sn string) ([]models.NetPolicyInstance, error) {
+	var items []models.NetPolicyInstance
+	err := a.gormDB.Where("policy_id = ? and sn = ?", policyId, sn).Find(&items).Error
+	return items, err
+}
+
+// PushTr181Policy Push the policy to a TR-181 device. The instances of the removed rules
+// are deleted, an instance is added for each new rule and set when the device answers
+// with its number (see OnAddObjectResponse), the other rules are set in one request.
+func (a *Application) PushTr181Policy(policy models.NetPolicy, rules []models.NetPolicyRule, dev models.NetCpe, session string) error {
+	if _, err := RenderTr181Policy(rules, nil); err != nil {
+		return err
+	}
+	items, err := a.PolicyInstances(policy.ID, dev.Sn)
+	if err != nil {
+		return err
+	}
+	cpe := a.CwmpTable().GetCwmpCpe(dev.Sn)
+	byRule := make(map[int64]models.NetPolicyRule, len(rules))
+	for _, rule := range rules {
+		byRule[rule.ID] = rule
+	}
+	instances := make(map[int64]int)
+	for _, item := range items {
+		rule, ok := byRule[item.RuleId]
+		switch {
+		case ok && item.Instance > 0 && item.Object == tr181PolicyObjects[rule.Kind]:
+			instances[item.RuleId] = item.Instance
+			continue
+		case item.Instance == 0:
+			// never created or the AddObject is unanswered, a late answer deletes the instance
+			a.gormDB.Delete(&models.NetPolicyInstance{}, item.ID)
+			continue
+		}
+		id := PolicySessionPrefix + "del-" + common.UUID()
+		err = a.gormDB.Model(&models.NetPolicyInstance{}).Where("id = ?", item.ID).
+			Updates(map[string]interface{}{"session": id, "updated_at": time.Now()}).Error
+		if err == nil {
+			err = cpe.SendCwmpEventData(models.CwmpEventData{
+				Session: session,
+				Sn:      dev.Sn,
+				Message: &cwmp.DeleteObject{ID: id, ObjectName: fmt.Sprintf("%s%d.", item.Object, item.Instance)},
+			}, 5000, false)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	params := make(map[string]cwmp.ValueStruct)
+	for _, rule := range rules {
+		if n, ok := instances[rule.ID]; ok {
+			if err = renderTr181Rule(params, rule, strconv.Itoa(n), tr181FilterOrder(rules, rule.ID)); err != nil {
+				return err
+			}
+			continue
+		}
+		id := PolicySessionPrefix + "add-" + rule.Kind + "-" + common.UUID()
+		err = a.gormDB.Clauses(clause.OnConflict{
+			Columns:   []clause.Column{{Name: "policy_id"}, {Name: "sn"}, {Name: "rule_id"}},
+			DoUpdates: clause.AssignmentColumns([]string{"object", "instance", "session", "updated_at"}),
+		}).Create(&models.NetPolicyInstance{
+			ID:        common.UUIDint64(),
+			PolicyId:  policy.ID,
+			Sn:        dev.Sn,
+			RuleId:    rule.ID,
+			Object:    tr181PolicyObjects[rule.Kind],
+			Session:   id,
+			UpdatedAt: time.Now(),
+		}).Error
+		if err == nil {
+			err = cpe.SendCwmpEventData(models.CwmpEventData{
+				Session: session,
+				Sn:      dev.Sn,
+				Message: &cwmp.AddObject{ID: id, ObjectName: tr181PolicyObjects[rule.Kind]},
+			}, 5000, false)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if len(params) == 0 {
+		return nil
+	}
+	if err = cpe.PrepareParameterValues(params); err != nil {
+		return err
+	}
+	return cpe.SendCwmpEventData(models.CwmpEventData{
+		Session: session,
+		Sn:      dev.Sn,
+		Message: &cwmp.SetParameterValues{ID: session, NoMore: 0, Params: params},
+	}, 5000, false)
+}
+
+// ClearPolicyObject The DeleteObject of a policy instance is answered, or the device
+// rejected an AddObject or a DeleteObject. The instance is forgotten: a failed add is
+// sent again on the next push, a failed delete is the one of an instance already gone.
+func (a *Application) ClearPolicyObject(id string) {
+	if strings.HasPrefix(id, PolicySessionPrefix) {
+		a.gormDB.Where("session = ?", id).Delete(&models.NetPolicyInstance{})
+	}
+}
+
+// OnAddObjectResponse Keep the instance created for a policy rule and return the
+// SetParameterValues of the rule, or the DeleteObject of an instance no longer wanted
+func (c *CwmpCpe) OnAddObjectResponse(resp *cwmp.AddObjectResponse) cwmp.Message {
+	if !strings.HasPrefix(resp.ID, PolicySessionPrefix) || resp.InstanceNumber <= 0 {
+		return nil
+	}
+	var item models.NetPolicyInstance
+	err := app.gormDB.Where("sn = ? and session = ?", c.Sn, resp.ID).First(&item).Error
+	if err != nil {
+		// the rule was removed or pushed again while the AddObject was pending, the
+		// request ID holds the rule kind as the response has no object name
+		kind, _, _ := strings.Cut(strings.TrimPrefix(resp.ID, PolicySessionPrefix+"add-"), "-")
+		if object, ok := tr181PolicyObjects[kind]; ok {
+			return &cwmp.DeleteObject{
+				ID:         PolicySessionPrefix + "del-" + common.UUID(),
+				ObjectName: fmt.Sprintf("%s%d.", object, resp.InstanceNumber),
+			}
+		}
+		return nil
+	}
+	app.gormDB.Model(&models.NetPolicyInstance{}).Where("id = ?", item.ID).
+		Updates(map[string]interface{}{"instance": resp.InstanceNumber, "session": "", "updated_at": time.Now()})
+	rules, err := app.PolicyRules(item.PolicyId)
+	if err != nil {
+		log.Errorf("policy %d rules: %s", item.PolicyId, err.Error())
+		return nil
+	}
+	for _, rule := range rules {
+		if rule.ID != item.RuleId {
+			continue
+		}
+		params := make(map[string]cwmp.ValueStruct)
+		err = renderTr181Rule(params, rule, strconv.Itoa(resp.InstanceNumber), tr181FilterOrder(rules, rule.ID))
+		if err == nil {
+			err = c.PrepareParameterValues(params)
+		}
+		if err != nil {
+			log.Errorf("policy %d rule %d: %s", item.PolicyId, rule.ID, err.Error())
+			return nil
+		}
+		spv := &cwmp.SetParameterValues{ID: resp.ID + "-set", NoMore: 0, Params: params}
+		c.TrackSetParameterValues(spv, ParamSourceSPV)
+		return spv
+	}
+	return nil
+}
+
+// PolicyDigest Digest of the rendered policy, a change of the policy changes the digest
+func PolicyDigest(content string) string {
+	return common.Md5Hash(content)
+}
+
+// Tr181PolicyContent Stable text of TR-181 params for the digest and the preview
+func Tr181PolicyContent(params map[string]cwmp.ValueStruct) string {
+	var names = make([]string, 0, len(params))
+	for name := range params {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var b strings.Builder
+	for _, name := range names {
+		b.WriteString(fmt.Sprintf("%s = %s (%s)\n", name, params[name].Value, params[name].Type))
+	}
+	return b.String()
+}
+
+// UpdatePolicyPush Save the push result of the policy on the device
+func (a *Application) UpdatePolicyPush(policyId int64, sn, method, digest, session string, err error) {
+	item := models.NetPolicyDevice{
+		ID:          common.UUIDint64(),
+		PolicyId:    policyId,
+		Sn:          sn,
+		Method:      method,
+		Digest:      digest,
+		Session:     session,
+		PushStatus:  PolicyPushed,
+		PushTime:    time.Now(),
+		DriftStatus: PolicyDriftUnknown,
+		UpdatedAt:   time.Now(),
+	}
+	if err != nil {
+		item.PushStatus = PolicyPushFailure
+		item.PushError = err.Error()
+	}
+	a.gormDB.Clauses(clause.OnConflict{
+		Columns: []clause.Column{{Name: "policy_id"}, {Name: "sn"}},
+		DoUpdates: clause.AssignmentColumns([]string{"method", "digest", "session", "push_status",
+			"push_error", "push_time", "drift_status", "updated_at"}),
+	}).Create(&item)
+}
+
+// CheckPolicyDrift Compare the policy with the config reported by the device, the
+// RouterOS API for Mikrotik devices and the values read again for TR-181 devices.
+// The result is kept as the drift state of the policy on the device.
+func (a *Application) CheckPolicyDrift(policy models.NetPolicy, rules []models.NetPolicyRule, dev models.NetCpe) (string, []string) {
+	status, detail := PolicyDriftUnknown, []string{}
+	method, err := a.PolicyMethod(dev)
+	switch {
+	case err != nil:
+		detail = append(detail, err.Error())
+	case method == PolicyMethodRouteros:
+		status, detail = a.routerosPolicyDrift(policy, rules, dev)
+	default:
+		status, detail = a.tr181PolicyDrift(policy, rules, dev)
+	}
+	a.savePolicyDrift(policy.ID, dev.Sn, method, status, detail)
+	return status, detail
+}
+
+// savePolicyDrift Keep the drift state of the policy on the device
+func (a *Application) savePolicyDrift(policyId int64, sn, method, status string, detail []string) {
+	item := models.NetPolicyDevice{
+		ID:          common.UUIDint64(),
+		PolicyId:    policyId,
+		Sn:          sn,
+		Method:      method,
+		DriftStatus: status,
+		DriftDetail: strings.Join(detail, "\n"),
+		CheckTime:   time.Now(),
+		UpdatedAt:   time.Now(),
+	}
+	a.gormDB.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "policy_id"}, {Name: "sn"}},
+		DoUpdates: clause.AssignmentColumns([]string{"method", "drift_status", "drift_detail", "check_time", "updated_at"}),
+	}).Create(&item)
+}
+
+func (a *Application) routerosPolicyDrift(policy models.NetPolicy, rules []models.NetPolicyRule, dev models.NetCpe) (string, []string) {
+	client, err := a.DialRouteros(dev)
+	if err != nil {
+		return PolicyDriftUnknown, []string{err.Error()}
+	}
+	defer client.Close()
+	tag := policyTag(policy.ID)
+	var detail []string
+	for _, m := range routerosPolicyMenus {
+		reply, err := client.Run(m.Menu + "/print")
+		if err != nil {
+			return PolicyDriftUnknown, []string{fmt.Sprintf("%s: %s", m.Menu, err.Error())}
+		}
+		var entries []map[string]string
+		for _, s := range reply.Re {
+			if strings.HasPrefix(s.Map["comment"], tag) {
+				entries = append(entries, s.Map)
+			}
+		}
+		var expected [][][2]string
+		for _, rule := range rules {
+			if rule.Kind == m.Kind {
+				expected = append(expected, routerosPolicyAttrs(policy.ID, rule))
+			}
+		}
+		if len(entries) != len(expected) {
+			detail = append(detail, fmt.Sprintf("%s: %d entries on the device, %d expected", m.Menu, len(entries), len(expected)))
+		}
+		for i := 0; i < len(entries) && i < len(expected); i++ {
+			for _, attr := range expected[i] {
+				value := entries[i][attr[0]]
+				if routerosNormalize(value) != routerosNormalize(attr[1]) {
+					detail = append(detail, fmt.Sprintf("%s #%d: %s is %q, expected %q", m.Menu, i+1, attr[0], value, attr[1]))
+				}
+			}
+		}
+	}
+	return common.If(len(detail) == 0, PolicyInSync, PolicyDrift).(string), detail
+}
+
+// routerosNormalize RouterOS prints booleans as true/false and host addresses without /32
+func routerosNormalize(value string) string {
+	switch value {
+	case "yes":
+		return "true"
+	case "no":
+		return "false"
+	}
+	return strings.TrimSuffix(value, "/32")
+}
+
+// tr181PolicyDrift Read the rule instances from the device, the state is unknown
+// until the values are compared in OnPolicyDriftValues
+func (a *Application) tr181PolicyDrift(policy models.NetPolicy, rules []models.NetPolicyRule, dev models.NetCpe) (string, []string) {
+	_, objects, detail, err := a.tr181PolicyExpected(policy.ID, rules, dev.Sn)
+	if err != nil {
+		return PolicyDriftUnknown, []string{err.Error()}
+	}
+	if len(objects) == 0 {
+		return common.If(len(detail) == 0, PolicyInSync, PolicyDrift).(string), detail
+	}
+	cpe := a.CwmpTable().GetCwmpCpe(dev.Sn)
+	id := fmt.Sprintf("%s%d-%s", policyDriftSessionPrefix, policy.ID, common.UUID())
+	err = cpe.SendCwmpEventData(models.CwmpEventData{
+		Session: id,
+		Sn:      dev.Sn,
+		Message: &cwmp.GetParameterValues{ID: id, Name: "PolicyDrift", NoMore: 0, ParameterNames: objects},
+	}, 5000, false)
+	if err != nil {
+		return PolicyDriftUnknown, append(detail, "read request error "+err.Error())
+	}
+	return PolicyDriftUnknown, append(detail, "waiting for the values read from the device")
+}
+
+// tr181PolicyExpected The values the rules created on the device must have, the
+// instance objects to read them and the rules missing on the device
+func (a *Application) tr181PolicyExpected(policyId int64, rules []models.NetPolicyRule, sn string) (map[string]cwmp.ValueStruct, []string, []string, error) {
+	items, err := a.PolicyInstances(policyId, sn)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	var detail, objects []string
+	byRule := make(map[int64]models.NetPolicyRule, len(rules))
+	for _, rule := range rules {
+		byRule[rule.ID] = rule
+	}
+	instances := make(map[int64]int)
+	for _, item := range items {
+		switch {
+		case item.Instance == 0:
+		case item.Object != tr181PolicyObjects[byRule[item.RuleId].Kind]:
+			detail = append(detail, fmt.Sprintf("%s%d. of a removed rule is on the device", item.Object, item.Instance))
+		default:
+			instances[item.RuleId] = item.Instance
+		}
+	}
+	params := make(map[string]cwmp.ValueStruct)
+	for _, rule := range rules {
+		n, ok := instances[rule.ID]
+		if !ok {
+			detail = append(detail, fmt.Sprintf("%s rule %d is not created on the device", rule.Kind, rule.ID))
+			continue
+		}
+		if err = renderTr181Rule(params, rule, strconv.Itoa(n), tr181FilterOrder(rules, rule.ID)); err != nil {
+			return nil, nil, nil, err
+		}
+		objects = append(objects, fmt.Sprintf("%s%d.", tr181PolicyObjects[rule.Kind], n))
+	}
+	return params, objects, detail, nil
+}
+
+// tr181PolicyCompare Differences of the values read from the device with the expected ones
+func tr181PolicyCompare(expected map[string]cwmp.ValueStruct, values map[string]string) []string {
+	var names = make([]string, 0, len(expected))
+	for name := range expected {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var detail []string
+	for _, name := range names {
+		value, ok := values[name]
+		if !ok {
+			detail = append(detail, fmt.Sprintf("%s not reported", name))
+			continue
+		}
+		want := expected[name]
+		if tr181Normalize(want.Type, value) != tr181Normalize(want.Type, want.Value) {
+			detail = append(detail, fmt.Sprintf("%s is %q, expected %q", name, value, want.Value))
+		}
+	}
+	return detail
+}
+
+// OnPolicyDriftValues Compare the policy instance values read by the drift check
+func (c *CwmpCpe) OnPolicyDriftValues(resp *cwmp.GetParameterValuesResponse) {
+	if !strings.HasPrefix(resp.ID, policyDriftSessionPrefix) {
+		return
+	}
+	idstr, _, _ := strings.Cut(strings.TrimPrefix(resp.ID, policyDriftSessionPrefix), "-")
+	var policy models.NetPolicy
+	if app.gormDB.Where("id = ?", cast.ToInt64(idstr)).First(&policy).Error != nil {
+		return
+	}
+	rules, err := app.PolicyRules(policy.ID)
+	if err != nil {
+		app.savePolicyDrift(policy.ID, c.Sn, PolicyMethodTr181, PolicyDriftUnknown, []string{err.Error()})
+		return
+	}
+	expected, _, detail, err := app.tr181PolicyExpected(policy.ID, rules, c.Sn)
+	if err != nil {
+		app.savePolicyDrift(policy.ID, c.Sn, PolicyMethodTr181, PolicyDriftUnknown, []string{err.Error()})
+		return
+	}
+	detail = append(detail, tr181PolicyCompare(expected, resp.Values)...)
+	app.savePolicyDrift(policy.ID, c.Sn, PolicyMethodTr181,
+		common.If(len(detail) == 0, PolicyInSync, PolicyDrift).(string), detail)
+}
+
+func tr181Normalize(vtype, value string) string {
+	if vtype == "xsd:boolean" {
+		switch strings.ToLower(value) {
+		case "1", "true":
+			return "true"
+		case "0", "false":
+			return "false"
+		}
+	}
+	return value
+}
+
+// SchedCheckPolicyDrift Drift check of the enabled policies on their devices
+func (a *Application) SchedCheckPolicyDrift() {
+	defer func() {
+		if err := recover(); err != nil {
+			log.Error(err)
+		}
+	}()
+	var policies []models.NetPolicy
+	a.gormDB.Where("status = ?", common.ENABLED).Find(&policies)
+	for _, policy := range policies {
+		rules, err := a.PolicyRules(policy.ID)
+		if err != nil {
+			log.Errorf("policy %s rules: %s", policy.Name, err.Error())
+			continue
+		}
+		devs, err := a.PolicyDevices(policy.ID)
+		if err != nil {
+			log.Errorf("policy %s devices: %s", policy.Name, err.Error())
+			continue
+		}
+		// the RouterOS checks wait on the devices, a few of them run at once
+		sem := make(chan struct{}, policyDriftConcurrency)
+		var wg sync.WaitGroup
+		for _, dev := range devs {
+			sem <- struct{}{}
+			wg.Add(1)
+			go func(dev models.NetCpe) {
+				defer func() {
+					if err := recover(); err != nil {
+						log.Error(err)
+					}
+					<-sem
+					wg.Done()
+				}()
+				a.CheckPolicyDrift(policy, rules, dev)
+			}(dev)
+		}
+		wg.Wait()
+	}
+}
@@ -0,0 +1,31 @@
+package app
+
+import (
+	"testing"
+
+	"github.com/ca17/teamsacs/common/cwmp"
+)
+
+func TestTr181PolicyCompare(t *testing.T) {
+	expected := map[string]cwmp.ValueStruct{
+		"Device.NAT.PortMapping.2.Enable":       {Type: "xsd:boolean", Value: "true"},
+		"Device.NAT.PortMapping.2.ExternalPort": {Type: "xsd:unsignedInt", Value: "8080"},
+		"Device.NAT.PortMapping.2.InternalPort": {Type: "xsd:unsignedInt", Value: "80"},
+	}
+	detail := tr181PolicyCompare(expected, map[string]string{
+		"Device.NAT.PortMapping.2.Enable":       "1",
+		"Device.NAT.PortMapping.2.ExternalPort": "8081",
+	})
+	want := []string{
+		`Device.NAT.PortMapping.2.ExternalPort is "8081", expected "8080"`,
+		"Device.NAT.PortMapping.2.InternalPort not reported",
+	}
+	if len(detail) != len(want) {
+		t.Fatalf("detail = %v, want %v", detail, want)
+	}
+	for i := range want {
+		if detail[i] != want[i] {
+			t.Errorf("detail[%d] = %q, want %q", i, detail[i], want[i])
+		}
+	}
+}
@@ -0,0 +1,133 @@
+package app
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// Prometheus metrics, exposed on the /metrics endpoint of the admin and tr069 servers.
+// The tstorage log metrics are kept for the dashboard.
+var (
+	PromCwmpInforms = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "teamsacs",
+		Subsystem: "cwmp",
+		Name:      "informs_total",
+		Help:      "Informs received by event code and vendor",
+	}, []string{"event", "vendor"})
+
+	PromCwmpSessionsActive = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: "teamsacs",
+		Subsystem: "cwmp",
+		Name:      "sessions_active",
+		Help:      "CWMP sessions in progress",
+	})
+
+	PromCwmpSessionDuration = promauto.NewHistogram(prometheus.HistogramOpts{
+		Namespace: "teamsacs",
+		Subsystem: "cwmp",
+		Name:      "session_duration_seconds",
+		Help:      "Duration of the CWMP sessions",
+		Buckets:   prometheus.ExponentialBuckets(0.1, 2, 12),
+	})
+
+	PromCwmpRpcSent = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "teamsacs",
+		Subsystem: "cwmp",
+		Name:      "rpc_sent_total",
+		Help:      "Messages sent to the CPEs by type",
+	}, []string{"type"})
+
+	PromCwmpRpcFaults = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "teamsacs",
+		Subsystem: "cwmp",
+		Name:      "rpc_faults_total",
+		Help:      "RPCs answered with a CWMP fault by type and fault code",
+	}, []string{"type", "code"})
+
+	PromPresetTasks = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "teamsacs",
+		Subsystem: "preset",
+		Name:      "tasks_total",
+		Help:      "Preset task outcomes",
+	}, []string{"status"})
+
+	PromConnReq = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "teamsacs",
+		Subsystem: "connreq",
+		Name:      "requests_total",
+		Help:      "Connection requests by method and status",
+	}, []string{"method", "status"})
+
+	PromOltPollDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "teamsacs",
+		Subsystem: "olt",
+		Name:      "poll_duration_seconds",
+		Help:      "Duration of the OLT SNMP polls",
+		Buckets:   prometheus.ExponentialBuckets(0.5, 2, 10),
+	}, []string{"olt"})
+
+	PromOltPollErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "teamsacs",
+		Subsystem: "olt",
+		Name:      "poll_errors_total",
+		Help:      "Failed OLT SNMP polls",
+	}, []string{"olt"})
+
+	PromOltOnus = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "teamsacs",
+		Subsystem: "olt",
+		Name:      "onus",
+		Help:      "ONUs by phase state at the last poll",
+	}, []string{"olt", "phase_state"})
+)
+
+func init() {
+	prometheus.MustRegister(&cwmpQueueCollector{})
+}
+
+var (
+	promQueueDepth = prometheus.NewDesc("teamsacs_cwmp_queue_depth",
+		"RPCs waiting for a CWMP session by priority", []string{"priority"}, nil)
+	promInformQueue = prometheus.NewDesc("teamsacs_cwmp_inform_queue",
+		"Informs waiting for a worker", nil, nil)
+	promInformBusy = prometheus.NewDesc("teamsacs_cwmp_inform_workers_busy",
+		"Inform workers processing an Inform", nil, nil)
+	promInformRejected = prometheus.NewDesc("teamsacs_cwmp_informs_rejected_total",
+		"Informs rejected on overload", nil, nil)
+)
+
+// cwmpQueueCollector Queue depths read at scrape time
+type cwmpQueueCollector struct{}
+
+func (c *cwmpQueueCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- promQueueDepth
+	ch <- promInformQueue
+	ch <- promInformBusy
+	ch <- promInformRejected
+}
+
+func (c *cwmpQueueCollector) Collect(ch chan<- prometheus.Metric) {
+	if app == nil || app.gormDB == nil {
+		return
+	}
+	var rows []struct {
+		Hp    bool
+		Count int64
+	}
+	app.gormDB.Raw("select hp, count(*) as count from cwmp_queue_item group by hp").Scan(&rows)
+	depth := map[string]int64{"high": 0, "normal": 0}
+	for _, r := range rows {
+		if r.Hp {
+			depth["high"] = r.Count
+		} else {
+			depth["normal"] = r.Count
+		}
+	}
+	for priority, count := range depth {
+		ch <- prometheus.MustNewConstMetric(promQueueDepth, prometheus.GaugeValue, float64(count), priority)
+	}
+	stats := app.InformPoolStats()
+	ch <- prometheus.MustNewConstMetric(promInformQueue, prometheus.GaugeValue, float64(stats.Queued))
+	ch <- prometheus.MustNewConstMetric(promInformBusy, prometheus.GaugeValue, float64(stats.Busy))
+	ch <- prometheus.MustNewConstMetric(promInformRejected, prometheus.CounterValue, float64(stats.Rejected))
+}
@@ -0,0 +1,201 @@
+package app
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ca17/teamsacs/common"
+	"github.com/ca17/teamsacs/common/radius"
+	"github.com/ca17/teamsacs/common/zaplog/log"
+	"github.com/ca17/teamsacs/models"
+	"github.com/spf13/cast"
+	"gorm.io/gorm/clause"
+)
+
+// radiusSnRetry Interval of the device lookups of a username without a device
+const radiusSnRetry = 10 * time.Minute
+
+// radiusSnMisses Time of the last lookup of the usernames without a device, the
+// lookup scans the WAN info of all the devices and must not run on every Interim-Update
+var radiusSnMisses sync.Map
+
+// RADIUS session status
+const (
+	RadiusOnline  = "online"
+	RadiusOffline = "offline"
+)
+
+// SaveRadiusNas Create or update a NAS, an empty secret keeps the stored one
+func (a *Application) SaveRadiusNas(nas *models.NetRadiusNas, secret string) error {
+	nas.Ipaddr = strings.TrimSpace(nas.Ipaddr)
+	nas.Status = common.IfEmptyStr(nas.Status, common.ENABLED)
+	nas.UpdatedAt = time.Now()
+	var count int64
+	a.gormDB.Model(&models.NetRadiusNas{}).Where("ipaddr = ? and id <> ?", nas.Ipaddr, nas.ID).Count(&count)
+	if count > 0 {
+		return fmt.Errorf("NAS %s already exists", nas.Ipaddr)
+	}
+	columns := []string{"name", "ipaddr", "status", "remark", "updated_at"}
+	if secret != "" {
+		nas.Secret = a.encryptConnReqPassword(secret)
+		columns = append(columns, "secret")
+	}
+	if nas.ID == 0 {
+		if secret == "" {
+			return fmt.Errorf("NAS secret is required")
+		}
+		nas.ID = common.UUIDint64()
+		nas.CreatedAt = time.Now()
+		return a.gormDB.Create(nas).Error
+	}
+	return a.gormDB.Model(nas).Select(columns).Updates(nas).Error
+}
+
+// RadiusNasSecret Shared secret of the enabled NAS with the address
+func (a *Application) RadiusNasSecret(ipaddr string) ([]byte, bool) {
+	var nas models.NetRadiusNas
+	err := a.gormDB.Where("ipaddr = ? and status = ?", ipaddr, common.ENABLED).First(&nas).Error
+	if err != nil {
+		return nil, false
+	}
+	return []byte(a.decryptConnReqPassword(nas.Secret)), true
+}
+
+// RadiusUsernameSn Device with the PPPoE username in its WAN connections,
+// the device of the subscriber with the username otherwise
+func (a *Application) RadiusUsernameSn(username string) string {
+	if username == "" {
+		return ""
+	}
+	if last, ok := radiusSnMisses.Load(username); ok && time.Since(last.(time.Time)) < radiusSnRetry {
+		return ""
+	}
+	quoted, _ := json.Marshal(username)
+	like := strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(`"username":` + string(quoted))
+	var sn string
+	a.gormDB.Model(&models.NetCpe{}).Where("wan_info like ?", "%"+like+"%").
+		Order("updated_at desc").Limit(1).Pluck("sn", &sn)
+	if sn != "" {
+		return sn
+	}
+	a.gormDB.Model(&models.NetSubscriber{}).Where("pppoe_username = ? and cpe_sn <> ''", username).
+		Limit(1).Pluck("cpe_sn", &sn)
+	if sn == "" {
+		radiusSnMisses.Store(username, time.Now())
+	} else {
+		radiusSnMisses.Delete(username)
+	}
+	return sn
+}
+
+// UpdateRadiusAccounting Record the Start, Interim-Update and Stop of a PPPoE session,
+// Accounting-On and Accounting-Off end all the sessions of the NAS
+func (a *Application) UpdateRadiusAccounting(nasip string, p *radius.Packet) error {
+	now := time.Now()
+	status := p.Uint32(radius.AttrAcctStatusType)
+	switch status {
+	case radius.AcctStatusAccountingOn, radius.AcctStatusAccountingOff:
+		return a.gormDB.Model(&models.NetRadiusSession{}).
+			Where("nas_ipaddr = ? and status = ?", nasip, RadiusOnline).
+			Updates(map[string]interface{}{
+				"status":          RadiusOffline,
+				"terminate_cause": "NAS-Reboot",
+				"update_time":     now,
+				"stop_time":       now,
+			}).Error
+	case radius.AcctStatusStart, radius.AcctStatusInterimUpdate, radius.AcctStatusStop:
+	default:
+		return nil
+	}
+
+	sessionId := p.String(radius.AttrAcctSessionId)
+	if sessionId == "" {
+		return fmt.Errorf("Acct-Session-Id is missing")
+	}
+	sessionTime := int(p.Uint32(radius.AttrAcctSessionTime))
+	now = now.Add(-time.Duration(p.Uint32(radius.AttrAcctDelayTime)) * time.Second)
+	values := map[string]interface{}{
+		"status":        RadiusOnline,
+		"session_time":  sessionTime,
+		"input_octets":  p.InputOctets(),
+		"output_octets": p.OutputOctets(),
+		"update_time":   now,
+	}
+	if ip := p.IP(radius.AttrFramedIPAddress); ip != nil {
+		values["framed_ip"] = ip.String()
+	}
+	if status == radius.AcctStatusStop {
+		values["status"] = RadiusOffline
+		values["terminate_cause"] = p.TerminateCause()
+		values["stop_time"] = now
+	}
+
+	var sess models.NetRadiusSession
+	err := a.gormDB.Where("nas_ipaddr = ? and acct_session_id = ?", nasip, sessionId).First(&sess).Error
+	if err == nil {
+		// a late Interim-Update or a retransmitted Start must not bring an ended session online
+		if !sess.StopTime.IsZero() && status != radius.AcctStatusStop {
+			return nil
+		}
+		if sess.Sn == "" {
+			values["sn"] = a.RadiusUsernameSn(sess.Username)
+		}
+		return a.gormDB.Model(&models.NetRadiusSession{}).Where("id = ?", sess.ID).Updates(values).Error
+	}
+
+	username := p.String(radius.AttrUserName)
+	sess = models.NetRadiusSession{
+		ID:               common.UUIDint64(),
+		NasIpaddr:        nasip,
+		AcctSessionId:    sessionId,
+		Username:         username,
+		Sn:               a.RadiusUsernameSn(username),
+		FramedIp:         cast.ToString(values["framed_ip"]),
+		CallingStationId: p.String(radius.AttrCallingStationId),
+		NasPortId:        p.String(radius.AttrNasPortId),
+		Status:           values["status"].(string),
+		SessionTime:      sessionTime,
+		InputOctets:      p.InputOctets(),
+		OutputOctets:     p.OutputOctets(),
+		TerminateCause:   cast.ToString(values["terminate_cause"]),
+		StartTime:        now.Add(-time.Duration(sessionTime) * time.Second),
+		UpdateTime:       now,
+	}
+	if status == radius.AcctStatusStop {
+		sess.StopTime = now
+	}
+	// a retransmitted request may race with the first one, only a Stop updates an ended session
+	conflict := clause.OnConflict{
+		Columns:   []clause.Column{{Name: "nas_ipaddr"}, {Name: "acct_session_id"}},
+		DoUpdates: clause.Assignments(values),
+	}
+	if status != radius.AcctStatusStop {
+		conflict.Where = clause.Where{Exprs: []clause.Expression{
+			clause.Eq{Column: clause.Column{Table: "net_radius_session", Name: "status"}, Value: RadiusOnline},
+		}}
+	}
+	return a.gormDB.Clauses(conflict).Create(&sess).Error
+}
+
+// DeviceRadiusSession Last RADIUS session of the device
+func (a *Application) DeviceRadiusSession(sn string) (models.NetRadiusSession, bool) {
+	var sess models.NetRadiusSession
+	err := a.gormDB.Where("sn = ?", sn).Order("update_time desc").First(&sess).Error
+	return sess, err == nil
+}
+
+// ClearRadiusSessions Delete the ended sessions older than the retention days
+func (a *Application) ClearRadiusSessions() {
+	days := cast.ToInt(a.GetRadiusSettingsStringValue(ConfigRadiusSessionDays))
+	if days <= 0 {
+		days = 90
+	}
+	err := a.gormDB.Where("status = ? and update_time < ?", RadiusOffline, time.Now().AddDate(0, 0, -days)).
+		Delete(&models.NetRadiusSession{}).Error
+	if err != nil {
+		log.Errorf("ClearRadiusSessions: %s", err.Error())
+	}
+}
@@ -0,0 +1,131 @@
+package app
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ca17/teamsacs/common"
+	"github.com/ca17/teamsacs/common/routeros"
+	"github.com/ca17/teamsacs/models"
+	"gorm.io/gorm/clause"
+)
+
+const routerosDialTimeout = 10 * time.Second
+
+// IsMikrotik RouterOS device, the API actions are offered to these devices only
+func IsMikrotik(dev models.NetCpe) bool {
+	return strings.Contains(strings.ToLower(dev.Manufacturer), "mikrotik")
+}
+
+// RouterosApi Stored API access of the device, empty when never saved
+func (a *Application) RouterosApi(sn string) models.NetCpeApi {
+	var item models.NetCpeApi
+	a.gormDB.Where("sn = ?", sn).Find(&item)
+	return item
+}
+
+// SaveRouterosApi Save the API access of a device, an empty password keeps the stored one
+func (a *Application) SaveRouterosApi(sn, address string, port int, useTls bool, username, password string) error {
+	columns := []string{"address", "port", "use_tls", "username", "updated_at"}
+	item := models.NetCpeApi{
+		ID:        common.UUIDint64(),
+		Sn:        sn,
+		Address:   address,
+		Port:      port,
+		UseTls:    useTls,
+		Username:  username,
+		CreatedAt: time.Now(),
+		UpdatedAt: time.Now(),
+	}
+	if password != "" {
+		item.Password = a.encryptConnReqPassword(password)
+		columns = append(columns, "password")
+	}
+	return a.gormDB.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "sn"}},
+		DoUpdates: clause.AssignmentColumns(columns),
+	}).Create(&item).Error
+}
+
+// routerosCredential Device credentials, the MikrotikApi settings by default
+func (a *Application) routerosCredential(api models.NetCpeApi) (string, string) {
+	if api.Username != "" {
+		return api.Username, a.decryptConnReqPassword(api.Password)
+	}
+	return a.GetTr069SettingsStringValue(ConfigMikrotikApiUsername),
+		a.GetTr069SettingsStringValue(ConfigMikrotikApiPassword)
+}
+
+// deviceHost Management address of the device, the host of the ConnectionRequestURL
+// is the address the device is reachable at from the ACS
+func deviceHost(dev models.NetCpe, address string) (string, error) {
+	host := address
+	if host == "" && dev.CwmpUrl != "" {
+		u, err := url.Parse(dev.CwmpUrl)
+		if err == nil {
+			host = u.Hostname()
+		}
+	}
+	if host == "" {
+		return "", fmt.Errorf("device %s has no management address and no ConnectionRequestURL", dev.Sn)
+	}
+	return host, nil
+}
+
+// routerosAddress API address of the device
+func routerosAddress(dev models.NetCpe, api models.NetCpeApi) (string, error) {
+	host, err := deviceHost(dev, api.Address)
+	if err != nil {
+		return "", err
+	}
+	port := api.Port
+	if port <= 0 {
+		port = common.If(api.UseTls, routeros.DefaultTlsPort, routeros.DefaultPort).(int)
+	}
+	return net.JoinHostPort(host, strconv.Itoa(port)), nil
+}
+
+// DialRouteros Connect to the RouterOS API of the device, the result is kept as the API status
+func (a *Application) DialRouteros(dev models.NetCpe) (*routeros.Client, error) {
+	api := a.RouterosApi(dev.Sn)
+	address, err := routerosAddress(dev, api)
+	if err != nil {
+		return nil, err
+	}
+	username, password := a.routerosCredential(api)
+	var tlsConfig *tls.Config
+	if api.UseTls {
+		// the api-ssl service mostly runs with a self-signed certificate
+		tlsConfig = &tls.Config{InsecureSkipVerify: true}
+	}
+	client, err := routeros.Dial(address, username, password, tlsConfig, routerosDialTimeout)
+	a.updateRouterosStatus(dev.Sn, err)
+	if err != nil {
+		return nil, fmt.Errorf("RouterOS API %s: %s", address, err.Error())
+	}
+	return client, nil
+}
+
+func (a *Application) updateRouterosStatus(sn string, err error) {
+	item := models.NetCpeApi{
+		ID:         common.UUIDint64(),
+		Sn:         sn,
+		LastStatus: ConnReqSuccess,
+		LastTime:   time.Now(),
+		CreatedAt:  time.Now(),
+		UpdatedAt:  time.Now(),
+	}
+	if err != nil {
+		item.LastStatus = ConnReqFailure
+		item.LastError = err.Error()
+	}
+	a.gormDB.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "sn"}},
+		DoUpdates: clause.AssignmentColumns([]string{"last_status", "last_error", "last_time", "updated_at"}),
+	}).Create(&item)
+}
@@ -0,0 +1,288 @@
+package app
+
+import (
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ca17/teamsacs/common"
+	"github.com/ca17/teamsacs/common/cwmp"
+	"github.com/ca17/teamsacs/common/zaplog/log"
+	"github.com/ca17/teamsacs/models"
+)
+
+// SubscriberSessionPrefix SetParameterValues sessions of the subscriber provisioning
+const SubscriberSessionPrefix = "subscriber-"
+
+// subscriberRetryInterval Provisioning attempts of an unlinked or unprovisioned device
+const subscriberRetryInterval = 10 * time.Minute
+
+// Subscriber provisioning status
+const (
+	SubscriberPushed  = "pushed"
+	SubscriberSuccess = "success"
+	SubscriberFailure = "failure"
+)
+
+// NormalizePonSn PON serial number in the vendor form, the 16 digit hex
+// form 5A5445471234ABCD is the vendor ID in ASCII and 8 hex digits
+func NormalizePonSn(sn string) string {
+	sn = strings.ToUpper(strings.ReplaceAll(strings.TrimSpace(sn), "-", ""))
+	if len(sn) != 16 {
+		return sn
+	}
+	vendor, err := hex.DecodeString(sn[:8])
+	if err != nil {
+		return sn
+	}
+	for _, b := range vendor {
+		if b < 'A' || b > 'Z' {
+			return sn
+		}
+	}
+	if _, err = hex.DecodeString(sn[8:]); err != nil {
+		return sn
+	}
+	return string(vendor) + sn[8:]
+}
+
+// SaveSubscriber Create or update a subscriber, an empty password keeps the stored one.
+// The device link is set by LinkSubscriber only.
+func (a *Application) SaveSubscriber(sub *models.NetSubscriber, pppoePassword, wifiPassword string) error {
+	sub.CpeSn = ""
+	sub.PonSn = NormalizePonSn(sub.PonSn)
+	sub.Status = common.IfEmptyStr(sub.Status, common.ENABLED)
+	sub.UpdatedAt = time.Now()
+	if sub.PonSn != "" {
+		var count int64
+		a.gormDB.Model(&models.NetSubscriber{}).Where("pon_sn = ? and id <> ?", sub.PonSn, sub.ID).Count(&count)
+		if count > 0 {
+			return fmt.Errorf("PON SN %s belongs to another subscriber", sub.PonSn)
+		}
+	}
+	columns := []string{"account_id", "name", "phone", "address", "service_plan", "status", "pon_sn",
+		"odp_id", "odp_port", "pppoe_username", "vlan_id", "wifi_ssid", "remark", "updated_at"}
+	if pppoePassword != "" {
+		sub.PppoePassword = a.encryptConnReqPassword(pppoePassword)
+		columns = append(columns, "pppoe_password")
+	}
+	if wifiPassword != "" {
+		sub.WifiPassword = a.encryptConnReqPassword(wifiPassword)
+		columns = append(columns, "wifi_password")
+	}
+	if sub.ID == 0 {
+		sub.ID = common.UUIDint64()
+		sub.CreatedAt = time.Now()
+		return a.gormDB.Create(sub).Error
+	}
+	return a.gormDB.Model(sub).Select(columns).Updates(sub).Error
+}
+
+// MatchSubscriber Enabled subscriber of the device, the linked one or the one with the PON SN of the device
+func (a *Application) MatchSubscriber(dev models.NetCpe) (models.NetSubscriber, bool) {
+	var sub models.NetSubscriber
+	err := a.gormDB.Where("status = ? and cpe_sn = ?", common.ENABLED, dev.Sn).First(&sub).Error
+	if err == nil {
+		return sub, true
+	}
+	var sns []string
+	for _, sn := range []string{dev.Sn, dev.PonSnHex} {
+		if sn = NormalizePonSn(sn); sn != "" {
+			sns = append(sns, sn)
+		}
+	}
+	err = a.gormDB.Where("status = ? and pon_sn in ?", common.ENABLED, sns).First(&sub).Error
+	return sub, err == nil
+}
+
+// LinkSubscriber Link the subscriber to the device, the device takes the ODP port of the subscriber
+func (a *Application) LinkSubscriber(sub *models.NetSubscriber, dev models.NetCpe) error {
+	sub.CpeSn = dev.Sn
+	if err := a.gormDB.Model(&models.NetSubscriber{}).Where("id = ?", sub.ID).
+		Updates(map[string]interface{}{"cpe_sn": dev.Sn, "updated_at": time.Now()}).Error; err != nil {
+		return err
+	}
+	if sub.OdpId != 0 && (dev.OdpID != sub.OdpId || dev.OdpPort != sub.OdpPort) {
+		return a.AssignOdpPort(dev, sub.OdpId, sub.OdpPort, dev.DropLength)
+	}
+	return nil
+}
+
+// subscriberParams WAN and WiFi parameters of the subscriber, sent apart as
+// many CPEs reject WAN and WiFi changes in one SetParameterValues
+func (c *CwmpCpe) subscriberParams(sub models.NetSubscriber) (wan, wifi map[string]cwmp.ValueStruct, err error) {
+	wan = make(map[string]cwmp.ValueStruct)
+	wifi = make(map[string]cwmp.ValueStruct)
+	values := []struct {
+		params map[string]cwmp.ValueStruct
+		name   string
+		value  string
+	}{
+		{wan, "PPPoEUsername", sub.PppoeUsername},
+		{wan, "PPPoEPassword", app.decryptConnReqPassword(sub.PppoePassword)},
+		{wan, "WanVlanId", common.If(sub.VlanId > 0, strconv.Itoa(sub.VlanId), "").(string)},
+		{wifi, "WifiSSID.1", sub.WifiSsid},
+		{wifi, "WifiPassword.1", app.decryptConnReqPassword(sub.WifiPassword)},
+	}
+	for _, v := range values {
+		if v.value == "" {
+			continue
+		}
+		path, vtype, err := c.ResolveVirtualParamSet(VirtualParamPrefix + v.name)
+		if err != nil {
+			return nil, nil, err
+		}
+		v.params[path] = cwmp.ValueStruct{Type: vtype, Value: v.value}
+	}
+	if err = c.PrepareParameterValues(wan); err != nil {
+		return nil, nil, err
+	}
+	if err = c.PrepareParameterValues(wifi); err != nil {
+		return nil, nil, err
+	}
+	return wan, wifi, nil
+}
+
+// PushSubscriberConfig Send the WAN and WiFi settings of the subscriber to the device,
+// the provisioning status is confirmed by the SetParameterValuesResponse
+func (c *CwmpCpe) PushSubscriberConfig(sub models.NetSubscriber, timeout int, hp bool) (string, error) {
+	session := SubscriberSessionPrefix + common.UUID()
+	wan, wifi, err := c.subscriberParams(sub)
+	if err == nil && len(wan)+len(wifi) == 0 {
+		err = fmt.Errorf("subscriber %s has no WAN or WiFi settings", sub.AccountId)
+	}
+	for i, params := range []map[string]cwmp.ValueStruct{wan, wifi} {
+		if err != nil {
+			break
+		}
+		if len(params) == 0 {
+			continue
+		}
+		id := fmt.Sprintf("%s-%d", session, i)
+		err = c.SendCwmpEventData(models.CwmpEventData{
+			Session: id,
+			Sn:      c.Sn,
+			Message: &cwmp.SetParameterValues{ID: id, NoMore: 0, Params: params},
+		}, timeout, hp)
+	}
+	values := map[string]interface{}{
+		"provision_status":  SubscriberPushed,
+		"provision_session": session,
+		"provision_error":   "",
+		"provision_time":    time.Now(),
+	}
+	if err != nil {
+		values["provision_status"] = SubscriberFailure
+		values["provision_error"] = err.Error()
+	}
+	app.gormDB.Model(&models.NetSubscriber{}).Where("id = ?", sub.ID).Updates(values)
+	return session, err
+}
+
+// ProvisionSubscriber Zero touch provisioning on bootstrap, a device with the PON SN
+// of a subscriber is linked to the subscriber and gets the subscriber settings
+func (c *CwmpCpe) ProvisionSubscriber(timeout int, hp bool) error {
+	c.provisionCheck.Store(time.Now().Unix())
+	var dev models.NetCpe
+	if err := app.gormDB.Where("sn = ?", c.Sn).First(&dev).Error; err != nil {
+		return err
+	}
+	// the Inform updates are written in batches, the stored PON SN may be missing
+	c.sessionLock.Lock()
+	inform := c.LastInform
+	c.sessionLock.Unlock()
+	if inform != nil {
+		if ponSn := c.GetVirtualParamValue(VirtualParamPrefix+"PonSerialNumber", inform.Params); ponSn != "" {
+			dev.PonSnHex = ponSn
+		}
+	}
+	sub, ok := app.MatchSubscriber(dev)
+	if !ok {
+		return nil
+	}
+	if err := app.LinkSubscriber(&sub, dev); err != nil {
+		return err
+	}
+	_, err := c.PushSubscriberConfig(sub, timeout, hp)
+	if err == nil {
+		log.Infof("subscriber %s provisioned on %s", sub.AccountId, c.Sn)
+	}
+	return err
+}
+
+// RetryProvisionSubscriber Provision the device again on its later Informs, the
+// subscriber may be created after the bootstrap, or the push of the linked subscriber
+// may have failed or never been confirmed by the device
+func (c *CwmpCpe) RetryProvisionSubscriber(timeout int, hp bool) error {
+	if time.Since(time.Unix(c.provisionCheck.Load(), 0)) < subscriberRetryInterval {
+		return nil
+	}
+	c.provisionCheck.Store(time.Now().Unix())
+	var subs []models.NetSubscriber
+	if err := app.gormDB.Where("cpe_sn = ?", c.Sn).Find(&subs).Error; err != nil {
+		return err
+	}
+	if len(subs) == 0 {
+		return c.ProvisionSubscriber(timeout, hp)
+	}
+	var err error
+	for _, sub := range subs {
+		if !subscriberPushPending(sub, time.Now()) {
+			continue
+		}
+		if _, perr := c.PushSubscriberConfig(sub, timeout, hp); perr != nil {
+			err = perr
+		} else {
+			log.Infof("subscriber %s provisioning retried on %s", sub.AccountId, c.Sn)
+		}
+	}
+	return err
+}
+
+// subscriberPushPending The linked subscriber settings are not applied on the device,
+// the push failed, was never made, or is not confirmed within the retry interval
+func subscriberPushPending(sub models.NetSubscriber, now time.Time) bool {
+	switch sub.ProvisionStatus {
+	case SubscriberSuccess:
+		return false
+	case SubscriberPushed:
+		return now.Sub(sub.ProvisionTime) >= subscriberRetryInterval
+	default:
+		return true
+	}
+}
+
+// subscriberSession Provisioning session of a SetParameterValues ID
+func subscriberSession(id string) (string, bool) {
+	if !strings.HasPrefix(id, SubscriberSessionPrefix) {
+		return "", false
+	}
+	return id[:strings.LastIndex(id, "-")], true
+}
+
+// confirmSubscriberProvision The device applied the subscriber settings
+func (c *CwmpCpe) confirmSubscriberProvision(id string) {
+	session, ok := subscriberSession(id)
+	if !ok {
+		return
+	}
+	app.gormDB.Model(&models.NetSubscriber{}).
+		Where("provision_session = ? and provision_status = ?", session, SubscriberPushed).
+		Updates(map[string]interface{}{"provision_status": SubscriberSuccess, "provision_time": time.Now()})
+}
+
+// FailSubscriberProvision The device rejected the subscriber settings with a fault
+func (a *Application) FailSubscriberProvision(id string, fault string) {
+	session, ok := subscriberSession(id)
+	if !ok {
+		return
+	}
+	a.gormDB.Model(&models.NetSubscriber{}).Where("provision_session = ?", session).
+		Updates(map[string]interface{}{
+			"provision_status": SubscriberFailure,
+			"provision_error":  fault,
+			"provision_time":   time.Now(),
+		})
+}
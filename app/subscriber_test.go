@@ -0,0 +1,29 @@
+package app
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ca17/teamsacs/models"
+)
+
+func TestSubscriberPushPending(t *testing.T) {
+	now := time.Now()
+	tests := []struct {
+		status string
+		pushed time.Duration
+		want   bool
+	}{
+		{"", 0, true},
+		{SubscriberFailure, time.Minute, true},
+		{SubscriberPushed, time.Minute, false},
+		{SubscriberPushed, subscriberRetryInterval, true},
+		{SubscriberSuccess, time.Hour, false},
+	}
+	for _, tt := range tests {
+		sub := models.NetSubscriber{ProvisionStatus: tt.status, ProvisionTime: now.Add(-tt.pushed)}
+		if got := subscriberPushPending(sub, now); got != tt.want {
+			t.Errorf("subscriberPushPending(%q, %s) = %v, want %v", tt.status, tt.pushed, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,300 @@
+package app
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ca17/teamsacs/common"
+	"github.com/ca17/teamsacs/common/zaplog/log"
+	"github.com/ca17/teamsacs/models"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/spf13/cast"
+	"gorm.io/gorm/clause"
+)
+
+// Optical budget check status
+const (
+	OpticalNormal   = "normal"
+	OpticalDeviated = "deviated"
+	OpticalUnknown  = "unknown"
+)
+
+// pgUniqueViolation Postgres error code of a unique index violation
+const pgUniqueViolation = "23505"
+
+// splitterLosses Typical insertion loss in dB of the PLC splitter ratios
+var splitterLosses = map[int]float64{2: 3.7, 4: 7.3, 8: 10.5, 16: 13.8, 32: 17.1, 64: 20.5, 128: 24.0}
+
+var floatRe = regexp.MustCompile(`-?\d+(\.\d+)?`)
+
+// ParseDbm Optical power values like "-21.5", "-21.5 dBm"
+func ParseDbm(value string) (float64, bool) {
+	s := floatRe.FindString(value)
+	if s == "" {
+		return 0, false
+	}
+	v, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return 0, false
+	}
+	return v, true
+}
+
+// SplitterLoss The configured insertion loss, the typical loss of the ratio otherwise.
+// A ratio without a typical loss must have its loss configured.
+func SplitterLoss(ratio int, loss float64) (float64, error) {
+	if loss > 0 {
+		return loss, nil
+	}
+	typical, ok := splitterLosses[ratio]
+	if !ok {
+		return 0, fmt.Errorf("no typical loss of the 1:%d splitter, set the splitter loss", ratio)
+	}
+	return typical, nil
+}
+
+// OpticalLoss Loss of one element of the fibre path
+type OpticalLoss struct {
+	Name string  `json:"name"`
+	Loss float64 `json:"loss"` // dB
+}
+
+// FibrePath Passive path of an ONU: OLT PON port, feeder cable, ODC splitter,
+// distribution cable, ODP splitter port and drop cable
+type FibrePath struct {
+	Olt        *models.OltDevice `json:"olt"`
+	PonPort    string            `json:"pon_port"`
+	Odc        *models.OdcDevice `json:"odc"`
+	OdcPort    int               `json:"odc_port"`
+	Odp        *models.OdpDevice `json:"odp"`
+	OdpPort    int               `json:"odp_port"`
+	TxPower    float64           `json:"tx_power"` // OLT launch power dBm
+	Losses     []OpticalLoss     `json:"losses"`
+	ExpectedRx float64           `json:"expected_rx"` // dBm
+}
+
+// DeviceFibrePath Fibre path of the device from its ODP assignment
+func (a *Application) DeviceFibrePath(dev models.NetCpe) (*FibrePath, error) {
+	if dev.OdpID == 0 {
+		return nil, fmt.Errorf("device %s is not assigned to an ODP", dev.Sn)
+	}
+	path := &FibrePath{OdpPort: dev.OdpPort, Odp: new(models.OdpDevice), Odc: new(models.OdcDevice)}
+	if err := a.gormDB.Where("id = ?", dev.OdpID).First(path.Odp).Error; err != nil {
+		return nil, fmt.Errorf("ODP of device %s not found", dev.Sn)
+	}
+	if err := a.gormDB.Where("id = ?", path.Odp.OdcID).First(path.Odc).Error; err != nil {
+		return nil, fmt.Errorf("ODP %s is not linked to an ODC", path.Odp.Name)
+	}
+	path.OdcPort = path.Odp.OdcPort
+	path.PonPort = path.Odc.PonPort
+
+	path.TxPower = cast.ToFloat64(a.GetTr069SettingsStringValue(ConfigOpticalOltTxPower))
+	var olt models.OltDevice
+	if a.gormDB.Where("id = ?", path.Odc.OltID).First(&olt).Error == nil {
+		path.Olt = &olt
+		if olt.PonTxPower != 0 {
+			path.TxPower = olt.PonTxPower
+		}
+	}
+
+	fiberLoss := cast.ToFloat64(a.GetTr069SettingsStringValue(ConfigOpticalFiberLoss))
+	connectorLoss := cast.ToFloat64(a.GetTr069SettingsStringValue(ConfigOpticalConnectorLoss))
+	if err := path.budget(dev.DropLength, fiberLoss, connectorLoss); err != nil {
+		return nil, err
+	}
+	return path, nil
+}
+
+// budget Losses of the cables, splitters and connectors and the expected RX power.
+// fiberLoss is in dB/km, connectorLoss in dB per connector.
+func (p *FibrePath) budget(dropLength int, fiberLoss, connectorLoss float64) error {
+	odcRatio := common.If(p.Odc.SplitRatio > 0, p.Odc.SplitRatio, p.Odc.Capacity).(int)
+	odcLoss, err := SplitterLoss(odcRatio, p.Odc.SplitterLoss)
+	if err != nil {
+		return fmt.Errorf("ODC %s: %s", p.Odc.Name, err)
+	}
+	odpLoss, err := SplitterLoss(p.Odp.Capacity, p.Odp.SplitterLoss)
+	if err != nil {
+		return fmt.Errorf("ODP %s: %s", p.Odp.Name, err)
+	}
+	cables := []struct {
+		name   string
+		meters int
+	}{
+		{"Feeder cable", p.Odc.FeederLength},
+		{"Distribution cable", p.Odp.CableLength},
+		{"Drop cable", dropLength},
+	}
+	p.Losses = nil
+	for _, c := range cables {
+		p.Losses = append(p.Losses, OpticalLoss{Name: fmt.Sprintf("%s %dm", c.name, c.meters), Loss: float64(c.meters) / 1000 * fiberLoss})
+	}
+	p.Losses = append(p.Losses,
+		OpticalLoss{Name: fmt.Sprintf("ODC %s splitter 1:%d", p.Odc.Name, odcRatio), Loss: odcLoss},
+		OpticalLoss{Name: fmt.Sprintf("ODP %s splitter 1:%d", p.Odp.Name, p.Odp.Capacity), Loss: odpLoss},
+		OpticalLoss{Name: "Connectors x5", Loss: 5 * connectorLoss},
+	)
+	p.ExpectedRx = p.TxPower
+	for _, l := range p.Losses {
+		p.ExpectedRx -= l.Loss
+	}
+	p.ExpectedRx = math.Round(p.ExpectedRx*100) / 100
+	return nil
+}
+
+// MeasuredRxPower RX power reported by the ONU, the one polled from the OLT otherwise
+func (a *Application) MeasuredRxPower(dev models.NetCpe) (float64, bool) {
+	if v, ok := ParseDbm(dev.FiberRxPower); ok && v != 0 {
+		return v, true
+	}
+	if dev.PonSnHex == "" {
+		return 0, false
+	}
+	var onu models.OltOnuData
+	err := a.gormDB.Where("serial_number = ?", strings.ToUpper(dev.PonSnHex)).
+		Order("updated_at desc").First(&onu).Error
+	if err != nil || onu.RxPower == 0 {
+		return 0, false
+	}
+	return onu.RxPower, true
+}
+
+// CheckOpticalBudget Compare the measured RX power of the ONU with the budget of its fibre path
+func (a *Application) CheckOpticalBudget(dev models.NetCpe) models.NetCpeOptical {
+	item := models.NetCpeOptical{
+		ID:        common.UUIDint64(),
+		Sn:        dev.Sn,
+		OdpId:     dev.OdpID,
+		OdpPort:   dev.OdpPort,
+		Status:    OpticalUnknown,
+		CheckTime: time.Now(),
+	}
+	path, err := a.DeviceFibrePath(dev)
+	measured, ok := a.MeasuredRxPower(dev)
+	switch {
+	case err != nil:
+		item.Detail = err.Error()
+	case !ok:
+		item.ExpectedRx = path.ExpectedRx
+		item.Detail = "no RX power measured"
+	default:
+		tolerance := cast.ToFloat64(a.GetTr069SettingsStringValue(ConfigOpticalRxTolerance))
+		item.ExpectedRx = path.ExpectedRx
+		item.MeasuredRx = measured
+		item.Deviation = math.Round((measured-path.ExpectedRx)*100) / 100
+		item.Status = common.If(math.Abs(item.Deviation) > tolerance, OpticalDeviated, OpticalNormal).(string)
+		item.Detail = fmt.Sprintf("measured %.2f dBm, budget %.2f dBm", measured, path.ExpectedRx)
+	}
+	a.gormDB.Clauses(clause.OnConflict{
+		Columns: []clause.Column{{Name: "sn"}},
+		DoUpdates: clause.AssignmentColumns([]string{"odp_id", "odp_port", "expected_rx", "measured_rx",
+			"deviation", "status", "detail", "check_time"}),
+	}).Create(&item)
+	return item
+}
+
+// SchedCheckOpticalBudget Check the ONUs assigned to an ODP
+func (a *Application) SchedCheckOpticalBudget() {
+	defer func() {
+		if err := recover(); err != nil {
+			log.Error(err)
+		}
+	}()
+	var devs []models.NetCpe
+	a.gormDB.Where("odp_id > 0").Find(&devs)
+	for _, dev := range devs {
+		item := a.CheckOpticalBudget(dev)
+		if item.Status == OpticalDeviated {
+			log.Warnf("ONU %s RX power deviates %.2f dB from the optical budget", dev.Sn, item.Deviation)
+		}
+	}
+	// devices moved out of the fibre plant
+	a.gormDB.Where("sn not in (?)", a.gormDB.Model(&models.NetCpe{}).Select("sn").Where("odp_id > 0")).
+		Delete(&models.NetCpeOptical{})
+}
+
+// AssignOdpPort Assign the device to an ODP port, the port is in the ODP capacity
+// and not used by another device. The ODP used ports follow the assignments.
+func (a *Application) AssignOdpPort(dev models.NetCpe, odpId int64, port, dropLength int) error {
+	if odpId != 0 {
+		var odp models.OdpDevice
+		if err := a.gormDB.Where("id = ?", odpId).First(&odp).Error; err != nil {
+			return fmt.Errorf("ODP not found")
+		}
+		if err := checkOdpPort(odp, port); err != nil {
+			return err
+		}
+		if port > 0 {
+			var count int64
+			a.gormDB.Model(&models.NetCpe{}).Where("odp_id = ? and odp_port = ? and id <> ?", odpId, port, dev.ID).Count(&count)
+			if count > 0 {
+				return fmt.Errorf("port %d of ODP %s is used by another device", port, odp.Name)
+			}
+		}
+	} else {
+		port, dropLength = 0, 0
+	}
+	err := a.gormDB.Model(&models.NetCpe{}).Where("id = ?", dev.ID).Updates(map[string]interface{}{
+		"odp_id": odpId, "odp_port": port, "drop_length": dropLength,
+	}).Error
+	if isUniqueViolation(err) {
+		// assigned concurrently, the unique index of the ODP ports refused it
+		return fmt.Errorf("port %d of the ODP is used by another device", port)
+	}
+	if err != nil {
+		return err
+	}
+	for _, id := range []int64{dev.OdpID, odpId} {
+		if id != 0 {
+			a.updateOdpUsedPorts(id)
+		}
+	}
+	return nil
+}
+
+// isUniqueViolation The error of a statement refused by a unique index
+func isUniqueViolation(err error) bool {
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && pgErr.Code == pgUniqueViolation
+}
+
+// checkOdpPort The port is in the ODP capacity, 0 assigns the ODP without a port
+func checkOdpPort(odp models.OdpDevice, port int) error {
+	if port < 0 || (odp.Capacity > 0 && port > odp.Capacity) {
+		return fmt.Errorf("ODP %s has %d ports", odp.Name, odp.Capacity)
+	}
+	return nil
+}
+
+func (a *Application) updateOdpUsedPorts(odpId int64) {
+	var count int64
+	a.gormDB.Model(&models.NetCpe{}).Where("odp_id = ?", odpId).Count(&count)
+	a.gormDB.Model(&models.OdpDevice{}).Where("id = ?", odpId).Update("used_ports", count)
+}
+
+// CheckOdcPort The ODC splitter output is in the splitter ratio and not used by another ODP
+func (a *Application) CheckOdcPort(odp models.OdpDevice) error {
+	if odp.OdcID == 0 || odp.OdcPort == 0 {
+		return nil
+	}
+	var odc models.OdcDevice
+	if err := a.gormDB.Where("id = ?", odp.OdcID).First(&odc).Error; err != nil {
+		return fmt.Errorf("ODC not found")
+	}
+	ratio := common.If(odc.SplitRatio > 0, odc.SplitRatio, odc.Capacity).(int)
+	if odp.OdcPort < 0 || (ratio > 0 && odp.OdcPort > ratio) {
+		return fmt.Errorf("ODC %s splitter has %d outputs", odc.Name, ratio)
+	}
+	var count int64
+	a.gormDB.Model(&models.OdpDevice{}).
+		Where("odc_id = ? and odc_port = ? and id <> ?", odp.OdcID, odp.OdcPort, odp.ID).Count(&count)
+	if count > 0 {
+		return fmt.Errorf("output %d of ODC %s is used by another ODP", odp.OdcPort, odc.Name)
+	}
+	return nil
+}
@@ -0,0 +1,140 @@
+package app
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/ca17/teamsacs/models"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+func TestSplitterLoss(t *testing.T) {
+	tests := []struct {
+		name    string
+		ratio   int
+		loss    float64
+		want    float64
+		wantErr bool
+	}{
+		{"typical 1:8", 8, 0, 10.5, false},
+		{"typical 1:32", 32, 0, 17.1, false},
+		{"configured", 32, 16.5, 16.5, false},
+		{"configured unknown ratio", 12, 12.2, 12.2, false},
+		{"unknown ratio", 144, 0, 0, true},
+		{"no ratio", 0, 0, 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := SplitterLoss(tt.ratio, tt.loss)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("SplitterLoss() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("SplitterLoss() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFibrePathBudget(t *testing.T) {
+	tests := []struct {
+		name    string
+		odc     models.OdcDevice
+		odp     models.OdpDevice
+		drop    int
+		want    float64
+		wantErr bool
+	}{
+		{
+			name: "typical splitters",
+			odc:  models.OdcDevice{Name: "odc1", SplitRatio: 8, FeederLength: 5000},
+			odp:  models.OdpDevice{Name: "odp1", Capacity: 8, CableLength: 1000},
+			drop: 200,
+			// 3 - 6.2*0.35 - 10.5 - 10.5 - 5*0.5
+			want: -22.67,
+		},
+		{
+			name: "ODC ratio from the capacity",
+			odc:  models.OdcDevice{Name: "odc1", Capacity: 4},
+			odp:  models.OdpDevice{Name: "odp1", Capacity: 16},
+			want: 3 - 7.3 - 13.8 - 2.5,
+		},
+		{
+			name: "configured losses",
+			odc:  models.OdcDevice{Name: "odc1", SplitRatio: 144, SplitterLoss: 22},
+			odp:  models.OdpDevice{Name: "odp1", Capacity: 12, SplitterLoss: 11},
+			want: 3 - 22 - 11 - 2.5,
+		},
+		{
+			name:    "ODC splitter without a loss",
+			odc:     models.OdcDevice{Name: "odc1", SplitRatio: 144},
+			odp:     models.OdpDevice{Name: "odp1", Capacity: 8},
+			wantErr: true,
+		},
+		{
+			name:    "ODP splitter without a loss",
+			odc:     models.OdcDevice{Name: "odc1", SplitRatio: 8},
+			odp:     models.OdpDevice{Name: "odp1", Capacity: 12},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			odc, odp := tt.odc, tt.odp
+			path := &FibrePath{Odc: &odc, Odp: &odp, TxPower: 3}
+			err := path.budget(tt.drop, 0.35, 0.5)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("budget() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && fmt.Sprintf("%.2f", path.ExpectedRx) != fmt.Sprintf("%.2f", tt.want) {
+				t.Errorf("budget() expected rx = %v, want %.2f", path.ExpectedRx, tt.want)
+			}
+		})
+	}
+}
+
+func TestCheckOdpPort(t *testing.T) {
+	odp := models.OdpDevice{Name: "odp1", Capacity: 8}
+	tests := []struct {
+		name    string
+		odp     models.OdpDevice
+		port    int
+		wantErr bool
+	}{
+		{"no port", odp, 0, false},
+		{"first port", odp, 1, false},
+		{"last port", odp, 8, false},
+		{"over capacity", odp, 9, true},
+		{"negative", odp, -1, true},
+		{"no capacity", models.OdpDevice{Name: "odp2"}, 48, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := checkOdpPort(tt.odp, tt.port); (err != nil) != tt.wantErr {
+				t.Errorf("checkOdpPort() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestIsUniqueViolation(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"nil", nil, false},
+		{"unique violation", &pgconn.PgError{Code: pgUniqueViolation}, true},
+		{"wrapped", fmt.Errorf("update: %w", &pgconn.PgError{Code: pgUniqueViolation}), true},
+		{"other code", &pgconn.PgError{Code: "23503"}, false},
+		{"other error", errors.New("connection refused"), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isUniqueViolation(tt.err); got != tt.want {
+				t.Errorf("isUniqueViolation() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -11,12 +11,19 @@ const (
 	MetricsTr069MessageTotal = "tr069_message_total"
 	MetricsTr069Inform       = "tr069_inform"
 	MetricsTr069Download     = "tr069_download"
+	// MetricsTr069InformRejected Informs answered 503 on overload
+	MetricsTr069InformRejected = "tr069_inform_rejected"
+	// inform worker pool gauges, sampled by SchedInformPoolMonitorTask
+	MetricsTr069InformQueue   = "tr069_inform_queue"
+	MetricsTr069InformBusy    = "tr069_inform_busy"
+	MetricsTr069WriterPending = "tr069_writer_pending"
 )
 
 var tr069MetricsNames = []string{
 	MetricsTr069MessageTotal,
 	MetricsTr069Inform,
 	MetricsTr069Download,
+	MetricsTr069InformRejected,
 }
 
 func GetH24Metrics(name string) int64 {
@@ -41,6 +41,9 @@ var Tr069TeamsacsMikrotik string
 //go:embed tr069_preset.yml
 var Tr069PresetTemplate string
 
+//go:embed tr069_datamodel.yml
+var Tr069DataModel []byte
+
 var defaultBuildVer = "Latest Build 2023"
 
 func BuildVersion() string {
@@ -39,6 +39,7 @@ import (
 	"regexp"
 	"strconv"
 	"strings"
+	"sync/atomic"
 	"time"
 
 	"github.com/pkg/errors"
@@ -228,15 +229,32 @@ func UUID() string {
 	return fmt.Sprintf("%x-%x-%x-%x-%x-%x", unix32bits, buff[0:2], buff[2:4], buff[4:6], buff[6:8], buff[8:])
 }
 
-var snowflakeNode, _ = snowflake.NewNode(int64(mathrand.Intn(1000)))
+// snowflakeNode Generator of the int64 IDs, node 0 until SetSnowflakeNode
+var snowflakeNode atomic.Pointer[snowflake.Node]
+
+func init() {
+	node, _ := snowflake.NewNode(0)
+	snowflakeNode.Store(node)
+}
+
+// SetSnowflakeNode Set the node ID of the int64 IDs, 0 to 1023, it must be unique
+// among the running instances sharing a database
+func SetSnowflakeNode(id int64) error {
+	node, err := snowflake.NewNode(id)
+	if err != nil {
+		return err
+	}
+	snowflakeNode.Store(node)
+	return nil
+}
 
 // Generate int64
 func UUIDint64() int64 {
-	return snowflakeNode.Generate().Int64()
+	return snowflakeNode.Load().Generate().Int64()
 }
 
 func UUIDBase32() (string, error) {
-	id := snowflakeNode.Generate()
+	id := snowflakeNode.Load().Generate()
 	// Print out the ID in a few different ways.
 	return id.Base32(), nil
 }
@@ -0,0 +1,66 @@
+package cwmp
+
+import (
+	"encoding/xml"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/ca17/teamsacs/common/xmlx"
+)
+
+// AddObjectResponse instance number of the created object
+type AddObjectResponse struct {
+	ID             string
+	Name           string
+	InstanceNumber int
+	Status         int
+}
+
+type addObjectResponseBodyStruct struct {
+	Body addObjectResponseStruct `xml:"cwmp:AddObjectResponse"`
+}
+
+type addObjectResponseStruct struct {
+	InstanceNumber int
+	Status         int
+}
+
+// GetID get msg id
+func (msg *AddObjectResponse) GetID() string {
+	if len(msg.ID) < 1 {
+		msg.ID = fmt.Sprintf("ID:intrnl.unset.id.%s%d.%d", msg.GetName(), time.Now().Unix(), time.Now().UnixNano())
+	}
+	return msg.ID
+}
+
+// GetName get msg type
+func (msg *AddObjectResponse) GetName() string {
+	return "AddObjectResponse"
+}
+
+// CreateXML encode into xml
+func (msg *AddObjectResponse) CreateXML() []byte {
+	env := Envelope{}
+	env.XmlnsEnv = "http://schemas.xmlsoap.org/soap/envelope/"
+	env.XmlnsEnc = "http://schemas.xmlsoap.org/soap/encoding/"
+	env.XmlnsXsd = "http://www.w3.org/2001/XMLSchema"
+	env.XmlnsXsi = "http://www.w3.org/2001/XMLSchema-instance"
+	env.XmlnsCwmp = "urn:dslforum-org:cwmp-1-0"
+	id := IDStruct{Attr: "1", Value: msg.GetID()}
+	env.Header = HeaderStruct{ID: id}
+	body := addObjectResponseStruct{InstanceNumber: msg.InstanceNumber, Status: msg.Status}
+	env.Body = addObjectResponseBodyStruct{body}
+	output, err := xml.MarshalIndent(env, "  ", "    ")
+	if err != nil {
+		fmt.Printf("error: %v\n", err)
+	}
+	return output
+}
+
+// Parse decode from xml
+func (msg *AddObjectResponse) Parse(doc *xmlx.Document) {
+	msg.ID = getDocNodeValue(doc, "*", "ID")
+	msg.InstanceNumber, _ = strconv.Atoi(getDocNodeValue(doc, "*", "InstanceNumber"))
+	msg.Status, _ = strconv.Atoi(getDocNodeValue(doc, "*", "Status"))
+}
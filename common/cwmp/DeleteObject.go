@@ -0,0 +1,66 @@
+package cwmp
+
+import (
+	"encoding/xml"
+	"fmt"
+	"time"
+
+	"github.com/ca17/teamsacs/common/xmlx"
+)
+
+// DeleteObject remove an object instance, the name is the instance path ending with a dot
+type DeleteObject struct {
+	ID           string
+	Name         string
+	NoMore       int
+	ObjectName   string
+	ParameterKey string
+}
+
+type deleteObjectBodyStruct struct {
+	Body deleteObjectStruct `xml:"cwmp:DeleteObject"`
+}
+
+type deleteObjectStruct struct {
+	ObjectName   string
+	ParameterKey string
+}
+
+// GetID get msg id
+func (msg *DeleteObject) GetID() string {
+	if len(msg.ID) < 1 {
+		msg.ID = fmt.Sprintf("ID:intrnl.unset.id.%s%d.%d", msg.GetName(), time.Now().Unix(), time.Now().UnixNano())
+	}
+	return msg.ID
+}
+
+// GetName get msg name
+func (msg *DeleteObject) GetName() string {
+	return "DeleteObject"
+}
+
+// CreateXML encode into xml
+func (msg *DeleteObject) CreateXML() []byte {
+	env := Envelope{}
+	env.XmlnsEnv = "http://schemas.xmlsoap.org/soap/envelope/"
+	env.XmlnsEnc = "http://schemas.xmlsoap.org/soap/encoding/"
+	env.XmlnsXsd = "http://www.w3.org/2001/XMLSchema"
+	env.XmlnsXsi = "http://www.w3.org/2001/XMLSchema-instance"
+	env.XmlnsCwmp = "urn:dslforum-org:cwmp-1-0"
+	id := IDStruct{Attr: "1", Value: msg.GetID()}
+	env.Header = HeaderStruct{ID: id, NoMore: msg.NoMore}
+	body := deleteObjectStruct{ObjectName: msg.ObjectName, ParameterKey: msg.ParameterKey}
+	env.Body = deleteObjectBodyStruct{body}
+	output, err := xml.MarshalIndent(env, "  ", "    ")
+	if err != nil {
+		fmt.Printf("error: %v\n", err)
+	}
+	return output
+}
+
+// Parse decode from xml
+func (msg *DeleteObject) Parse(doc *xmlx.Document) {
+	msg.ID = getDocNodeValue(doc, "*", "ID")
+	msg.ObjectName = getDocNodeValue(doc, "*", "ObjectName")
+	msg.ParameterKey = getDocNodeValue(doc, "*", "ParameterKey")
+}
@@ -0,0 +1,63 @@
+package cwmp
+
+import (
+	"encoding/xml"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/ca17/teamsacs/common/xmlx"
+)
+
+// DeleteObjectResponse delete object response
+type DeleteObjectResponse struct {
+	ID     string
+	Name   string
+	Status int
+}
+
+type deleteObjectResponseBodyStruct struct {
+	Body deleteObjectResponseStruct `xml:"cwmp:DeleteObjectResponse"`
+}
+
+type deleteObjectResponseStruct struct {
+	Status int
+}
+
+// GetID get msg id
+func (msg *DeleteObjectResponse) GetID() string {
+	if len(msg.ID) < 1 {
+		msg.ID = fmt.Sprintf("ID:intrnl.unset.id.%s%d.%d", msg.GetName(), time.Now().Unix(), time.Now().UnixNano())
+	}
+	return msg.ID
+}
+
+// GetName get msg type
+func (msg *DeleteObjectResponse) GetName() string {
+	return "DeleteObjectResponse"
+}
+
+// CreateXML encode into xml
+func (msg *DeleteObjectResponse) CreateXML() []byte {
+	env := Envelope{}
+	env.XmlnsEnv = "http://schemas.xmlsoap.org/soap/envelope/"
+	env.XmlnsEnc = "http://schemas.xmlsoap.org/soap/encoding/"
+	env.XmlnsXsd = "http://www.w3.org/2001/XMLSchema"
+	env.XmlnsXsi = "http://www.w3.org/2001/XMLSchema-instance"
+	env.XmlnsCwmp = "urn:dslforum-org:cwmp-1-0"
+	id := IDStruct{Attr: "1", Value: msg.GetID()}
+	env.Header = HeaderStruct{ID: id}
+	body := deleteObjectResponseStruct{Status: msg.Status}
+	env.Body = deleteObjectResponseBodyStruct{body}
+	output, err := xml.MarshalIndent(env, "  ", "    ")
+	if err != nil {
+		fmt.Printf("error: %v\n", err)
+	}
+	return output
+}
+
+// Parse decode from xml
+func (msg *DeleteObjectResponse) Parse(doc *xmlx.Document) {
+	msg.ID = getDocNodeValue(doc, "*", "ID")
+	msg.Status, _ = strconv.Atoi(getDocNodeValue(doc, "*", "Status"))
+}
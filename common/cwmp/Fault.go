@@ -0,0 +1,75 @@
+package cwmp
+
+import (
+	"encoding/xml"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/ca17/teamsacs/common/xmlx"
+)
+
+// Fault SOAP fault sent by the CPE when an RPC fails
+type Fault struct {
+	ID          string
+	Name        string
+	FaultCode   int
+	FaultString string
+}
+
+type faultBodyStruct struct {
+	Body faultStruct `xml:"soap:Fault"`
+}
+
+type faultStruct struct {
+	FaultCode   string      `xml:"faultcode"`
+	FaultString string      `xml:"faultstring"`
+	Detail      faultDetail `xml:"detail"`
+}
+
+type faultDetail struct {
+	Fault FaultStruct `xml:"cwmp:Fault"`
+}
+
+// GetID get msg id
+func (msg *Fault) GetID() string {
+	if len(msg.ID) < 1 {
+		msg.ID = fmt.Sprintf("ID:intrnl.unset.id.%s%d.%d", msg.GetName(), time.Now().Unix(), time.Now().UnixNano())
+	}
+	return msg.ID
+}
+
+// GetName get msg name
+func (msg *Fault) GetName() string {
+	return "Fault"
+}
+
+// CreateXML encode into xml
+func (msg *Fault) CreateXML() []byte {
+	env := Envelope{}
+	env.XmlnsEnv = "http://schemas.xmlsoap.org/soap/envelope/"
+	env.XmlnsEnc = "http://schemas.xmlsoap.org/soap/encoding/"
+	env.XmlnsXsd = "http://www.w3.org/2001/XMLSchema"
+	env.XmlnsXsi = "http://www.w3.org/2001/XMLSchema-instance"
+	env.XmlnsCwmp = "urn:dslforum-org:cwmp-1-0"
+	id := IDStruct{Attr: "1", Value: msg.GetID()}
+	env.Header = HeaderStruct{ID: id}
+	body := faultStruct{
+		FaultCode:   "Client",
+		FaultString: "CWMP fault",
+		Detail:      faultDetail{Fault: FaultStruct{FaultCode: msg.FaultCode, FaultString: msg.FaultString}},
+	}
+	env.Body = faultBodyStruct{body}
+	output, err := xml.MarshalIndent(env, "  ", "    ")
+	if err != nil {
+		fmt.Printf("error: %v\n", err)
+	}
+	return output
+}
+
+// Parse decode from xml
+func (msg *Fault) Parse(doc *xmlx.Document) {
+	msg.ID = getDocNodeValue(doc, "*", "ID")
+	msg.FaultString = getDocNodeValue(doc, "*", "FaultString")
+	msg.FaultCode, _ = strconv.Atoi(getDocNodeValue(doc, "*", "FaultCode"))
+}
@@ -15,6 +15,7 @@ type GetParameterValuesResponse struct {
 	ID     string
 	Name   string
 	Values map[string]string
+	Types  map[string]string
 }
 
 // NewGetParameterValuesResponse create GetParameterValuesResponse object
@@ -62,7 +63,7 @@ func (msg *GetParameterValuesResponse) CreateXML() []byte {
 	for k, v := range msg.Values {
 		param := ParameterValueStruct{
 			Name:  NodeStruct{Type: XsdString, Value: k},
-			Value: NodeStruct{Type: XsdString, Value: v}}
+			Value: NodeStruct{Type: msg.valueType(k), Value: v}}
 		params.Params = append(params.Params, param)
 	}
 	info := getParameterValuesResponseStruct{Params: params}
@@ -81,6 +82,7 @@ func (msg *GetParameterValuesResponse) Parse(doc *xmlx.Document) {
 	paramsNode := doc.SelectNode("*", "ParameterList")
 	if len(strings.TrimSpace(paramsNode.String())) > 0 {
 		params := make(map[string]string)
+		types := make(map[string]string)
 		var name, value string
 		for _, param := range paramsNode.Children {
 			fmt.Println("param:", param)
@@ -89,9 +91,20 @@ func (msg *GetParameterValuesResponse) Parse(doc *xmlx.Document) {
 				name = param.SelectNode("", "Name").GetValue()
 				value = param.SelectNode("", "Value").GetValue()
 				params[name] = value
+				if ptype := getNodeType(param, "", "Value"); ptype != "" {
+					types[name] = ptype
+				}
 			}
 
 		}
 		msg.Values = params
+		msg.Types = types
 	}
 }
+
+func (msg *GetParameterValuesResponse) valueType(name string) string {
+	if t, ok := msg.Types[name]; ok && t != "" {
+		return t
+	}
+	return XsdString
+}
@@ -25,6 +25,7 @@ type Inform struct {
 	RetryCount   int               `json:"retryCount"`
 	CommandKey   string            `json:"commandKey"`
 	Params       map[string]string `json:"params"`
+	ParamTypes   map[string]string `json:"param_types"`
 }
 
 type informBodyStruct struct {
@@ -53,6 +54,7 @@ func NewInform() *Inform {
 	inform := new(Inform)
 	inform.Events = make(map[string]string)
 	inform.Params = make(map[string]string)
+	inform.ParamTypes = make(map[string]string)
 	return inform
 }
 
@@ -100,9 +102,13 @@ func (msg *Inform) CreateXML() []byte {
 	paramLen := strconv.Itoa(len(msg.Params))
 	paramList := ParameterListStruct{Type: "cwmp:ParameterValueStruct[" + paramLen + "]"}
 	for k, v := range msg.Params {
+		ptype := msg.GetParamType(k)
+		if ptype == "" {
+			ptype = XsdString
+		}
 		param := ParameterValueStruct{
 			Name:  NodeStruct{Type: XsdString, Value: k},
-			Value: NodeStruct{Type: XsdString, Value: v}}
+			Value: NodeStruct{Type: ptype, Value: v}}
 		paramList.Params = append(paramList.Params, param)
 	}
 	info := informStruct{DeviceID: deviceID, Event: event, MaxEnvelopes: maxEnvelopes,
@@ -161,6 +167,9 @@ func (msg *Inform) Parse(doc *xmlx.Document) {
 			if param != nil && len(strings.TrimSpace(param.String())) > 0 {
 				name = getNodeValue(param, "", "Name")
 				msg.Params[name] = getNodeValue(param, "", "Value")
+				if ptype := getNodeType(param, "", "Value"); ptype != "" {
+					msg.ParamTypes[name] = ptype
+				}
 			}
 		}
 	}
@@ -195,6 +204,11 @@ func (msg *Inform) GetParam(name string) (value string) {
 	return
 }
 
+// GetParamType get param xsd type in inform
+func (msg *Inform) GetParamType(name string) string {
+	return msg.ParamTypes[name]
+}
+
 // GetConfigVersion get current config version
 func (msg *Inform) GetConfigVersion() (version string) {
 	version = msg.GetParam("InternetGatewayDevice.DeviceConfig.ConfigVersion")
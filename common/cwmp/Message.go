@@ -11,6 +11,20 @@ const (
 	XsdString string = "xsd:string"
 	// XsdUnsignedint uint type
 	XsdUnsignedint string = "xsd:unsignedInt"
+	// XsdInt int type
+	XsdInt string = "xsd:int"
+	// XsdLong long type
+	XsdLong string = "xsd:long"
+	// XsdUnsignedLong unsigned long type
+	XsdUnsignedLong string = "xsd:unsignedLong"
+	// XsdBoolean boolean type
+	XsdBoolean string = "xsd:boolean"
+	// XsdDateTime dateTime type
+	XsdDateTime string = "xsd:dateTime"
+	// XsdBase64 base64 type
+	XsdBase64 string = "xsd:base64"
+	// XsdHexBinary hexBinary type
+	XsdHexBinary string = "xsd:hexBinary"
 )
 
 const (
@@ -31,6 +31,8 @@ func ParseXML(data []byte) (msg Message, err error) {
 			msg = NewInform()
 		case "GetParameterValuesResponse":
 			msg = &GetParameterValuesResponse{}
+		case "SetParameterValues":
+			msg = &SetParameterValues{}
 		case "SetParameterValuesResponse":
 			msg = &SetParameterValuesResponse{}
 		case "GetParameterNames":
@@ -49,10 +51,16 @@ func ParseXML(data []byte) (msg Message, err error) {
 			msg = &RebootResponse{}
 		case "FactoryResetResponse":
 			msg = &FactoryResetResponse{}
+		case "AddObjectResponse":
+			msg = &AddObjectResponse{}
+		case "DeleteObjectResponse":
+			msg = &DeleteObjectResponse{}
 		case "ScheduleInform":
 			msg = &ScheduleInform{}
 		case "ScheduleInformResponse":
 			msg = &ScheduleInformResponse{}
+		case "Fault":
+			msg = &Fault{}
 		default:
 			return nil, errors.New("no msg type match: " + name)
 		}
@@ -0,0 +1,26 @@
+package cwmp
+
+import (
+	"github.com/ca17/teamsacs/common/xmlx"
+)
+
+// RawMessage ACS request kept as XML, e.g. read back from the shared RPC queue
+type RawMessage struct {
+	ID      string `json:"id"`
+	Name    string `json:"name"`
+	Content string `json:"content"`
+}
+
+func (msg *RawMessage) Parse(doc *xmlx.Document) {}
+
+func (msg *RawMessage) CreateXML() []byte {
+	return []byte(msg.Content)
+}
+
+func (msg *RawMessage) GetName() string {
+	return msg.Name
+}
+
+func (msg *RawMessage) GetID() string {
+	return msg.ID
+}
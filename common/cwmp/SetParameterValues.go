@@ -4,6 +4,7 @@ import (
 	"encoding/xml"
 	"fmt"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/ca17/teamsacs/common/xmlx"
@@ -74,5 +75,22 @@ func (msg *SetParameterValues) CreateXML() []byte {
 
 // Parse decode from xml
 func (msg *SetParameterValues) Parse(doc *xmlx.Document) {
-	// TODO
+	msg.ID = getDocNodeValue(doc, "*", "ID")
+	msg.ParameterKey = getDocNodeValue(doc, "*", "ParameterKey")
+	msg.Params = make(map[string]ValueStruct)
+	paramsNode := doc.SelectNode("*", "ParameterList")
+	if paramsNode != nil && len(strings.TrimSpace(paramsNode.String())) > 0 {
+		for _, param := range paramsNode.Children {
+			if param != nil && len(strings.TrimSpace(param.String())) > 0 {
+				name := getNodeValue(param, "", "Name")
+				if name == "" {
+					continue
+				}
+				msg.Params[name] = ValueStruct{
+					Type:  getNodeType(param, "", "Value"),
+					Value: getNodeValue(param, "", "Value"),
+				}
+			}
+		}
+	}
 }
@@ -0,0 +1,62 @@
+package cwmp
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// NormalizeType returns the xsd: form of a parameter type, xsd:string if empty
+func NormalizeType(t string) string {
+	t = strings.TrimSpace(t)
+	if t == "" {
+		return XsdString
+	}
+	if strings.HasPrefix(t, "xsd:") {
+		return t
+	}
+	return "xsd:" + t
+}
+
+// ValidateValue check that the value is valid for the xsd type
+func ValidateValue(xsdType, value string) error {
+	var err error
+	switch NormalizeType(xsdType) {
+	case XsdBoolean:
+		if !isBoolValue(value) {
+			err = fmt.Errorf("must be true, false, 1 or 0")
+		}
+	case XsdInt:
+		_, err = strconv.ParseInt(value, 10, 32)
+	case XsdUnsignedint:
+		_, err = strconv.ParseUint(value, 10, 32)
+	case XsdLong:
+		_, err = strconv.ParseInt(value, 10, 64)
+	case XsdUnsignedLong:
+		_, err = strconv.ParseUint(value, 10, 64)
+	case XsdDateTime:
+		if _, err = time.Parse(time.RFC3339, value); err != nil {
+			_, err = time.Parse("2006-01-02T15:04:05", value)
+		}
+	case XsdBase64:
+		_, err = base64.StdEncoding.DecodeString(value)
+	case XsdHexBinary:
+		_, err = hex.DecodeString(value)
+	}
+	if err != nil {
+		return fmt.Errorf("invalid %s value %q: %s", NormalizeType(xsdType), value, err)
+	}
+	return nil
+}
+
+// isBoolValue check the value is a valid xsd:boolean
+func isBoolValue(value string) bool {
+	switch value {
+	case "true", "false", "1", "0":
+		return true
+	}
+	return false
+}
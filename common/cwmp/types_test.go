@@ -0,0 +1,112 @@
+package cwmp
+
+import (
+	"testing"
+)
+
+func TestValidateValue(t *testing.T) {
+	cases := []struct {
+		xsdType string
+		value   string
+		ok      bool
+	}{
+		{"xsd:string", "any value", true},
+		{"", "any value", true},
+		{"boolean", "true", true},
+		{"xsd:boolean", "yes", false},
+		{"xsd:unsignedInt", "300", true},
+		{"xsd:unsignedInt", "-1", false},
+		{"xsd:int", "-1", true},
+		{"xsd:dateTime", "2023-01-02T03:04:05Z", true},
+		{"xsd:dateTime", "yesterday", false},
+		{"xsd:base64", "aGVsbG8=", true},
+		{"xsd:base64", "%%", false},
+	}
+	for _, c := range cases {
+		err := ValidateValue(c.xsdType, c.value)
+		if (err == nil) != c.ok {
+			t.Errorf("ValidateValue(%s, %s) = %v", c.xsdType, c.value, err)
+		}
+	}
+}
+
+func TestInform_ParamTypes(t *testing.T) {
+	msg, err := ParseXML(informTest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	inform := msg.(*Inform)
+	for name := range inform.Params {
+		if inform.GetParamType(name) == "" {
+			t.Errorf("param %s has no type", name)
+		}
+	}
+}
+
+func TestSetParameterValues_Parse(t *testing.T) {
+	req := &SetParameterValues{
+		ID:           "PresetTask-1",
+		ParameterKey: "key1",
+		Params: map[string]ValueStruct{
+			"Device.WiFi.SSID.1.SSID":          {Type: XsdString, Value: "home"},
+			"Device.WiFi.Radio.1.Channel":      {Type: XsdUnsignedint, Value: "6"},
+			"Device.WiFi.AccessPoint.1.Enable": {Type: XsdBoolean, Value: "true"},
+		},
+	}
+	msg, err := ParseXML(req.CreateXML())
+	if err != nil {
+		t.Fatal(err)
+	}
+	spv := msg.(*SetParameterValues)
+	if spv.ID != req.ID || spv.ParameterKey != req.ParameterKey {
+		t.Errorf("header mismatch: %s %s", spv.ID, spv.ParameterKey)
+	}
+	if len(spv.Params) != len(req.Params) {
+		t.Fatalf("params = %v", spv.Params)
+	}
+	for name, v := range req.Params {
+		if spv.Params[name] != v {
+			t.Errorf("param %s = %v, want %v", name, spv.Params[name], v)
+		}
+	}
+}
+
+func TestBuildUDPConnectionRequest(t *testing.T) {
+	msg := string(BuildUDPConnectionRequest("10.1.1.1:8080", "CPE57689", "secret", 1120673700, "1234", "XTGRWIPC6D3IPXS3"))
+	want := "GET http://10.1.1.1:8080?ts=1120673700&id=1234&un=CPE57689&cn=XTGRWIPC6D3IPXS3" +
+		"&sig=FDA44088E52A347CE49D6312521D5621EEB40037 HTTP/1.1\r\nHost: 10.1.1.1:8080\r\n\r\n"
+	if msg != want {
+		t.Errorf("BuildUDPConnectionRequest = %q", msg)
+	}
+}
+
+func TestFault_Parse(t *testing.T) {
+	req := &Fault{ID: "PresetTask-2", FaultCode: 9005, FaultString: "Invalid parameter name"}
+	msg, err := ParseXML(req.CreateXML())
+	if err != nil {
+		t.Fatal(err)
+	}
+	fault := msg.(*Fault)
+	if fault.ID != req.ID || fault.FaultCode != 9005 || fault.FaultString != req.FaultString {
+		t.Errorf("unexpected fault %+v", fault)
+	}
+}
+
+func TestAddObjectResponse_Parse(t *testing.T) {
+	resp := &AddObjectResponse{ID: "policy-add-1", InstanceNumber: 7, Status: 1}
+	msg, err := ParseXML(resp.CreateXML())
+	if err != nil {
+		t.Fatal(err)
+	}
+	aor := msg.(*AddObjectResponse)
+	if aor.ID != "policy-add-1" || aor.InstanceNumber != 7 || aor.Status != 1 {
+		t.Errorf("AddObjectResponse parsed as %+v", aor)
+	}
+	msg, err = ParseXML((&DeleteObjectResponse{ID: "policy-del-1"}).CreateXML())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg.GetID() != "policy-del-1" {
+		t.Errorf("DeleteObjectResponse parsed as %+v", msg)
+	}
+}
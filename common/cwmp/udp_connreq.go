@@ -0,0 +1,59 @@
+package cwmp
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// udpConnReqRetries TR-111 recommends sending the UDP connection request several times
+const udpConnReqRetries = 3
+
+// UDPConnectionRequestSignature HMAC-SHA1 of ts, id, un and cn keyed with the connection request password
+func UDPConnectionRequestSignature(password string, ts int64, id, username, cnonce string) string {
+	mac := hmac.New(sha1.New, []byte(password))
+	mac.Write([]byte(fmt.Sprintf("%d%s%s%s", ts, id, username, cnonce)))
+	return strings.ToUpper(hex.EncodeToString(mac.Sum(nil)))
+}
+
+// BuildUDPConnectionRequest HTTP GET message of a TR-111 UDP connection request
+func BuildUDPConnectionRequest(addr, username, password string, ts int64, id, cnonce string) []byte {
+	sig := UDPConnectionRequestSignature(password, ts, id, username, cnonce)
+	return []byte(fmt.Sprintf("GET http://%s?ts=%d&id=%s&un=%s&cn=%s&sig=%s HTTP/1.1\r\nHost: %s\r\n\r\n",
+		addr, ts, id, url.QueryEscape(username), cnonce, sig, addr))
+}
+
+func randomHex(n int) string {
+	b := make([]byte, n)
+	_, _ = rand.Read(b)
+	return strings.ToUpper(hex.EncodeToString(b))
+}
+
+// UDPConnectionRequest Send a TR-111 UDP connection request to the address learned by STUN,
+// UDP has no reply, nil only means the message was sent
+func UDPConnectionRequest(username, password, addr string) error {
+	if _, _, err := net.SplitHostPort(addr); err != nil {
+		return fmt.Errorf("invalid UDPConnectionRequestAddress %s: %w", addr, err)
+	}
+	conn, err := net.DialTimeout("udp", addr, 5*time.Second)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	msg := BuildUDPConnectionRequest(addr, username, password, time.Now().Unix(), randomHex(4), randomHex(8))
+	for i := 0; i < udpConnReqRetries; i++ {
+		if i > 0 {
+			time.Sleep(500 * time.Millisecond)
+		}
+		if _, err = conn.Write(msg); err != nil {
+			return err
+		}
+	}
+	return nil
+}
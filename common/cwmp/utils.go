@@ -19,3 +19,12 @@ func getNodeValue(node *xmlx.Node, ns string, name string) string {
 	}
 	return ""
 }
+
+// getNodeType get the xsi:type attribute of the child node
+func getNodeType(node *xmlx.Node, ns string, name string) string {
+	_node := node.SelectNode(ns, name)
+	if _node != nil {
+		return _node.As("*", "type")
+	}
+	return ""
+}
@@ -0,0 +1,217 @@
+package datamodel
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	TR098 = "TR-098"
+	TR181 = "TR-181"
+
+	RootTR098 = "InternetGatewayDevice."
+	RootTR181 = "Device."
+
+	TypeObject = "object"
+
+	instanceIndex = "{i}"
+)
+
+// Definition one parameter or object of the data model, mapped between TR-181 and TR-098.
+// Object names end with "." and translate every path below them.
+type Definition struct {
+	TR181    string `yaml:"tr181" json:"tr181"`
+	TR098    string `yaml:"tr098" json:"tr098"`
+	Type     string `yaml:"type" json:"type"`
+	Writable bool   `yaml:"writable" json:"writable"`
+}
+
+// Parameter a parameter definition of one data model
+type Parameter struct {
+	Name     string `json:"name"`
+	Type     string `json:"type"`
+	Writable bool   `json:"writable"`
+}
+
+type Schema struct {
+	Definitions []Definition `yaml:"definitions"`
+}
+
+// Load parse the yaml schema definitions
+func Load(data []byte) (*Schema, error) {
+	var s Schema
+	if err := yaml.Unmarshal(data, &s); err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+// ModelOf Returns the data model of the path
+func ModelOf(path string) string {
+	switch {
+	case strings.HasPrefix(path, RootTR098):
+		return TR098
+	case strings.HasPrefix(path, RootTR181):
+		return TR181
+	}
+	return ""
+}
+
+// RootOf Returns the root object of the data model
+func RootOf(model string) string {
+	switch model {
+	case TR098:
+		return RootTR098
+	case TR181:
+		return RootTR181
+	}
+	return ""
+}
+
+// DetectModel Detect the data model from parameter names, TR-098 wins
+// because TR-098 devices may still report some Device.* parameters
+func DetectModel(params map[string]string) string {
+	var model string
+	for name := range params {
+		switch ModelOf(name) {
+		case TR098:
+			return TR098
+		case TR181:
+			model = TR181
+		}
+	}
+	return model
+}
+
+func (d *Definition) name(model string) string {
+	if model == TR098 {
+		return d.TR098
+	}
+	return d.TR181
+}
+
+func (d *Definition) isObject() bool {
+	return d.Type == TypeObject
+}
+
+// Parameters Returns the parameter definitions of the data model
+func (s *Schema) Parameters(model string) []Parameter {
+	var result = make([]Parameter, 0)
+	for i := range s.Definitions {
+		d := &s.Definitions[i]
+		name := d.name(model)
+		if name == "" {
+			continue
+		}
+		result = append(result, Parameter{Name: name, Type: d.Type, Writable: d.Writable})
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Name < result[j].Name
+	})
+	return result
+}
+
+// Lookup Find the definition of a parameter path, instance numbers match {i}
+func (s *Schema) Lookup(path string) *Definition {
+	d, _, _ := s.match(path)
+	if d == nil || d.isObject() {
+		return nil
+	}
+	return d
+}
+
+// Translate the path into the target data model, the path is returned
+// unchanged when it already belongs to the model. Paths without a mapping
+// are returned unchanged with false, the caller must not send them to a
+// device of the target model.
+func (s *Schema) Translate(path, model string) (string, bool) {
+	src := ModelOf(path)
+	if src == "" || src == model || RootOf(model) == "" {
+		return path, src == model
+	}
+	d, idx, rest := s.match(path)
+	if d == nil {
+		return path, false
+	}
+	target := d.name(model)
+	if target == "" {
+		return path, false
+	}
+	for strings.Contains(target, instanceIndex) {
+		v := "1"
+		if len(idx) > 0 {
+			v, idx = idx[0], idx[1:]
+		}
+		target = strings.Replace(target, instanceIndex, v, 1)
+	}
+	// the target has a single instance, e.g. the TR-098 XponInterface,
+	// only the first source instance maps to it
+	for _, v := range idx {
+		if v != "1" {
+			return path, false
+		}
+	}
+	return target + rest, true
+}
+
+// match Find the most specific definition of the path, leaf parameters
+// must match exactly while objects match as prefix
+func (s *Schema) match(path string) (*Definition, []string, string) {
+	src := ModelOf(path)
+	var found *Definition
+	var foundIdx []string
+	var foundRest string
+	var foundLen = -1
+	for i := range s.Definitions {
+		d := &s.Definitions[i]
+		pattern := d.name(src)
+		if pattern == "" {
+			continue
+		}
+		// the root object maps the root itself, not the paths below it
+		if pattern == RootOf(src) && path != pattern {
+			continue
+		}
+		idx, n, rest, ok := matchPattern(pattern, path, d.isObject())
+		if !ok || n <= foundLen {
+			continue
+		}
+		found, foundIdx, foundRest, foundLen = d, idx, rest, n
+	}
+	return found, foundIdx, foundRest
+}
+
+func matchPattern(pattern, path string, prefix bool) ([]string, int, string, bool) {
+	ps := strings.Split(strings.TrimSuffix(pattern, "."), ".")
+	xs := strings.Split(strings.TrimSuffix(path, "."), ".")
+	if len(xs) < len(ps) || (!prefix && len(xs) != len(ps)) {
+		return nil, 0, "", false
+	}
+	if !prefix && strings.HasSuffix(path, ".") {
+		return nil, 0, "", false
+	}
+	var idx []string
+	for i, p := range ps {
+		if p == instanceIndex {
+			if _, err := strconv.Atoi(xs[i]); err != nil {
+				return nil, 0, "", false
+			}
+			idx = append(idx, xs[i])
+			continue
+		}
+		if p != xs[i] {
+			return nil, 0, "", false
+		}
+	}
+	var rest string
+	if prefix && len(xs) > len(ps) {
+		rest = strings.Join(xs[len(ps):], ".")
+		if strings.HasSuffix(path, ".") {
+			rest += "."
+		}
+	}
+	return idx, len(ps), rest, true
+}
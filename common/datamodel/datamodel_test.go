@@ -0,0 +1,80 @@
+package datamodel
+
+import (
+	"testing"
+)
+
+const testSchema = `
+definitions:
+  - { tr181: "Device.", tr098: "InternetGatewayDevice.", type: object }
+  - { tr181: "Device.PPP.", type: object }
+  - { tr098: "InternetGatewayDevice.WANDevice.", type: object }
+  - { tr181: "Device.Optical.Interface.{i}.", tr098: "InternetGatewayDevice.DeviceInfo.XponInterface.", type: object }
+  - { tr181: "Device.Optical.Interface.{i}.RxPower", tr098: "InternetGatewayDevice.DeviceInfo.XponInterface.RXPower", type: int }
+  - { tr181: "Device.WiFi.", tr098: "InternetGatewayDevice.LANDevice.1.WLANConfiguration.", type: object }
+  - { tr181: "Device.WiFi.SSID.{i}.", tr098: "InternetGatewayDevice.LANDevice.1.WLANConfiguration.{i}.", type: object }
+  - { tr181: "Device.WiFi.SSID.{i}.SSID", tr098: "InternetGatewayDevice.LANDevice.1.WLANConfiguration.{i}.SSID", type: string, writable: true }
+  - { tr181: "Device.ManagementServer.PeriodicInformEnable", tr098: "InternetGatewayDevice.ManagementServer.PeriodicInformEnable", type: boolean, writable: true }
+  - { tr181: "Device.DeviceInfo.X_MIKROTIK_ArchName", type: string }
+`
+
+func TestTranslate(t *testing.T) {
+	s, err := Load([]byte(testSchema))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cases := []struct {
+		path  string
+		model string
+		want  string
+		ok    bool
+	}{
+		{"Device.ManagementServer.PeriodicInformEnable", TR098, "InternetGatewayDevice.ManagementServer.PeriodicInformEnable", true},
+		{"InternetGatewayDevice.ManagementServer.PeriodicInformEnable", TR181, "Device.ManagementServer.PeriodicInformEnable", true},
+		{"Device.WiFi.SSID.2.SSID", TR098, "InternetGatewayDevice.LANDevice.1.WLANConfiguration.2.SSID", true},
+		{"InternetGatewayDevice.LANDevice.1.WLANConfiguration.3.SSID", TR181, "Device.WiFi.SSID.3.SSID", true},
+		{"Device.WiFi.", TR098, "InternetGatewayDevice.LANDevice.1.WLANConfiguration.", true},
+		{"Device.WiFi.SSID.1.Status", TR098, "InternetGatewayDevice.LANDevice.1.WLANConfiguration.1.Status", true},
+		{"Device.DeviceInfo.X_MIKROTIK_ArchName", TR098, "Device.DeviceInfo.X_MIKROTIK_ArchName", false},
+		{"Device.WiFi.SSID.1.SSID", TR181, "Device.WiFi.SSID.1.SSID", true},
+		{"Device.", TR098, "InternetGatewayDevice.", true},
+		{"Device.Users.User.1.Username", TR098, "Device.Users.User.1.Username", false},
+		{"Device.PPP.", TR098, "Device.PPP.", false},
+		{"InternetGatewayDevice.WANDevice.", TR181, "InternetGatewayDevice.WANDevice.", false},
+		{"Device.Optical.Interface.1.RxPower", TR098, "InternetGatewayDevice.DeviceInfo.XponInterface.RXPower", true},
+		{"Device.Optical.Interface.2.RxPower", TR098, "Device.Optical.Interface.2.RxPower", false},
+		{"InternetGatewayDevice.DeviceInfo.XponInterface.Status", TR181, "Device.Optical.Interface.1.Status", true},
+	}
+	for _, c := range cases {
+		got, ok := s.Translate(c.path, c.model)
+		if got != c.want || ok != c.ok {
+			t.Errorf("Translate(%s, %s) = %s %v, want %s %v", c.path, c.model, got, ok, c.want, c.ok)
+		}
+	}
+}
+
+func TestLookup(t *testing.T) {
+	s, err := Load([]byte(testSchema))
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := s.Lookup("InternetGatewayDevice.LANDevice.1.WLANConfiguration.4.SSID")
+	if d == nil || d.Type != "string" || !d.Writable {
+		t.Errorf("Lookup SSID = %v", d)
+	}
+	if s.Lookup("Device.WiFi.SSID.1.") != nil {
+		t.Errorf("Lookup object should return nil")
+	}
+}
+
+func TestDetectModel(t *testing.T) {
+	if m := DetectModel(map[string]string{"Device.DeviceInfo.UpTime": "1"}); m != TR181 {
+		t.Errorf("DetectModel = %s", m)
+	}
+	if m := DetectModel(map[string]string{
+		"Device.DeviceInfo.UpTime":                "1",
+		"InternetGatewayDevice.DeviceInfo.UpTime": "1",
+	}); m != TR098 {
+		t.Errorf("DetectModel = %s", m)
+	}
+}
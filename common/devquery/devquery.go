@@ -0,0 +1,385 @@
+// Package devquery parses device filter expressions and translates them into SQL.
+//
+//	manufacturer = "ZTE" AND param["Device.Optical.Interface.1.Stats.RXPower"] < -25 AND node = "Jakarta"
+//
+// Conditions compare a device field or a parameter value with a string or number
+// literal using = != < <= > >= ~ (contains) or LIKE, and can be combined with AND, OR, NOT
+// and parentheses. The generated SQL targets the net_cpe table, parameter values
+// are read from net_cpe_param.
+package devquery
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Field a device field usable in expressions, Column is a column name or SQL expression
+type Field struct {
+	Column  string
+	Numeric bool
+}
+
+// Node expression syntax tree node
+type Node interface {
+	String() string
+}
+
+// Binary AND / OR of two expressions
+type Binary struct {
+	Op    string
+	Left  Node
+	Right Node
+}
+
+// Not negated expression
+type Not struct {
+	X Node
+}
+
+// Condition one comparison, Param is set for param["..."] operands
+type Condition struct {
+	Field  string
+	Param  string
+	Op     string
+	Value  string
+	Number bool
+}
+
+func (b *Binary) String() string {
+	return fmt.Sprintf("(%s %s %s)", b.Left, b.Op, b.Right)
+}
+
+func (n *Not) String() string {
+	return fmt.Sprintf("NOT %s", n.X)
+}
+
+func (c *Condition) String() string {
+	name := c.Field
+	if c.Param != "" {
+		name = fmt.Sprintf("param[%q]", c.Param)
+	}
+	if c.Number {
+		return fmt.Sprintf("%s %s %s", name, c.Op, c.Value)
+	}
+	return fmt.Sprintf("%s %s %q", name, c.Op, c.Value)
+}
+
+const (
+	tokEOF = iota
+	tokIdent
+	tokString
+	tokNumber
+	tokOp
+	tokLParen
+	tokRParen
+	tokLBrack
+	tokRBrack
+)
+
+type token struct {
+	kind  int
+	value string
+	pos   int
+}
+
+func tokenize(src string) ([]token, error) {
+	var tokens []token
+	rs := []rune(src)
+	for i := 0; i < len(rs); {
+		r := rs[i]
+		switch {
+		case unicode.IsSpace(r):
+			i++
+		case r == '(':
+			tokens = append(tokens, token{tokLParen, "(", i})
+			i++
+		case r == ')':
+			tokens = append(tokens, token{tokRParen, ")", i})
+			i++
+		case r == '[':
+			tokens = append(tokens, token{tokLBrack, "[", i})
+			i++
+		case r == ']':
+			tokens = append(tokens, token{tokRBrack, "]", i})
+			i++
+		case r == '"' || r == '\'':
+			var sb strings.Builder
+			start := i
+			i++
+			for ; i < len(rs) && rs[i] != r; i++ {
+				if rs[i] == '\\' && i+1 < len(rs) {
+					i++
+				}
+				sb.WriteRune(rs[i])
+			}
+			if i >= len(rs) {
+				return nil, fmt.Errorf("unterminated string at %d", start)
+			}
+			i++
+			tokens = append(tokens, token{tokString, sb.String(), start})
+		case strings.ContainsRune("=!<>~", r):
+			start := i
+			op := string(r)
+			if i+1 < len(rs) && (rs[i+1] == '=' || (r == '<' && rs[i+1] == '>')) {
+				op += string(rs[i+1])
+			}
+			i += len([]rune(op))
+			switch op {
+			case "==":
+				op = "="
+			case "<>":
+				op = "!="
+			case "=", "!=", "<", "<=", ">", ">=", "~":
+			default:
+				return nil, fmt.Errorf("unexpected %s at %d", op, start)
+			}
+			tokens = append(tokens, token{tokOp, op, start})
+		case r == '-' || unicode.IsDigit(r):
+			start := i
+			i++
+			for i < len(rs) && (unicode.IsDigit(rs[i]) || rs[i] == '.') {
+				i++
+			}
+			num := string(rs[start:i])
+			if _, err := strconv.ParseFloat(num, 64); err != nil {
+				return nil, fmt.Errorf("invalid number %s at %d", num, start)
+			}
+			tokens = append(tokens, token{tokNumber, num, start})
+		case unicode.IsLetter(r) || r == '_':
+			start := i
+			for i < len(rs) && (unicode.IsLetter(rs[i]) || unicode.IsDigit(rs[i]) || rs[i] == '_') {
+				i++
+			}
+			tokens = append(tokens, token{tokIdent, string(rs[start:i]), start})
+		default:
+			return nil, fmt.Errorf("unexpected %c at %d", r, i)
+		}
+	}
+	return append(tokens, token{tokEOF, "", len(rs)}), nil
+}
+
+type parser struct {
+	tokens []token
+	pos    int
+}
+
+func (p *parser) peek() token {
+	return p.tokens[p.pos]
+}
+
+func (p *parser) next() token {
+	t := p.tokens[p.pos]
+	if t.kind != tokEOF {
+		p.pos++
+	}
+	return t
+}
+
+func (p *parser) keyword(name string) bool {
+	t := p.peek()
+	if t.kind == tokIdent && strings.EqualFold(t.value, name) {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *parser) parseOr() (Node, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.keyword("OR") {
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = &Binary{Op: "OR", Left: left, Right: right}
+	}
+	return left, nil
+}
+
+func (p *parser) parseAnd() (Node, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for p.keyword("AND") {
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		left = &Binary{Op: "AND", Left: left, Right: right}
+	}
+	return left, nil
+}
+
+func (p *parser) parseUnary() (Node, error) {
+	if p.keyword("NOT") {
+		x, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return &Not{X: x}, nil
+	}
+	if p.peek().kind == tokLParen {
+		p.next()
+		x, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if t := p.next(); t.kind != tokRParen {
+			return nil, fmt.Errorf("expected ) at %d", t.pos)
+		}
+		return x, nil
+	}
+	return p.parseCondition()
+}
+
+func (p *parser) parseCondition() (Node, error) {
+	t := p.next()
+	if t.kind != tokIdent {
+		return nil, fmt.Errorf("expected field name at %d", t.pos)
+	}
+	cond := &Condition{Field: strings.ToLower(t.value)}
+	if cond.Field == "param" && p.peek().kind == tokLBrack {
+		p.next()
+		name := p.next()
+		if name.kind != tokString || name.value == "" {
+			return nil, fmt.Errorf("expected parameter name at %d", name.pos)
+		}
+		if rb := p.next(); rb.kind != tokRBrack {
+			return nil, fmt.Errorf("expected ] at %d", rb.pos)
+		}
+		cond.Field = ""
+		cond.Param = name.value
+	}
+	op := p.next()
+	switch {
+	case op.kind == tokOp:
+		cond.Op = op.value
+	case op.kind == tokIdent && strings.EqualFold(op.value, "LIKE"):
+		cond.Op = "LIKE"
+	default:
+		return nil, fmt.Errorf("expected operator at %d", op.pos)
+	}
+	val := p.next()
+	switch val.kind {
+	case tokString:
+	case tokNumber:
+		cond.Number = true
+	default:
+		return nil, fmt.Errorf("expected string or number at %d", val.pos)
+	}
+	cond.Value = val.value
+	return cond, nil
+}
+
+// Parse parse a filter expression
+func Parse(expr string) (Node, error) {
+	if strings.TrimSpace(expr) == "" {
+		return nil, errors.New("empty expression")
+	}
+	tokens, err := tokenize(expr)
+	if err != nil {
+		return nil, err
+	}
+	p := &parser{tokens: tokens}
+	node, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if t := p.peek(); t.kind != tokEOF {
+		return nil, fmt.Errorf("unexpected %s at %d", t.value, t.pos)
+	}
+	return node, nil
+}
+
+// numericExpr a text SQL expression cast to numeric, NULL when it is not a number.
+// The regex avoids ? which would be taken as a placeholder.
+func numericExpr(column string) string {
+	return fmt.Sprintf(`(CASE WHEN %s ~ '^\s*-{0,1}[0-9]+(\.[0-9]+){0,1}\s*$' THEN CAST(%s AS numeric) END)`, column, column)
+}
+
+func compare(column string, numeric bool, c *Condition, args *[]interface{}) (string, error) {
+	switch c.Op {
+	case "~":
+		*args = append(*args, "%"+c.Value+"%")
+		return fmt.Sprintf("CAST(%s AS text) LIKE ?", column), nil
+	case "LIKE":
+		*args = append(*args, strings.ReplaceAll(c.Value, "*", "%"))
+		return fmt.Sprintf("CAST(%s AS text) LIKE ?", column), nil
+	}
+	ordering := c.Op != "=" && c.Op != "!="
+	switch {
+	case numeric && !c.Number:
+		return "", fmt.Errorf("%s requires a number", c)
+	case numeric:
+		v, _ := strconv.ParseFloat(c.Value, 64)
+		*args = append(*args, v)
+		return fmt.Sprintf("%s %s ?", column, c.Op), nil
+	case c.Number && ordering:
+		v, _ := strconv.ParseFloat(c.Value, 64)
+		*args = append(*args, v)
+		return fmt.Sprintf("%s %s ?", numericExpr(column), c.Op), nil
+	case ordering:
+		return "", fmt.Errorf("%s: %s requires a number", c, c.Op)
+	default:
+		*args = append(*args, c.Value)
+		return fmt.Sprintf("%s %s ?", column, c.Op), nil
+	}
+}
+
+func compile(node Node, fields map[string]Field, args *[]interface{}) (string, error) {
+	switch n := node.(type) {
+	case *Binary:
+		left, err := compile(n.Left, fields, args)
+		if err != nil {
+			return "", err
+		}
+		right, err := compile(n.Right, fields, args)
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("(%s %s %s)", left, n.Op, right), nil
+	case *Not:
+		x, err := compile(n.X, fields, args)
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("(NOT %s)", x), nil
+	case *Condition:
+		if n.Param != "" {
+			*args = append(*args, n.Param)
+			cmp, err := compare("net_cpe_param.value", false, n, args)
+			if err != nil {
+				return "", err
+			}
+			return fmt.Sprintf("EXISTS (SELECT 1 FROM net_cpe_param WHERE net_cpe_param.sn = net_cpe.sn AND net_cpe_param.name = ? AND %s)", cmp), nil
+		}
+		field, ok := fields[n.Field]
+		if !ok {
+			return "", fmt.Errorf("unknown field %s", n.Field)
+		}
+		return compare(field.Column, field.Numeric, n, args)
+	}
+	return "", fmt.Errorf("unsupported node %T", node)
+}
+
+// Compile translate an expression into a SQL condition with placeholders,
+// only the given fields are accepted
+func Compile(expr string, fields map[string]Field) (string, []interface{}, error) {
+	node, err := Parse(expr)
+	if err != nil {
+		return "", nil, err
+	}
+	var args []interface{}
+	sql, err := compile(node, fields, &args)
+	if err != nil {
+		return "", nil, err
+	}
+	return sql, args, nil
+}
@@ -0,0 +1,62 @@
+package devquery
+
+import (
+	"reflect"
+	"testing"
+)
+
+var testFields = map[string]Field{
+	"manufacturer": {Column: "manufacturer"},
+	"node":         {Column: "(SELECT name FROM net_node WHERE net_node.id = net_cpe.node_id)"},
+	"uptime":       {Column: "uptime", Numeric: true},
+}
+
+func TestParse(t *testing.T) {
+	cases := []struct {
+		expr string
+		want string
+	}{
+		{`manufacturer = "ZTE"`, `manufacturer = "ZTE"`},
+		{`manufacturer == 'ZTE' and uptime > 3600`, `(manufacturer = "ZTE" AND uptime > 3600)`},
+		{`a = "1" OR b = "2" AND c = "3"`, `(a = "1" OR (b = "2" AND c = "3"))`},
+		{`NOT (a = "1" OR b <> "2")`, `NOT (a = "1" OR b != "2")`},
+		{`param["Device.X.RXPower"] < -25`, `param["Device.X.RXPower"] < -25`},
+		{`node like "Jak*"`, `node LIKE "Jak*"`},
+	}
+	for _, c := range cases {
+		node, err := Parse(c.expr)
+		if err != nil {
+			t.Errorf("Parse(%s): %s", c.expr, err)
+			continue
+		}
+		if node.String() != c.want {
+			t.Errorf("Parse(%s) = %s, want %s", c.expr, node, c.want)
+		}
+	}
+	for _, expr := range []string{``, `manufacturer`, `manufacturer = `, `(a = "1"`, `a = "1" b = "2"`, `param[a] = "1"`, `a ! "1"`, `a = "1`} {
+		if _, err := Parse(expr); err == nil {
+			t.Errorf("Parse(%s) should fail", expr)
+		}
+	}
+}
+
+func TestCompile(t *testing.T) {
+	sql, args, err := Compile(`manufacturer = "ZTE" AND param["Device.X.RXPower"] < -25 AND node = "Jakarta"`, testFields)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `((manufacturer = ? AND EXISTS (SELECT 1 FROM net_cpe_param WHERE net_cpe_param.sn = net_cpe.sn AND net_cpe_param.name = ? AND ` +
+		numericExpr("net_cpe_param.value") + ` < ?)) AND (SELECT name FROM net_node WHERE net_node.id = net_cpe.node_id) = ?)`
+	if sql != want {
+		t.Errorf("sql = %s\nwant  %s", sql, want)
+	}
+	if !reflect.DeepEqual(args, []interface{}{"ZTE", "Device.X.RXPower", -25.0, "Jakarta"}) {
+		t.Errorf("args = %v", args)
+	}
+
+	for _, expr := range []string{`model = "x"`, `uptime = "long"`, `manufacturer > "ZTE"`} {
+		if _, _, err := Compile(expr, testFields); err == nil {
+			t.Errorf("Compile(%s) should fail", expr)
+		}
+	}
+}
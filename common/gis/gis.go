@@ -0,0 +1,248 @@
+package gis
+
+// GeoJSON (RFC 7946) and KML 2.2 encoding of the network plant, points for
+// the devices and line strings for the cables, for QGIS and Google Earth.
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+var ErrInvalidCoordinate = errors.New("invalid coordinate")
+
+// ErrNoCoordinate Both latitude and longitude are empty
+var ErrNoCoordinate = errors.New("no coordinate")
+
+type Point struct {
+	Lat float64
+	Lng float64
+}
+
+// ParsePoint Parse and validate a latitude and longitude pair, 0,0 is
+// rejected as it is the value of unset GPS fixes
+func ParsePoint(lat, lng string) (Point, error) {
+	if strings.TrimSpace(lat) == "" && strings.TrimSpace(lng) == "" {
+		return Point{}, ErrNoCoordinate
+	}
+	p, err := parseLatLng(lat, lng)
+	if err == nil && p.Lat == 0 && p.Lng == 0 {
+		err = fmt.Errorf("%w: 0,0", ErrInvalidCoordinate)
+	}
+	return p, err
+}
+
+func parseLatLng(lat, lng string) (Point, error) {
+	lat, lng = strings.TrimSpace(lat), strings.TrimSpace(lng)
+	var p Point
+	var err error
+	if p.Lat, err = strconv.ParseFloat(lat, 64); err != nil || p.Lat < -90 || p.Lat > 90 {
+		return p, fmt.Errorf("%w: latitude %q", ErrInvalidCoordinate, lat)
+	}
+	if p.Lng, err = strconv.ParseFloat(lng, 64); err != nil || p.Lng < -180 || p.Lng > 180 {
+		return p, fmt.Errorf("%w: longitude %q", ErrInvalidCoordinate, lng)
+	}
+	return p, nil
+}
+
+// BBox Bounding box, the longitude range may cross the antimeridian
+type BBox struct {
+	MinLng, MinLat, MaxLng, MaxLat float64
+}
+
+// ParseBBox Parse a "minLng,minLat,maxLng,maxLat" box as in GeoJSON and WMS
+func ParseBBox(s string) (*BBox, error) {
+	parts := strings.Split(s, ",")
+	if len(parts) != 4 {
+		return nil, fmt.Errorf("bbox must be minLng,minLat,maxLng,maxLat")
+	}
+	min, err := parseLatLng(parts[1], parts[0])
+	if err != nil {
+		return nil, err
+	}
+	max, err := parseLatLng(parts[3], parts[2])
+	if err != nil {
+		return nil, err
+	}
+	if min.Lat > max.Lat {
+		return nil, fmt.Errorf("bbox minLat is above maxLat")
+	}
+	return &BBox{MinLng: min.Lng, MinLat: min.Lat, MaxLng: max.Lng, MaxLat: max.Lat}, nil
+}
+
+// Where SQL condition and arguments of the points in the box on numeric
+// latitude and longitude columns, the same test as Contains
+func (b *BBox) Where(lat, lng string) (string, []interface{}) {
+	if b.MinLng <= b.MaxLng {
+		return fmt.Sprintf("%s between ? and ? and %s between ? and ?", lat, lng),
+			[]interface{}{b.MinLat, b.MaxLat, b.MinLng, b.MaxLng}
+	}
+	return fmt.Sprintf("%s between ? and ? and (%s >= ? or %s <= ?)", lat, lng, lng),
+		[]interface{}{b.MinLat, b.MaxLat, b.MinLng, b.MaxLng}
+}
+
+func (b *BBox) Contains(p Point) bool {
+	if b == nil {
+		return true
+	}
+	if p.Lat < b.MinLat || p.Lat > b.MaxLat {
+		return false
+	}
+	if b.MinLng <= b.MaxLng {
+		return p.Lng >= b.MinLng && p.Lng <= b.MaxLng
+	}
+	return p.Lng >= b.MinLng || p.Lng <= b.MaxLng
+}
+
+// Feature A point with one coordinate, a line string with more
+type Feature struct {
+	ID          string
+	Name        string
+	Style       string
+	Coordinates []Point
+	Properties  map[string]interface{}
+}
+
+// Style Marker and line style, the color is #rrggbb
+type Style struct {
+	ID    string
+	Color string
+	Scale float64
+}
+
+type geoJSONGeometry struct {
+	Type        string      `json:"type"`
+	Coordinates interface{} `json:"coordinates"`
+}
+
+type geoJSONFeature struct {
+	Type       string                 `json:"type"`
+	ID         string                 `json:"id,omitempty"`
+	Geometry   geoJSONGeometry        `json:"geometry"`
+	Properties map[string]interface{} `json:"properties"`
+}
+
+// GeoJSON FeatureCollection of the features, the name, style and the simplestyle
+// marker-color or stroke of the style are added to the properties
+func GeoJSON(styles []Style, features []Feature) ([]byte, error) {
+	colors := make(map[string]string)
+	for _, s := range styles {
+		colors[s.ID] = s.Color
+	}
+	items := make([]geoJSONFeature, 0, len(features))
+	for _, f := range features {
+		props := map[string]interface{}{"name": f.Name, "style": f.Style}
+		if c, ok := colors[f.Style]; ok && len(f.Coordinates) == 1 {
+			props["marker-color"] = c
+		} else if ok {
+			props["stroke"] = c
+		}
+		for k, v := range f.Properties {
+			props[k] = v
+		}
+		g := geoJSONGeometry{Type: "Point"}
+		coords := make([][]float64, len(f.Coordinates))
+		for i, p := range f.Coordinates {
+			coords[i] = []float64{p.Lng, p.Lat}
+		}
+		if len(coords) == 1 {
+			g.Coordinates = coords[0]
+		} else {
+			g.Type = "LineString"
+			g.Coordinates = coords
+		}
+		items = append(items, geoJSONFeature{Type: "Feature", ID: f.ID, Geometry: g, Properties: props})
+	}
+	return json.Marshal(map[string]interface{}{"type": "FeatureCollection", "features": items})
+}
+
+type kmlData struct {
+	Name  string `xml:"name,attr"`
+	Value string `xml:"value"`
+}
+
+type kmlPlacemark struct {
+	ID           string    `xml:"id,attr,omitempty"`
+	Name         string    `xml:"name"`
+	StyleUrl     string    `xml:"styleUrl,omitempty"`
+	ExtendedData []kmlData `xml:"ExtendedData>Data,omitempty"`
+	Point        *string   `xml:"Point>coordinates,omitempty"`
+	LineString   *string   `xml:"LineString>coordinates,omitempty"`
+}
+
+type kmlStyle struct {
+	ID         string  `xml:"id,attr"`
+	IconColor  string  `xml:"IconStyle>color"`
+	IconScale  float64 `xml:"IconStyle>scale"`
+	LineColor  string  `xml:"LineStyle>color"`
+	LineWidth  int     `xml:"LineStyle>width"`
+	LabelScale float64 `xml:"LabelStyle>scale"`
+}
+
+type kmlDocument struct {
+	XMLName    xml.Name       `xml:"kml"`
+	Xmlns      string         `xml:"xmlns,attr"`
+	Name       string         `xml:"Document>name"`
+	Styles     []kmlStyle     `xml:"Document>Style"`
+	Placemarks []kmlPlacemark `xml:"Document>Placemark"`
+}
+
+// KML document of the features, the feature style refers to one of the styles
+func KML(name string, styles []Style, features []Feature) ([]byte, error) {
+	doc := kmlDocument{Xmlns: "http://www.opengis.net/kml/2.2", Name: name}
+	for _, s := range styles {
+		scale := s.Scale
+		if scale == 0 {
+			scale = 1
+		}
+		color := kmlColor(s.Color)
+		doc.Styles = append(doc.Styles, kmlStyle{ID: s.ID, IconColor: color, IconScale: scale,
+			LineColor: color, LineWidth: 2, LabelScale: 0.7})
+	}
+	for _, f := range features {
+		pm := kmlPlacemark{ID: f.ID, Name: f.Name}
+		if f.Style != "" {
+			pm.StyleUrl = "#" + f.Style
+		}
+		keys := make([]string, 0, len(f.Properties))
+		for k := range f.Properties {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			if f.Properties[k] == nil {
+				continue
+			}
+			pm.ExtendedData = append(pm.ExtendedData, kmlData{Name: k, Value: fmt.Sprint(f.Properties[k])})
+		}
+		coords := make([]string, len(f.Coordinates))
+		for i, p := range f.Coordinates {
+			coords[i] = strconv.FormatFloat(p.Lng, 'f', -1, 64) + "," + strconv.FormatFloat(p.Lat, 'f', -1, 64)
+		}
+		value := strings.Join(coords, " ")
+		if len(coords) == 1 {
+			pm.Point = &value
+		} else {
+			pm.LineString = &value
+		}
+		doc.Placemarks = append(doc.Placemarks, pm)
+	}
+	data, err := xml.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(xml.Header), data...), nil
+}
+
+// kmlColor #rrggbb to the aabbggrr KML color
+func kmlColor(c string) string {
+	c = strings.TrimPrefix(c, "#")
+	if len(c) != 6 {
+		return "ffffffff"
+	}
+	return "ff" + c[4:6] + c[2:4] + c[0:2]
+}
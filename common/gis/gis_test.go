@@ -0,0 +1,97 @@
+package gis
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestParsePoint(t *testing.T) {
+	p, err := ParsePoint(" -6.2088", "106.8456 ")
+	if err != nil || p.Lat != -6.2088 || p.Lng != 106.8456 {
+		t.Fatalf("ParsePoint = %+v %v", p, err)
+	}
+	if _, err = ParsePoint("", ""); err != ErrNoCoordinate {
+		t.Errorf("empty = %v", err)
+	}
+	for _, c := range [][2]string{{"91", "10"}, {"10", "181"}, {"abc", "10"}, {"0", "0"}, {"10", ""}} {
+		if _, err = ParsePoint(c[0], c[1]); !errors.Is(err, ErrInvalidCoordinate) {
+			t.Errorf("ParsePoint(%q, %q) = %v", c[0], c[1], err)
+		}
+	}
+}
+
+func TestBBox(t *testing.T) {
+	b, err := ParseBBox("106.7,-6.3,106.9,-6.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !b.Contains(Point{Lat: -6.2, Lng: 106.8}) || b.Contains(Point{Lat: -6.2, Lng: 107}) {
+		t.Error("Contains mismatch")
+	}
+	// antimeridian
+	b, _ = ParseBBox("179,-20,-179,-10")
+	if !b.Contains(Point{Lat: -15, Lng: 179.5}) || b.Contains(Point{Lat: -15, Lng: 0}) {
+		t.Error("antimeridian Contains mismatch")
+	}
+	if _, err = ParseBBox("1,2,3"); err == nil {
+		t.Error("expected error")
+	}
+}
+
+func TestBBoxWhere(t *testing.T) {
+	b, _ := ParseBBox("106.7,-6.3,106.9,-6.1")
+	where, args := b.Where("lat", "lng")
+	if where != "lat between ? and ? and lng between ? and ?" || fmt.Sprint(args) != "[-6.3 -6.1 106.7 106.9]" {
+		t.Errorf("Where = %s %v", where, args)
+	}
+	b, _ = ParseBBox("179,-20,-179,-10")
+	where, args = b.Where("lat", "lng")
+	if where != "lat between ? and ? and (lng >= ? or lng <= ?)" || fmt.Sprint(args) != "[-20 -10 179 -179]" {
+		t.Errorf("antimeridian Where = %s %v", where, args)
+	}
+}
+
+func TestEncode(t *testing.T) {
+	features := []Feature{
+		{ID: "odp-1", Name: "ODP <1>", Style: "odp", Coordinates: []Point{{-6.2, 106.8}},
+			Properties: map[string]interface{}{"capacity": 8}},
+		{ID: "cable-1", Name: "cable", Style: "cable", Coordinates: []Point{{-6.2, 106.8}, {-6.21, 106.81}}},
+	}
+	styles := []Style{{ID: "odp", Color: "#ff8000"}}
+	data, err := GeoJSON(styles, features)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var fc struct {
+		Features []struct {
+			Geometry struct {
+				Type        string
+				Coordinates json.RawMessage
+			}
+			Properties map[string]interface{}
+		}
+	}
+	if err = json.Unmarshal(data, &fc); err != nil {
+		t.Fatal(err)
+	}
+	if len(fc.Features) != 2 || fc.Features[0].Geometry.Type != "Point" ||
+		string(fc.Features[0].Geometry.Coordinates) != "[106.8,-6.2]" || fc.Features[1].Geometry.Type != "LineString" ||
+		fc.Features[0].Properties["marker-color"] != "#ff8000" {
+		t.Errorf("GeoJSON = %s", data)
+	}
+
+	data, err = KML("plant", styles, features)
+	if err != nil {
+		t.Fatal(err)
+	}
+	kml := string(data)
+	for _, want := range []string{"<styleUrl>#odp</styleUrl>", "<color>ff0080ff</color>", "<coordinates>106.8,-6.2</coordinates>",
+		"ODP &lt;1&gt;", "<LineString>", `<Data name="capacity">`} {
+		if !strings.Contains(kml, want) {
+			t.Errorf("KML missing %s\n%s", want, kml)
+		}
+	}
+}
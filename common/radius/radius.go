@@ -0,0 +1,213 @@
+package radius
+
+// Minimal RADIUS accounting (RFC 2866) support, the NAS sends
+// Accounting-Request packets for PPPoE sessions and the ACS answers
+// with an Accounting-Response.
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/binary"
+	"errors"
+	"net"
+)
+
+const (
+	headerSize = 20
+	maxSize    = 4096
+)
+
+// Packet codes
+const (
+	CodeAccountingRequest  byte = 4
+	CodeAccountingResponse byte = 5
+)
+
+// Attribute types
+const (
+	AttrUserName            byte = 1
+	AttrNasIPAddress        byte = 4
+	AttrNasPort             byte = 5
+	AttrFramedIPAddress     byte = 8
+	AttrCalledStationId     byte = 30
+	AttrCallingStationId    byte = 31
+	AttrNasIdentifier       byte = 32
+	AttrAcctStatusType      byte = 40
+	AttrAcctDelayTime       byte = 41
+	AttrAcctInputOctets     byte = 42
+	AttrAcctOutputOctets    byte = 43
+	AttrAcctSessionId       byte = 44
+	AttrAcctSessionTime     byte = 46
+	AttrAcctTerminateCause  byte = 49
+	AttrAcctInputGigawords  byte = 52
+	AttrAcctOutputGigawords byte = 53
+	AttrNasPortId           byte = 87
+)
+
+// Acct-Status-Type values
+const (
+	AcctStatusStart         uint32 = 1
+	AcctStatusStop          uint32 = 2
+	AcctStatusInterimUpdate uint32 = 3
+	AcctStatusAccountingOn  uint32 = 7
+	AcctStatusAccountingOff uint32 = 8
+)
+
+var terminateCauses = map[uint32]string{
+	1: "User-Request", 2: "Lost-Carrier", 3: "Lost-Service", 4: "Idle-Timeout",
+	5: "Session-Timeout", 6: "Admin-Reset", 7: "Admin-Reboot", 8: "Port-Error",
+	9: "NAS-Error", 10: "NAS-Request", 11: "NAS-Reboot", 12: "Port-Unneeded",
+	13: "Port-Preempted", 14: "Port-Suspended", 15: "Service-Unavailable",
+	16: "Callback", 17: "User-Error", 18: "Host-Request",
+}
+
+var ErrInvalidPacket = errors.New("invalid radius packet")
+
+// ErrAuthenticator The request authenticator does not match the shared secret
+var ErrAuthenticator = errors.New("radius request authenticator mismatch")
+
+type Attribute struct {
+	Type  byte
+	Value []byte
+}
+
+type Packet struct {
+	Code          byte
+	Identifier    byte
+	Authenticator [16]byte
+	Attributes    []Attribute
+}
+
+func Parse(b []byte) (*Packet, error) {
+	if len(b) < headerSize || len(b) > maxSize {
+		return nil, ErrInvalidPacket
+	}
+	size := int(binary.BigEndian.Uint16(b[2:4]))
+	if size < headerSize || size > len(b) {
+		return nil, ErrInvalidPacket
+	}
+	p := &Packet{Code: b[0], Identifier: b[1]}
+	copy(p.Authenticator[:], b[4:headerSize])
+	body := b[headerSize:size]
+	for len(body) > 0 {
+		if len(body) < 2 || body[1] < 2 || int(body[1]) > len(body) {
+			return nil, ErrInvalidPacket
+		}
+		p.Attributes = append(p.Attributes, Attribute{Type: body[0], Value: body[2:body[1]]})
+		body = body[body[1]:]
+	}
+	return p, nil
+}
+
+// Get Value of the first attribute of the type
+func (p *Packet) Get(atype byte) ([]byte, bool) {
+	for _, a := range p.Attributes {
+		if a.Type == atype {
+			return a.Value, true
+		}
+	}
+	return nil, false
+}
+
+func (p *Packet) String(atype byte) string {
+	v, _ := p.Get(atype)
+	return string(v)
+}
+
+func (p *Packet) Uint32(atype byte) uint32 {
+	v, ok := p.Get(atype)
+	if !ok || len(v) != 4 {
+		return 0
+	}
+	return binary.BigEndian.Uint32(v)
+}
+
+func (p *Packet) IP(atype byte) net.IP {
+	v, ok := p.Get(atype)
+	if !ok || len(v) != 4 {
+		return nil
+	}
+	return net.IPv4(v[0], v[1], v[2], v[3])
+}
+
+// InputOctets Acct-Input-Octets including the Acct-Input-Gigawords wraps
+func (p *Packet) InputOctets() int64 {
+	return int64(p.Uint32(AttrAcctInputGigawords))<<32 | int64(p.Uint32(AttrAcctInputOctets))
+}
+
+// OutputOctets Acct-Output-Octets including the Acct-Output-Gigawords wraps
+func (p *Packet) OutputOctets() int64 {
+	return int64(p.Uint32(AttrAcctOutputGigawords))<<32 | int64(p.Uint32(AttrAcctOutputOctets))
+}
+
+// TerminateCause Acct-Terminate-Cause name
+func (p *Packet) TerminateCause() string {
+	v, ok := p.Get(AttrAcctTerminateCause)
+	if !ok || len(v) != 4 {
+		return ""
+	}
+	if name, ok := terminateCauses[binary.BigEndian.Uint32(v)]; ok {
+		return name
+	}
+	return "Unknown"
+}
+
+func (p *Packet) Add(atype byte, value []byte) {
+	p.Attributes = append(p.Attributes, Attribute{Type: atype, Value: value})
+}
+
+func (p *Packet) AddUint32(atype byte, value uint32) {
+	v := make([]byte, 4)
+	binary.BigEndian.PutUint32(v, value)
+	p.Add(atype, v)
+}
+
+func (p *Packet) Encode() []byte {
+	size := headerSize
+	for _, a := range p.Attributes {
+		size += 2 + len(a.Value)
+	}
+	b := make([]byte, size)
+	b[0] = p.Code
+	b[1] = p.Identifier
+	binary.BigEndian.PutUint16(b[2:4], uint16(size))
+	copy(b[4:headerSize], p.Authenticator[:])
+	pos := headerSize
+	for _, a := range p.Attributes {
+		b[pos] = a.Type
+		b[pos+1] = byte(2 + len(a.Value))
+		copy(b[pos+2:], a.Value)
+		pos += 2 + len(a.Value)
+	}
+	return b
+}
+
+// authenticator MD5 of the packet with the given authenticator field and the secret
+func (p *Packet) authenticator(auth [16]byte, secret []byte) [16]byte {
+	b := p.Encode()
+	copy(b[4:headerSize], auth[:])
+	return md5.Sum(append(b, secret...))
+}
+
+// VerifyRequest Check the Accounting-Request authenticator,
+// the MD5 of the packet with a zero authenticator and the secret
+func (p *Packet) VerifyRequest(secret []byte) error {
+	want := p.authenticator([16]byte{}, secret)
+	if !bytes.Equal(want[:], p.Authenticator[:]) {
+		return ErrAuthenticator
+	}
+	return nil
+}
+
+// SignRequest Set the Accounting-Request authenticator, used by tests and clients
+func (p *Packet) SignRequest(secret []byte) {
+	p.Authenticator = p.authenticator([16]byte{}, secret)
+}
+
+// NewAccountingResponse Accounting-Response of the request, the response
+// authenticator is the MD5 of the response with the request authenticator and the secret
+func NewAccountingResponse(req *Packet, secret []byte) *Packet {
+	resp := &Packet{Code: CodeAccountingResponse, Identifier: req.Identifier}
+	resp.Authenticator = resp.authenticator(req.Authenticator, secret)
+	return resp
+}
@@ -0,0 +1,64 @@
+package radius
+
+import (
+	"crypto/md5"
+	"net"
+	"testing"
+)
+
+func TestAccountingRequest(t *testing.T) {
+	secret := []byte("testing123")
+	req := &Packet{Code: CodeAccountingRequest, Identifier: 7}
+	req.Add(AttrUserName, []byte("user01@isp"))
+	req.AddUint32(AttrAcctStatusType, AcctStatusStop)
+	req.Add(AttrAcctSessionId, []byte("81200001"))
+	req.Add(AttrFramedIPAddress, net.ParseIP("100.64.1.20").To4())
+	req.AddUint32(AttrAcctInputOctets, 1000)
+	req.AddUint32(AttrAcctInputGigawords, 2)
+	req.AddUint32(AttrAcctOutputOctets, 500)
+	req.AddUint32(AttrAcctTerminateCause, 2)
+	req.SignRequest(secret)
+
+	p, err := Parse(req.Encode())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = p.VerifyRequest(secret); err != nil {
+		t.Fatal(err)
+	}
+	if p.VerifyRequest([]byte("wrong")) != ErrAuthenticator {
+		t.Error("wrong secret accepted")
+	}
+	if p.String(AttrUserName) != "user01@isp" || p.Uint32(AttrAcctStatusType) != AcctStatusStop {
+		t.Errorf("attributes not parsed %+v", p.Attributes)
+	}
+	if p.IP(AttrFramedIPAddress).String() != "100.64.1.20" {
+		t.Errorf("Framed-IP-Address = %s", p.IP(AttrFramedIPAddress))
+	}
+	if p.InputOctets() != 2<<32+1000 || p.OutputOctets() != 500 {
+		t.Errorf("octets = %d %d", p.InputOctets(), p.OutputOctets())
+	}
+	if p.TerminateCause() != "Lost-Carrier" {
+		t.Errorf("Acct-Terminate-Cause = %s", p.TerminateCause())
+	}
+
+	resp := NewAccountingResponse(p, secret).Encode()
+	check := append([]byte{}, resp...)
+	copy(check[4:20], p.Authenticator[:])
+	sum := md5.Sum(append(check, secret...))
+	if string(resp[4:20]) != string(sum[:]) || resp[0] != CodeAccountingResponse || resp[1] != 7 {
+		t.Errorf("bad response %x", resp)
+	}
+}
+
+func TestParseInvalid(t *testing.T) {
+	for _, b := range [][]byte{
+		[]byte("short"),
+		{4, 1, 0, 22, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 1, 5},
+		{4, 1, 0, 30, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0},
+	} {
+		if _, err := Parse(b); err == nil {
+			t.Errorf("expected error for %x", b)
+		}
+	}
+}
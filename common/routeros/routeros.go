@@ -0,0 +1,356 @@
+// Package routeros RouterOS API client (port 8728, 8729 with TLS)
+// https://help.mikrotik.com/docs/display/ROS/API
+package routeros
+
+import (
+	"bufio"
+	"context"
+	"crypto/md5"
+	"crypto/tls"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	DefaultPort    = 8728
+	DefaultTlsPort = 8729
+)
+
+// Reply words
+const (
+	WordRe    = "!re"
+	WordDone  = "!done"
+	WordTrap  = "!trap"
+	WordFatal = "!fatal"
+	WordEmpty = "!empty"
+)
+
+var ErrClosed = errors.New("routeros connection closed")
+
+// maxWordSize Longest word accepted from the device, the length prefix allows 4 GiB
+const maxWordSize = 1 << 20
+
+// Sentence Reply sentence, Map holds the =key=value attribute words
+type Sentence struct {
+	Word string
+	Tag  string
+	Map  map[string]string
+	List []Pair
+}
+
+// Pair Attribute word in the order of the reply
+type Pair struct {
+	Key   string
+	Value string
+}
+
+func (s *Sentence) String() string {
+	var b strings.Builder
+	b.WriteString(s.Word)
+	for _, p := range s.List {
+		b.WriteString(" =")
+		b.WriteString(p.Key)
+		b.WriteString("=")
+		b.WriteString(p.Value)
+	}
+	return b.String()
+}
+
+// Reply Result of a command, the !re sentences and the final !done
+type Reply struct {
+	Re   []*Sentence
+	Done *Sentence
+}
+
+// DeviceError !trap or !fatal reply of the device
+type DeviceError struct {
+	Sentence *Sentence
+}
+
+func (e *DeviceError) Error() string {
+	if msg := e.Sentence.Map["message"]; msg != "" {
+		return "routeros: " + msg
+	}
+	return "routeros: " + e.Sentence.String()
+}
+
+// Client RouterOS API connection. Run is serialized, Listen holds the connection
+// until its context is done.
+type Client struct {
+	conn    net.Conn
+	r       *bufio.Reader
+	lock    sync.Mutex
+	timeout time.Duration
+	tag     int64
+	closed  atomic.Bool
+}
+
+// Dial Connect and login, tlsConfig nil for the plain API service
+func Dial(address, username, password string, tlsConfig *tls.Config, timeout time.Duration) (*Client, error) {
+	dialer := &net.Dialer{Timeout: timeout}
+	var conn net.Conn
+	var err error
+	if tlsConfig != nil {
+		conn, err = tls.DialWithDialer(dialer, "tcp", address, tlsConfig)
+	} else {
+		conn, err = dialer.Dial("tcp", address)
+	}
+	if err != nil {
+		return nil, err
+	}
+	c := NewClient(conn, timeout)
+	if err = c.Login(username, password); err != nil {
+		c.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+// NewClient Client of an established connection
+func NewClient(conn net.Conn, timeout time.Duration) *Client {
+	if timeout <= 0 {
+		timeout = 30 * time.Second
+	}
+	return &Client{conn: conn, r: bufio.NewReader(conn), timeout: timeout}
+}
+
+// Close Close the connection
+func (c *Client) Close() error {
+	c.closed.Store(true)
+	return c.conn.Close()
+}
+
+// Login Plain login of RouterOS 6.43+, the challenge login of older versions
+// when the device answers with a =ret= challenge
+func (c *Client) Login(username, password string) error {
+	reply, err := c.Run("/login", "=name="+username, "=password="+password)
+	if err != nil {
+		return err
+	}
+	challenge, ok := reply.Done.Map["ret"]
+	if !ok {
+		return nil
+	}
+	b, err := hex.DecodeString(challenge)
+	if err != nil {
+		return fmt.Errorf("routeros: invalid login challenge %s", challenge)
+	}
+	h := md5.New()
+	h.Write([]byte{0})
+	h.Write([]byte(password))
+	h.Write(b)
+	_, err = c.Run("/login", "=name="+username, "=response=00"+hex.EncodeToString(h.Sum(nil)))
+	return err
+}
+
+// Run Send a command sentence and read the reply until !done
+func (c *Client) Run(words ...string) (*Reply, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.closed.Load() {
+		return nil, ErrClosed
+	}
+	_ = c.conn.SetDeadline(time.Now().Add(c.timeout))
+	defer c.conn.SetDeadline(time.Time{})
+	if err := c.writeSentence(words); err != nil {
+		return nil, err
+	}
+	reply := &Reply{}
+	var trap error
+	for {
+		s, err := c.readSentence()
+		if err != nil {
+			return nil, err
+		}
+		switch s.Word {
+		case WordRe:
+			reply.Re = append(reply.Re, s)
+		case WordTrap:
+			if trap == nil {
+				trap = &DeviceError{Sentence: s}
+			}
+		case WordFatal:
+			c.closed.Store(true)
+			return nil, &DeviceError{Sentence: s}
+		case WordDone, WordEmpty:
+			reply.Done = s
+			if s.Word == WordEmpty {
+				// RouterOS 7.18+ sends !empty then !done for empty results
+				continue
+			}
+			return reply, trap
+		}
+	}
+}
+
+// Listen Run a streaming command such as /interface/listen or a
+// monitor-traffic and call fn for each !re until ctx is done or the device
+// ends the command. The command is canceled on the device when ctx is done
+// and the connection is closed, the late replies of /cancel are not read.
+func (c *Client) Listen(ctx context.Context, fn func(s *Sentence), words ...string) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.closed.Load() {
+		return ErrClosed
+	}
+	c.tag++
+	tag := strconv.FormatInt(c.tag, 10)
+	_ = c.conn.SetWriteDeadline(time.Now().Add(c.timeout))
+	if err := c.writeSentence(append(words, ".tag="+tag)); err != nil {
+		return err
+	}
+	_ = c.conn.SetWriteDeadline(time.Time{})
+
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		select {
+		case <-ctx.Done():
+			// the device ends the listen with a !trap interrupted and !done
+			_ = c.conn.SetWriteDeadline(time.Now().Add(c.timeout))
+			_ = c.writeSentence([]string{"/cancel", "=tag=" + tag})
+			_ = c.conn.SetReadDeadline(time.Now().Add(c.timeout))
+		case <-stop:
+		}
+	}()
+	defer func() {
+		if ctx.Err() != nil {
+			c.closed.Store(true)
+			c.conn.Close()
+			return
+		}
+		_ = c.conn.SetDeadline(time.Time{})
+	}()
+
+	for {
+		s, err := c.readSentence()
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+		if s.Tag != tag {
+			continue
+		}
+		switch s.Word {
+		case WordRe:
+			fn(s)
+		case WordTrap:
+			if ctx.Err() != nil {
+				continue
+			}
+			return &DeviceError{Sentence: s}
+		case WordFatal:
+			c.closed.Store(true)
+			return &DeviceError{Sentence: s}
+		case WordDone:
+			return nil
+		}
+	}
+}
+
+func (c *Client) writeSentence(words []string) error {
+	var buf []byte
+	for _, w := range words {
+		buf = appendWord(buf, w)
+	}
+	buf = append(buf, 0)
+	_, err := c.conn.Write(buf)
+	return err
+}
+
+func (c *Client) readSentence() (*Sentence, error) {
+	s := &Sentence{Map: make(map[string]string)}
+	for {
+		w, err := readWord(c.r)
+		if err != nil {
+			return nil, err
+		}
+		if w == "" {
+			if s.Word == "" {
+				// ignore the empty sentences
+				continue
+			}
+			return s, nil
+		}
+		switch {
+		case s.Word == "":
+			s.Word = w
+		case strings.HasPrefix(w, ".tag="):
+			s.Tag = w[5:]
+		case strings.HasPrefix(w, "="):
+			kv := strings.SplitN(w[1:], "=", 2)
+			p := Pair{Key: kv[0]}
+			if len(kv) == 2 {
+				p.Value = kv[1]
+			}
+			s.Map[p.Key] = p.Value
+			s.List = append(s.List, p)
+		}
+	}
+}
+
+// appendWord Length prefixed word, the length takes 1 to 5 bytes
+func appendWord(buf []byte, w string) []byte {
+	l := len(w)
+	switch {
+	case l < 0x80:
+		buf = append(buf, byte(l))
+	case l < 0x4000:
+		buf = append(buf, byte(l>>8)|0x80, byte(l))
+	case l < 0x200000:
+		buf = append(buf, byte(l>>16)|0xC0, byte(l>>8), byte(l))
+	case l < 0x10000000:
+		buf = append(buf, byte(l>>24)|0xE0, byte(l>>16), byte(l>>8), byte(l))
+	default:
+		buf = append(buf, 0xF0, byte(l>>24), byte(l>>16), byte(l>>8), byte(l))
+	}
+	return append(buf, w...)
+}
+
+func readWord(r *bufio.Reader) (string, error) {
+	first, err := r.ReadByte()
+	if err != nil {
+		return "", err
+	}
+	var l int
+	var extra int
+	switch {
+	case first&0x80 == 0:
+		l = int(first)
+	case first&0xC0 == 0x80:
+		l, extra = int(first&0x3F), 1
+	case first&0xE0 == 0xC0:
+		l, extra = int(first&0x1F), 2
+	case first&0xF0 == 0xE0:
+		l, extra = int(first&0x0F), 3
+	case first == 0xF0:
+		l, extra = 0, 4
+	default:
+		return "", fmt.Errorf("routeros: invalid word length 0x%x", first)
+	}
+	for i := 0; i < extra; i++ {
+		b, err := r.ReadByte()
+		if err != nil {
+			return "", err
+		}
+		l = l<<8 | int(b)
+	}
+	if l > maxWordSize {
+		return "", fmt.Errorf("routeros: word length %d exceeds %d", l, maxWordSize)
+	}
+	buf := make([]byte, l)
+	if _, err = io.ReadFull(r, buf); err != nil {
+		return "", err
+	}
+	return string(buf), nil
+}
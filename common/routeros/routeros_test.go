@@ -0,0 +1,134 @@
+package routeros
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWordLength(t *testing.T) {
+	for _, l := range []int{0, 1, 0x7F, 0x80, 0x3FFF, 0x4000, maxWordSize} {
+		w := strings.Repeat("a", l)
+		buf := appendWord(nil, w)
+		r, err := readWord(bufio.NewReader(strings.NewReader(string(buf))))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(r) != l {
+			t.Fatalf("length %d decoded as %d", l, len(r))
+		}
+	}
+	// the length is checked before the word is allocated
+	for _, l := range []int{maxWordSize + 1, 0x200000, 0xFFFFFFFF} {
+		buf := []byte{0xF0, byte(l >> 24), byte(l >> 16), byte(l >> 8), byte(l)}
+		if _, err := readWord(bufio.NewReader(strings.NewReader(string(buf)))); err == nil {
+			t.Fatalf("length %d accepted", l)
+		}
+	}
+}
+
+// fakeDevice Answers the sentences of a client on the other end of a pipe
+func fakeDevice(t *testing.T, conn net.Conn, handler func(words []string, reply func(words ...string))) {
+	r := bufio.NewReader(conn)
+	reply := func(words ...string) {
+		var buf []byte
+		for _, w := range words {
+			buf = appendWord(buf, w)
+		}
+		_, _ = conn.Write(append(buf, 0))
+	}
+	go func() {
+		var words []string
+		for {
+			w, err := readWord(r)
+			if err != nil {
+				return
+			}
+			if w != "" {
+				words = append(words, w)
+				continue
+			}
+			handler(words, reply)
+			words = nil
+		}
+	}()
+}
+
+func TestClientRun(t *testing.T) {
+	client, server := net.Pipe()
+	defer server.Close()
+	fakeDevice(t, server, func(words []string, reply func(words ...string)) {
+		switch words[0] {
+		case "/login":
+			if words[2] != "=password=secret" {
+				reply("!trap", "=message=invalid user name or password (6)")
+			}
+			reply("!done")
+		case "/interface/print":
+			reply("!re", "=.id=*1", "=name=ether1", "=running=true")
+			reply("!re", "=.id=*2", "=name=ether2", "=running=false")
+			reply("!done")
+		case "/ip/firewall/filter/remove":
+			reply("!trap", "=message=no such item")
+			reply("!done")
+		}
+	})
+
+	c := NewClient(client, time.Second)
+	defer c.Close()
+	if err := c.Login("admin", "secret"); err != nil {
+		t.Fatal(err)
+	}
+	reply, err := c.Run("/interface/print")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(reply.Re) != 2 || reply.Re[1].Map["name"] != "ether2" {
+		t.Fatalf("unexpected reply %+v", reply.Re)
+	}
+	_, err = c.Run("/ip/firewall/filter/remove", "=.id=*99")
+	if err == nil || err.Error() != "routeros: no such item" {
+		t.Fatalf("expected trap error, got %v", err)
+	}
+}
+
+func TestClientListen(t *testing.T) {
+	client, server := net.Pipe()
+	defer server.Close()
+	var tag string
+	fakeDevice(t, server, func(words []string, reply func(words ...string)) {
+		switch words[0] {
+		case "/interface/monitor-traffic":
+			tag = words[len(words)-1]
+			for i := 0; i < 3; i++ {
+				reply("!re", "=name=ether1", "=rx-bits-per-second=1000", tag)
+			}
+		case "/cancel":
+			reply("!trap", "=category=2", "=message=interrupted", tag)
+			reply("!done", tag)
+			reply("!done")
+		}
+	})
+
+	c := NewClient(client, time.Second)
+	ctx, cancel := context.WithCancel(context.Background())
+	var count int
+	err := c.Listen(ctx, func(s *Sentence) {
+		count++
+		if count == 3 {
+			cancel()
+		}
+	}, "/interface/monitor-traffic", "=interface=ether1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 3 {
+		t.Fatalf("expected 3 sentences, got %d", count)
+	}
+	if _, err = c.Run("/interface/print"); err != ErrClosed {
+		t.Fatalf("expected closed client, got %v", err)
+	}
+}
@@ -1,25 +1,43 @@
 package sftpc
 
 import (
+	"errors"
 	"fmt"
 	"io"
 	"os"
 	"time"
 
+	"github.com/ca17/teamsacs/common/sshc"
 	"github.com/pkg/sftp"
 	"golang.org/x/crypto/ssh"
 )
 
 type Client struct {
-	User     string
-	Password string
-	Host     string
-	Port     int
+	User       string
+	Password   string
+	PrivateKey string
+	Host       string
+	Port       int
+
+	// HostKeyCallback Check of the server key, required (see sshc.PinHostKey)
+	HostKeyCallback ssh.HostKeyCallback
+	// KeyPassphrase Passphrase of an encrypted private key
+	KeyPassphrase string
 }
 
 // SftpSession sftp会话
 type SftpSession struct {
-	Client *sftp.Client
+	Client    *sftp.Client
+	sshClient *ssh.Client
+}
+
+// Close Close the sftp session and its ssh connection
+func (s *SftpSession) Close() error {
+	err := s.Client.Close()
+	if s.sshClient != nil {
+		s.sshClient.Close()
+	}
+	return err
 }
 
 // NewSession 创建一个新的sftp会话
@@ -32,15 +50,19 @@ func (s *Client) NewSession() (*SftpSession, error) {
 		sftpClient   *sftp.Client
 		err          error
 	)
+	if s.HostKeyCallback == nil {
+		return nil, errors.New("no host key callback")
+	}
 	// get auth method
-	auth = make([]ssh.AuthMethod, 0)
-	auth = append(auth, ssh.Password(s.Password))
+	if auth, err = sshc.AuthMethods(s.Password, s.PrivateKey, s.KeyPassphrase); err != nil {
+		return nil, err
+	}
 
 	clientConfig = &ssh.ClientConfig{
 		User:            s.User,
 		Auth:            auth,
 		Timeout:         10 * time.Second,
-		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+		HostKeyCallback: s.HostKeyCallback,
 	}
 
 	// connet to ssh
@@ -52,11 +74,13 @@ func (s *Client) NewSession() (*SftpSession, error) {
 
 	// create sftp client
 	if sftpClient, err = sftp.NewClient(sshClient); err != nil {
+		sshClient.Close()
 		return nil, err
 	}
 
 	return &SftpSession{
-		Client: sftpClient,
+		Client:    sftpClient,
+		sshClient: sshClient,
 	}, nil
 }
 
@@ -69,7 +93,7 @@ func (s *Client) Upload(session *SftpSession, localFilePath, remotePath string)
 		if err != nil {
 			return err
 		}
-		defer _session.Client.Close()
+		defer _session.Close()
 	} else {
 		_session = session
 	}
@@ -88,7 +112,7 @@ func (s *Client) Upload(session *SftpSession, localFilePath, remotePath string)
 	buf := make([]byte, 1024)
 	for {
 		n, e := srcFile.Read(buf)
-		if e != nil && e!= io.EOF {
+		if e != nil && e != io.EOF {
 			return e
 		}
 		if n == 0 {
@@ -111,7 +135,7 @@ func (s *Client) Download(session *SftpSession, remotePath, localFilePath string
 		if err != nil {
 			return err
 		}
-		defer _session.Client.Close()
+		defer _session.Close()
 	} else {
 		_session = session
 	}
@@ -143,7 +167,7 @@ func (s *Client) Remove(session *SftpSession, remotePath string) error {
 		if err != nil {
 			return err
 		}
-		defer _session.Client.Close()
+		defer _session.Close()
 	} else {
 		_session = session
 	}
@@ -156,5 +180,5 @@ func (s *Client) Remove(session *SftpSession, remotePath string) error {
 }
 
 func (s *Client) Close(session *SftpSession) error {
-	return session.Client.Close()
+	return session.Close()
 }
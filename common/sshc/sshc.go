@@ -1,22 +1,32 @@
-package sftpc
+package sshc
 
 import (
+	"bytes"
+	"errors"
 	"fmt"
+	"net"
+	"strings"
 	"time"
 
 	"golang.org/x/crypto/ssh"
 )
 
 type Client struct {
-	User     string
-	Password string
-	Host     string
-	Port     int
-	sshClient    *ssh.Client
+	User       string
+	Password   string
+	PrivateKey string // PEM private key, used before the password when set
+	Host       string
+	Port       int
+	sshClient  *ssh.Client
+
+	// HostKeyCallback Check of the server key, required (see PinHostKey)
+	HostKeyCallback ssh.HostKeyCallback
+	// KeyPassphrase Passphrase of an encrypted private key
+	KeyPassphrase string
 }
 
-func NewClient(user string, password string, host string, port int) (*Client,error) {
-	c :=  &Client{User: user, Password: password, Host: host, Port: port}
+func NewClient(user string, password string, host string, port int) (*Client, error) {
+	c := &Client{User: user, Password: password, Host: host, Port: port}
 	err := c.Connect()
 	if err != nil {
 		return nil, err
@@ -24,22 +34,69 @@ func NewClient(user string, password string, host string, port int) (*Client,err
 	return c, nil
 }
 
-func (s *Client) Connect()  error {
+// AuthMethods Private key and password authentication, the passphrase is only
+// used when the private key is encrypted
+func AuthMethods(password, privateKey, passphrase string) ([]ssh.AuthMethod, error) {
+	auth := make([]ssh.AuthMethod, 0)
+	if privateKey != "" {
+		signer, err := ssh.ParsePrivateKey([]byte(privateKey))
+		var missing *ssh.PassphraseMissingError
+		if errors.As(err, &missing) {
+			if passphrase == "" {
+				return nil, errors.New("private key is encrypted, no passphrase set")
+			}
+			signer, err = ssh.ParsePrivateKeyWithPassphrase([]byte(privateKey), []byte(passphrase))
+		}
+		if err != nil {
+			return nil, err
+		}
+		auth = append(auth, ssh.PublicKeys(signer))
+	}
+	if password != "" {
+		auth = append(auth, ssh.Password(password))
+	}
+	return auth, nil
+}
+
+// PinHostKey Accept the server key pinned in authorized_keys format, the key of
+// the first connection is passed to pin when none is pinned yet
+func PinHostKey(pinned string, pin func(key string) error) ssh.HostKeyCallback {
+	return func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+		if pinned == "" {
+			return pin(strings.TrimSpace(string(ssh.MarshalAuthorizedKey(key))))
+		}
+		want, _, _, _, err := ssh.ParseAuthorizedKey([]byte(pinned))
+		if err != nil {
+			return fmt.Errorf("invalid pinned host key: %w", err)
+		}
+		if !bytes.Equal(want.Marshal(), key.Marshal()) {
+			return fmt.Errorf("host key mismatch for %s: got %s, pinned %s",
+				hostname, ssh.FingerprintSHA256(key), ssh.FingerprintSHA256(want))
+		}
+		return nil
+	}
+}
+
+func (s *Client) Connect() error {
 	var (
 		auth         []ssh.AuthMethod
 		addr         string
 		clientConfig *ssh.ClientConfig
 		err          error
 	)
+	if s.HostKeyCallback == nil {
+		return errors.New("no host key callback")
+	}
 	// get auth method
-	auth = make([]ssh.AuthMethod, 0)
-	auth = append(auth, ssh.Password(s.Password))
+	if auth, err = AuthMethods(s.Password, s.PrivateKey, s.KeyPassphrase); err != nil {
+		return err
+	}
 
 	clientConfig = &ssh.ClientConfig{
 		User:            s.User,
 		Auth:            auth,
 		Timeout:         10 * time.Second,
-		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+		HostKeyCallback: s.HostKeyCallback,
 	}
 
 	// connet to ssh
@@ -51,7 +108,12 @@ func (s *Client) Connect()  error {
 	return nil
 }
 
-func (s *Client) Close()  error {
+// SSHClient Connected ssh client, for terminal and sftp sessions
+func (s *Client) SSHClient() *ssh.Client {
+	return s.sshClient
+}
+
+func (s *Client) Close() error {
 	return s.sshClient.Close()
 }
 
@@ -73,4 +135,3 @@ func (s *Client) ExecCommand(session *ssh.Session, cmd string) (string, error) {
 	}
 	return string(rbyte), err
 }
-
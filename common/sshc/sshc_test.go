@@ -0,0 +1,54 @@
+package sshc
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/pem"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+)
+
+func TestAuthMethods(t *testing.T) {
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	plain, err := ssh.MarshalPrivateKey(key, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	encrypted, err := ssh.MarshalPrivateKeyWithPassphrase(key, "", []byte("keypass"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	plainKey, encryptedKey := string(pem.EncodeToMemory(plain)), string(pem.EncodeToMemory(encrypted))
+	tests := []struct {
+		name       string
+		password   string
+		privateKey string
+		passphrase string
+		wantAuth   int
+		wantErr    bool
+	}{
+		{"password", "secret", "", "", 1, false},
+		{"plain key", "", plainKey, "", 1, false},
+		{"plain key and password", "secret", plainKey, "", 2, false},
+		{"plain key with a passphrase", "secret", plainKey, "keypass", 2, false},
+		{"encrypted key", "secret", encryptedKey, "keypass", 2, false},
+		{"encrypted key without passphrase", "secret", encryptedKey, "", 0, true},
+		{"encrypted key wrong passphrase", "secret", encryptedKey, "secret", 0, true},
+		{"invalid key", "", "not a key", "", 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			auth, err := AuthMethods(tt.password, tt.privateKey, tt.passphrase)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("AuthMethods() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if len(auth) != tt.wantAuth {
+				t.Errorf("AuthMethods() = %d methods, want %d", len(auth), tt.wantAuth)
+			}
+		})
+	}
+}
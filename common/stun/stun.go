@@ -0,0 +1,235 @@
+package stun
+
+// Minimal STUN (RFC 3489 / RFC 5389) Binding support for TR-111 Part 2,
+// CPEs behind NAT send Binding Requests so the ACS learns their public
+// UDP connection request address.
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/binary"
+	"errors"
+	"net"
+)
+
+const headerSize = 20
+
+// MagicCookie RFC 5389 magic cookie, RFC 3489 clients use random bytes instead
+const MagicCookie uint32 = 0x2112A442
+
+// Message types
+const (
+	BindingRequest       uint16 = 0x0001
+	BindingResponse      uint16 = 0x0101
+	BindingErrorResponse uint16 = 0x0111
+)
+
+// Attribute types
+const (
+	AttrMappedAddress    uint16 = 0x0001
+	AttrResponseAddress  uint16 = 0x0002
+	AttrUsername         uint16 = 0x0006
+	AttrMessageIntegrity uint16 = 0x0008
+	AttrErrorCode        uint16 = 0x0009
+	AttrXorMappedAddress uint16 = 0x0020
+	AttrFingerprint      uint16 = 0x8028
+	AttrSoftware         uint16 = 0x8022
+	// TR-111 attributes
+	AttrConnectionRequestBinding uint16 = 0xC001
+	AttrBindingChange            uint16 = 0xC002
+)
+
+// ConnectionRequestBinding Value of the TR-111 CONNECTION-REQUEST-BINDING attribute
+const ConnectionRequestBinding = "dslforum.org/TR-111 "
+
+var ErrInvalidMessage = errors.New("invalid stun message")
+
+type Attribute struct {
+	Type  uint16
+	Value []byte
+}
+
+type Message struct {
+	Type uint16
+	// TransactionID includes the magic cookie, 16 bytes as in RFC 3489
+	TransactionID [16]byte
+	Attributes    []Attribute
+}
+
+// IsStunMessage Quick check of the message header
+func IsStunMessage(b []byte) bool {
+	return len(b) >= headerSize && b[0]&0xC0 == 0 &&
+		int(binary.BigEndian.Uint16(b[2:4]))+headerSize == len(b)
+}
+
+func Parse(b []byte) (*Message, error) {
+	if !IsStunMessage(b) {
+		return nil, ErrInvalidMessage
+	}
+	m := &Message{Type: binary.BigEndian.Uint16(b[0:2])}
+	copy(m.TransactionID[:], b[4:headerSize])
+	body := b[headerSize:]
+	for len(body) > 0 {
+		if len(body) < 4 {
+			return nil, ErrInvalidMessage
+		}
+		atype := binary.BigEndian.Uint16(body[0:2])
+		alen := int(binary.BigEndian.Uint16(body[2:4]))
+		if len(body) < 4+alen {
+			return nil, ErrInvalidMessage
+		}
+		m.Attributes = append(m.Attributes, Attribute{Type: atype, Value: body[4 : 4+alen]})
+		plen := 4 + (alen+3)&^3
+		if plen > len(body) {
+			plen = len(body)
+		}
+		body = body[plen:]
+	}
+	return m, nil
+}
+
+// Get Value of the first attribute of the type
+func (m *Message) Get(atype uint16) ([]byte, bool) {
+	for _, a := range m.Attributes {
+		if a.Type == atype {
+			return a.Value, true
+		}
+	}
+	return nil, false
+}
+
+func (m *Message) Has(atype uint16) bool {
+	_, ok := m.Get(atype)
+	return ok
+}
+
+func (m *Message) Username() string {
+	v, _ := m.Get(AttrUsername)
+	return string(v)
+}
+
+// IsConnectionRequestBinding The request carries the TR-111 CONNECTION-REQUEST-BINDING attribute,
+// requests without it are only used by the CPE to discover the binding lifetime
+func (m *Message) IsConnectionRequestBinding() bool {
+	v, ok := m.Get(AttrConnectionRequestBinding)
+	return ok && string(v) == ConnectionRequestBinding
+}
+
+func (m *Message) Add(atype uint16, value []byte) {
+	m.Attributes = append(m.Attributes, Attribute{Type: atype, Value: value})
+}
+
+func (m *Message) Encode() []byte {
+	size := headerSize
+	for _, a := range m.Attributes {
+		size += 4 + (len(a.Value)+3)&^3
+	}
+	b := make([]byte, size)
+	binary.BigEndian.PutUint16(b[0:2], m.Type)
+	binary.BigEndian.PutUint16(b[2:4], uint16(size-headerSize))
+	copy(b[4:headerSize], m.TransactionID[:])
+	pos := headerSize
+	for _, a := range m.Attributes {
+		binary.BigEndian.PutUint16(b[pos:pos+2], a.Type)
+		binary.BigEndian.PutUint16(b[pos+2:pos+4], uint16(len(a.Value)))
+		copy(b[pos+4:], a.Value)
+		pos += 4 + (len(a.Value)+3)&^3
+	}
+	return b
+}
+
+// isRFC5389 The transaction ID starts with the magic cookie
+func (m *Message) isRFC5389() bool {
+	return binary.BigEndian.Uint32(m.TransactionID[0:4]) == MagicCookie
+}
+
+func encodeAddress(addr *net.UDPAddr, xor bool, tid [16]byte) []byte {
+	ip := addr.IP.To4()
+	family := byte(0x01)
+	if ip == nil {
+		ip = addr.IP.To16()
+		family = 0x02
+	}
+	v := make([]byte, 4+len(ip))
+	v[1] = family
+	port := uint16(addr.Port)
+	if xor {
+		port ^= uint16(MagicCookie >> 16)
+	}
+	binary.BigEndian.PutUint16(v[2:4], port)
+	copy(v[4:], ip)
+	if xor {
+		// IPv4 is xored with the cookie, IPv6 with cookie + transaction ID
+		for i := range ip {
+			v[4+i] ^= tid[i]
+		}
+	}
+	return v
+}
+
+// DecodeAddress Decode a MAPPED-ADDRESS or XOR-MAPPED-ADDRESS attribute value
+func DecodeAddress(v []byte, xor bool, tid [16]byte) (*net.UDPAddr, error) {
+	if len(v) != 8 && len(v) != 20 {
+		return nil, ErrInvalidMessage
+	}
+	port := binary.BigEndian.Uint16(v[2:4])
+	ip := make(net.IP, len(v)-4)
+	copy(ip, v[4:])
+	if xor {
+		port ^= uint16(MagicCookie >> 16)
+		for i := range ip {
+			ip[i] ^= tid[i]
+		}
+	}
+	return &net.UDPAddr{IP: ip, Port: int(port)}, nil
+}
+
+// NewBindingResponse Binding Response telling the client its public address,
+// XOR-MAPPED-ADDRESS is only added for RFC 5389 clients
+func NewBindingResponse(req *Message, addr *net.UDPAddr) *Message {
+	resp := &Message{Type: BindingResponse, TransactionID: req.TransactionID}
+	resp.Add(AttrMappedAddress, encodeAddress(addr, false, req.TransactionID))
+	if req.isRFC5389() {
+		resp.Add(AttrXorMappedAddress, encodeAddress(addr, true, req.TransactionID))
+	}
+	return resp
+}
+
+// CheckIntegrity Verify the MESSAGE-INTEGRITY attribute of a raw message with the
+// short-term credential key. The HMAC covers the message up to the attribute with the
+// header length ending after it (RFC 5389), RFC 3489 clients also pad it to 64 bytes.
+func CheckIntegrity(b []byte, key []byte) bool {
+	if !IsStunMessage(b) {
+		return false
+	}
+	pos := headerSize
+	for pos+4 <= len(b) {
+		atype := binary.BigEndian.Uint16(b[pos : pos+2])
+		alen := int(binary.BigEndian.Uint16(b[pos+2 : pos+4]))
+		if atype != AttrMessageIntegrity {
+			pos += 4 + (alen+3)&^3
+			continue
+		}
+		if alen != sha1.Size || pos+4+alen > len(b) {
+			return false
+		}
+		text := make([]byte, pos)
+		copy(text, b[:pos])
+		binary.BigEndian.PutUint16(text[2:4], uint16(pos+4+alen-headerSize))
+		sum := b[pos+4 : pos+4+alen]
+		if hmac.Equal(integrity(text, key), sum) {
+			return true
+		}
+		if len(text)%64 != 0 {
+			text = append(text, make([]byte, 64-len(text)%64)...)
+		}
+		return hmac.Equal(integrity(text, key), sum)
+	}
+	return false
+}
+
+func integrity(text, key []byte) []byte {
+	mac := hmac.New(sha1.New, key)
+	mac.Write(text)
+	return mac.Sum(nil)
+}
@@ -0,0 +1,118 @@
+package stun
+
+import (
+	"encoding/hex"
+	"net"
+	"strings"
+	"testing"
+)
+
+// RFC 5769 test vectors
+var (
+	sampleRequest = `
+		000100582112a442b7e7a701bc34d686fa87dfae
+		802200105354554e207465737420636c69656e74
+		002400046e0001ff80290008932ff9b151263b36
+		000600096576746a3a6836765920202000080014
+		9aeaa70cbfd8cb56781ef2b5b2d3f249c1b571a2
+		80280004e57a3bcf`
+	sampleIPv4Response = `
+		0101003c2112a442b7e7a701bc34d686fa87dfae
+		8022000b7465737420766563746f722000200008
+		0001a147e112a643000800142b91f599fd9e90c3
+		8c7489f92af9ba53f06be7d780280004c07d4c96`
+	sampleIPv6Response = `
+		010100482112a442b7e7a701bc34d686fa87dfae
+		8022000b7465737420766563746f722000200014
+		0002a1470113a9faa5d3f179bc25f4b5bed2b9d9
+		00080014a382954e4be67bf11784c97c8292c275
+		bfe3ed4180280004c8fb0b4c`
+	samplePassword = "VOkJxbRl1RmTxUk/WvJxBt"
+)
+
+func decodeVector(t *testing.T, v string) []byte {
+	b, err := hex.DecodeString(strings.Join(strings.Fields(v), ""))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func TestSampleRequest(t *testing.T) {
+	data := decodeVector(t, sampleRequest)
+	msg, err := Parse(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg.Type != BindingRequest || !msg.isRFC5389() {
+		t.Fatalf("header mismatch %+v", msg)
+	}
+	if msg.Username() != "evtj:h6vY" {
+		t.Errorf("Username = %q", msg.Username())
+	}
+	if !CheckIntegrity(data, []byte(samplePassword)) {
+		t.Error("MESSAGE-INTEGRITY not verified")
+	}
+	if CheckIntegrity(data, []byte("wrong")) {
+		t.Error("MESSAGE-INTEGRITY verified with a wrong password")
+	}
+	tampered := append([]byte(nil), data...)
+	tampered[30] ^= 0x01
+	if CheckIntegrity(tampered, []byte(samplePassword)) {
+		t.Error("MESSAGE-INTEGRITY verified on a modified message")
+	}
+	if msg.IsConnectionRequestBinding() {
+		t.Error("unexpected TR-111 binding attribute")
+	}
+}
+
+func TestSampleResponses(t *testing.T) {
+	tests := []struct {
+		name   string
+		vector string
+		addr   *net.UDPAddr
+	}{
+		{"ipv4", sampleIPv4Response, &net.UDPAddr{IP: net.ParseIP("192.0.2.1").To4(), Port: 32853}},
+		{"ipv6", sampleIPv6Response, &net.UDPAddr{IP: net.ParseIP("2001:db8:1234:5678:11:2233:4455:6677"), Port: 32853}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data := decodeVector(t, tt.vector)
+			msg, err := Parse(data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if msg.Type != BindingResponse {
+				t.Fatalf("Type = %x", msg.Type)
+			}
+			if !CheckIntegrity(data, []byte(samplePassword)) {
+				t.Error("MESSAGE-INTEGRITY not verified")
+			}
+			v, ok := msg.Get(AttrXorMappedAddress)
+			if !ok {
+				t.Fatal("XOR-MAPPED-ADDRESS missing")
+			}
+			got, err := DecodeAddress(v, true, msg.TransactionID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !got.IP.Equal(tt.addr.IP) || got.Port != tt.addr.Port {
+				t.Errorf("XOR-MAPPED-ADDRESS = %s", got)
+			}
+
+			// our response to the sample request encodes the same address
+			req, _ := Parse(decodeVector(t, sampleRequest))
+			resp := NewBindingResponse(req, tt.addr)
+			xv, _ := resp.Get(AttrXorMappedAddress)
+			if hex.EncodeToString(xv) != hex.EncodeToString(v) {
+				t.Errorf("XOR-MAPPED-ADDRESS encoded %x, want %x", xv, v)
+			}
+		})
+	}
+}
+
+func TestParseInvalid(t *testing.T) {
+	if _, err := Parse([]byte("GET / HTTP/1.1\r\n\r\n")); err == nil {
+		t.Error("expected error")
+	}
+}
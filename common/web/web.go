@@ -21,6 +21,7 @@ import (
 	"encoding/csv"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"io"
 	"log"
 	"net/url"
@@ -406,6 +407,20 @@ func CreateToken(secret, uid, level string, exp time.Duration) (string, error) {
 	return token.SignedString([]byte(secret))
 }
 
+// ParseToken Verify a token created by CreateToken and return its claims
+func ParseToken(secret, tokenstr string) (jwt.MapClaims, error) {
+	token, err := jwt.Parse(tokenstr, func(t *jwt.Token) (interface{}, error) {
+		if t.Method.Alg() != jwt.SigningMethodHS256.Alg() {
+			return nil, fmt.Errorf("unexpected jwt signing method=%v", t.Header["alg"])
+		}
+		return []byte(secret), nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return token.Claims.(jwt.MapClaims), nil
+}
+
 func QueryPageResult[T any](c echo.Context, tx *gorm.DB, prequery *PreQuery) (*PageResult, error) {
 	var count, start int
 	NewParamReader(c).
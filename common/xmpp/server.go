@@ -0,0 +1,468 @@
+package xmpp
+
+// Minimal embedded XMPP server (RFC 6120) for TR-069 Annex K connection requests,
+// CPEs keep a client connection open and the ACS sends connectionRequest IQs over it.
+// Only STARTTLS, SASL PLAIN, resource binding, presence and IQ routing to the ACS are supported.
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	nsStream    = "http://etherx.jabber.org/streams"
+	nsClient    = "jabber:client"
+	nsTLS       = "urn:ietf:params:xml:ns:xmpp-tls"
+	nsSASL      = "urn:ietf:params:xml:ns:xmpp-sasl"
+	nsBind      = "urn:ietf:params:xml:ns:xmpp-bind"
+	nsSession   = "urn:ietf:params:xml:ns:xmpp-session"
+	nsStanza    = "urn:ietf:params:xml:ns:xmpp-stanzas"
+	NsConnReq   = "urn:broadband-forum-org:cwmp:xmppConnReq-1-0"
+	AcsNode     = "acs"
+	acsResource = "teamsacs"
+)
+
+const (
+	// authTimeout Time given to a client to open the stream and authenticate
+	authTimeout = 30 * time.Second
+	// maxAuthStanzaSize Size limit of the stanzas read before authentication
+	maxAuthStanzaSize = 4 << 10
+	// maxStanzaSize Size limit of the stanzas of authenticated clients
+	maxStanzaSize = 64 << 10
+)
+
+var (
+	ErrNotOnline      = errors.New("xmpp client is not online")
+	ErrStanzaTooLarge = errors.New("xmpp stanza too large")
+	errHostUnknown    = errors.New("xmpp stream to an unknown host")
+)
+
+// Element generic XML stanza
+type Element struct {
+	XMLName  xml.Name
+	Attrs    []xml.Attr `xml:",any,attr"`
+	Children []Element  `xml:",any"`
+	Text     string     `xml:",chardata"`
+}
+
+func (e *Element) Attr(name string) string {
+	for _, a := range e.Attrs {
+		if a.Name.Local == name {
+			return a.Value
+		}
+	}
+	return ""
+}
+
+func (e *Element) Child(local string) *Element {
+	for i := range e.Children {
+		if e.Children[i].XMLName.Local == local {
+			return &e.Children[i]
+		}
+	}
+	return nil
+}
+
+// Bare JID without resource
+func Bare(jid string) string {
+	if i := strings.Index(jid, "/"); i >= 0 {
+		return jid[:i]
+	}
+	return jid
+}
+
+// Node Local part of a JID
+func Node(jid string) string {
+	if i := strings.Index(jid, "@"); i >= 0 {
+		return jid[:i]
+	}
+	return ""
+}
+
+type Server struct {
+	// Domain of the server, the JIDs of the clients are in this domain
+	Domain string
+	// TLSConfig enables STARTTLS, it is then required before authentication
+	TLSConfig *tls.Config
+	// Auth checks the SASL PLAIN credentials of a CPE
+	Auth func(username, password string) bool
+	// OnOnline is called with the full JID after resource binding
+	OnOnline func(jid string)
+	// OnOffline is called with the full JID when the stream is closed
+	OnOffline func(jid string)
+
+	mu       sync.Mutex
+	sessions map[string]*session // bare JID
+	seq      atomic.Int64
+
+	listener net.Listener
+	conns    map[net.Conn]struct{}
+	closed   bool
+}
+
+type session struct {
+	server  *Server
+	conn    net.Conn
+	reader  *stanzaReader
+	wmu     sync.Mutex
+	jid     string
+	pending sync.Map // iq id -> chan *Element
+}
+
+// stanzaReader Limits the bytes read for one stanza, the decoder reads it byte by byte
+type stanzaReader struct {
+	r   *bufio.Reader
+	n   int
+	max int
+}
+
+func newStanzaReader(conn net.Conn, max int) *stanzaReader {
+	return &stanzaReader{r: bufio.NewReader(conn), max: max}
+}
+
+func (l *stanzaReader) ReadByte() (byte, error) {
+	if l.n >= l.max {
+		return 0, ErrStanzaTooLarge
+	}
+	l.n++
+	return l.r.ReadByte()
+}
+
+func (l *stanzaReader) Read(p []byte) (int, error) {
+	if l.n >= l.max {
+		return 0, ErrStanzaTooLarge
+	}
+	if len(p) > l.max-l.n {
+		p = p[:l.max-l.n]
+	}
+	n, err := l.r.Read(p)
+	l.n += n
+	return n, err
+}
+
+// next Start counting the next stanza
+func (l *stanzaReader) next() {
+	l.n = 0
+}
+
+// Serve Accept the client connections until Close
+func (s *Server) Serve(l net.Listener) error {
+	s.mu.Lock()
+	if s.sessions == nil {
+		s.sessions = make(map[string]*session)
+	}
+	s.conns = make(map[net.Conn]struct{})
+	s.listener = l
+	closed := s.closed
+	s.mu.Unlock()
+	if closed {
+		return l.Close()
+	}
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			s.mu.Lock()
+			closed := s.closed
+			s.mu.Unlock()
+			if closed {
+				return nil
+			}
+			return err
+		}
+		s.mu.Lock()
+		if s.closed {
+			s.mu.Unlock()
+			conn.Close()
+			return nil
+		}
+		s.conns[conn] = struct{}{}
+		s.mu.Unlock()
+		go s.handle(conn)
+	}
+}
+
+// Close Stop accepting clients and close the client connections
+func (s *Server) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.closed = true
+	var err error
+	if s.listener != nil {
+		err = s.listener.Close()
+	}
+	for conn := range s.conns {
+		conn.Close()
+	}
+	return err
+}
+
+// Online Full JID of the online client for a bare or full JID
+func (s *Server) Online(jid string) (string, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sess, ok := s.sessions[Bare(jid)]
+	if !ok {
+		return "", false
+	}
+	return sess.jid, true
+}
+
+// ConnectionRequest Send a TR-069 Annex K connectionRequest IQ and wait for the result
+func (s *Server) ConnectionRequest(jid, username, password string, timeout time.Duration) error {
+	s.mu.Lock()
+	sess, ok := s.sessions[Bare(jid)]
+	s.mu.Unlock()
+	if !ok {
+		return ErrNotOnline
+	}
+	id := fmt.Sprintf("cr%d", s.seq.Add(1))
+	ch := make(chan *Element, 1)
+	sess.pending.Store(id, ch)
+	defer sess.pending.Delete(id)
+
+	if err := sess.write(ConnectionRequestIQ(AcsNode+"@"+s.Domain+"/"+acsResource, sess.jid, id, username, password)); err != nil {
+		return err
+	}
+	select {
+	case resp := <-ch:
+		if resp.Attr("type") == "result" {
+			return nil
+		}
+		if e := resp.Child("error"); e != nil && len(e.Children) > 0 {
+			return fmt.Errorf("xmpp connection request rejected: %s", e.Children[0].XMLName.Local)
+		}
+		return errors.New("xmpp connection request rejected")
+	case <-time.After(timeout):
+		return errors.New("xmpp connection request timeout")
+	}
+}
+
+// ConnectionRequestIQ TR-069 Annex K connectionRequest IQ stanza
+func ConnectionRequestIQ(from, to, id, username, password string) []byte {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, `<iq from="%s" to="%s" id="%s" type="get"><connectionRequest xmlns="%s"><username>`,
+		escape(from), escape(to), escape(id), NsConnReq)
+	_ = xml.EscapeText(&b, []byte(username))
+	b.WriteString("</username><password>")
+	_ = xml.EscapeText(&b, []byte(password))
+	b.WriteString("</password></connectionRequest></iq>")
+	return b.Bytes()
+}
+
+func escape(s string) string {
+	var b bytes.Buffer
+	_ = xml.EscapeText(&b, []byte(s))
+	return b.String()
+}
+
+func (s *Server) handle(conn net.Conn) {
+	sess := &session{server: s, conn: conn}
+	defer func() {
+		sess.conn.Close()
+		s.mu.Lock()
+		delete(s.conns, conn)
+		s.mu.Unlock()
+		if sess.jid == "" {
+			return
+		}
+		s.mu.Lock()
+		current := s.sessions[Bare(sess.jid)] == sess
+		if current {
+			delete(s.sessions, Bare(sess.jid))
+		}
+		s.mu.Unlock()
+		if current && s.OnOffline != nil {
+			s.OnOffline(sess.jid)
+		}
+	}()
+	_ = sess.serve()
+}
+
+func (sess *session) write(data []byte) error {
+	sess.wmu.Lock()
+	defer sess.wmu.Unlock()
+	_ = sess.conn.SetWriteDeadline(time.Now().Add(30 * time.Second))
+	_, err := sess.conn.Write(data)
+	return err
+}
+
+func (sess *session) writef(format string, args ...interface{}) error {
+	return sess.write([]byte(fmt.Sprintf(format, args...)))
+}
+
+// openStream Wait for the client stream header and answer with ours and the stream features
+func (sess *session) openStream(dec *xml.Decoder, tlsDone, authed bool) error {
+	domain := sess.server.Domain
+	for {
+		tok, err := dec.Token()
+		if err != nil {
+			return err
+		}
+		if se, ok := tok.(xml.StartElement); ok {
+			if se.Name.Local != "stream" || se.Name.Space != nsStream {
+				return fmt.Errorf("unexpected element %s", se.Name.Local)
+			}
+			for _, a := range se.Attr {
+				if a.Name.Local == "to" && !strings.EqualFold(a.Value, domain) {
+					_ = sess.writef(`<?xml version="1.0"?><stream:stream xmlns="%s" xmlns:stream="%s" from="%s" version="1.0">`+
+						`<stream:error><host-unknown xmlns="urn:ietf:params:xml:ns:xmpp-streams"/></stream:error></stream:stream>`,
+						nsClient, nsStream, escape(domain))
+					return errHostUnknown
+				}
+			}
+			break
+		}
+	}
+	sess.reader.next()
+	var features string
+	switch {
+	case !tlsDone && sess.server.TLSConfig != nil:
+		features = `<starttls xmlns="` + nsTLS + `"><required/></starttls>`
+	case !authed:
+		features = `<mechanisms xmlns="` + nsSASL + `"><mechanism>PLAIN</mechanism></mechanisms>`
+	default:
+		features = `<bind xmlns="` + nsBind + `"/><session xmlns="` + nsSession + `"><optional/></session>`
+	}
+	return sess.writef(`<?xml version="1.0"?><stream:stream xmlns="%s" xmlns:stream="%s" id="%d" from="%s" version="1.0">`+
+		`<stream:features>%s</stream:features>`, nsClient, nsStream, sess.server.seq.Add(1), escape(domain), features)
+}
+
+func (sess *session) serve() error {
+	var tlsDone, authed bool
+	// unauthenticated clients get a deadline and small stanzas
+	_ = sess.conn.SetReadDeadline(time.Now().Add(authTimeout))
+	sess.reader = newStanzaReader(sess.conn, maxAuthStanzaSize)
+	dec := xml.NewDecoder(sess.reader)
+	if err := sess.openStream(dec, tlsDone, authed); err != nil {
+		return err
+	}
+	for {
+		tok, err := dec.Token()
+		if err != nil {
+			return err
+		}
+		if _, ok := tok.(xml.EndElement); ok {
+			// </stream:stream>
+			_ = sess.write([]byte("</stream:stream>"))
+			return nil
+		}
+		se, ok := tok.(xml.StartElement)
+		if !ok {
+			continue
+		}
+		var el Element
+		if err = dec.DecodeElement(&el, &se); err != nil {
+			return err
+		}
+		sess.reader.next()
+		switch {
+		case el.XMLName.Local == "starttls" && !tlsDone && sess.server.TLSConfig != nil:
+			if err = sess.writef(`<proceed xmlns="%s"/>`, nsTLS); err != nil {
+				return err
+			}
+			tconn := tls.Server(sess.conn, sess.server.TLSConfig)
+			if err = tconn.Handshake(); err != nil {
+				return err
+			}
+			sess.conn = tconn
+			tlsDone = true
+			sess.reader = newStanzaReader(tconn, maxAuthStanzaSize)
+			dec = xml.NewDecoder(sess.reader)
+			if err = sess.openStream(dec, tlsDone, authed); err != nil {
+				return err
+			}
+		case el.XMLName.Local == "auth" && !authed && (tlsDone || sess.server.TLSConfig == nil):
+			user, ok := sess.authenticate(&el)
+			if !ok {
+				_ = sess.writef(`<failure xmlns="%s"><not-authorized/></failure></stream:stream>`, nsSASL)
+				return errors.New("xmpp authentication failed")
+			}
+			if err = sess.writef(`<success xmlns="%s"/>`, nsSASL); err != nil {
+				return err
+			}
+			authed = true
+			sess.jid = user + "@" + sess.server.Domain
+			// CPEs keep the stream open without traffic, the stanza size stays limited
+			_ = sess.conn.SetReadDeadline(time.Time{})
+			sess.reader.max = maxStanzaSize
+			dec = xml.NewDecoder(sess.reader)
+			if err = sess.openStream(dec, tlsDone, authed); err != nil {
+				return err
+			}
+		case el.XMLName.Local == "iq" && authed:
+			if err = sess.handleIQ(&el); err != nil {
+				return err
+			}
+		case el.XMLName.Local == "presence" && authed:
+			// presence is not routed, the ACS is the only other party
+		default:
+			_ = sess.write([]byte(`<stream:error><not-authorized xmlns="urn:ietf:params:xml:ns:xmpp-streams"/></stream:error></stream:stream>`))
+			return fmt.Errorf("unexpected element %s", el.XMLName.Local)
+		}
+	}
+}
+
+// authenticate SASL PLAIN: [authzid] NUL authcid NUL passwd
+func (sess *session) authenticate(el *Element) (string, bool) {
+	if el.Attr("mechanism") != "PLAIN" || sess.server.Auth == nil {
+		return "", false
+	}
+	data, err := base64.StdEncoding.DecodeString(strings.TrimSpace(el.Text))
+	if err != nil {
+		return "", false
+	}
+	parts := strings.Split(string(data), "\x00")
+	if len(parts) != 3 || parts[1] == "" {
+		return "", false
+	}
+	if !sess.server.Auth(parts[1], parts[2]) {
+		return "", false
+	}
+	return parts[1], true
+}
+
+func (sess *session) handleIQ(el *Element) error {
+	id, typ := el.Attr("id"), el.Attr("type")
+	switch typ {
+	case "result", "error":
+		if ch, ok := sess.pending.Load(id); ok {
+			ch.(chan *Element) <- el
+		}
+		return nil
+	}
+	switch {
+	case el.Child("bind") != nil && typ == "set" && !strings.Contains(sess.jid, "/"):
+		resource := "cwmp"
+		if r := el.Child("bind").Child("resource"); r != nil && strings.TrimSpace(r.Text) != "" {
+			resource = strings.TrimSpace(r.Text)
+		}
+		sess.jid = sess.jid + "/" + resource
+		s := sess.server
+		s.mu.Lock()
+		if old, ok := s.sessions[Bare(sess.jid)]; ok && old != sess {
+			// a new login of the same CPE replaces the stale stream
+			old.conn.Close()
+		}
+		s.sessions[Bare(sess.jid)] = sess
+		s.mu.Unlock()
+		if s.OnOnline != nil {
+			go s.OnOnline(sess.jid)
+		}
+		return sess.writef(`<iq type="result" id="%s"><bind xmlns="%s"><jid>%s</jid></bind></iq>`,
+			escape(id), nsBind, escape(sess.jid))
+	case el.Child("session") != nil, el.Child("ping") != nil:
+		return sess.writef(`<iq type="result" id="%s"/>`, escape(id))
+	}
+	return sess.writef(`<iq type="error" id="%s"><error type="cancel"><service-unavailable xmlns="%s"/></error></iq>`,
+		escape(id), nsStanza)
+}
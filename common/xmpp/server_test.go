@@ -0,0 +1,138 @@
+package xmpp
+
+import (
+	"encoding/base64"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// readElement Next top level element sent by the server, the stream header is skipped
+func readElement(t *testing.T, dec *xml.Decoder) *Element {
+	for {
+		tok, err := dec.Token()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if se, ok := tok.(xml.StartElement); ok && se.Name.Local != "stream" {
+			var el Element
+			if err = dec.DecodeElement(&el, &se); err != nil {
+				t.Fatal(err)
+			}
+			return &el
+		}
+	}
+}
+
+func TestConnectionRequest(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	online := make(chan string, 1)
+	s := &Server{
+		Domain:   "acs.example.com",
+		Auth:     func(username, password string) bool { return username == "CPE0001" && password == "secret" },
+		OnOnline: func(jid string) { online <- jid },
+	}
+	go s.Serve(l)
+
+	conn, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	header := `<stream:stream to="acs.example.com" xmlns="jabber:client" xmlns:stream="http://etherx.jabber.org/streams" version="1.0">`
+	dec := xml.NewDecoder(conn)
+
+	fmt.Fprint(conn, header)
+	if el := readElement(t, dec); el.Child("mechanisms") == nil {
+		t.Fatalf("expected sasl features, got %+v", el)
+	}
+	fmt.Fprintf(conn, `<auth xmlns="urn:ietf:params:xml:ns:xmpp-sasl" mechanism="PLAIN">%s</auth>`,
+		base64.StdEncoding.EncodeToString([]byte("\x00CPE0001\x00secret")))
+	if el := readElement(t, dec); el.XMLName.Local != "success" {
+		t.Fatalf("expected success, got %s", el.XMLName.Local)
+	}
+
+	dec = xml.NewDecoder(conn)
+	fmt.Fprint(conn, header)
+	if el := readElement(t, dec); el.Child("bind") == nil {
+		t.Fatalf("expected bind feature, got %+v", el)
+	}
+	fmt.Fprint(conn, `<iq type="set" id="b1"><bind xmlns="urn:ietf:params:xml:ns:xmpp-bind"><resource>cwmp</resource></bind></iq>`)
+	el := readElement(t, dec)
+	if jid := el.Child("bind").Child("jid").Text; jid != "CPE0001@acs.example.com/cwmp" {
+		t.Fatalf("bound jid %s", jid)
+	}
+	select {
+	case <-online:
+	case <-time.After(time.Second):
+		t.Fatal("OnOnline not called")
+	}
+	if jid, ok := s.Online("CPE0001@acs.example.com"); !ok || jid != "CPE0001@acs.example.com/cwmp" {
+		t.Fatalf("Online = %s %v", jid, ok)
+	}
+
+	result := make(chan error, 1)
+	go func() { result <- s.ConnectionRequest("CPE0001@acs.example.com", "CPE0001", "crpass", 2*time.Second) }()
+	req := readElement(t, dec)
+	cr := req.Child("connectionRequest")
+	if cr == nil || cr.XMLName.Space != NsConnReq || cr.Child("password").Text != "crpass" {
+		t.Fatalf("unexpected connection request %+v", req)
+	}
+	fmt.Fprintf(conn, `<iq type="result" id="%s" to="%s"/>`, req.Attr("id"), req.Attr("from"))
+	if err = <-result; err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestAuthFailure(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	s := &Server{Domain: "acs", Auth: func(username, password string) bool { return false }}
+	go s.handle(server)
+	go fmt.Fprintf(client, `<stream:stream to="acs" xmlns="jabber:client" xmlns:stream="http://etherx.jabber.org/streams" version="1.0">`+
+		`<auth xmlns="urn:ietf:params:xml:ns:xmpp-sasl" mechanism="PLAIN">%s</auth>`,
+		base64.StdEncoding.EncodeToString([]byte("\x00CPE0001\x00wrong")))
+	dec := xml.NewDecoder(client)
+	readElement(t, dec) // features
+	if el := readElement(t, dec); el.XMLName.Local != "failure" {
+		t.Fatalf("expected failure, got %s", el.XMLName.Local)
+	}
+}
+
+func TestUnauthenticatedLimits(t *testing.T) {
+	tests := []struct {
+		name   string
+		stream string
+	}{
+		{"unknown host", `<stream:stream to="other.example.com" xmlns="jabber:client" xmlns:stream="http://etherx.jabber.org/streams" version="1.0">`},
+		{"large stanza", `<stream:stream to="acs" xmlns="jabber:client" xmlns:stream="http://etherx.jabber.org/streams" version="1.0">` +
+			`<auth xmlns="urn:ietf:params:xml:ns:xmpp-sasl" mechanism="PLAIN">` + strings.Repeat("A", maxAuthStanzaSize)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client, server := net.Pipe()
+			defer client.Close()
+			s := &Server{Domain: "acs", Auth: func(username, password string) bool { return true }}
+			done := make(chan struct{})
+			go func() {
+				s.handle(server)
+				close(done)
+			}()
+			go fmt.Fprint(client, tt.stream)
+			go io.Copy(io.Discard, client)
+			select {
+			case <-done:
+			case <-time.After(2 * time.Second):
+				t.Fatal("connection not closed")
+			}
+		})
+	}
+}
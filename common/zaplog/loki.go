@@ -151,43 +151,45 @@ func (c *LokiClient) Error(msg string) {
 	c.logAppend("error", msg)
 }
 
-func (c *LokiClient) Start() {
-	timeC := time.NewTicker(time.Millisecond * 1000)
-
-	processLog := func() {
-		defer func() {
-			if err := recover(); err != nil {
-				slog.Println(err)
-			}
-		}()
-		queue := make([]string, 0)
-		for {
-			clog, err := readWithTimeout(c.logChl)
-			if err == readLogtimeout {
-				break
-			}
-			if clog == "" {
-				continue
-			}
-			queue = append(queue, clog)
+// flush Push the queued logs to loki
+func (c *LokiClient) flush() {
+	defer func() {
+		if err := recover(); err != nil {
+			slog.Println(err)
 		}
-
-		if len(queue) > 0 {
-			_ = c.push(Labels{"job": c.Job}, queue...)
+	}()
+	queue := make([]string, 0)
+	for {
+		clog, err := readWithTimeout(c.logChl)
+		if err == readLogtimeout {
+			break
 		}
+		if clog == "" {
+			continue
+		}
+		queue = append(queue, clog)
+	}
+
+	if len(queue) > 0 {
+		_ = c.push(Labels{"job": c.Job}, queue...)
 	}
+}
 
+func (c *LokiClient) Start() {
+	timeC := time.NewTicker(time.Millisecond * 1000)
+	defer timeC.Stop()
 	for {
 		select {
 		case <-c.stopProcess:
 			return
 		case <-timeC.C:
-			go processLog()
+			go c.flush()
 		}
 	}
 }
 
+// Stop Stop the background push and flush the logs still queued
 func (c *LokiClient) Stop() {
 	close(c.stopProcess)
-	close(c.logChl)
+	c.flush()
 }
@@ -86,62 +86,66 @@ func (c *metricsWriter) Start() {
 		return
 	}
 	timeC := time.NewTicker(time.Millisecond * 5000)
-
-	processLog := func() {
-		defer func() {
-			if err := recover(); err != nil {
-				slog.Println(err)
-			}
-		}()
-
-		metrics := make(map[string]int64, 0)
-		for {
-			mitem, err := c.readWithTimeout(c.logChl)
-			if err == readLogtimeout {
-				break
-			}
-			if mitem == emptyMetricsItem {
-				continue
-			}
-			if _, ok := metrics[mitem.Metrics]; ok {
-				metrics[mitem.Metrics] += 1
-			} else {
-				metrics[mitem.Metrics] = 1
-			}
+	defer timeC.Stop()
+	for {
+		select {
+		case <-c.stopProcess:
+			return
+		case <-timeC.C:
+			go c.flush()
 		}
+	}
+}
 
-		var errcount int
-		for k, v := range metrics {
-			if err := c.tsdb.InsertRows([]tstorage.Row{
-				{
-					Metric: k,
-					DataPoint: tstorage.DataPoint{
-						Value:     float64(v),
-						Timestamp: time.Now().Unix(),
-					},
-				},
-			}); err != nil {
-				errcount++
-			}
+// flush Write the queued metrics to the tsdb
+func (c *metricsWriter) flush() {
+	if c.tsdb == nil {
+		return
+	}
+	defer func() {
+		if err := recover(); err != nil {
+			slog.Println(err)
 		}
+	}()
 
-		if errcount > 0 {
-			slog.Println("add timeseries data error total", errcount)
+	metrics := make(map[string]int64, 0)
+	for {
+		mitem, err := c.readWithTimeout(c.logChl)
+		if err == readLogtimeout {
+			break
+		}
+		if mitem == emptyMetricsItem {
+			continue
+		}
+		if _, ok := metrics[mitem.Metrics]; ok {
+			metrics[mitem.Metrics] += 1
+		} else {
+			metrics[mitem.Metrics] = 1
 		}
-
 	}
 
-	for {
-		select {
-		case <-c.stopProcess:
-			return
-		case <-timeC.C:
-			go processLog()
+	var errcount int
+	for k, v := range metrics {
+		if err := c.tsdb.InsertRows([]tstorage.Row{
+			{
+				Metric: k,
+				DataPoint: tstorage.DataPoint{
+					Value:     float64(v),
+					Timestamp: time.Now().Unix(),
+				},
+			},
+		}); err != nil {
+			errcount++
 		}
 	}
+
+	if errcount > 0 {
+		slog.Println("add timeseries data error total", errcount)
+	}
 }
 
+// Stop Stop the background writes and flush the metrics still queued
 func (c *metricsWriter) Stop() {
 	close(c.stopProcess)
-	close(c.logChl)
+	c.flush()
 }
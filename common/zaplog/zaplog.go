@@ -89,10 +89,17 @@ func TSDB() tstorage.Storage {
 // 	return _logger
 // }
 
+// Release Flush the log writers, must be called before the process exits
 func (l *Logger) Release() {
-	logger.lokiWriter.client.Stop()
-	if logger.metricsWriter.tsdb != nil {
-		_ = logger.metricsWriter.tsdb.Close()
+	_ = zap.L().Sync()
+	if l.lokiWriter != nil {
+		l.lokiWriter.client.Stop()
+	}
+	if l.metricsWriter != nil {
+		l.metricsWriter.Stop()
+		if l.metricsWriter.tsdb != nil {
+			_ = l.metricsWriter.tsdb.Close()
+		}
 	}
 }
 
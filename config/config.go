@@ -28,6 +28,9 @@ type SysConfig struct {
 	Location string `yaml:"location"`
 	Workdir  string `yaml:"workdir"`
 	Debug    bool   `yaml:"debug"`
+	// NodeId ID of the instance in the generated IDs, 1 to 1023 and unique
+	// per instance, 0 takes a free one with a database advisory lock
+	NodeId int `yaml:"node_id"`
 }
 
 // WebConfig WEB Configuration
@@ -45,6 +48,24 @@ type Tr069Config struct {
 	Tls    bool   `yaml:"tls" json:"tls"`
 	Secret string `yaml:"secret" json:"secret"`
 	Debug  bool   `yaml:"debug" json:"debug"`
+	// TR-111 STUN server UDP port, 0 disables it
+	StunPort int `yaml:"stun_port" json:"stun_port"`
+	// TR-069 Annex K XMPP server TCP port (5222), 0 disables it
+	XmppPort int `yaml:"xmpp_port" json:"xmpp_port"`
+	// Inform processing workers and queue size
+	InformWorkers int `yaml:"inform_workers" json:"inform_workers"`
+	InformQueue   int `yaml:"inform_queue" json:"inform_queue"`
+	// InformOverload Policy when the inform queue is full: reject answers 503
+	// with Retry-After, wait holds the request until the queue has room
+	InformOverload   string `yaml:"inform_overload" json:"inform_overload"`
+	InformRetryAfter int    `yaml:"inform_retry_after" json:"inform_retry_after"`
+}
+
+// RadiusConfig Embedded RADIUS accounting receiver
+type RadiusConfig struct {
+	Host string `yaml:"host" json:"host"`
+	// Accounting UDP port, 0 disables it
+	AcctPort int `yaml:"acct_port" json:"acct_port"`
 }
 
 type MqttConfig struct {
@@ -55,11 +76,12 @@ type MqttConfig struct {
 }
 
 type AppConfig struct {
-	System   SysConfig   `yaml:"system" json:"system"`
-	Web      WebConfig   `yaml:"web" json:"web"`
-	Database DBConfig    `yaml:"database" json:"database"`
-	Tr069    Tr069Config `yaml:"tr069" json:"tr069"`
-	Mqtt     MqttConfig  `yaml:"mqtt" json:"mqtt"`
+	System   SysConfig    `yaml:"system" json:"system"`
+	Web      WebConfig    `yaml:"web" json:"web"`
+	Database DBConfig     `yaml:"database" json:"database"`
+	Tr069    Tr069Config  `yaml:"tr069" json:"tr069"`
+	Radius   RadiusConfig `yaml:"radius" json:"radius"`
+	Mqtt     MqttConfig   `yaml:"mqtt" json:"mqtt"`
 }
 
 func (c *AppConfig) GetLogDir() string {
@@ -153,11 +175,21 @@ var DefaultAppConfig = &AppConfig{
 		Debug:    false,
 	},
 	Tr069: Tr069Config{
-		Host:   "0.0.0.0",
-		Tls:    true,
-		Port:   2999,
-		Secret: "9b6de5cc-1q21-1203-xxtt-0f568ac9d237",
-		Debug:  true,
+		Host:             "0.0.0.0",
+		Tls:              true,
+		Port:             2999,
+		Secret:           "9b6de5cc-1q21-1203-xxtt-0f568ac9d237",
+		Debug:            true,
+		StunPort:         3478,
+		XmppPort:         0,
+		InformWorkers:    32,
+		InformQueue:      2000,
+		InformOverload:   "reject",
+		InformRetryAfter: 60,
+	},
+	Radius: RadiusConfig{
+		Host:     "0.0.0.0",
+		AcctPort: 1813,
 	},
 	Mqtt: MqttConfig{
 		Server:   "",
@@ -187,6 +219,7 @@ func LoadConfig(cfile string) *AppConfig {
 
 	setEnvValue("TEAMSACS_SYSTEM_WORKER_DIR", &cfg.System.Workdir)
 	setEnvBoolValue("TEAMSACS_SYSTEM_DEBUG", &cfg.System.Debug)
+	setEnvIntValue("TEAMSACS_SYSTEM_NODE_ID", &cfg.System.NodeId)
 
 	// WEB
 	setEnvValue("TEAMSACS_WEB_HOST", &cfg.Web.Host)
@@ -208,6 +241,16 @@ func LoadConfig(cfile string) *AppConfig {
 	setEnvBoolValue("TEAMSACS_TR069_WEB_TLS", &cfg.Tr069.Tls)
 	setEnvBoolValue("TEAMSACS_TR069_WEB_DEBUG", &cfg.Tr069.Debug)
 	setEnvIntValue("TEAMSACS_TR069_WEB_PORT", &cfg.Tr069.Port)
+	setEnvIntValue("TEAMSACS_TR069_STUN_PORT", &cfg.Tr069.StunPort)
+	setEnvIntValue("TEAMSACS_TR069_XMPP_PORT", &cfg.Tr069.XmppPort)
+	setEnvIntValue("TEAMSACS_TR069_INFORM_WORKERS", &cfg.Tr069.InformWorkers)
+	setEnvIntValue("TEAMSACS_TR069_INFORM_QUEUE", &cfg.Tr069.InformQueue)
+	setEnvValue("TEAMSACS_TR069_INFORM_OVERLOAD", &cfg.Tr069.InformOverload)
+	setEnvIntValue("TEAMSACS_TR069_INFORM_RETRY_AFTER", &cfg.Tr069.InformRetryAfter)
+
+	// Radius
+	setEnvValue("TEAMSACS_RADIUS_HOST", &cfg.Radius.Host)
+	setEnvIntValue("TEAMSACS_RADIUS_ACCT_PORT", &cfg.Radius.AcctPort)
 
 	setEnvValue("TEAMSACS_MQTT_SERVER", &cfg.Mqtt.Server)
 	setEnvValue("TEAMSACS_MQTT_USERNAME", &cfg.Mqtt.Username)
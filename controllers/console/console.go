@@ -0,0 +1,269 @@
+package console
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ca17/teamsacs/app"
+	"github.com/ca17/teamsacs/common"
+	"github.com/ca17/teamsacs/common/web"
+	"github.com/ca17/teamsacs/common/zaplog/log"
+	"github.com/ca17/teamsacs/models"
+	"github.com/ca17/teamsacs/webserver"
+	"github.com/labstack/echo/v4"
+	"github.com/spf13/cast"
+)
+
+// devices started at the same time by an ad-hoc run
+const consoleExecConcurrency = 10
+
+func InitRouter() {
+
+	initTerminalRouter()
+
+	// SSH access of a device, the secrets are never returned
+	webserver.GET("/admin/console/ssh/get", func(c echo.Context) error {
+		dev, err := consoleDevice(c.QueryParam("devid"))
+		if err != nil {
+			return c.JSON(http.StatusOK, common.EmptyData)
+		}
+		item := app.GApp().SshAccess(dev.Sn)
+		return c.JSON(http.StatusOK, map[string]interface{}{
+			"address":        item.Address,
+			"port":           item.Port,
+			"username":       item.Username,
+			"has_password":   item.Password != "",
+			"has_key":        item.PrivateKey != "",
+			"has_passphrase": item.KeyPassphrase != "",
+			"last_status":    item.LastStatus,
+			"last_error":     item.LastError,
+			"last_time":      item.LastTime,
+			"host_key":       item.HostKey,
+		})
+	})
+
+	webserver.POST("/admin/console/ssh/update", func(c echo.Context) error {
+		dev, err := consoleDevice(c.FormValue("devid"))
+		if err != nil {
+			return c.JSON(http.StatusOK, web.RestError(err.Error()))
+		}
+		username := strings.TrimSpace(c.FormValue("username"))
+		common.MustNotEmpty("Username", username)
+		err = app.GApp().SaveSshAccess(dev.Sn,
+			strings.TrimSpace(c.FormValue("address")),
+			cast.ToInt(c.FormValue("port")),
+			username,
+			c.FormValue("password"),
+			strings.TrimSpace(c.FormValue("private_key")),
+			c.FormValue("key_passphrase"))
+		// a replaced device has a new host key
+		if err == nil && c.FormValue("reset_host_key") == "1" {
+			err = app.GApp().ResetSshHostKey(dev.Sn)
+		}
+		if err != nil {
+			return c.JSON(http.StatusOK, web.RestError(err.Error()))
+		}
+		webserver.PubOpLog(c, fmt.Sprintf("Update SSH access of %s", dev.Sn))
+		return c.JSON(http.StatusOK, web.RestSucc("Success"))
+	})
+
+	webserver.GET("/admin/console/commandset/options", func(c echo.Context) error {
+		var data []models.ConsoleCommandSet
+		common.Must(app.GDB().Order("name asc").Find(&data).Error)
+		var opts = make([]web.JsonOptions, 0)
+		for _, d := range data {
+			opts = append(opts, web.JsonOptions{Id: cast.ToString(d.ID), Value: d.Name})
+		}
+		return c.JSON(http.StatusOK, opts)
+	})
+
+	webserver.GET("/admin/console/commandset/query", func(c echo.Context) error {
+		prequery := web.NewPreQuery(c).
+			DefaultOrderBy("updated_at desc").
+			KeyFields("name", "remark")
+
+		result, err := web.QueryPageResult[models.ConsoleCommandSet](c, app.GDB(), prequery)
+		if err != nil {
+			return c.JSON(http.StatusOK, common.EmptyList)
+		}
+		return c.JSON(http.StatusOK, result)
+	})
+
+	webserver.POST("/admin/console/commandset/add", func(c echo.Context) error {
+		form := new(models.ConsoleCommandSet)
+		common.Must(c.Bind(form))
+		common.MustNotEmpty("Name", form.Name)
+		common.MustNotEmpty("Content", form.Content)
+		form.ID = common.UUIDint64()
+		common.Must(app.GDB().Create(form).Error)
+		webserver.PubOpLog(c, fmt.Sprintf("Create console command set %s", form.Name))
+		return c.JSON(http.StatusOK, web.RestSucc("success"))
+	})
+
+	webserver.POST("/admin/console/commandset/update", func(c echo.Context) error {
+		form := new(models.ConsoleCommandSet)
+		common.Must(c.Bind(form))
+		common.MustNotEmpty("Name", form.Name)
+		common.MustNotEmpty("Content", form.Content)
+		common.Must(app.GDB().Save(form).Error)
+		webserver.PubOpLog(c, fmt.Sprintf("Update console command set %s", form.Name))
+		return c.JSON(http.StatusOK, web.RestSucc("success"))
+	})
+
+	webserver.GET("/admin/console/commandset/delete", func(c echo.Context) error {
+		ids := c.QueryParam("ids")
+		common.Must(app.GDB().Delete(models.ConsoleCommandSet{}, strings.Split(ids, ",")).Error)
+		webserver.PubOpLog(c, fmt.Sprintf("Delete console command sets %s", ids))
+		return c.JSON(http.StatusOK, web.RestSucc("success"))
+	})
+
+	// Ad-hoc commands or a command set on devices, the runs share the returned session.
+	// Large device sets are run as bulk jobs with the console action type.
+	webserver.POST("/admin/console/exec", func(c echo.Context) error {
+		var devids, commands string
+		var setid int64
+		var timeout int
+		common.Must(web.NewParamReader(c).
+			ReadRequiedString(&devids, "devids").
+			ReadString(&commands, "commands").
+			ReadInt64(&setid, "setid", 0).
+			ReadInt(&timeout, "timeout", 0).LastError)
+		if setid > 0 {
+			var set models.ConsoleCommandSet
+			if err := app.GDB().Where("id = ?", setid).First(&set).Error; err != nil {
+				return c.JSON(http.StatusOK, web.RestError("Command set not found"))
+			}
+			commands, timeout = set.Content, set.Timeout
+		}
+		common.MustNotEmpty("Commands", commands)
+
+		var devs []models.NetCpe
+		common.Must(app.GDB().Where("id in ?", strings.Split(devids, ",")).Find(&devs).Error)
+		if len(devs) == 0 {
+			return c.JSON(http.StatusOK, web.RestError("Device not found"))
+		}
+		session := common.UUID()
+		operator := webserver.GetCurrUser(c).Username
+		go runConsoleCommands(devs, commands, timeout, session, operator)
+		webserver.PubOpLog(c, fmt.Sprintf("Run console commands on %d devices, session %s", len(devs), session))
+		return c.JSON(http.StatusOK, web.RestResult(map[string]string{"session": session}))
+	})
+
+	webserver.GET("/admin/console/run/query", func(c echo.Context) error {
+		prequery := web.NewPreQuery(c).
+			DefaultOrderBy("start_time desc").
+			DateRange2("starttime", "endtime", "start_time", time.Now().Add(-time.Hour*24*7), time.Now()).
+			QueryField("sn", "sn").
+			QueryField("session", "session").
+			QueryField("job_id", "job_id").
+			QueryField("status", "status").
+			KeyFields("commands", "output", "last_error")
+
+		result, err := web.QueryPageResult[models.ConsoleRun](c, app.GDB(), prequery)
+		if err != nil {
+			return c.JSON(http.StatusOK, common.EmptyList)
+		}
+		return c.JSON(http.StatusOK, result)
+	})
+
+	// Upload a file of the cwmp file store to the device
+	webserver.POST("/admin/console/sftp/upload", func(c echo.Context) error {
+		var devid, filename, remotePath string
+		common.Must(web.NewParamReader(c).
+			ReadRequiedString(&devid, "devid").
+			ReadRequiedString(&filename, "filename").
+			ReadRequiedString(&remotePath, "remote_path").LastError)
+		dev, err := consoleDevice(devid)
+		if err != nil {
+			return c.JSON(http.StatusOK, web.RestError(err.Error()))
+		}
+		localPath, err := fileStorePath(filename)
+		if err != nil {
+			return c.JSON(http.StatusOK, web.RestError(err.Error()))
+		}
+		if !common.FileExists(localPath) {
+			return c.JSON(http.StatusOK, web.RestError("file not found: "+filename))
+		}
+		client, err := app.GApp().SftpClient(dev)
+		if err != nil {
+			return c.JSON(http.StatusOK, web.RestError(err.Error()))
+		}
+		if err = client.Upload(nil, localPath, remotePath); err != nil {
+			return c.JSON(http.StatusOK, web.RestError(err.Error()))
+		}
+		webserver.PubOpLog(c, fmt.Sprintf("SFTP upload %s to %s:%s", filename, dev.Sn, remotePath))
+		return c.JSON(http.StatusOK, web.RestSucc("Upload success"))
+	})
+
+	// Download a file of the device to the cwmp file store as <sn>_<filename>
+	webserver.POST("/admin/console/sftp/download", func(c echo.Context) error {
+		var devid, remotePath string
+		common.Must(web.NewParamReader(c).
+			ReadRequiedString(&devid, "devid").
+			ReadRequiedString(&remotePath, "remote_path").LastError)
+		dev, err := consoleDevice(devid)
+		if err != nil {
+			return c.JSON(http.StatusOK, web.RestError(err.Error()))
+		}
+		filename := dev.Sn + "_" + path.Base(remotePath)
+		localPath, err := fileStorePath(filename)
+		if err != nil {
+			return c.JSON(http.StatusOK, web.RestError(err.Error()))
+		}
+		client, err := app.GApp().SftpClient(dev)
+		if err != nil {
+			return c.JSON(http.StatusOK, web.RestError(err.Error()))
+		}
+		_ = os.MkdirAll(path.Dir(localPath), 0777)
+		if err = client.Download(nil, remotePath, localPath); err != nil {
+			return c.JSON(http.StatusOK, web.RestError(err.Error()))
+		}
+		webserver.PubOpLog(c, fmt.Sprintf("SFTP download %s:%s to %s", dev.Sn, remotePath, filename))
+		return c.JSON(http.StatusOK, web.RestSucc("Saved as "+filename))
+	})
+}
+
+func consoleDevice(devid string) (models.NetCpe, error) {
+	var dev models.NetCpe
+	if err := app.GDB().Where("id=?", devid).First(&dev).Error; err != nil {
+		return dev, fmt.Errorf("Device not found")
+	}
+	if common.IsEmptyOrNA(dev.Sn) {
+		return dev, fmt.Errorf("Device SN %s invalid", dev.Sn)
+	}
+	return dev, nil
+}
+
+// fileStorePath Path of a file of the cwmp file store, without sub directories
+func fileStorePath(filename string) (string, error) {
+	if filename == "" || filename != filepath.Base(filename) || strings.HasPrefix(filename, ".") {
+		return "", fmt.Errorf("invalid filename %s", filename)
+	}
+	return path.Join(app.GConfig().System.Workdir, "cwmp", filename), nil
+}
+
+func runConsoleCommands(devs []models.NetCpe, commands string, timeout int, session, operator string) {
+	sem := make(chan struct{}, consoleExecConcurrency)
+	var wg sync.WaitGroup
+	for _, dev := range devs {
+		sem <- struct{}{}
+		wg.Add(1)
+		go func(dev models.NetCpe) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			_, err := app.GApp().RunDeviceCommands(dev, commands, timeout, 0, session, operator)
+			if err != nil {
+				log.Errorf("console run %s on %s error: %s", session, dev.Sn, err.Error())
+			}
+		}(dev)
+	}
+	wg.Wait()
+}
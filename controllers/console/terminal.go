@@ -0,0 +1,156 @@
+package console
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/ca17/teamsacs/app"
+	"github.com/ca17/teamsacs/common/zaplog/log"
+	"github.com/ca17/teamsacs/webserver"
+	"github.com/labstack/echo/v4"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/net/websocket"
+)
+
+const terminalIdleTimeout = 30 * time.Minute
+
+// terminalMessage Browser message: input keys or the terminal size
+type terminalMessage struct {
+	Type string `json:"type"` // input | resize
+	Data string `json:"data"`
+	Cols int    `json:"cols"`
+	Rows int    `json:"rows"`
+}
+
+// wsWriter Shell output sent as websocket text frames
+type wsWriter struct {
+	lock sync.Mutex
+	ws   *websocket.Conn
+}
+
+func (w *wsWriter) Write(p []byte) (int, error) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	if err := websocket.Message.Send(w.ws, string(p)); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+func initTerminalRouter() {
+
+	webserver.GET("/admin/console/terminal", func(c echo.Context) error {
+		dev, err := consoleDevice(c.QueryParam("devid"))
+		if err != nil {
+			return c.String(http.StatusNotFound, err.Error())
+		}
+		return c.Render(http.StatusOK, "webconsole", map[string]interface{}{
+			"devid": dev.ID,
+			"sn":    dev.Sn,
+			"name":  dev.Name,
+		})
+	})
+
+	// Web terminal, the SSH shell of the device proxied over a websocket
+	webserver.GET("/admin/console/terminal/ws", func(c echo.Context) error {
+		dev, err := consoleDevice(c.QueryParam("devid"))
+		if err != nil {
+			return c.String(http.StatusNotFound, err.Error())
+		}
+		operator := webserver.GetCurrUser(c).Username
+		server := websocket.Server{
+			// the admin session cookie is sent cross-site too, only the admin pages may connect
+			Handshake: func(config *websocket.Config, r *http.Request) error {
+				origin, err := url.Parse(r.Header.Get("Origin"))
+				if err != nil || origin.Host != r.Host {
+					return fmt.Errorf("origin not allowed")
+				}
+				return nil
+			},
+			Handler: func(ws *websocket.Conn) {
+				defer ws.Close()
+				out := &wsWriter{ws: ws}
+				client, err := app.GApp().DialSsh(dev)
+				if err != nil {
+					_, _ = out.Write([]byte(err.Error() + "\r\n"))
+					return
+				}
+				defer client.Close()
+				log.Infof("%s open web terminal of %s", operator, dev.Sn)
+				if err = runTerminal(ws, out, client.SSHClient()); err != nil {
+					_, _ = out.Write([]byte("\r\n" + err.Error() + "\r\n"))
+				}
+				log.Infof("%s close web terminal of %s", operator, dev.Sn)
+			},
+		}
+		webserver.PubOpLog(c, fmt.Sprintf("Open web terminal of %s", dev.Sn))
+		server.ServeHTTP(c.Response(), c.Request())
+		return nil
+	})
+}
+
+// runTerminal Shell session until the shell exits or the websocket is closed
+func runTerminal(ws *websocket.Conn, out *wsWriter, client *ssh.Client) error {
+	session, err := client.NewSession()
+	if err != nil {
+		return err
+	}
+	defer session.Close()
+	modes := ssh.TerminalModes{
+		ssh.ECHO:          1,
+		ssh.TTY_OP_ISPEED: 14400,
+		ssh.TTY_OP_OSPEED: 14400,
+	}
+	if err = session.RequestPty("xterm", 40, 120, modes); err != nil {
+		return err
+	}
+	stdin, err := session.StdinPipe()
+	if err != nil {
+		return err
+	}
+	session.Stdout = out
+	session.Stderr = out
+	if err = session.Shell(); err != nil {
+		return err
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- session.Wait()
+	}()
+	go func() {
+		for {
+			_ = ws.SetReadDeadline(time.Now().Add(terminalIdleTimeout))
+			var data string
+			if err := websocket.Message.Receive(ws, &data); err != nil {
+				// closes the shell, Wait returns
+				session.Close()
+				return
+			}
+			var msg terminalMessage
+			if json.Unmarshal([]byte(data), &msg) != nil {
+				continue
+			}
+			switch msg.Type {
+			case "input":
+				_, _ = stdin.Write([]byte(msg.Data))
+			case "resize":
+				if msg.Cols > 0 && msg.Rows > 0 {
+					_ = session.WindowChange(msg.Rows, msg.Cols)
+				}
+			}
+		}
+	}()
+	err = <-done
+	if _, ok := err.(*ssh.ExitMissingError); ok {
+		return nil
+	}
+	if _, ok := err.(*ssh.ExitError); ok {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,52 @@
+package cpe
+
+import (
+	"net/http"
+
+	"github.com/ca17/teamsacs/app"
+	"github.com/ca17/teamsacs/common"
+	"github.com/ca17/teamsacs/common/web"
+	"github.com/ca17/teamsacs/models"
+	"github.com/ca17/teamsacs/webserver"
+	"github.com/labstack/echo/v4"
+)
+
+func initConnReqRouter() {
+
+	// Connection request credentials state and health per device
+	webserver.GET("/admin/cpe/connreq/query", func(c echo.Context) error {
+		prequery := web.NewPreQuery(c).
+			DefaultOrderBy("last_time desc").
+			QueryField("last_status", "last_status").
+			QueryField("method", "method").
+			KeyFields("sn", "last_error")
+
+		result, err := web.QueryPageResult[models.NetCpeConnReq](c, app.GDB(), prequery)
+		if err != nil {
+			return c.JSON(http.StatusOK, common.EmptyList)
+		}
+		return c.JSON(http.StatusOK, result)
+	})
+
+	// Connection request success rate and latency of all devices
+	webserver.GET("/admin/cpe/connreq/summary", func(c echo.Context) error {
+		type summary struct {
+			Devices    int64   `json:"devices"`
+			Failing    int64   `json:"failing"`
+			Success    int64   `json:"success"`
+			Failure    int64   `json:"failure"`
+			AvgLatency float64 `json:"avg_latency"`
+			Pending    int64   `json:"pending"`
+		}
+		var data summary
+		common.Must(app.GDB().Model(&models.NetCpeConnReq{}).Select(
+			"count(*) as devices, "+
+				"coalesce(sum(case when last_status = ? then 1 else 0 end), 0) as failing, "+
+				"coalesce(sum(success), 0) as success, "+
+				"coalesce(sum(failure), 0) as failure, "+
+				"coalesce(avg(case when success > 0 then avg_latency end), 0) as avg_latency, "+
+				"coalesce(sum(case when pending_username <> '' then 1 else 0 end), 0) as pending",
+			app.ConnReqFailure).Scan(&data).Error)
+		return c.JSON(http.StatusOK, web.RestResult(data))
+	})
+}
@@ -18,6 +18,15 @@ import (
 )
 
 func InitRouter() {
+
+	initHistoryRouter()
+
+	initGroupRouter()
+
+	initConnReqRouter()
+
+	initMetricRouter()
+
 	webserver.GET("/admin/cpe", func(c echo.Context) error {
 		return c.Render(http.StatusOK, "cpe", nil)
 	})
@@ -62,11 +71,18 @@ func InitRouter() {
 		var count, start int
 		var nodeId string
 		var deviceType string
+		var groupId, expr string
 		web.NewParamReader(c).
 			ReadInt(&start, "start", 0).
 			ReadInt(&count, "count", 40).
 			ReadString(&nodeId, "node_id").
-			ReadString(&deviceType, "device_type")
+			ReadString(&deviceType, "device_type").
+			ReadString(&groupId, "group_id").
+			ReadString(&expr, "expr")
+		filter, filterArgs, err := deviceFilter(groupId, expr)
+		if err != nil {
+			return c.JSON(http.StatusOK, web.RestError(err.Error()))
+		}
 		var data []models.NetCpe
 		getQuery := func() *gorm.DB {
 			query := app.GDB().Model(&models.NetCpe{})
@@ -87,6 +103,10 @@ func InitRouter() {
 				query = query.Where("device_type = ?", deviceType)
 			}
 
+			if filter != "" {
+				query = query.Where(filter, filterArgs...)
+			}
+
 			for name, value := range web.ParseEqualMap(c) {
 				query = query.Where(fmt.Sprintf("%s = ?", name), value)
 			}
@@ -146,6 +166,9 @@ func InitRouter() {
 		common.Must(c.Bind(form))
 		common.CheckEmpty("sn", form.Sn)
 		common.CheckEmpty("name", form.Name)
+		if err := app.ValidCoordinate(form.Latitude, form.Longitude); err != nil {
+			return c.JSON(http.StatusOK, web.RestError(err.Error()))
+		}
 
 		var count int64 = 0
 		app.GDB().Model(models.NetCpe{}).Where("sn=?", form.Sn).Count(&count)
@@ -163,6 +186,9 @@ func InitRouter() {
 		common.Must(c.Bind(form))
 		common.CheckEmpty("sn", form.Sn)
 		common.CheckEmpty("name", form.Name)
+		if err := app.ValidCoordinate(form.Latitude, form.Longitude); err != nil {
+			return c.JSON(http.StatusOK, web.RestError(err.Error()))
+		}
 		app.GDB().Where("id=?", form.ID).Updates(form)
 		app.GApp().CwmpTable().ClearCwmpCpeCache(form.Sn)
 		webserver.PubOpLog(c, fmt.Sprintf("Update CPE information：%v", form))
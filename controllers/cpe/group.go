@@ -0,0 +1,141 @@
+package cpe
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/ca17/teamsacs/app"
+	"github.com/ca17/teamsacs/common"
+	"github.com/ca17/teamsacs/common/devquery"
+	"github.com/ca17/teamsacs/common/web"
+	"github.com/ca17/teamsacs/models"
+	"github.com/ca17/teamsacs/webserver"
+	"github.com/labstack/echo/v4"
+	"github.com/spf13/cast"
+	"gorm.io/gorm"
+)
+
+// deviceFilter SQL condition of a device group and/or a filter expression, empty when neither is set
+func deviceFilter(groupId, expr string) (string, []interface{}, error) {
+	var conds []string
+	var args []interface{}
+	if groupId != "" {
+		var group models.NetCpeGroup
+		if err := app.GDB().Where("id = ?", groupId).First(&group).Error; err != nil {
+			return "", nil, fmt.Errorf("device group %s not found", groupId)
+		}
+		sql, gargs, err := devquery.Compile(group.Expression, app.DeviceGroupFields)
+		if err != nil {
+			return "", nil, err
+		}
+		conds = append(conds, sql)
+		args = append(args, gargs...)
+	}
+	if strings.TrimSpace(expr) != "" {
+		sql, eargs, err := devquery.Compile(expr, app.DeviceGroupFields)
+		if err != nil {
+			return "", nil, err
+		}
+		conds = append(conds, sql)
+		args = append(args, eargs...)
+	}
+	return strings.Join(conds, " AND "), args, nil
+}
+
+func initGroupRouter() {
+
+	webserver.GET("/admin/cpe/group/query", func(c echo.Context) error {
+		prequery := web.NewPreQuery(c).
+			DefaultOrderBy("name asc").
+			KeyFields("name", "expression", "remark")
+
+		result, err := web.QueryPageResult[models.NetCpeGroup](c, app.GDB(), prequery)
+		if err != nil {
+			return c.JSON(http.StatusOK, common.EmptyList)
+		}
+		return c.JSON(http.StatusOK, result)
+	})
+
+	webserver.GET("/admin/cpe/group/options", func(c echo.Context) error {
+		var data []models.NetCpeGroup
+		common.Must(app.GDB().Order("name asc").Find(&data).Error)
+		var options = make([]web.JsonOptions, 0)
+		for _, d := range data {
+			options = append(options, web.JsonOptions{
+				Id:    cast.ToString(d.ID),
+				Value: d.Name,
+			})
+		}
+		return c.JSON(http.StatusOK, options)
+	})
+
+	webserver.POST("/admin/cpe/group/add", func(c echo.Context) error {
+		form := new(models.NetCpeGroup)
+		common.Must(c.Bind(form))
+		form.ID = common.UUIDint64()
+		common.MustNotEmpty("Name", form.Name)
+		if _, _, err := devquery.Compile(form.Expression, app.DeviceGroupFields); err != nil {
+			return c.JSON(http.StatusOK, web.RestError("Invalid expression: "+err.Error()))
+		}
+		form.CreatedAt = time.Now()
+		form.UpdatedAt = time.Now()
+		common.Must(app.GDB().Create(form).Error)
+		webserver.PubOpLog(c, fmt.Sprintf("Create device group：%v", form))
+		return c.JSON(http.StatusOK, web.RestSucc("success"))
+	})
+
+	webserver.POST("/admin/cpe/group/update", func(c echo.Context) error {
+		form := new(models.NetCpeGroup)
+		common.Must(c.Bind(form))
+		common.MustNotEmpty("Name", form.Name)
+		if _, _, err := devquery.Compile(form.Expression, app.DeviceGroupFields); err != nil {
+			return c.JSON(http.StatusOK, web.RestError("Invalid expression: "+err.Error()))
+		}
+		form.UpdatedAt = time.Now()
+		common.Must(app.GDB().Select("name", "expression", "remark", "updated_at").Updates(form).Error)
+		webserver.PubOpLog(c, fmt.Sprintf("Update device group：%v", form))
+		return c.JSON(http.StatusOK, web.RestSucc("success"))
+	})
+
+	webserver.GET("/admin/cpe/group/delete", func(c echo.Context) error {
+		ids := c.QueryParam("ids")
+		common.Must(app.GDB().Delete(models.NetCpeGroup{}, strings.Split(ids, ",")).Error)
+		webserver.PubOpLog(c, fmt.Sprintf("Delete device group：%s", ids))
+		return c.JSON(http.StatusOK, web.RestSucc("success"))
+	})
+
+	// Number of devices matching an expression and the first of them
+	webserver.POST("/admin/cpe/group/preview", func(c echo.Context) error {
+		var expr string
+		common.Must(web.NewParamReader(c).ReadRequiedString(&expr, "expression").LastError)
+		query, err := app.GApp().DeviceFilterQuery(expr)
+		if err != nil {
+			return c.JSON(http.StatusOK, web.RestError("Invalid expression: "+err.Error()))
+		}
+		var total int64
+		common.Must(query.Session(&gorm.Session{}).Count(&total).Error)
+		var devices []models.NetCpe
+		common.Must(query.Session(&gorm.Session{}).Order("sn asc").Limit(20).Find(&devices).Error)
+		return c.JSON(http.StatusOK, web.RestResult(map[string]interface{}{
+			"total":   total,
+			"devices": devices,
+		}))
+	})
+
+	// Field names usable in expressions
+	webserver.GET("/admin/cpe/group/fields", func(c echo.Context) error {
+		var names []string
+		for name := range app.DeviceGroupFields {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		var options = make([]web.JsonOptions, 0)
+		for _, name := range names {
+			options = append(options, web.JsonOptions{Id: name, Value: name})
+		}
+		return c.JSON(http.StatusOK, options)
+	})
+}
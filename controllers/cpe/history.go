@@ -0,0 +1,93 @@
+package cpe
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/ca17/teamsacs/app"
+	"github.com/ca17/teamsacs/common"
+	"github.com/ca17/teamsacs/common/timeutil"
+	"github.com/ca17/teamsacs/common/web"
+	"github.com/ca17/teamsacs/models"
+	"github.com/ca17/teamsacs/webserver"
+	"github.com/labstack/echo/v4"
+)
+
+// paramDiff Value of one parameter at two points in time
+type paramDiff struct {
+	Name      string    `json:"name"`
+	FromValue string    `json:"from_value"`
+	ToValue   string    `json:"to_value"`
+	Changes   int       `json:"changes"`
+	Source    string    `json:"source"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+func initHistoryRouter() {
+
+	// Parameter change timeline of a device
+	webserver.GET("/admin/cpe/params/history", func(c echo.Context) error {
+		prequery := web.NewPreQuery(c).
+			DefaultOrderBy("created_at desc").
+			DateRange2("starttime", "endtime", "created_at", time.Now().Add(-time.Hour*24*7), time.Now()).
+			QueryField("sn", "sn").
+			QueryField("name", "name").
+			QueryField("source", "source").
+			KeyFields("name", "old_value", "new_value", "session")
+
+		result, err := web.QueryPageResult[models.NetCpeParamHistory](c, app.GDB(), prequery)
+		if err != nil {
+			return c.JSON(http.StatusOK, common.EmptyList)
+		}
+		return c.JSON(http.StatusOK, result)
+	})
+
+	// Parameters that differ between two points in time, from/to: 2006-01-02 15:04:05
+	webserver.GET("/admin/cpe/params/diff", func(c echo.Context) error {
+		var sn, from, to string
+		common.Must(web.NewParamReader(c).
+			ReadRequiedString(&sn, "sn").
+			ReadRequiedString(&from, "from").
+			ReadStringWithDefault(&to, "to", time.Now().Format(timeutil.YYYYMMDDHHMMSS_LAYOUT)).
+			LastError)
+		fromTime, err := time.ParseInLocation(timeutil.YYYYMMDDHHMMSS_LAYOUT, from, time.Local)
+		if err != nil {
+			return c.JSON(http.StatusOK, web.RestError("invalid from time"))
+		}
+		toTime, err := time.ParseInLocation(timeutil.YYYYMMDDHHMMSS_LAYOUT, to, time.Local)
+		if err != nil {
+			return c.JSON(http.StatusOK, web.RestError("invalid to time"))
+		}
+		if !toTime.After(fromTime) {
+			return c.JSON(http.StatusOK, web.RestError("to time must be after from time"))
+		}
+
+		var items []models.NetCpeParamHistory
+		common.Must(app.GDB().
+			Where("sn = ? and created_at > ? and created_at <= ?", sn, fromTime, toTime).
+			Order("created_at asc").Find(&items).Error)
+
+		var diffs = make(map[string]*paramDiff)
+		var names []string
+		for _, item := range items {
+			d, ok := diffs[item.Name]
+			if !ok {
+				d = &paramDiff{Name: item.Name, FromValue: item.OldValue}
+				diffs[item.Name] = d
+				names = append(names, item.Name)
+			}
+			d.ToValue = item.NewValue
+			d.Changes++
+			d.Source = item.Source
+			d.UpdatedAt = item.CreatedAt
+		}
+
+		var result = make([]paramDiff, 0)
+		for _, name := range names {
+			if d := diffs[name]; d.FromValue != d.ToValue {
+				result = append(result, *d)
+			}
+		}
+		return c.JSON(http.StatusOK, web.RestResult(result))
+	})
+}
@@ -0,0 +1,101 @@
+package cpe
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/ca17/teamsacs/app"
+	"github.com/ca17/teamsacs/common"
+	"github.com/ca17/teamsacs/common/echarts"
+	"github.com/ca17/teamsacs/common/web"
+	"github.com/ca17/teamsacs/webserver"
+	"github.com/labstack/echo/v4"
+)
+
+// deviceMetricPoint Value pushed by a device script
+type deviceMetricPoint struct {
+	Time  string  `json:"time"`
+	Value float64 `json:"value"`
+}
+
+func initMetricRouter() {
+
+	// Metrics of the device scripts
+	webserver.GET("/admin/cpe/metric/names", func(c echo.Context) error {
+		var result = make([]web.JsonOptions, 0)
+		for _, name := range app.DeviceMetricNames {
+			result = append(result, web.JsonOptions{Id: name, Value: name})
+		}
+		return c.JSON(http.StatusOK, result)
+	})
+
+	// Points of a device metric, hours: time range until now, default 24
+	webserver.GET("/admin/cpe/metric/query", func(c echo.Context) error {
+		sn, name, hours, err := readDeviceMetricParams(c)
+		if err != nil {
+			return c.JSON(http.StatusOK, web.RestError(err.Error()))
+		}
+		points, err := app.QueryDeviceMetric(sn, name, time.Now().Add(-time.Duration(hours)*time.Hour), time.Now())
+		if err != nil {
+			return c.JSON(http.StatusOK, common.EmptyList)
+		}
+		var result = make([]deviceMetricPoint, 0, len(points))
+		for _, p := range points {
+			result = append(result, deviceMetricPoint{
+				Time:  time.Unix(p.Timestamp, 0).Format("2006-01-02 15:04:05"),
+				Value: p.Value,
+			})
+		}
+		return c.JSON(http.StatusOK, result)
+	})
+
+	// Line chart of a device metric, averaged per minute
+	webserver.GET("/admin/cpe/metric/line", func(c echo.Context) error {
+		sn, name, hours, err := readDeviceMetricParams(c)
+		if err != nil {
+			return c.JSON(http.StatusOK, common.EmptyList)
+		}
+		points, err := app.QueryDeviceMetric(sn, name, time.Now().Add(-time.Duration(hours)*time.Hour), time.Now())
+		if err != nil {
+			return c.JSON(http.StatusOK, common.EmptyList)
+		}
+		var items []echarts.MetricLineItem
+		for i, p := range points {
+			items = append(items, echarts.MetricLineItem{
+				Id:    i + 1,
+				Time:  time.Unix(p.Timestamp, 0).Format("2006-01-02 15:04"),
+				Value: p.Value,
+			})
+		}
+
+		result := echarts.AvgMetricLine(items)
+		tsdata := echarts.NewTimeValues()
+		for _, item := range result {
+			timestamp, err := time.ParseInLocation("2006-01-02 15:04", item.Time, time.Local)
+			if err != nil {
+				continue
+			}
+			tsdata.AddData(timestamp.Unix()*1000, item.Value)
+		}
+		so := echarts.NewSeriesObject("line")
+		so.SetAttr("name", name)
+		so.SetAttr("showSymbol", false)
+		so.SetAttr("smooth", true)
+		so.SetAttr("areaStyle", echarts.Dict{})
+		so.SetAttr("data", tsdata)
+
+		return c.JSON(http.StatusOK, echarts.Series(so))
+	})
+}
+
+func readDeviceMetricParams(c echo.Context) (sn, name string, hours int, err error) {
+	err = web.NewParamReader(c).
+		ReadRequiedString(&sn, "sn").
+		ReadRequiedString(&name, "metric").
+		ReadInt(&hours, "hours", 24).
+		LastError
+	if hours <= 0 || hours > 24*30 {
+		hours = 24
+	}
+	return
+}
@@ -1,6 +1,7 @@
 package controllers
 
 import (
+	"github.com/ca17/teamsacs/controllers/console"
 	"github.com/ca17/teamsacs/controllers/cpe"
 	"github.com/ca17/teamsacs/controllers/cwmpconfig"
 	"github.com/ca17/teamsacs/controllers/cwmppreset"
@@ -16,6 +17,7 @@ import (
 	"github.com/ca17/teamsacs/controllers/settings"
 	"github.com/ca17/teamsacs/controllers/supervise"
 	"github.com/ca17/teamsacs/controllers/translate"
+	"github.com/ca17/teamsacs/controllers/vparams"
 )
 
 // Init web 控制器初始化
@@ -35,4 +37,6 @@ func Init() {
 	metrics.InitRouter()
 	translate.InitRouter()
 	files.InitRouter()
+	vparams.InitRouter()
+	console.InitRouter()
 }
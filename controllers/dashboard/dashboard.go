@@ -76,6 +76,8 @@ func InitRouter() {
 		data = append(data, counterItem{Icon: "mdi mdi-circle-slice-2", Name: "24h Total Message", Value: result[app.MetricsTr069MessageTotal]})
 		data = append(data, counterItem{Icon: "mdi mdi-circle-slice-2", Name: "24h TR069 Inform", Value: result[app.MetricsTr069Inform]})
 		data = append(data, counterItem{Icon: "mdi mdi-circle-slice-2", Name: "24h TR069 Download", Value: result[app.MetricsTr069Download]})
+		data = append(data, counterItem{Icon: "mdi mdi-circle-slice-2", Name: "24h Inform Rejected", Value: result[app.MetricsTr069InformRejected]})
+		data = append(data, counterItem{Icon: "mdi mdi-circle-slice-2", Name: "Inform Queue", Value: app.GApp().InformPoolStats().Queued})
 
 		var cpeCount int64
 		app.GDB().Model(&models.NetCpe{}).Count(&cpeCount)
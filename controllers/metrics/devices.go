@@ -0,0 +1,173 @@
+package metrics
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/ca17/teamsacs/app"
+	"github.com/ca17/teamsacs/models"
+	"github.com/ca17/teamsacs/webserver"
+	"github.com/labstack/echo/v4"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/spf13/cast"
+	"gorm.io/gorm"
+)
+
+// Per device metrics for Prometheus. The service discovery splits every node
+// into shards of MetricsShardSize devices, each shard is a scrape target. The
+// scrapers authenticate with the MetricsToken setting (see webserver.sessionCheck):
+//
+//	http_sd_configs:
+//	  - url: http://acs:2979/metrics/devices/sd
+//	    authorization: { credentials: <MetricsToken> }
+
+var devicesLabels = []string{"sn", "node", "model"}
+
+const devicesPageSize = 1000
+
+func initDevicesRouter() {
+	webserver.GET("/metrics/devices", deviceMetrics)
+	webserver.GET("/metrics/devices/sd", deviceMetricsDiscovery)
+}
+
+func metricsShardSize() int {
+	size := cast.ToInt(app.GApp().GetSystemSettingsStringValue(app.ConfigSystemMetricsShard))
+	if size <= 0 {
+		size = 5000
+	}
+	return size
+}
+
+// deviceMetricsDiscovery Prometheus HTTP service discovery, one target per node shard
+func deviceMetricsDiscovery(c echo.Context) error {
+	type targetGroup struct {
+		Targets []string          `json:"targets"`
+		Labels  map[string]string `json:"labels"`
+	}
+	var nodes []struct {
+		NodeId int64
+		Name   string
+		Count  int64
+	}
+	app.GDB().Raw(`select net_cpe.node_id, coalesce(net_node.name, '') as name, count(*) as count
+		from net_cpe left join net_node on net_node.id = net_cpe.node_id
+		group by net_cpe.node_id, net_node.name order by net_cpe.node_id`).Scan(&nodes)
+	size := int64(metricsShardSize())
+	var groups = make([]targetGroup, 0)
+	for _, node := range nodes {
+		shards := (node.Count + size - 1) / size
+		for shard := int64(0); shard < shards; shard++ {
+			groups = append(groups, targetGroup{
+				Targets: []string{c.Request().Host},
+				Labels: map[string]string{
+					"__scheme__":       c.Scheme(),
+					"__metrics_path__": "/metrics/devices",
+					"__param_node":     strconv.FormatInt(node.NodeId, 10),
+					"__param_shard":    strconv.FormatInt(shard, 10),
+					"__param_shards":   strconv.FormatInt(shards, 10),
+					"node":             node.Name,
+				},
+			})
+		}
+	}
+	return c.JSON(http.StatusOK, groups)
+}
+
+// deviceMetrics Gauges of the devices of a node shard, all devices without parameters
+func deviceMetrics(c echo.Context) error {
+	query := app.GDB().Model(&models.NetCpe{})
+	if node := c.QueryParam("node"); node != "" {
+		query = query.Where("node_id = ?", cast.ToInt64(node))
+	}
+	if shards := cast.ToInt(c.QueryParam("shards")); shards > 1 {
+		// stable shard of the serial number, hashtext is a signed int4
+		query = query.Where("mod(hashtext(sn)::bigint + 2147483648, ?) = ?", shards, cast.ToInt(c.QueryParam("shard")))
+	}
+	var nodes []models.NetNode
+	app.GDB().Find(&nodes)
+	nodeNames := make(map[int64]string)
+	for _, n := range nodes {
+		nodeNames[n.ID] = n.Name
+	}
+
+	reg := prometheus.NewRegistry()
+	gauge := func(name, help string, labels ...string) *prometheus.GaugeVec {
+		g := prometheus.NewGaugeVec(prometheus.GaugeOpts{Namespace: "teamsacs", Subsystem: "device", Name: name, Help: help},
+			append(devicesLabels, labels...))
+		reg.MustRegister(g)
+		return g
+	}
+	up := gauge("up", "CWMP status of the device, 1 online")
+	uptime := gauge("uptime_seconds", "Device uptime")
+	cpu := gauge("cpu_usage_percent", "Device CPU usage")
+	memTotal := gauge("memory_total_kilobytes", "Device total memory")
+	memFree := gauge("memory_free_kilobytes", "Device free memory")
+	rxPower := gauge("optical_rx_power_dbm", "Optical RX power reported by the ONT")
+	txPower := gauge("optical_tx_power_dbm", "Optical TX power reported by the ONT")
+	oltRxPower := gauge("olt_rx_power_dbm", "ONU RX power polled from the OLT")
+	wanUp := gauge("wan_up", "WAN connection status, 1 connected", "interface")
+
+	// the devices are read by pages, the scrape of a whole node or of all the
+	// devices does not hold them in memory nor bind all the serial numbers at once
+	var devices []models.NetCpe
+	err := query.Select("id", "sn", "node_id", "model", "cwmp_status", "uptime", "cpu_usage",
+		"memory_total", "memory_free", "fiber_rx_power", "fiber_tx_power", "pon_sn_hex").
+		FindInBatches(&devices, devicesPageSize, func(tx *gorm.DB, batch int) error {
+			var sns, ponSns []string
+			labelsBySn := make(map[string][]string)
+			for _, dev := range devices {
+				labels := []string{dev.Sn, nodeNames[dev.NodeId], dev.Model}
+				labelsBySn[dev.Sn] = labels
+				sns = append(sns, dev.Sn)
+				if dev.PonSnHex != "" {
+					ponSns = append(ponSns, strings.ToUpper(dev.PonSnHex))
+					labelsBySn[strings.ToUpper(dev.PonSnHex)] = labels
+				}
+				up.WithLabelValues(labels...).Set(float64(cast.ToInt(dev.CwmpStatus == "online")))
+				uptime.WithLabelValues(labels...).Set(float64(dev.Uptime))
+				cpu.WithLabelValues(labels...).Set(float64(dev.CPUUsage))
+				memTotal.WithLabelValues(labels...).Set(float64(dev.MemoryTotal))
+				memFree.WithLabelValues(labels...).Set(float64(dev.MemoryFree))
+				if v, ok := app.ParseDbm(dev.FiberRxPower); ok {
+					rxPower.WithLabelValues(labels...).Set(v)
+				}
+				if v, ok := app.ParseDbm(dev.FiberTxPower); ok {
+					txPower.WithLabelValues(labels...).Set(v)
+				}
+			}
+
+			if len(ponSns) > 0 {
+				var onus []models.OltOnuData
+				app.GDB().Select("serial_number", "rx_power").Where("serial_number in ?", ponSns).Find(&onus)
+				for _, onu := range onus {
+					if labels, ok := labelsBySn[strings.ToUpper(onu.SerialNumber)]; ok {
+						oltRxPower.WithLabelValues(labels...).Set(onu.RxPower)
+					}
+				}
+			}
+
+			var params []models.NetCpeParam
+			app.GDB().Select("sn", "name", "value").
+				Where("sn in ?", sns).
+				Where("(name like 'InternetGatewayDevice.WANDevice.%.ConnectionStatus' or name like 'Device.PPP.Interface.%.Status')").
+				Find(&params)
+			for _, p := range params {
+				labels, ok := labelsBySn[p.Sn]
+				if !ok {
+					continue
+				}
+				iface := strings.TrimSuffix(strings.TrimSuffix(p.Name, ".ConnectionStatus"), ".Status")
+				connected := p.Value == "Connected" || p.Value == "Up"
+				wanUp.WithLabelValues(labels[0], labels[1], labels[2], iface).Set(float64(cast.ToInt(connected)))
+			}
+			return nil
+		}).Error
+	if err != nil {
+		return c.String(http.StatusInternalServerError, err.Error())
+	}
+
+	promhttp.HandlerFor(reg, promhttp.HandlerOpts{EnableOpenMetrics: true}).ServeHTTP(c.Response(), c.Request())
+	return nil
+}
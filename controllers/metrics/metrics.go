@@ -16,6 +16,7 @@ import (
 )
 
 func InitRouter() {
+	initDevicesRouter()
 
 	webserver.GET("/admin/metrics/system/hostname", func(c echo.Context) error {
 		hinfo, err := host.Info()
@@ -50,6 +50,7 @@ func InitRouter() {
 		var data []item
 		data = append(data, item{Name: "system", Title: "System config", Icon: "mdi mdi-cogs"})
 		data = append(data, item{Name: "tr069", Title: "TR069 config", Icon: "mdi mdi-switch"})
+		data = append(data, item{Name: "radius", Title: "RADIUS config", Icon: "mdi mdi-account-network"})
 		return c.JSON(http.StatusOK, data)
 	})
 
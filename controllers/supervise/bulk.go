@@ -0,0 +1,261 @@
+package supervise
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/ca17/teamsacs/app"
+	"github.com/ca17/teamsacs/common"
+	"github.com/ca17/teamsacs/common/timeutil"
+	"github.com/ca17/teamsacs/common/web"
+	"github.com/ca17/teamsacs/events"
+	"github.com/ca17/teamsacs/models"
+	"github.com/ca17/teamsacs/webserver"
+	"github.com/labstack/echo/v4"
+	"github.com/spf13/cast"
+	"gorm.io/gorm"
+)
+
+// bulkTargets Devices of a bulk job target: list of device IDs, device group ID or filter expression
+func bulkTargets(targetType, target string) ([]models.NetCpe, error) {
+	var query *gorm.DB
+	var err error
+	switch targetType {
+	case "list":
+		query = app.GDB().Model(&models.NetCpe{}).Where("id in ?", strings.Split(target, ","))
+	case "group":
+		query, err = app.GApp().DeviceGroupQuery(cast.ToInt64(target))
+	case "filter":
+		query, err = app.GApp().DeviceFilterQuery(target)
+	default:
+		return nil, fmt.Errorf("unsupported target type %s", targetType)
+	}
+	if err != nil {
+		return nil, err
+	}
+	var devs []models.NetCpe
+	err = query.Select("net_cpe.id", "net_cpe.sn").Order("net_cpe.sn asc").Find(&devs).Error
+	return devs, err
+}
+
+func checkBulkAction(form *models.CwmpBulkJob) error {
+	switch form.ActionType {
+	case "cwmp":
+		if form.ActionId == "cwmpFactoryConfiguration" {
+			return fmt.Errorf("action %s is not supported in bulk jobs", form.ActionId)
+		}
+		for _, cmd := range cwmpCmds {
+			if cmd.Sid == form.ActionId {
+				return nil
+			}
+		}
+		return fmt.Errorf("unknown cwmp action %s", form.ActionId)
+	case "webcreds":
+		return nil
+	case "cwmpconfig":
+		var count int64
+		app.GDB().Model(&models.CwmpConfig{}).Where("id = ?", form.ActionId).Count(&count)
+		if count == 0 {
+			return fmt.Errorf("TR069 configuration %s does not exist", form.ActionId)
+		}
+		return nil
+	case "console":
+		var count int64
+		app.GDB().Model(&models.ConsoleCommandSet{}).Where("id = ?", cast.ToInt64(form.ActionId)).Count(&count)
+		if count == 0 {
+			return fmt.Errorf("Console command set %s does not exist", form.ActionId)
+		}
+		return nil
+	}
+	return fmt.Errorf("unsupported action type %s", form.ActionType)
+}
+
+// createBulkJob Save the job with one item per device and start it
+func createBulkJob(job *models.CwmpBulkJob, devs []models.NetCpe) error {
+	job.ID = common.UUIDint64()
+	job.Status = BulkJobRunning
+	job.Total = int64(len(devs))
+	job.Success = 0
+	job.Failure = 0
+	job.CreatedAt = time.Now()
+	job.UpdatedAt = time.Now()
+	var items = make([]models.CwmpBulkJobItem, 0, len(devs))
+	for _, dev := range devs {
+		items = append(items, models.CwmpBulkJobItem{
+			ID:        common.UUIDint64(),
+			JobId:     job.ID,
+			CpeId:     dev.ID,
+			Sn:        dev.Sn,
+			Status:    BulkItemPending,
+			ExecTime:  timeutil.EmptyTime,
+			UpdatedAt: time.Now(),
+		})
+	}
+	err := app.GDB().Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(job).Error; err != nil {
+			return err
+		}
+		return tx.CreateInBatches(items, 500).Error
+	})
+	if err != nil {
+		return err
+	}
+	startBulkJob(*job)
+	return nil
+}
+
+func initBulkRouter() {
+
+	events.Supervisor.SubscribeAsync(events.EventSuperviseLog, onBulkSuperviseLog, false)
+	events.Supervisor.SubscribeAsync(events.EventCwmpSuperviseStatus, onBulkCwmpStatus, false)
+	go resumeBulkJobs(true)
+	common.Must(app.GApp().AddLeaderJob("@every 1m", func() { resumeBulkJobs(false) }))
+
+	// Actions usable in bulk jobs
+	webserver.GET("/admin/supervise/bulk/actions", func(c echo.Context) error {
+		var actions []SuperviseAction
+		for _, cmd := range cwmpCmds {
+			if cmd.Sid != "cwmpFactoryConfiguration" {
+				actions = append(actions, cmd)
+			}
+		}
+		var configs []models.CwmpConfig
+		common.Must(app.GDB().Order("name asc").Find(&configs).Error)
+		for _, cfg := range configs {
+			actions = append(actions, SuperviseAction{Name: cfg.Name, Type: "cwmpconfig", Level: cfg.Level, Sid: cfg.ID})
+		}
+		var sets []models.ConsoleCommandSet
+		common.Must(app.GDB().Order("name asc").Find(&sets).Error)
+		for _, set := range sets {
+			actions = append(actions, SuperviseAction{Name: set.Name, Type: "console", Level: "normal", Sid: cast.ToString(set.ID)})
+		}
+		actions = append(actions, SuperviseAction{Name: "Push web credentials and periodic inform", Type: "webcreds", Level: "normal", Sid: "webcreds"})
+		return c.JSON(http.StatusOK, actions)
+	})
+
+	webserver.GET("/admin/supervise/bulk/query", func(c echo.Context) error {
+		prequery := web.NewPreQuery(c).
+			DefaultOrderBy("created_at desc").
+			QueryField("status", "status").
+			KeyFields("name", "action_id", "operator")
+
+		result, err := web.QueryPageResult[models.CwmpBulkJob](c, app.GDB(), prequery)
+		if err != nil {
+			return c.JSON(http.StatusOK, common.EmptyList)
+		}
+		return c.JSON(http.StatusOK, result)
+	})
+
+	webserver.GET("/admin/supervise/bulk/items", func(c echo.Context) error {
+		prequery := web.NewPreQuery(c).
+			DefaultOrderBy("id asc").
+			QueryField("job_id", "job_id").
+			QueryField("status", "status").
+			KeyFields("sn", "message")
+
+		result, err := web.QueryPageResult[models.CwmpBulkJobItem](c, app.GDB(), prequery)
+		if err != nil {
+			return c.JSON(http.StatusOK, common.EmptyList)
+		}
+		return c.JSON(http.StatusOK, result)
+	})
+
+	webserver.POST("/admin/supervise/bulk/create", func(c echo.Context) error {
+		form := new(models.CwmpBulkJob)
+		common.Must(c.Bind(form))
+		common.MustNotEmpty("Name", form.Name)
+		common.MustNotEmpty("Target", form.Target)
+		if err := checkBulkAction(form); err != nil {
+			return c.JSON(http.StatusOK, web.RestError(err.Error()))
+		}
+		devs, err := bulkTargets(form.TargetType, form.Target)
+		if err != nil {
+			return c.JSON(http.StatusOK, web.RestError(err.Error()))
+		}
+		if len(devs) == 0 {
+			return c.JSON(http.StatusOK, web.RestError("No device matches the target"))
+		}
+
+		form.Operator = webserver.GetCurrUser(c).Username
+		common.Must(createBulkJob(form, devs))
+		webserver.PubOpLog(c, fmt.Sprintf("Create bulk job %s：%s %s on %d devices", form.Name, form.ActionType, form.ActionId, form.Total))
+		return c.JSON(http.StatusOK, web.RestSucc("success"))
+	})
+
+	webserver.GET("/admin/supervise/bulk/pause", func(c echo.Context) error {
+		var id int64
+		common.Must(web.NewParamReader(c).ReadInt64(&id, "id", 0).LastError)
+		err := app.GDB().Model(&models.CwmpBulkJob{}).Where("id = ? and status = ?", id, BulkJobRunning).
+			Updates(map[string]interface{}{"status": BulkJobPaused, "updated_at": time.Now()}).Error
+		common.Must(err)
+		stopBulkJob(id)
+		webserver.PubOpLog(c, fmt.Sprintf("Pause bulk job：%d", id))
+		return c.JSON(http.StatusOK, web.RestSucc("success"))
+	})
+
+	webserver.GET("/admin/supervise/bulk/resume", func(c echo.Context) error {
+		var id int64
+		common.Must(web.NewParamReader(c).ReadInt64(&id, "id", 0).LastError)
+		var job models.CwmpBulkJob
+		common.Must(app.GDB().Where("id = ?", id).First(&job).Error)
+		if job.Status != BulkJobPaused {
+			return c.JSON(http.StatusOK, web.RestError("Only paused jobs can be resumed"))
+		}
+		common.Must(app.GDB().Model(&job).
+			Updates(map[string]interface{}{"status": BulkJobRunning, "updated_at": time.Now()}).Error)
+		startBulkJob(job)
+		webserver.PubOpLog(c, fmt.Sprintf("Resume bulk job：%d", id))
+		return c.JSON(http.StatusOK, web.RestSucc("success"))
+	})
+
+	webserver.GET("/admin/supervise/bulk/cancel", func(c echo.Context) error {
+		var id int64
+		common.Must(web.NewParamReader(c).ReadInt64(&id, "id", 0).LastError)
+		err := app.GDB().Model(&models.CwmpBulkJob{}).
+			Where("id = ? and status in ?", id, []string{BulkJobRunning, BulkJobPaused}).
+			Updates(map[string]interface{}{"status": BulkJobCancel, "updated_at": time.Now()}).Error
+		common.Must(err)
+		stopBulkJob(id)
+		common.Must(app.GDB().Model(&models.CwmpBulkJobItem{}).
+			Where("job_id = ? and status = ?", id, BulkItemPending).
+			Updates(map[string]interface{}{"status": BulkItemCancel, "updated_at": time.Now()}).Error)
+		webserver.PubOpLog(c, fmt.Sprintf("Cancel bulk job：%d", id))
+		return c.JSON(http.StatusOK, web.RestSucc("success"))
+	})
+
+	webserver.GET("/admin/supervise/bulk/delete", func(c echo.Context) error {
+		ids := strings.Split(c.QueryParam("ids"), ",")
+		for _, id := range ids {
+			stopBulkJob(cast.ToInt64(id))
+		}
+		common.Must(app.GDB().Where("job_id in ?", ids).Delete(&models.CwmpBulkJobItem{}).Error)
+		common.Must(app.GDB().Delete(models.CwmpBulkJob{}, ids).Error)
+		webserver.PubOpLog(c, fmt.Sprintf("Delete bulk job：%s", strings.Join(ids, ",")))
+		return c.JSON(http.StatusOK, web.RestSucc("success"))
+	})
+
+	// Job report, format=csv|xlsx
+	webserver.GET("/admin/supervise/bulk/export", func(c echo.Context) error {
+		var id int64
+		common.Must(web.NewParamReader(c).ReadInt64(&id, "id", 0).LastError)
+		var items []models.CwmpBulkJobItem
+		common.Must(app.GDB().Where("job_id = ?", id).Order("id asc").Find(&items).Error)
+		if c.QueryParam("format") == "csv" {
+			return webserver.ExportCsv(c, items, fmt.Sprintf("bulkjob-%d", id))
+		}
+		var datas = make([]map[string]interface{}, 0, len(items))
+		for _, item := range items {
+			datas = append(datas, map[string]interface{}{
+				"id":        item.ID,
+				"sn":        item.Sn,
+				"status":    item.Status,
+				"message":   item.Message,
+				"session":   item.Session,
+				"exec_time": item.ExecTime.Format(timeutil.YYYYMMDDHHMMSS_LAYOUT),
+			})
+		}
+		return webserver.ExportData(c, datas, "bulkjob")
+	})
+}
@@ -0,0 +1,438 @@
+package supervise
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ca17/teamsacs/app"
+	"github.com/ca17/teamsacs/common"
+	"github.com/ca17/teamsacs/common/zaplog/log"
+	"github.com/ca17/teamsacs/models"
+	"github.com/spf13/cast"
+)
+
+const bulkSessionPrefix = "bulk-"
+
+// bulkItemTimeout Time given to a device to answer the action of a bulk job
+const bulkItemTimeout = time.Minute * 5
+
+// bulkHeartbeat Interval of the runner heartbeat, a running job without a heartbeat
+// for bulkOrphanTimeout lost its instance and is resumed by the leader
+const (
+	bulkHeartbeat     = time.Second * 30
+	bulkOrphanTimeout = time.Minute * 2
+)
+
+// bulkNoResponseActions CWMP actions without a response message carrying the session
+var bulkNoResponseActions = []string{"cwmpDiscoverParameters"}
+
+// Bulk job status
+const (
+	BulkJobRunning = "running"
+	BulkJobPaused  = "paused"
+	BulkJobCancel  = "cancel"
+	BulkJobDone    = "done"
+)
+
+// Bulk job item status
+const (
+	BulkItemPending = "pending"
+	BulkItemRunning = "running"
+	BulkItemSuccess = "success"
+	BulkItemFailure = "failure"
+	BulkItemCancel  = "cancel"
+)
+
+type bulkRun struct {
+	cancel context.CancelFunc
+}
+
+// bulkRuns Runners of the bulk jobs in progress
+var bulkRuns = struct {
+	sync.Mutex
+	jobs map[int64]*bulkRun
+}{jobs: make(map[int64]*bulkRun)}
+
+// bulkWaits Items waiting for the response of the device, keyed by the cwmp session,
+// an empty result is a success, otherwise the error message
+var bulkWaits = struct {
+	sync.Mutex
+	items map[string]chan string
+}{items: make(map[string]chan string)}
+
+func addBulkWait(sessions ...string) chan string {
+	ch := make(chan string, len(sessions))
+	bulkWaits.Lock()
+	for _, session := range sessions {
+		bulkWaits.items[session] = ch
+	}
+	bulkWaits.Unlock()
+	return ch
+}
+
+func removeBulkWait(sessions ...string) {
+	bulkWaits.Lock()
+	for _, session := range sessions {
+		delete(bulkWaits.items, session)
+	}
+	bulkWaits.Unlock()
+}
+
+// deliverBulkResult Wake the item waiting for the session, each session reports once
+func deliverBulkResult(session, result string) {
+	bulkWaits.Lock()
+	ch, ok := bulkWaits.items[session]
+	delete(bulkWaits.items, session)
+	bulkWaits.Unlock()
+	if ok {
+		ch <- result
+	}
+}
+
+// waitBulkResult Wait for the response of each session, the first error fails the item
+func waitBulkResult(ch chan string, sessions ...string) error {
+	defer removeBulkWait(sessions...)
+	timer := time.NewTimer(bulkItemTimeout)
+	defer timer.Stop()
+	for range sessions {
+		select {
+		case result := <-ch:
+			if result != "" {
+				return errors.New(result)
+			}
+		case <-timer.C:
+			return fmt.Errorf("no response from the device within %s", bulkItemTimeout)
+		}
+	}
+	return nil
+}
+
+// startBulkJob Run the pending items of a job in the background
+func startBulkJob(job models.CwmpBulkJob) {
+	bulkRuns.Lock()
+	defer bulkRuns.Unlock()
+	if _, ok := bulkRuns.jobs[job.ID]; ok {
+		return
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	run := &bulkRun{cancel: cancel}
+	bulkRuns.jobs[job.ID] = run
+	go runBulkJob(ctx, run, job)
+}
+
+// stopBulkJob Stop starting new devices, the devices already started complete
+func stopBulkJob(jobId int64) {
+	bulkRuns.Lock()
+	defer bulkRuns.Unlock()
+	if run, ok := bulkRuns.jobs[jobId]; ok {
+		run.cancel()
+		delete(bulkRuns.jobs, jobId)
+	}
+}
+
+// resumeBulkJobs Restart the running jobs whose instance stopped, the ones of this
+// instance at startup and the ones without a heartbeat. Runs on the leader only.
+func resumeBulkJobs(startup bool) {
+	if !app.GApp().IsLeader() {
+		return
+	}
+	node := app.GApp().NodeName()
+	stale := time.Now().Add(-bulkOrphanTimeout)
+	orphan, args := "heartbeat_at is null or heartbeat_at < ?", []interface{}{stale}
+	if startup {
+		orphan, args = orphan+" or runner = ?", append(args, node)
+	}
+	var jobs []models.CwmpBulkJob
+	app.GDB().Where("status = ?", BulkJobRunning).Where(orphan, args...).Find(&jobs)
+	for _, job := range jobs {
+		// claim the job, the previous leader round may have taken it
+		result := app.GDB().Model(&models.CwmpBulkJob{}).
+			Where("id = ? and status = ?", job.ID, BulkJobRunning).Where(orphan, args...).
+			Updates(map[string]interface{}{"runner": node, "heartbeat_at": time.Now()})
+		if result.Error != nil || result.RowsAffected == 0 {
+			continue
+		}
+		log.Infof("resume bulk job %d of %s", job.ID, common.IfEmptyStr(job.Runner, "an unknown instance"))
+		app.GDB().Model(&models.CwmpBulkJobItem{}).
+			Where("job_id = ? and status = ?", job.ID, BulkItemRunning).
+			Update("status", BulkItemPending)
+		startBulkJob(job)
+	}
+}
+
+// bulkJobRunning The job is still running, it may be paused or cancelled on another instance
+func bulkJobRunning(jobId int64) bool {
+	var count int64
+	app.GDB().Model(&models.CwmpBulkJob{}).Where("id = ? and status = ?", jobId, BulkJobRunning).Count(&count)
+	return count > 0
+}
+
+func runBulkJob(ctx context.Context, run *bulkRun, job models.CwmpBulkJob) {
+	defer func() {
+		bulkRuns.Lock()
+		if bulkRuns.jobs[job.ID] == run {
+			delete(bulkRuns.jobs, job.ID)
+		}
+		bulkRuns.Unlock()
+	}()
+
+	concurrency := job.Concurrency
+	if concurrency <= 0 {
+		concurrency = 1
+	}
+	var interval time.Duration
+	if job.Rate > 0 {
+		interval = time.Minute / time.Duration(job.Rate)
+	}
+	sem := make(chan struct{}, concurrency)
+	var wg sync.WaitGroup
+
+	node := app.GApp().NodeName()
+	heartbeat := func() {
+		app.GDB().Model(&models.CwmpBulkJob{}).Where("id = ?", job.ID).
+			Updates(map[string]interface{}{"runner": node, "heartbeat_at": time.Now()})
+	}
+	heartbeat()
+	beatCtx, stopBeat := context.WithCancel(context.Background())
+	defer stopBeat()
+	go func() {
+		ticker := time.NewTicker(bulkHeartbeat)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-beatCtx.Done():
+				return
+			case <-ticker.C:
+				heartbeat()
+			}
+		}
+	}()
+
+loop:
+	for {
+		select {
+		case <-ctx.Done():
+			break loop
+		case sem <- struct{}{}:
+		}
+		if !bulkJobRunning(job.ID) {
+			<-sem
+			break
+		}
+		var item models.CwmpBulkJobItem
+		app.GDB().Where("job_id = ? and status = ?", job.ID, BulkItemPending).
+			Order("id asc").Limit(1).Find(&item)
+		if item.ID == 0 {
+			<-sem
+			break
+		}
+		item.Session = fmt.Sprintf("%s%d-%s", bulkSessionPrefix, job.ID, common.UUID())
+		// the item is claimed once, the runner of a resumed job may still be finishing
+		result := app.GDB().Model(&models.CwmpBulkJobItem{}).
+			Where("id = ? and status = ?", item.ID, BulkItemPending).
+			Updates(map[string]interface{}{
+				"status":     BulkItemRunning,
+				"session":    item.Session,
+				"exec_time":  time.Now(),
+				"updated_at": time.Now(),
+			})
+		if result.Error != nil {
+			log.Errorf("bulk job %d claim item error, %s", job.ID, result.Error.Error())
+			<-sem
+			break
+		}
+		if result.RowsAffected == 0 {
+			<-sem
+			continue
+		}
+		wg.Add(1)
+		go func(item models.CwmpBulkJobItem) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			execBulkJobItem(job, item)
+		}(item)
+
+		if interval > 0 {
+			select {
+			case <-ctx.Done():
+				break loop
+			case <-time.After(interval):
+			}
+		}
+	}
+	wg.Wait()
+	updateBulkJobStats(job.ID)
+	if ctx.Err() == nil {
+		app.GDB().Model(&models.CwmpBulkJob{}).
+			Where("id = ? and status = ?", job.ID, BulkJobRunning).
+			Updates(map[string]interface{}{"status": BulkJobDone, "updated_at": time.Now()})
+	}
+}
+
+func execBulkJobItem(job models.CwmpBulkJob, item models.CwmpBulkJobItem) {
+	defer func() {
+		if err := recover(); err != nil {
+			finishBulkJobItem(item, BulkItemFailure, fmt.Sprint(err))
+		}
+	}()
+	var dev models.NetCpe
+	err := app.GDB().Where("id = ?", item.CpeId).First(&dev).Error
+	if err != nil || common.IsEmptyOrNA(dev.Sn) {
+		finishBulkJobItem(item, BulkItemFailure, "device not found")
+		return
+	}
+
+	switch job.ActionType {
+	case "cwmp":
+		if common.InSlice(job.ActionId, bulkNoResponseActions) {
+			err = runCwmpAction(job.ActionId, dev, item.Session)
+			break
+		}
+		ch := addBulkWait(item.Session)
+		err = runCwmpAction(job.ActionId, dev, item.Session)
+		if err == nil {
+			err = waitBulkResult(ch, item.Session)
+		}
+		removeBulkWait(item.Session)
+	case "cwmpconfig":
+		var script models.CwmpConfig
+		err = app.GDB().Where("id = ?", job.ActionId).First(&script).Error
+		if err == nil {
+			err = checkCwmpConfig(script, dev)
+		}
+		if err == nil {
+			ch := addBulkWait(item.Session)
+			err = sendCwmpConfig(script, dev, item.Session)
+			if err == nil {
+				err = waitBulkResult(ch, item.Session)
+			}
+			removeBulkWait(item.Session)
+		}
+	case "webcreds":
+		err = pushDeviceSettings(dev, item.Session)
+	case "console":
+		var set models.ConsoleCommandSet
+		err = app.GDB().Where("id = ?", cast.ToInt64(job.ActionId)).First(&set).Error
+		if err == nil {
+			_, err = app.GApp().RunDeviceCommands(dev, set.Content, set.Timeout, job.ID, item.Session, job.Operator)
+		}
+		if err == nil {
+			finishBulkJobItem(item, BulkItemSuccess, "Commands completed")
+			return
+		}
+	default:
+		err = fmt.Errorf("unsupported action type %s", job.ActionType)
+	}
+	if err != nil {
+		finishBulkJobItem(item, BulkItemFailure, err.Error())
+		return
+	}
+	if job.ActionType == "cwmp" && common.InSlice(job.ActionId, bulkNoResponseActions) {
+		finishBulkJobItem(item, BulkItemSuccess, "The instruction has been sent")
+		return
+	}
+	finishBulkJobItem(item, BulkItemSuccess, "The device has responded")
+}
+
+// pushDeviceSettings Push the web credentials and the periodic inform settings,
+// then wait for the device to confirm them
+func pushDeviceSettings(dev models.NetCpe, session string) error {
+	cpe := app.GApp().CwmpTable().GetCwmpCpe(dev.Sn)
+	credsSession, informSession := session+"-creds", session+"-inform"
+	// the connection request errors are reported on the item session
+	ch := addBulkWait(session, credsSession, informSession)
+	defer removeBulkWait(session, credsSession, informSession)
+	if err := cpe.PushWebCredentials(credsSession, 1000, false); err != nil {
+		return fmt.Errorf("push web credentials error %s", err.Error())
+	}
+	if err := cpe.PushPeriodicInform(informSession, 1000, false); err != nil {
+		return fmt.Errorf("push periodic inform error %s", err.Error())
+	}
+	var sessions = []string{informSession}
+	// no web credentials are pushed when none is configured
+	var count int64
+	app.GDB().Model(&models.CwmpQueueItem{}).Where("session = ?", credsSession).Count(&count)
+	if count > 0 {
+		sessions = append(sessions, credsSession)
+	}
+	go connectDeviceAuth(session, dev)
+	return waitBulkResult(ch, sessions...)
+}
+
+// finishBulkJobItem Save the item result, an error already reported
+// through the supervise log is not overwritten by success
+func finishBulkJobItem(item models.CwmpBulkJobItem, status, message string) {
+	query := app.GDB().Model(&models.CwmpBulkJobItem{}).Where("id = ?", item.ID)
+	if status == BulkItemSuccess {
+		query = query.Where("status = ?", BulkItemRunning)
+	}
+	err := query.Updates(map[string]interface{}{
+		"status":     status,
+		"message":    message,
+		"updated_at": time.Now(),
+	}).Error
+	if err != nil {
+		log.Errorf("finishBulkJobItem: %s", err.Error())
+	}
+	updateBulkJobStats(item.JobId)
+}
+
+// onBulkCwmpStatus Wake the item when the device answers the message of the session
+func onBulkCwmpStatus(sn, session, level, message string) {
+	if !strings.HasPrefix(session, bulkSessionPrefix) {
+		return
+	}
+	switch {
+	case level == "error":
+		deliverBulkResult(session, message)
+	case strings.HasPrefix(message, "Recv Cwmp"):
+		deliverBulkResult(session, "")
+	}
+}
+
+// onBulkSuperviseLog Mark the item failed when its action reports an error
+func onBulkSuperviseLog(devid int64, session, level, message string) {
+	if level != "error" || !strings.HasPrefix(session, bulkSessionPrefix) {
+		return
+	}
+	deliverBulkResult(session, message)
+	app.GDB().Model(&models.CwmpBulkJobItem{}).Where("session = ?", session).
+		Updates(map[string]interface{}{
+			"status":     BulkItemFailure,
+			"message":    message,
+			"updated_at": time.Now(),
+		})
+	jobId := strings.SplitN(strings.TrimPrefix(session, bulkSessionPrefix), "-", 2)[0]
+	updateBulkJobStats(cast.ToInt64(jobId))
+}
+
+func updateBulkJobStats(jobId int64) {
+	type statusCount struct {
+		Status string
+		Total  int64
+	}
+	var counts []statusCount
+	app.GDB().Model(&models.CwmpBulkJobItem{}).
+		Select("status, count(*) as total").
+		Where("job_id = ?", jobId).Group("status").Scan(&counts)
+	var success, failure int64
+	for _, sc := range counts {
+		switch sc.Status {
+		case BulkItemSuccess:
+			success = sc.Total
+		case BulkItemFailure:
+			failure = sc.Total
+		}
+	}
+	app.GDB().Model(&models.CwmpBulkJob{}).Where("id = ?", jobId).Updates(map[string]interface{}{
+		"success":    success,
+		"failure":    failure,
+		"updated_at": time.Now(),
+	})
+}
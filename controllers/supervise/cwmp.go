@@ -10,6 +10,7 @@ import (
 	"github.com/ca17/teamsacs/common"
 	"github.com/ca17/teamsacs/common/cwmp"
 	"github.com/ca17/teamsacs/common/web"
+	"github.com/ca17/teamsacs/common/xmpp"
 	"github.com/ca17/teamsacs/common/zaplog/log"
 	"github.com/ca17/teamsacs/events"
 	"github.com/ca17/teamsacs/models"
@@ -20,8 +21,10 @@ var cwmpCmds = []SuperviseAction{
 	{Name: "Test cpe cwmp connection", Type: "cwmp", Level: "normal", Sid: "cwmpDeviceConnectTest"},
 	{Name: "Get the list of RPC methods", Type: "cwmp", Level: "normal", Sid: "cwmpGetRPCMethods"},
 	{Name: "Get a list of parameter prefixes", Type: "cwmp", Level: "normal", Sid: "cwmpGetParameterNames"},
+	{Name: "Discover the full parameter tree", Type: "cwmp", Level: "normal", Sid: "cwmpDiscoverParameters"},
 	{Name: "Get and update device information", Type: "cwmp", Level: "normal", Sid: "cwmpDeviceInfoUpdate"},
 	{Name: "Configure device authentication information", Type: "cwmp", Level: "normal", Sid: "cwmpDeviceManagementAuthUpdate"},
+	{Name: "Rotate connection request password", Type: "cwmp", Level: "normal", Sid: "cwmpRotateConnReqAuth"},
 	{Name: "Upload device logs", Type: "cwmp", Level: "normal", Sid: "cwmpDeviceUploadLog"},
 	{Name: "Upload device backup (text)", Type: "cwmp", Level: "normal", Sid: "cwmpDeviceBackup"},
 	{Name: "Download the factory configuration", Type: "cwmp", Level: "major", Sid: "cwmpFactoryConfiguration"},
@@ -40,73 +43,138 @@ func execCwmp(c echo.Context, id string, deviceId int64, session string) error {
 		return c.JSON(http.StatusOK, web.RestError(fmt.Sprintf("Device SN %s invalid", dev.Sn)))
 	}
 
-	switch id {
-	case "cwmpFactoryConfiguration":
+	if id == "cwmpFactoryConfiguration" {
 		return execCwmpFactoryConfiguration(c, id, deviceId, session)
+	}
+	go func() {
+		if err := runCwmpAction(id, dev, session); err != nil {
+			events.PubSuperviseLog(dev.ID, session, "error", err.Error())
+		}
+	}()
+	return c.JSON(200, web.RestSucc("The instruction has been sent, please check the execution log later, please do not execute it repeatedly in a short time"))
+
+}
+
+// runCwmpAction Run a cwmp supervise action on one device, the result is published to the supervise log
+func runCwmpAction(id string, dev models.NetCpe, session string) error {
+	switch id {
 	case "cwmpReboot":
-		go cwmpDeviceReboot(id, dev, session)
+		cwmpDeviceReboot(id, dev, session)
 	case "cwmpFactoryReset":
-		go cwmpDeviceFactoryReset(id, dev, session)
+		cwmpDeviceFactoryReset(id, dev, session)
 	case "cwmpDeviceInfoUpdate":
-		go cwmpDeviceInfoUpdate(id, dev, session)
+		cwmpDeviceInfoUpdate(id, dev, session)
 	case "cwmpDeviceManagementAuthUpdate":
-		go cwmpDeviceManagementAuthUpdate(id, dev, session)
+		cwmpDeviceManagementAuthUpdate(id, dev, session)
+	case "cwmpRotateConnReqAuth":
+		cwmpRotateConnReqAuth(id, dev, session)
 	case "cwmpDeviceConnectTest":
-		go cwmpDeviceConnectTest(id, dev, session)
+		cwmpDeviceConnectTest(id, dev, session)
 	case "cwmpGetParameterNames":
-		go cwmpGetParameterNames(id, dev, session)
+		cwmpGetParameterNames(id, dev, session)
+	case "cwmpDiscoverParameters":
+		cwmpDiscoverParameters(id, dev, session)
 	case "cwmpGetRPCMethods":
-		go cwmpGetRPCMethods(id, dev, session)
+		cwmpGetRPCMethods(id, dev, session)
 	case "cwmpDeviceBackup":
-		go cwmpDeviceBackup(id, dev, session)
+		cwmpDeviceBackup(id, dev, session)
 	case "cwmpDeviceUploadLog":
-		go cwmpDeviceUploadLog(id, dev, session)
+		cwmpDeviceUploadLog(id, dev, session)
 	case "cwmpOntOpticalInfo":
-		go cwmpOntOpticalInfo(id, dev, session)
+		cwmpOntOpticalInfo(id, dev, session)
 	case "cwmpOntWanInfo":
-		go cwmpOntWanInfo(id, dev, session)
+		cwmpOntWanInfo(id, dev, session)
 	case "cwmpWifiSsid":
-		go cwmpWifiSsid(id, dev, session)
+		cwmpWifiSsid(id, dev, session)
+	default:
+		return fmt.Errorf("unsupported cwmp action %s", id)
 	}
-	return c.JSON(200, web.RestSucc("The instruction has been sent, please check the execution log later, please do not execute it repeatedly in a short time"))
-
+	return nil
 }
 
+// connectDeviceAuth Wake the device with a connection request, using only the credentials
+// confirmed by the device: XMPP when it is online there, then HTTP, then the TR-111 UDP binding
 func connectDeviceAuth(session string, dev models.NetCpe) {
-	if dev.CwmpUrl == "" {
+	if dev.CwmpUrl == "" && dev.UdpConnReqAddr == "" && dev.XmppJid == "" {
 		log.Infof("connectDeviceAuth: no CwmpUrl for sn=%s", dev.Sn)
 		events.PubSuperviseLog(dev.ID, session, "error", "CPE ConnectionRequestURL is empty, device may not be online or never sent Inform")
 		return
 	}
-	configuredPass := app.GApp().GetTr069SettingsStringValue("CpeConnectionRequestPassword")
-
-	// Try configured credentials first, then common factory defaults
-	type cred struct{ user, pass string }
-	attempts := []cred{
-		{dev.Sn, configuredPass},
-		{"cpe", "cpe"},
-		{"admin", "admin"},
-		{dev.Sn, ""},
-		{"", ""},
+	username, password := app.GApp().ConnReqCredential(dev.Sn)
+	// Devices with a persistent XMPP channel do not need inbound connections
+	if dev.XmppJid != "" && connectDeviceXmpp(session, dev, username, password) {
+		return
 	}
-
-	for _, c := range attempts {
-		log.Infof("connectDeviceAuth: trying Connection Request to %s user=%s session=%s", dev.CwmpUrl, c.user, session)
-		isok, err := cwmp.ConnectionRequestAuth(c.user, c.pass, dev.CwmpUrl)
-		if err != nil {
-			log.Infof("connectDeviceAuth: FAILED %s user=%s err=%s", dev.CwmpUrl, c.user, err.Error())
-			continue
-		}
-		if isok {
-			log.Infof("connectDeviceAuth: SUCCESS with user=%s - CPE should be connecting now %s", c.user, dev.CwmpUrl)
-			events.PubSuperviseLog(dev.ID, session, "info", fmt.Sprintf("TR069 Connection Request success (user=%s) - CPE %s should connect immediately", c.user, dev.CwmpUrl))
+	if dev.CwmpUrl == "" {
+		if dev.UdpConnReqAddr == "" {
+			events.PubSuperviseLog(dev.ID, session, "error",
+				fmt.Sprintf("TR069 XMPP Connection Request to %s failed, device has no ConnectionRequestURL", dev.XmppJid))
 			return
 		}
-		log.Infof("connectDeviceAuth: REJECTED with user=%s %s, trying next", c.user, dev.CwmpUrl)
+		connectDeviceUdp(session, dev, username, password)
+		return
+	}
+
+	log.Infof("connectDeviceAuth: Connection Request to %s user=%s session=%s", dev.CwmpUrl, username, session)
+	start := time.Now()
+	isok, err := cwmp.ConnectionRequestAuth(username, password, dev.CwmpUrl)
+	if err == nil && isok {
+		app.GApp().RecordConnReqResult(dev.Sn, "http", true, time.Since(start), "")
+		log.Infof("connectDeviceAuth: SUCCESS with user=%s - CPE should be connecting now %s", username, dev.CwmpUrl)
+		events.PubSuperviseLog(dev.ID, session, "info", fmt.Sprintf("TR069 Connection Request success (user=%s) - CPE %s should connect immediately", username, dev.CwmpUrl))
+		return
+	}
+	reason := fmt.Sprintf("credentials of user %s rejected", username)
+	if err != nil {
+		reason = err.Error()
+	}
+	app.GApp().RecordConnReqResult(dev.Sn, "http", false, time.Since(start), reason)
+	log.Infof("connectDeviceAuth: FAILED %s user=%s %s", dev.CwmpUrl, username, reason)
+
+	// Devices behind NAT are only reachable through the TR-111 binding
+	if dev.UdpConnReqAddr != "" {
+		log.Infof("connectDeviceAuth: HTTP Connection Request to %s failed, falling back to UDP %s", dev.CwmpUrl, dev.UdpConnReqAddr)
+		connectDeviceUdp(session, dev, username, password)
+		return
 	}
 
 	events.PubSuperviseLog(dev.ID, session, "warn",
-		fmt.Sprintf("TR069 Connection Request to %s failed with all credential attempts", dev.CwmpUrl))
+		fmt.Sprintf("TR069 Connection Request to %s failed: %s", dev.CwmpUrl, reason))
+}
+
+// connectDeviceXmpp TR-069 Annex K connection request over the embedded XMPP server,
+// false when the device is not online there so the caller falls back to HTTP
+func connectDeviceXmpp(session string, dev models.NetCpe, username, password string) bool {
+	start := time.Now()
+	err := app.GApp().XmppConnectionRequest(dev.XmppJid, username, password)
+	if err != nil {
+		if err != xmpp.ErrNotOnline {
+			app.GApp().RecordConnReqResult(dev.Sn, "xmpp", false, time.Since(start), err.Error())
+		}
+		log.Infof("connectDeviceXmpp: FAILED %s err=%s", dev.XmppJid, err.Error())
+		return false
+	}
+	app.GApp().RecordConnReqResult(dev.Sn, "xmpp", true, time.Since(start), "")
+	events.PubSuperviseLog(dev.ID, session, "info",
+		fmt.Sprintf("TR069 XMPP Connection Request success - CPE %s should connect immediately", dev.XmppJid))
+	return true
+}
+
+// connectDeviceUdp TR-111 UDP connection request to the address learned by STUN,
+// UDP has no reply so success means the message was sent
+func connectDeviceUdp(session string, dev models.NetCpe, username, password string) {
+	start := time.Now()
+	err := cwmp.UDPConnectionRequest(username, password, dev.UdpConnReqAddr)
+	if err != nil {
+		app.GApp().RecordConnReqResult(dev.Sn, "udp", false, time.Since(start), err.Error())
+		log.Infof("connectDeviceUdp: FAILED %s err=%s", dev.UdpConnReqAddr, err.Error())
+		events.PubSuperviseLog(dev.ID, session, "error",
+			fmt.Sprintf("TR069 UDP Connection Request to %s failed %s", dev.UdpConnReqAddr, err.Error()))
+		return
+	}
+	app.GApp().RecordConnReqResult(dev.Sn, "udp", true, time.Since(start), "")
+	events.PubSuperviseLog(dev.ID, session, "info",
+		fmt.Sprintf("TR069 UDP Connection Request sent to %s - CPE should connect shortly", dev.UdpConnReqAddr))
 }
 
 func cwmpDeviceInfoUpdate(sid string, dev models.NetCpe, session string) {
@@ -118,10 +186,10 @@ func cwmpDeviceInfoUpdate(sid string, dev models.NetCpe, session string) {
 			ID:     session,
 			Name:   "",
 			NoMore: 0,
-			ParameterNames: []string{
+			ParameterNames: cpe.TranslatePaths([]string{
 				"Device.DeviceInfo.",
 				"Device.ManagementServer.",
-			},
+			}),
 		},
 	}, 5000, true)
 	if err != nil {
@@ -145,6 +213,33 @@ func cwmpDeviceManagementAuthUpdate(sid string, dev models.NetCpe, session strin
 
 }
 
+// cwmpRotateConnReqAuth Push a new connection request password, the device is woken
+// with the current one and the new one is used after the device confirms it
+func cwmpRotateConnReqAuth(sid string, dev models.NetCpe, session string) {
+	err := app.GApp().RotateConnReqCredential(dev.Sn)
+	if err != nil {
+		events.PubSuperviseLog(dev.ID, session, "error", fmt.Sprintf("TR069 Rotate connection request password error %s", err.Error()))
+		return
+	}
+	cpe := app.GApp().CwmpTable().GetCwmpCpe(dev.Sn)
+	err = cpe.UpdateManagementAuthInfo(session, 5000, true)
+	if err != nil {
+		events.PubSuperviseLog(dev.ID, session, "error", fmt.Sprintf("TR069 Update device management authentication information push timeout %s", err.Error()))
+		return
+	}
+	// the STUN and XMPP passwords follow the connection request password
+	err = cpe.PushStunConfig(session+"-stun", 5000, false)
+	if err != nil {
+		events.PubSuperviseLog(dev.ID, session, "error", fmt.Sprintf("TR069 Update STUN password push timeout %s", err.Error()))
+	}
+	err = cpe.PushXmppConfig(session+"-xmpp", 5000, false)
+	if err != nil {
+		events.PubSuperviseLog(dev.ID, session, "error", fmt.Sprintf("TR069 Update XMPP password push timeout %s", err.Error()))
+	}
+
+	go connectDeviceAuth(session, dev)
+}
+
 func cwmpDeviceConnectTest(sid string, dev models.NetCpe, session string) {
 	cpe := app.GApp().CwmpTable().GetCwmpCpe(dev.Sn)
 	err := cpe.SendCwmpEventData(models.CwmpEventData{
@@ -154,9 +249,9 @@ func cwmpDeviceConnectTest(sid string, dev models.NetCpe, session string) {
 			ID:     session,
 			Name:   "test connection",
 			NoMore: 0,
-			ParameterNames: []string{
+			ParameterNames: cpe.TranslatePaths([]string{
 				"Device.DeviceInfo.",
-			},
+			}),
 		},
 	}, 5000, true)
 	if err != nil {
@@ -178,7 +273,7 @@ func cwmpGetParameterNames(sid string, dev models.NetCpe, session string) {
 			ID:            session,
 			Name:          "GetParameterNames",
 			NoMore:        0,
-			ParameterPath: "Device.",
+			ParameterPath: cpe.TranslatePath("Device."),
 			NextLevel:     "true",
 		},
 	}, 5000, true)
@@ -192,6 +287,21 @@ func cwmpGetParameterNames(sid string, dev models.NetCpe, session string) {
 
 }
 
+func cwmpDiscoverParameters(sid string, dev models.NetCpe, session string) {
+	cpe := app.GApp().CwmpTable().GetCwmpCpe(dev.Sn)
+	err := cpe.StartParamDiscovery()
+	if err != nil {
+		events.PubSuperviseLog(dev.ID, session, "error",
+			fmt.Sprintf("CWMP parameter discovery start failed %s", err.Error()))
+		return
+	}
+	events.PubSuperviseLog(dev.ID, session, "info",
+		"CWMP parameter discovery started, the tree is walked over the next device sessions")
+
+	go connectDeviceAuth(session, dev)
+
+}
+
 func cwmpGetRPCMethods(sid string, dev models.NetCpe, session string) {
 	cpe := app.GApp().CwmpTable().GetCwmpCpe(dev.Sn)
 	err := cpe.SendCwmpEventData(models.CwmpEventData{
@@ -333,9 +443,9 @@ func cwmpOntOpticalInfo(sid string, dev models.NetCpe, session string) {
 			ID:     session,
 			Name:   "GetOntOpticalInfo",
 			NoMore: 0,
-			ParameterNames: []string{
+			ParameterNames: cpe.TranslatePaths([]string{
 				"Device.Optical.",
-			},
+			}),
 		},
 	}, 5000, true)
 	if err != nil {
@@ -356,11 +466,12 @@ func cwmpOntWanInfo(sid string, dev models.NetCpe, session string) {
 			ID:     session,
 			Name:   "GetOntWanInfo",
 			NoMore: 0,
-			ParameterNames: []string{
+			ParameterNames: cpe.TranslatePaths([]string{
 				"Device.IP.",
 				"Device.PPP.",
 				"Device.Ethernet.",
-			},
+				"InternetGatewayDevice.WANDevice.",
+			}),
 		},
 	}, 5000, true)
 	if err != nil {
@@ -374,11 +485,7 @@ func cwmpOntWanInfo(sid string, dev models.NetCpe, session string) {
 // cwmpWifiSsid retrieves WiFi SSID from the device
 func cwmpWifiSsid(sid string, dev models.NetCpe, session string) {
 	cpe := app.GApp().CwmpTable().GetCwmpCpe(dev.Sn)
-	// Try both TR-181 and TR-098 WiFi paths
-	paramNames := []string{
-		"Device.WiFi.",
-		"InternetGatewayDevice.LANDevice.1.WLANConfiguration.",
-	}
+	paramNames := cpe.TranslatePaths([]string{"Device.WiFi."})
 	err := cpe.SendCwmpEventData(models.CwmpEventData{
 		Session: session,
 		Sn:      dev.Sn,
@@ -397,22 +504,72 @@ func cwmpWifiSsid(sid string, dev models.NetCpe, session string) {
 	go connectDeviceAuth(session, dev)
 }
 
+// cwmpSetParameterValue sends a single SetParameterValues to the device,
+// virtual parameters and TR-181 names are resolved for the device first
+func cwmpSetParameterValue(dev models.NetCpe, name, vtype, value string) (string, error) {
+	cpe := app.GApp().CwmpTable().GetCwmpCpe(dev.Sn)
+	var path string
+	var err error
+	if app.IsVirtualParam(name) {
+		path, vtype, err = cpe.ResolveVirtualParamSet(name)
+	} else {
+		path, err = cpe.MapPath(name)
+	}
+	if err != nil {
+		return "", err
+	}
+	params := map[string]cwmp.ValueStruct{path: {Type: vtype, Value: value}}
+	if err := cpe.PrepareParameterValues(params); err != nil {
+		return "", err
+	}
+	session := fmt.Sprintf("Param-SetParameterValues-%s", common.UUID())
+	err = cpe.SendCwmpEventData(models.CwmpEventData{
+		Session: session,
+		Sn:      dev.Sn,
+		Message: &cwmp.SetParameterValues{ID: session, NoMore: 0, Params: params},
+	}, 5000, true)
+	if err != nil {
+		return "", err
+	}
+	go connectDeviceAuth(session, dev)
+	return path, nil
+}
+
 // cwmpSetWifiParams creates separate CwmpPresetTasks for each param group
 // and sends them directly to CPE via channel for immediate execution
 func cwmpSetWifiParams(dev models.NetCpe, ssidIdx int, ssid, password, channel, enable string) error {
-	prefix := fmt.Sprintf("InternetGatewayDevice.LANDevice.1.WLANConfiguration.%d.", ssidIdx)
 	cpe := app.GApp().CwmpTable().GetCwmpCpe(dev.Sn)
 	taskCount := 0
 
-	// Task 1: SSID + Password
-	ssidParams := make(map[string]cwmp.ValueStruct)
-	if ssid != "" {
-		ssidParams[prefix+"SSID"] = cwmp.ValueStruct{Type: "xsd:string", Value: ssid}
+	// wifiParams resolves the virtual parameters of the SSID, the optional ones
+	// are skipped when the data model of the device has no mapping
+	wifiParams := func(values map[string]string, optional ...string) (map[string]cwmp.ValueStruct, error) {
+		params := make(map[string]cwmp.ValueStruct)
+		for vname, value := range values {
+			if value == "" {
+				continue
+			}
+			path, vtype, err := cpe.ResolveVirtualParamSet(fmt.Sprintf("%s%s.%d", app.VirtualParamPrefix, vname, ssidIdx))
+			if err != nil {
+				if common.InSlice(vname, optional) {
+					continue
+				}
+				return nil, err
+			}
+			params[path] = cwmp.ValueStruct{Type: vtype, Value: value}
+		}
+		return params, nil
 	}
-	if password != "" {
-		ssidParams[prefix+"KeyPassphrase"] = cwmp.ValueStruct{Type: "xsd:string", Value: password}
+
+	// Task 1: SSID + Password
+	ssidParams, err := wifiParams(map[string]string{"WifiSSID": ssid, "WifiPassword": password})
+	if err != nil {
+		return err
 	}
 	if len(ssidParams) > 0 {
+		if err := cpe.PrepareParameterValues(ssidParams); err != nil {
+			return err
+		}
 		session := fmt.Sprintf("Wifi-SetWifiSSID-%s", common.UUID())
 		msg := &cwmp.SetParameterValues{ID: session, NoMore: 0, Params: ssidParams}
 		// Send directly to CPE channel for immediate execution
@@ -433,17 +590,20 @@ func cwmpSetWifiParams(dev models.NetCpe, ssidIdx int, ssid, password, channel,
 
 	// Task 2: Channel (separate — CPE rejects when combined with SSID)
 	if channel != "" {
-		chParams := make(map[string]cwmp.ValueStruct)
-		chParams[prefix+"Channel"] = cwmp.ValueStruct{Type: "xsd:unsignedInt", Value: channel}
-		if channel == "0" {
-			chParams[prefix+"AutoChannelEnable"] = cwmp.ValueStruct{Type: "xsd:boolean", Value: "true"}
-		} else {
-			chParams[prefix+"AutoChannelEnable"] = cwmp.ValueStruct{Type: "xsd:boolean", Value: "false"}
+		chParams, err := wifiParams(map[string]string{
+			"WifiChannel":     channel,
+			"WifiAutoChannel": common.If(channel == "0", "true", "false").(string),
+		})
+		if err != nil {
+			return err
+		}
+		if err := cpe.PrepareParameterValues(chParams); err != nil {
+			return err
 		}
 		session := fmt.Sprintf("Wifi-SetWifiChannel-%s", common.UUID())
 		msg := &cwmp.SetParameterValues{ID: session, NoMore: 0, Params: chParams}
 		// Send directly to CPE channel for immediate execution
-		err := cpe.SendCwmpEventData(models.CwmpEventData{
+		err = cpe.SendCwmpEventData(models.CwmpEventData{
 			Session: session,
 			Sn:      dev.Sn,
 			Message: msg,
@@ -460,15 +620,20 @@ func cwmpSetWifiParams(dev models.NetCpe, ssidIdx int, ssid, password, channel,
 
 	// Task 3: Enable + BeaconType (separate — CPE rejects when combined)
 	if enable == "true" || enable == "false" {
-		enParams := make(map[string]cwmp.ValueStruct)
-		enParams[prefix+"Enable"] = cwmp.ValueStruct{Type: "xsd:boolean", Value: enable}
-		if enable == "true" {
-			enParams[prefix+"BeaconType"] = cwmp.ValueStruct{Type: "xsd:string", Value: "WPAand11i"}
+		enParams, err := wifiParams(map[string]string{
+			"WifiEnable":     enable,
+			"WifiBeaconType": common.If(enable == "true", "WPAand11i", "").(string),
+		}, "WifiBeaconType")
+		if err != nil {
+			return err
+		}
+		if err := cpe.PrepareParameterValues(enParams); err != nil {
+			return err
 		}
 		session := fmt.Sprintf("Wifi-SetWifiEnable-%s", common.UUID())
 		msg := &cwmp.SetParameterValues{ID: session, NoMore: 0, Params: enParams}
 		// Send directly to CPE channel for immediate execution
-		err := cpe.SendCwmpEventData(models.CwmpEventData{
+		err = cpe.SendCwmpEventData(models.CwmpEventData{
 			Session: session,
 			Sn:      dev.Sn,
 			Message: msg,
@@ -539,6 +704,9 @@ func cwmpSetWanParams(dev models.NetCpe, devIdx, connIdx int, connType, username
 		if password != "" {
 			authParams[connPath+"Password"] = cwmp.ValueStruct{Type: "xsd:string", Value: password}
 		}
+		if err := cpe.PrepareParameterValues(authParams); err != nil {
+			return err
+		}
 		session := fmt.Sprintf("Wan-SetWanAuth-%s", common.UUID())
 		msg := &cwmp.SetParameterValues{ID: session, NoMore: 0, Params: authParams}
 		// Send directly to CPE channel for immediate execution
@@ -564,6 +732,9 @@ func cwmpSetWanParams(dev models.NetCpe, devIdx, connIdx int, connType, username
 		vlanParams[devPath+"X_CT-COM_WANGponLinkConfig.VLANIDMark"] = cwmp.ValueStruct{Type: "xsd:unsignedInt", Value: vlanID}
 		// Also set at connection level
 		vlanParams[connPath+"X_CT-COM_VLANIDMark"] = cwmp.ValueStruct{Type: "xsd:unsignedInt", Value: vlanID}
+		if err := cpe.PrepareParameterValues(vlanParams); err != nil {
+			return err
+		}
 		session := fmt.Sprintf("Wan-SetWanVLAN-%s", common.UUID())
 		msg := &cwmp.SetParameterValues{ID: session, NoMore: 0, Params: vlanParams}
 		// Send directly to CPE channel for immediate execution
@@ -597,6 +768,9 @@ func cwmpSetWanParams(dev models.NetCpe, devIdx, connIdx int, connType, username
 		}
 		ipParams := make(map[string]cwmp.ValueStruct)
 		ipParams[connPath+"X_CT-COM_IPMode"] = cwmp.ValueStruct{Type: "xsd:unsignedInt", Value: ipModeNumeric}
+		if err := cpe.PrepareParameterValues(ipParams); err != nil {
+			return err
+		}
 		session := fmt.Sprintf("Wan-SetWanIPMode-%s", common.UUID())
 		msg := &cwmp.SetParameterValues{ID: session, NoMore: 0, Params: ipParams}
 		// Send directly to CPE channel for immediate execution
@@ -619,6 +793,9 @@ func cwmpSetWanParams(dev models.NetCpe, devIdx, connIdx int, connType, username
 	if enable == "true" || enable == "false" {
 		enParams := make(map[string]cwmp.ValueStruct)
 		enParams[connPath+"Enable"] = cwmp.ValueStruct{Type: "xsd:boolean", Value: enable}
+		if err := cpe.PrepareParameterValues(enParams); err != nil {
+			return err
+		}
 		session := fmt.Sprintf("Wan-SetWanEnable-%s", common.UUID())
 		msg := &cwmp.SetParameterValues{ID: session, NoMore: 0, Params: enParams}
 		// Send directly to CPE channel for immediate execution
@@ -34,7 +34,8 @@ func execCwmpFactoryConfiguration(c echo.Context, id string, deviceId int64, ses
 	}
 
 	cpe := app.GApp().CwmpTable().GetCwmpCpe(dev.Sn)
-	if !app.GApp().MatchDevice(dev, factscript.Oui, factscript.ProductClass, factscript.SoftwareVersion) {
+	if !app.GApp().MatchDevice(dev, factscript.Oui, factscript.ProductClass, factscript.SoftwareVersion) ||
+		!cpe.MatchDeviceGroup(factscript.DeviceGroup) {
 		return c.JSON(http.StatusOK, web.RestError(fmt.Sprintf("Device %s Does not match CwmpFactoryReset", dev.Sn)))
 	}
 
@@ -41,7 +41,8 @@ func execCwmpUpdateFirmware(c echo.Context, devids []string, firmwareId, session
 		}
 
 		cpe := app.GApp().CwmpTable().GetCwmpCpe(dev.Sn)
-		if !app.GApp().MatchDevice(dev, firmwareCfg.Oui, firmwareCfg.ProductClass, firmwareCfg.SoftwareVersion) {
+		if !app.GApp().MatchDevice(dev, firmwareCfg.Oui, firmwareCfg.ProductClass, firmwareCfg.SoftwareVersion) ||
+			!cpe.MatchDeviceGroup(firmwareCfg.DeviceGroup) {
 			events.PubSuperviseLog(dev.ID, session, "error",
 				fmt.Sprintf("cpe %s not match CwmpFirmwareConfig", dev.Sn))
 			continue
@@ -0,0 +1,87 @@
+package supervise
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/ca17/teamsacs/app"
+	"github.com/ca17/teamsacs/common"
+	"github.com/ca17/teamsacs/common/gis"
+	"github.com/ca17/teamsacs/common/web"
+	"github.com/ca17/teamsacs/webserver"
+	"github.com/labstack/echo/v4"
+)
+
+func initGisRouter() {
+
+	// Network plant as GeoJSON, kinds=olt,odc,odp,cpe,cable and bbox=minLng,minLat,maxLng,maxLat filter it
+	webserver.GET("/admin/gis/geojson", func(c echo.Context) error {
+		query, err := gisQuery(c)
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, web.RestError(err.Error()))
+		}
+		features, err := app.GApp().GisFeatures(query)
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, web.RestError(err.Error()))
+		}
+		data, err := gis.GeoJSON(app.GisStyles, features)
+		common.Must(err)
+		c.Response().Header().Set("Content-Disposition", "attachment;filename=teamsacs-plant.geojson")
+		return c.Blob(http.StatusOK, "application/geo+json", data)
+	})
+
+	// Network plant as KML for Google Earth, same filters as the GeoJSON export
+	webserver.GET("/admin/gis/kml", func(c echo.Context) error {
+		query, err := gisQuery(c)
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, web.RestError(err.Error()))
+		}
+		features, err := app.GApp().GisFeatures(query)
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, web.RestError(err.Error()))
+		}
+		data, err := gis.KML("TeamsACS network plant", app.GisStyles, features)
+		common.Must(err)
+		c.Response().Header().Set("Content-Disposition", "attachment;filename=teamsacs-plant.kml")
+		return c.Blob(http.StatusOK, "application/vnd.google-earth.kml+xml", data)
+	})
+
+	// Bulk coordinates import, a CSV upload or the data form value with
+	// kind,key,latitude,longitude lines
+	webserver.POST("/admin/gis/import", func(c echo.Context) error {
+		var updated int
+		var errs []string
+		if file, err := c.FormFile("upload"); err == nil {
+			src, err := file.Open()
+			common.Must(err)
+			defer src.Close()
+			updated, errs = app.GApp().ImportGisCoordinates(src)
+		} else {
+			updated, errs = app.GApp().ImportGisCoordinates(strings.NewReader(c.FormValue("data")))
+		}
+		webserver.PubOpLog(c, "Import GIS coordinates")
+		return c.JSON(http.StatusOK, web.RestResult(map[string]interface{}{
+			"updated": updated,
+			"errors":  errs,
+		}))
+	})
+}
+
+func gisQuery(c echo.Context) (app.GisQuery, error) {
+	query := app.GisQuery{Kinds: make(map[string]bool)}
+	kinds := app.GisKinds
+	if v := c.QueryParam("kinds"); v != "" {
+		kinds = strings.Split(v, ",")
+	}
+	for _, kind := range kinds {
+		query.Kinds[strings.TrimSpace(kind)] = true
+	}
+	if v := c.QueryParam("bbox"); v != "" {
+		bbox, err := gis.ParseBBox(v)
+		if err != nil {
+			return query, err
+		}
+		query.BBox = bbox
+	}
+	return query, nil
+}
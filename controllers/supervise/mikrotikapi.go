@@ -0,0 +1,375 @@
+package supervise
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"path"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/ca17/teamsacs/app"
+	"github.com/ca17/teamsacs/common"
+	"github.com/ca17/teamsacs/common/cwmp"
+	"github.com/ca17/teamsacs/common/routeros"
+	"github.com/ca17/teamsacs/common/web"
+	"github.com/ca17/teamsacs/events"
+	"github.com/ca17/teamsacs/models"
+	"github.com/ca17/teamsacs/webserver"
+	"github.com/labstack/echo/v4"
+	"github.com/spf13/cast"
+)
+
+// RouterOS API actions, for what TR-069 does poorly on Mikrotik devices.
+// The actions fall back to TR-069 when the API is not reachable.
+var mikrotikApiCmds = []SuperviseAction{
+	{Name: "Test RouterOS API connection", Type: "mikrotikapi", Level: "normal", Sid: "mikrotikApiTest"},
+	{Name: "Live interface traffic (30s)", Type: "mikrotikapi", Level: "normal", Sid: "mikrotikApiTraffic"},
+	{Name: "List firewall filter rules", Type: "mikrotikapi", Level: "normal", Sid: "mikrotikApiFirewall"},
+	{Name: "Export configuration", Type: "mikrotikapi", Level: "normal", Sid: "mikrotikApiExport"},
+}
+
+const mikrotikTrafficDuration = 30 * time.Second
+
+// firewall menus managed by the API
+var mikrotikFirewallMenus = map[string]bool{"filter": true, "nat": true, "mangle": true, "raw": true}
+
+// attributes of the rules added from the ACS
+var mikrotikFirewallAttrs = []string{
+	"chain", "action", "protocol", "src-address", "dst-address", "src-port", "dst-port",
+	"in-interface", "out-interface", "to-addresses", "to-ports", "comment",
+}
+
+var mikrotikAttrValueRe = regexp.MustCompile(`^[^\r\n{}]{0,255}$`)
+
+func initMikrotikApiRouter() {
+
+	// API access of a device, the password is never returned
+	webserver.GET("/admin/supervise/mikrotik/api/get", func(c echo.Context) error {
+		dev, err := mikrotikDevice(c.QueryParam("devid"))
+		if err != nil {
+			return c.JSON(http.StatusOK, web.RestError(err.Error()))
+		}
+		return c.JSON(http.StatusOK, app.GApp().RouterosApi(dev.Sn))
+	})
+
+	webserver.POST("/admin/supervise/mikrotik/api/update", func(c echo.Context) error {
+		dev, err := mikrotikDevice(c.FormValue("devid"))
+		if err != nil {
+			return c.JSON(http.StatusOK, web.RestError(err.Error()))
+		}
+		err = app.GApp().SaveRouterosApi(dev.Sn,
+			strings.TrimSpace(c.FormValue("address")),
+			cast.ToInt(c.FormValue("port")),
+			c.FormValue("use_tls") == "1" || c.FormValue("use_tls") == "true",
+			strings.TrimSpace(c.FormValue("username")),
+			c.FormValue("password"))
+		if err != nil {
+			return c.JSON(http.StatusOK, web.RestError(err.Error()))
+		}
+		webserver.PubOpLog(c, fmt.Sprintf("Update RouterOS API access of %s", dev.Sn))
+		return c.JSON(http.StatusOK, web.RestSucc("Success"))
+	})
+
+	// Rules of a firewall menu: filter | nat | mangle | raw
+	webserver.GET("/admin/supervise/mikrotik/firewall/query", func(c echo.Context) error {
+		dev, err := mikrotikDevice(c.QueryParam("devid"))
+		if err != nil {
+			return c.JSON(http.StatusOK, common.EmptyList)
+		}
+		menu := common.IfEmptyStr(c.QueryParam("menu"), "filter")
+		if !mikrotikFirewallMenus[menu] {
+			return c.JSON(http.StatusOK, common.EmptyList)
+		}
+		client, err := app.GApp().DialRouteros(dev)
+		if err != nil {
+			return c.JSON(http.StatusOK, common.EmptyList)
+		}
+		defer client.Close()
+		reply, err := client.Run("/ip/firewall/" + menu + "/print")
+		if err != nil {
+			return c.JSON(http.StatusOK, common.EmptyList)
+		}
+		var rules = make([]map[string]string, 0, len(reply.Re))
+		for _, s := range reply.Re {
+			rule := make(map[string]string, len(s.Map)+1)
+			for k, v := range s.Map {
+				rule[k] = v
+			}
+			// webix rows need an id
+			rule["id"] = s.Map[".id"]
+			rules = append(rules, rule)
+		}
+		return c.JSON(http.StatusOK, rules)
+	})
+
+	// Add a rule with the API, pushed as a TR-069 script when the API is not reachable
+	webserver.POST("/admin/supervise/mikrotik/firewall/add", func(c echo.Context) error {
+		dev, err := mikrotikDevice(c.FormValue("devid"))
+		if err != nil {
+			return c.JSON(http.StatusOK, web.RestError(err.Error()))
+		}
+		menu := common.IfEmptyStr(c.FormValue("menu"), "filter")
+		if !mikrotikFirewallMenus[menu] {
+			return c.JSON(http.StatusOK, web.RestError("unsupported firewall menu "+menu))
+		}
+		var attrs [][2]string
+		for _, name := range mikrotikFirewallAttrs {
+			value := strings.TrimSpace(c.FormValue(name))
+			if value == "" {
+				continue
+			}
+			if !mikrotikAttrValueRe.MatchString(value) {
+				return c.JSON(http.StatusOK, web.RestError("invalid value of "+name))
+			}
+			attrs = append(attrs, [2]string{name, value})
+		}
+		if c.FormValue("chain") == "" {
+			return c.JSON(http.StatusOK, web.RestError("chain is required"))
+		}
+
+		client, err := app.GApp().DialRouteros(dev)
+		if err != nil {
+			session := common.UUID()
+			go sendCwmpConfig(models.CwmpConfig{
+				ID:      "mikrotikApiFirewall",
+				Name:    "Firewall " + menu + " rule",
+				Level:   "normal",
+				Timeout: 60,
+				Content: mikrotikFirewallScript(menu, attrs),
+			}, dev, session)
+			webserver.PubOpLog(c, fmt.Sprintf("Push firewall %s rule to %s over TR-069", menu, dev.Sn))
+			return c.JSON(http.StatusOK, web.RestSucc(fmt.Sprintf("%s, the rule is pushed over TR-069", err.Error())))
+		}
+		defer client.Close()
+		words := []string{"/ip/firewall/" + menu + "/add"}
+		for _, attr := range attrs {
+			words = append(words, "="+attr[0]+"="+attr[1])
+		}
+		reply, err := client.Run(words...)
+		if err != nil {
+			return c.JSON(http.StatusOK, web.RestError(err.Error()))
+		}
+		webserver.PubOpLog(c, fmt.Sprintf("Add firewall %s rule %s to %s", menu, reply.Done.Map["ret"], dev.Sn))
+		return c.JSON(http.StatusOK, web.RestSucc("Rule added "+reply.Done.Map["ret"]))
+	})
+
+	// Remove, enable or disable rules by .id, op: remove | enable | disable
+	webserver.POST("/admin/supervise/mikrotik/firewall/:op", func(c echo.Context) error {
+		op := c.Param("op")
+		if op != "remove" && op != "enable" && op != "disable" {
+			return c.JSON(http.StatusOK, web.RestError("unsupported operation "+op))
+		}
+		dev, err := mikrotikDevice(c.FormValue("devid"))
+		if err != nil {
+			return c.JSON(http.StatusOK, web.RestError(err.Error()))
+		}
+		menu := common.IfEmptyStr(c.FormValue("menu"), "filter")
+		ids := c.FormValue("ids")
+		if !mikrotikFirewallMenus[menu] || ids == "" {
+			return c.JSON(http.StatusOK, web.RestError("invalid firewall menu or rule ids"))
+		}
+		client, err := app.GApp().DialRouteros(dev)
+		if err != nil {
+			return c.JSON(http.StatusOK, web.RestError(err.Error()))
+		}
+		defer client.Close()
+		_, err = client.Run("/ip/firewall/"+menu+"/"+op, "=.id="+ids)
+		if err != nil {
+			return c.JSON(http.StatusOK, web.RestError(err.Error()))
+		}
+		webserver.PubOpLog(c, fmt.Sprintf("Firewall %s %s rules %s of %s", menu, op, ids, dev.Sn))
+		return c.JSON(http.StatusOK, web.RestSucc("Success"))
+	})
+}
+
+func mikrotikDevice(devid string) (models.NetCpe, error) {
+	var dev models.NetCpe
+	if err := app.GDB().Where("id=?", devid).First(&dev).Error; err != nil {
+		return dev, fmt.Errorf("Device not found")
+	}
+	if !app.IsMikrotik(dev) {
+		return dev, fmt.Errorf("Device %s is not a Mikrotik device", dev.Sn)
+	}
+	return dev, nil
+}
+
+// mikrotikFirewallScript RouterOS script adding the rule, for the TR-069 fallback
+func mikrotikFirewallScript(menu string, attrs [][2]string) string {
+	var b strings.Builder
+	b.WriteString("/ip firewall " + menu + " add")
+	for _, attr := range attrs {
+		b.WriteString(" " + attr[0] + "=" + app.RouterosQuote(attr[1]))
+	}
+	b.WriteString("\n")
+	return b.String()
+}
+
+func execMikrotikApi(c echo.Context, id string, deviceId int64, session string) error {
+	var dev models.NetCpe
+	common.Must(app.GDB().Where("id=?", deviceId).First(&dev).Error)
+	if !app.IsMikrotik(dev) {
+		return c.JSON(http.StatusOK, web.RestError(fmt.Sprintf("Device %s is not a Mikrotik device", dev.Sn)))
+	}
+	go func() {
+		if err := runMikrotikApiAction(id, dev, session); err != nil {
+			events.PubSuperviseLog(dev.ID, session, "error", err.Error())
+		}
+	}()
+	return c.JSON(200, web.RestSucc("The instruction has been sent, please check the execution log later, please do not execute it repeatedly in a short time"))
+}
+
+// runMikrotikApiAction Run a RouterOS API action, the TR-069 action when the API is not reachable
+func runMikrotikApiAction(id string, dev models.NetCpe, session string) error {
+	client, err := app.GApp().DialRouteros(dev)
+	if err != nil {
+		events.PubSuperviseLog(dev.ID, session, "error", err.Error()+", fall back to TR-069")
+		switch id {
+		case "mikrotikApiTest":
+			cwmpDeviceConnectTest(id, dev, session)
+		case "mikrotikApiTraffic":
+			cwmpInterfaceStats(id, dev, session)
+		case "mikrotikApiFirewall", "mikrotikApiExport":
+			events.PubSuperviseLog(dev.ID, session, "info", "The firewall rules are in the configuration backup uploaded over TR-069")
+			cwmpDeviceBackup(id, dev, session)
+		default:
+			return fmt.Errorf("unsupported mikrotik api action %s", id)
+		}
+		return nil
+	}
+	defer client.Close()
+
+	switch id {
+	case "mikrotikApiTest":
+		reply, err := client.Run("/system/resource/print")
+		if err != nil {
+			return err
+		}
+		for _, s := range reply.Re {
+			events.PubSuperviseLog(dev.ID, session, "info", fmt.Sprintf("RouterOS %s %s, uptime %s, cpu load %s%%",
+				s.Map["version"], s.Map["board-name"], s.Map["uptime"], s.Map["cpu-load"]))
+		}
+	case "mikrotikApiTraffic":
+		return mikrotikTraffic(client, dev, session)
+	case "mikrotikApiFirewall":
+		reply, err := client.Run("/ip/firewall/filter/print")
+		if err != nil {
+			return err
+		}
+		var lines []string
+		for _, s := range reply.Re {
+			lines = append(lines, s.String())
+		}
+		events.PubSuperviseLog(dev.ID, session, "info",
+			fmt.Sprintf("%d firewall filter rules\n%s", len(reply.Re), strings.Join(lines, "\n")))
+	case "mikrotikApiExport":
+		return mikrotikExport(client, dev, session)
+	default:
+		return fmt.Errorf("unsupported mikrotik api action %s", id)
+	}
+	return nil
+}
+
+// mikrotikTraffic Stream the traffic of the running interfaces to the supervise log
+func mikrotikTraffic(client *routeros.Client, dev models.NetCpe, session string) error {
+	reply, err := client.Run("/interface/print", "?running=true", "=.proplist=name")
+	if err != nil {
+		return err
+	}
+	var names []string
+	for _, s := range reply.Re {
+		names = append(names, s.Map["name"])
+	}
+	if len(names) == 0 {
+		return fmt.Errorf("no running interface")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), mikrotikTrafficDuration)
+	defer cancel()
+	return client.Listen(ctx, func(s *routeros.Sentence) {
+		events.PubSuperviseLog(dev.ID, session, "info", fmt.Sprintf("%s rx %s bps tx %s bps",
+			s.Map["name"], s.Map["rx-bits-per-second"], s.Map["tx-bits-per-second"]))
+	}, "/interface/monitor-traffic", "=interface="+strings.Join(names, ","),
+		"=.proplist=name,rx-bits-per-second,tx-bits-per-second")
+}
+
+// mikrotikExport Export the configuration to a file of the device and save its content
+// like the TR-069 backups. RouterOS returns at most 4KB of the file contents over the
+// API, a larger export is downloaded over SFTP. A partial export is never saved.
+func mikrotikExport(client *routeros.Client, dev models.NetCpe, session string) error {
+	// the export adds the .rsc extension
+	name := "teamsacs-" + session + ".rsc"
+	if _, err := client.Run("/export", "=file="+strings.TrimSuffix(name, ".rsc")); err != nil {
+		return err
+	}
+	defer client.Run("/file/remove", "=numbers="+name)
+	reply, err := client.Run("/file/print", "?name="+name, "=.proplist=size,contents")
+	if err != nil {
+		return err
+	}
+	if len(reply.Re) == 0 {
+		return fmt.Errorf("export file %s not found", name)
+	}
+	size := cast.ToInt(reply.Re[0].Map["size"])
+	if size <= 0 {
+		return fmt.Errorf("export file %s has no size", name)
+	}
+
+	dir := path.Join(app.GConfig().System.Workdir, "cwmp")
+	_ = os.MkdirAll(dir, 0777)
+	filename := dev.Sn + "_" + time.Now().Format("20060102") + ".rsc"
+	tmpfile := path.Join(dir, filename+".tmp")
+	defer os.Remove(tmpfile)
+	if contents := reply.Re[0].Map["contents"]; len(contents) == size {
+		err = os.WriteFile(tmpfile, []byte(contents), 0644)
+	} else {
+		err = mikrotikDownload(dev, name, tmpfile)
+	}
+	if err != nil {
+		return err
+	}
+	if st, err := os.Stat(tmpfile); err != nil || st.Size() != int64(size) {
+		return fmt.Errorf("export of %d bytes incomplete, not saved", size)
+	}
+	if err = os.Rename(tmpfile, path.Join(dir, filename)); err != nil {
+		return err
+	}
+	events.PubSuperviseLog(dev.ID, session, "info", "Configuration exported to "+filename)
+	return nil
+}
+
+// mikrotikDownload Download a file of the device over SFTP
+func mikrotikDownload(dev models.NetCpe, name, localFile string) error {
+	sftp, err := app.GApp().SftpClient(dev)
+	if err != nil {
+		return fmt.Errorf("export larger than the API returns, SFTP needed: %s", err.Error())
+	}
+	if err = sftp.Download(nil, "/"+name, localFile); err != nil {
+		return fmt.Errorf("export download error %s", err.Error())
+	}
+	return nil
+}
+
+// cwmpInterfaceStats Interface counters over TR-069
+func cwmpInterfaceStats(sid string, dev models.NetCpe, session string) {
+	cpe := app.GApp().CwmpTable().GetCwmpCpe(dev.Sn)
+	err := cpe.SendCwmpEventData(models.CwmpEventData{
+		Session: session,
+		Sn:      dev.Sn,
+		Message: &cwmp.GetParameterValues{
+			ID:     session,
+			Name:   "GetInterfaceStats",
+			NoMore: 0,
+			ParameterNames: cpe.TranslatePaths([]string{
+				"Device.Ethernet.Interface.",
+			}),
+		},
+	}, 5000, true)
+	if err != nil {
+		events.PubSuperviseLog(dev.ID, session, "error",
+			fmt.Sprintf("TR069 Get interface stats timeout %s", err.Error()))
+		return
+	}
+	go connectDeviceAuth(session, dev)
+}
@@ -6,6 +6,7 @@ import (
 
 	"github.com/ca17/teamsacs/app"
 	"github.com/ca17/teamsacs/common"
+	"github.com/ca17/teamsacs/common/web"
 	"github.com/ca17/teamsacs/models"
 	"github.com/ca17/teamsacs/webserver"
 	"github.com/labstack/echo/v4"
@@ -20,8 +21,14 @@ func initOdcOdpRouter() {
 	})
 
 	webserver.POST("/admin/odc/add", func(c echo.Context) error {
+		if err := app.ValidCoordinate(c.FormValue("latitude"), c.FormValue("longitude")); err != nil {
+			return c.JSON(http.StatusOK, map[string]interface{}{"code": 1, "msg": err.Error()})
+		}
 		cap, _ := strconv.Atoi(c.FormValue("capacity"))
 		oltID, _ := strconv.ParseInt(c.FormValue("olt_id"), 10, 64)
+		ratio, _ := strconv.Atoi(c.FormValue("split_ratio"))
+		loss, _ := strconv.ParseFloat(c.FormValue("splitter_loss"), 64)
+		feeder, _ := strconv.Atoi(c.FormValue("feeder_length"))
 		item := models.OdcDevice{
 			ID:        common.UUIDint64(),
 			Name:      c.FormValue("name"),
@@ -33,6 +40,10 @@ func initOdcOdpRouter() {
 			OltID:     oltID,
 			PonPort:   c.FormValue("pon_port"),
 			Remark:    c.FormValue("remark"),
+
+			SplitRatio:   ratio,
+			SplitterLoss: loss,
+			FeederLength: feeder,
 		}
 		if item.Name == "" {
 			return c.JSON(http.StatusOK, map[string]interface{}{"code": 1, "msg": "Name is required"})
@@ -44,15 +55,22 @@ func initOdcOdpRouter() {
 	})
 
 	webserver.POST("/admin/odc/update", func(c echo.Context) error {
+		if err := app.ValidCoordinate(c.FormValue("latitude"), c.FormValue("longitude")); err != nil {
+			return c.JSON(http.StatusOK, map[string]interface{}{"code": 1, "msg": err.Error()})
+		}
 		id, _ := strconv.ParseInt(c.FormValue("id"), 10, 64)
 		cap, _ := strconv.Atoi(c.FormValue("capacity"))
 		oltID, _ := strconv.ParseInt(c.FormValue("olt_id"), 10, 64)
+		ratio, _ := strconv.Atoi(c.FormValue("split_ratio"))
+		loss, _ := strconv.ParseFloat(c.FormValue("splitter_loss"), 64)
+		feeder, _ := strconv.Atoi(c.FormValue("feeder_length"))
 		app.GDB().Model(&models.OdcDevice{}).Where("id = ?", id).Updates(map[string]interface{}{
 			"name": c.FormValue("name"), "location": c.FormValue("location"),
 			"address": c.FormValue("address"), "latitude": c.FormValue("latitude"),
 			"longitude": c.FormValue("longitude"), "capacity": cap,
 			"olt_id": oltID, "pon_port": c.FormValue("pon_port"),
-			"remark": c.FormValue("remark"),
+			"remark": c.FormValue("remark"), "split_ratio": ratio,
+			"splitter_loss": loss, "feeder_length": feeder,
 		})
 		return c.JSON(http.StatusOK, map[string]interface{}{"code": 0, "msg": "Updated"})
 	})
@@ -71,9 +89,14 @@ func initOdcOdpRouter() {
 	})
 
 	webserver.POST("/admin/odp/add", func(c echo.Context) error {
+		if err := app.ValidCoordinate(c.FormValue("latitude"), c.FormValue("longitude")); err != nil {
+			return c.JSON(http.StatusOK, map[string]interface{}{"code": 1, "msg": err.Error()})
+		}
 		cap, _ := strconv.Atoi(c.FormValue("capacity"))
 		odcID, _ := strconv.ParseInt(c.FormValue("odc_id"), 10, 64)
-		usedPorts, _ := strconv.Atoi(c.FormValue("used_ports"))
+		odcPort, _ := strconv.Atoi(c.FormValue("odc_port"))
+		cable, _ := strconv.Atoi(c.FormValue("cable_length"))
+		loss, _ := strconv.ParseFloat(c.FormValue("splitter_loss"), 64)
 		item := models.OdpDevice{
 			ID:        common.UUIDint64(),
 			Name:      c.FormValue("name"),
@@ -83,12 +106,18 @@ func initOdcOdpRouter() {
 			Latitude:  c.FormValue("latitude"),
 			Longitude: c.FormValue("longitude"),
 			Capacity:  cap,
-			UsedPorts: usedPorts,
 			Remark:    c.FormValue("remark"),
+
+			OdcPort:      odcPort,
+			CableLength:  cable,
+			SplitterLoss: loss,
 		}
 		if item.Name == "" {
 			return c.JSON(http.StatusOK, map[string]interface{}{"code": 1, "msg": "Name is required"})
 		}
+		if err := app.GApp().CheckOdcPort(item); err != nil {
+			return c.JSON(http.StatusOK, map[string]interface{}{"code": 1, "msg": err.Error()})
+		}
 		if err := app.GDB().Create(&item).Error; err != nil {
 			return c.JSON(http.StatusOK, map[string]interface{}{"code": 1, "msg": err.Error()})
 		}
@@ -96,16 +125,25 @@ func initOdcOdpRouter() {
 	})
 
 	webserver.POST("/admin/odp/update", func(c echo.Context) error {
+		if err := app.ValidCoordinate(c.FormValue("latitude"), c.FormValue("longitude")); err != nil {
+			return c.JSON(http.StatusOK, map[string]interface{}{"code": 1, "msg": err.Error()})
+		}
 		id, _ := strconv.ParseInt(c.FormValue("id"), 10, 64)
 		cap, _ := strconv.Atoi(c.FormValue("capacity"))
 		odcID, _ := strconv.ParseInt(c.FormValue("odc_id"), 10, 64)
-		usedPorts, _ := strconv.Atoi(c.FormValue("used_ports"))
+		odcPort, _ := strconv.Atoi(c.FormValue("odc_port"))
+		cable, _ := strconv.Atoi(c.FormValue("cable_length"))
+		loss, _ := strconv.ParseFloat(c.FormValue("splitter_loss"), 64)
+		err := app.GApp().CheckOdcPort(models.OdpDevice{ID: id, OdcID: odcID, OdcPort: odcPort})
+		if err != nil {
+			return c.JSON(http.StatusOK, map[string]interface{}{"code": 1, "msg": err.Error()})
+		}
 		app.GDB().Model(&models.OdpDevice{}).Where("id = ?", id).Updates(map[string]interface{}{
 			"name": c.FormValue("name"), "odc_id": odcID,
 			"location": c.FormValue("location"), "address": c.FormValue("address"),
 			"latitude": c.FormValue("latitude"), "longitude": c.FormValue("longitude"),
-			"capacity": cap, "used_ports": usedPorts,
-			"remark": c.FormValue("remark"),
+			"capacity": cap, "remark": c.FormValue("remark"), "odc_port": odcPort,
+			"cable_length": cable, "splitter_loss": loss,
 		})
 		return c.JSON(http.StatusOK, map[string]interface{}{"code": 0, "msg": "Updated"})
 	})
@@ -136,11 +174,19 @@ func initOdcOdpRouter() {
 		return c.JSON(http.StatusOK, items)
 	})
 
-	// Assign CPE to ODP
+	// Assign CPE to an ODP port, odp_id 0 removes the assignment
 	webserver.POST("/admin/cpe/assign-odp", func(c echo.Context) error {
 		cpeID, _ := strconv.ParseInt(c.FormValue("cpe_id"), 10, 64)
 		odpID, _ := strconv.ParseInt(c.FormValue("odp_id"), 10, 64)
-		app.GDB().Model(&models.NetCpe{}).Where("id = ?", cpeID).Update("odp_id", odpID)
+		odpPort, _ := strconv.Atoi(c.FormValue("odp_port"))
+		dropLength, _ := strconv.Atoi(c.FormValue("drop_length"))
+		var cpe models.NetCpe
+		if err := app.GDB().Where("id = ?", cpeID).First(&cpe).Error; err != nil {
+			return c.JSON(http.StatusOK, map[string]interface{}{"code": 1, "msg": "CPE not found"})
+		}
+		if err := app.GApp().AssignOdpPort(cpe, odpID, odpPort, dropLength); err != nil {
+			return c.JSON(http.StatusOK, map[string]interface{}{"code": 1, "msg": err.Error()})
+		}
 		return c.JSON(http.StatusOK, map[string]interface{}{"code": 0, "msg": "ODP assigned"})
 	})
 
@@ -153,11 +199,59 @@ func initOdcOdpRouter() {
 			Name       string `json:"name"`
 			Model      string `json:"model"`
 			CwmpStatus string `json:"cwmp_status"`
+			OdpPort    int    `json:"odp_port"`
 		}
 		app.GDB().Model(&models.NetCpe{}).
 			Where("odp_id = ?", odpID).
-			Select("id, sn, name, model, cwmp_status").
-			Order("sn").Find(&cpes)
+			Select("id, sn, name, model, cwmp_status, odp_port").
+			Order("odp_port, sn").Find(&cpes)
 		return c.JSON(http.StatusOK, cpes)
 	})
+
+	// Fibre path of a CPE with the losses and the expected RX power
+	webserver.GET("/admin/cpe/:id/fibre-path", func(c echo.Context) error {
+		var cpe models.NetCpe
+		if err := app.GDB().Where("id = ?", c.Param("id")).First(&cpe).Error; err != nil {
+			return c.JSON(http.StatusOK, map[string]interface{}{"code": 1, "msg": "CPE not found"})
+		}
+		path, err := app.GApp().DeviceFibrePath(cpe)
+		if err != nil {
+			return c.JSON(http.StatusOK, map[string]interface{}{"code": 1, "msg": err.Error()})
+		}
+		measured, ok := app.GApp().MeasuredRxPower(cpe)
+		return c.JSON(http.StatusOK, map[string]interface{}{
+			"code":        0,
+			"path":        path,
+			"measured_rx": common.If(ok, measured, nil),
+		})
+	})
+
+	// ONU optical budget checks, status=deviated lists the flagged ONUs
+	webserver.GET("/admin/optical/budget/query", func(c echo.Context) error {
+		prequery := web.NewPreQuery(c).
+			DefaultOrderBy("check_time desc").
+			QueryField("status", "status").
+			QueryField("odp_id", "odp_id").
+			KeyFields("sn", "detail")
+
+		result, err := web.QueryPageResult[models.NetCpeOptical](c, app.GDB(), prequery)
+		if err != nil {
+			return c.JSON(http.StatusOK, common.EmptyList)
+		}
+		return c.JSON(http.StatusOK, result)
+	})
+
+	// Check the optical budget of a CPE now, all the assigned ONUs without cpe_id
+	webserver.POST("/admin/optical/budget/check", func(c echo.Context) error {
+		cpeID := c.FormValue("cpe_id")
+		if cpeID == "" {
+			go app.GApp().SchedCheckOpticalBudget()
+			return c.JSON(http.StatusOK, map[string]interface{}{"code": 0, "msg": "Optical budget check started"})
+		}
+		var cpe models.NetCpe
+		if err := app.GDB().Where("id = ?", cpeID).First(&cpe).Error; err != nil {
+			return c.JSON(http.StatusOK, map[string]interface{}{"code": 1, "msg": "CPE not found"})
+		}
+		return c.JSON(http.StatusOK, map[string]interface{}{"code": 0, "data": app.GApp().CheckOpticalBudget(cpe)})
+	})
 }
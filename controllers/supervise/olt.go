@@ -1,9 +1,11 @@
 package supervise
 
 import (
+	"fmt"
 	"net/http"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/ca17/teamsacs/app"
 	"github.com/ca17/teamsacs/common"
@@ -27,6 +29,9 @@ func initOltRouter() {
 		if err := c.Bind(olt); err != nil {
 			return c.JSON(http.StatusOK, map[string]interface{}{"code": 1, "msg": "Invalid data"})
 		}
+		if err := app.ValidCoordinate(olt.Latitude, olt.Longitude); err != nil {
+			return c.JSON(http.StatusOK, map[string]interface{}{"code": 1, "msg": err.Error()})
+		}
 		olt.ID = common.UUIDint64()
 		if olt.SNMPPort == 0 {
 			olt.SNMPPort = 161
@@ -48,22 +53,37 @@ func initOltRouter() {
 	// Update OLT
 	webserver.POST("/admin/olt/update", func(c echo.Context) error {
 		var form struct {
-			ID            int64  `json:"id,string" form:"id"`
-			Name          string `json:"name" form:"name"`
-			IPAddress     string `json:"ip_address" form:"ip_address"`
-			SNMPPort      int    `json:"snmp_port" form:"snmp_port"`
-			SNMPCommunity string `json:"snmp_community" form:"snmp_community"`
-			Model         string `json:"model" form:"model"`
+			ID            int64   `json:"id,string" form:"id"`
+			Name          string  `json:"name" form:"name"`
+			IPAddress     string  `json:"ip_address" form:"ip_address"`
+			SNMPPort      int     `json:"snmp_port" form:"snmp_port"`
+			SNMPCommunity string  `json:"snmp_community" form:"snmp_community"`
+			Model         string  `json:"model" form:"model"`
+			PonTxPower    float64 `json:"pon_tx_power" form:"pon_tx_power"`
+			Latitude      string  `json:"latitude" form:"latitude"`
+			Longitude     string  `json:"longitude" form:"longitude"`
+
+			SNMPWriteCommunity string `json:"snmp_write_community" form:"snmp_write_community"`
 		}
 		if err := c.Bind(&form); err != nil {
 			return c.JSON(http.StatusOK, map[string]interface{}{"code": 1, "msg": "Invalid data"})
 		}
+		if err := app.ValidCoordinate(form.Latitude, form.Longitude); err != nil {
+			return c.JSON(http.StatusOK, map[string]interface{}{"code": 1, "msg": err.Error()})
+		}
 		updates := map[string]interface{}{
 			"name":           form.Name,
 			"ip_address":     form.IPAddress,
 			"snmp_port":      form.SNMPPort,
 			"snmp_community": form.SNMPCommunity,
 			"model":          form.Model,
+			"pon_tx_power":   form.PonTxPower,
+			"latitude":       form.Latitude,
+			"longitude":      form.Longitude,
+		}
+		// the write community is not sent to the UI, empty keeps the stored one
+		if form.SNMPWriteCommunity != "" {
+			updates["snmp_write_community"] = form.SNMPWriteCommunity
 		}
 		app.GDB().Model(&models.OltDevice{}).Where("id = ?", form.ID).Updates(updates)
 		return c.JSON(http.StatusOK, map[string]interface{}{"code": 0, "msg": "Updated"})
@@ -104,7 +124,7 @@ func initOltRouter() {
 	webserver.GET("/admin/olt/onu/:sn", func(c echo.Context) error {
 		sn := strings.ToUpper(c.Param("sn"))
 		var onuData models.OltOnuData
-		result := app.GDB().Where("UPPER(serial_number) = ?", sn).
+		result := app.GDB().Where("serial_number = ?", sn).
 			Order("updated_at desc").First(&onuData)
 		if result.Error != nil {
 			return c.JSON(http.StatusOK, map[string]interface{}{
@@ -126,6 +146,63 @@ func initOltRouter() {
 		})
 	})
 
+	// Run an ONU action through the OLT: reboot, deactivate, activate, delete or reregister
+	webserver.POST("/admin/olt/onu/action", func(c echo.Context) error {
+		sn, action := strings.ToUpper(c.FormValue("sn")), c.FormValue("action")
+		if !common.InSlice(action, zsnmp.OnuActions) {
+			return c.JSON(http.StatusOK, map[string]interface{}{"code": 1, "msg": "Invalid action"})
+		}
+		onu, olt, err := findOltOnu(sn)
+		if err != nil {
+			return c.JSON(http.StatusOK, map[string]interface{}{"code": 1, "msg": err.Error()})
+		}
+		community := common.IfEmptyStr(olt.SNMPWriteCommunity, olt.SNMPCommunity)
+		drv := zsnmp.NewZTEDriverWithModel(olt.IPAddress, olt.SNMPPort, community, olt.Model)
+		err = drv.RunONUAction(zsnmp.ONUConfig{
+			IfIndex:      onu.IfIndex,
+			OnuID:        onu.OnuID,
+			SerialNumber: onu.SerialNumber,
+			Type:         onu.OnuType,
+			Name:         onu.OnuName,
+		}, action)
+		result := common.If(err == nil, "done", "failed").(string)
+		if err == nil && action == zsnmp.OnuActionReregister {
+			result = "completed with a warning, " + zsnmp.ReregisterServiceWarning
+		}
+		webserver.PubOpLog(c, fmt.Sprintf("ONU %s %s on OLT %s %s:%d %s",
+			action, onu.SerialNumber, olt.Name, onu.PONPort, onu.OnuID, result))
+		if err != nil {
+			return c.JSON(http.StatusOK, map[string]interface{}{"code": 1, "msg": err.Error()})
+		}
+		switch action {
+		case zsnmp.OnuActionDelete:
+			app.GDB().Where("id = ?", onu.ID).Delete(&models.OltOnuData{})
+		case zsnmp.OnuActionDeactivate, zsnmp.OnuActionReboot, zsnmp.OnuActionReregister:
+			// the next poll reads the new state
+			app.GDB().Model(&models.OltOnuData{}).Where("id = ?", onu.ID).Update("phase_state", "offline")
+		}
+		return c.JSON(http.StatusOK, map[string]interface{}{"code": 0, "msg": "ONU " + action + " " + result})
+	})
+
+	// Read the ONU distance and the RX power measured by the OLT
+	webserver.GET("/admin/olt/onu/:sn/link", func(c echo.Context) error {
+		onu, olt, err := findOltOnu(strings.ToUpper(c.Param("sn")))
+		if err != nil {
+			return c.JSON(http.StatusOK, map[string]interface{}{"code": 1, "msg": err.Error()})
+		}
+		drv := zsnmp.NewZTEDriverWithModel(olt.IPAddress, olt.SNMPPort, olt.SNMPCommunity, olt.Model)
+		link, err := drv.ReadONULink(onu.IfIndex, onu.OnuID)
+		if err != nil {
+			return c.JSON(http.StatusOK, map[string]interface{}{"code": 1, "msg": err.Error()})
+		}
+		return c.JSON(http.StatusOK, map[string]interface{}{
+			"code":     0,
+			"data":     link,
+			"onu":      onu,
+			"olt_name": olt.Name,
+		})
+	})
+
 	// List all ONU data for an OLT
 	webserver.GET("/admin/olt/:id/onus", func(c echo.Context) error {
 		oltID := c.Param("id")
@@ -134,13 +211,49 @@ func initOltRouter() {
 		return c.JSON(http.StatusOK, onus)
 	})
 
+	// List the PON and uplink ports of an OLT, kind: pon or uplink
+	webserver.GET("/admin/olt/:id/ports", func(c echo.Context) error {
+		query := app.GDB().Where("olt_id = ?", c.Param("id"))
+		if kind := c.QueryParam("kind"); kind != "" {
+			query = query.Where("kind = ?", kind)
+		}
+		var ports []models.OltPortData
+		query.Order("kind, name").Find(&ports)
+		return c.JSON(http.StatusOK, ports)
+	})
+
+	// Points of an OLT port series, hours: time range until now, default 24
+	webserver.GET("/admin/olt/:id/ports/metrics", func(c echo.Context) error {
+		oltID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+		port, name := c.QueryParam("port"), c.QueryParam("name")
+		if err != nil || port == "" || !common.InSlice(name, app.OltPortMetricNames) {
+			return c.JSON(http.StatusOK, map[string]interface{}{"code": 1, "msg": "Invalid OLT, port or metric name"})
+		}
+		hours, err := strconv.Atoi(c.QueryParam("hours"))
+		if err != nil || hours <= 0 || hours > 24*30 {
+			hours = 24
+		}
+		points, err := app.QueryOltPortMetric(oltID, port, name, time.Now().Add(-time.Duration(hours)*time.Hour), time.Now())
+		if err != nil {
+			return c.JSON(http.StatusOK, common.EmptyList)
+		}
+		result := make([]map[string]interface{}, 0, len(points))
+		for _, p := range points {
+			result = append(result, map[string]interface{}{
+				"time":  time.Unix(p.Timestamp, 0).Format("2006-01-02 15:04:05"),
+				"value": p.Value,
+			})
+		}
+		return c.JSON(http.StatusOK, result)
+	})
+
 	// Get full topology path for a CPE: OLT → ODC → ODP → ONU
 	webserver.GET("/admin/olt/topology/:sn", func(c echo.Context) error {
 		sn := strings.ToUpper(c.Param("sn"))
 
 		// Find ONU data
 		var onuData models.OltOnuData
-		result := app.GDB().Where("UPPER(serial_number) = ?", sn).
+		result := app.GDB().Where("serial_number = ?", sn).
 			Order("updated_at desc").First(&onuData)
 		if result.Error != nil {
 			return c.JSON(http.StatusOK, map[string]interface{}{"found": false})
@@ -184,6 +297,7 @@ func initOltRouter() {
 				"status":   olt.Status,
 			},
 			"pon_port": onuData.PONPort,
+			"odp_port": cpe.OdpPort,
 			"onu": map[string]interface{}{
 				"sn":          onuData.SerialNumber,
 				"name":        onuData.OnuName,
@@ -197,3 +311,16 @@ func initOltRouter() {
 		})
 	})
 }
+
+// findOltOnu The latest polled ONU of the serial number and its OLT
+func findOltOnu(sn string) (models.OltOnuData, models.OltDevice, error) {
+	var onu models.OltOnuData
+	var olt models.OltDevice
+	if sn == "" || app.GDB().Where("serial_number = ?", sn).Order("updated_at desc").First(&onu).Error != nil {
+		return onu, olt, fmt.Errorf("ONU %s not found on the OLTs", sn)
+	}
+	if app.GDB().Where("id = ?", onu.OltID).First(&olt).Error != nil {
+		return onu, olt, fmt.Errorf("OLT of ONU %s not found", sn)
+	}
+	return onu, olt, nil
+}
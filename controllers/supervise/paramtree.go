@@ -0,0 +1,70 @@
+package supervise
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/ca17/teamsacs/app"
+	"github.com/ca17/teamsacs/common"
+	"github.com/ca17/teamsacs/common/web"
+	"github.com/ca17/teamsacs/models"
+	"github.com/ca17/teamsacs/webserver"
+	"github.com/labstack/echo/v4"
+)
+
+// paramTreeNode Tree node with the last known value of a leaf
+type paramTreeNode struct {
+	models.NetCpeParamNode
+	Value string `json:"value"`
+	Type  string `json:"type"`
+}
+
+func initParamTreeRouter() {
+
+	// Discovery progress, number of nodes by status
+	webserver.GET("/admin/supervise/paramtree/status", func(c echo.Context) error {
+		var sn string
+		common.Must(web.NewParamReader(c).ReadRequiedString(&sn, "sn").LastError)
+		return c.JSON(http.StatusOK, web.RestResult(app.GApp().CwmpTable().GetCwmpCpe(sn).DiscoveryStatus()))
+	})
+
+	webserver.GET("/admin/supervise/paramtree/cancel", func(c echo.Context) error {
+		var sn string
+		common.Must(web.NewParamReader(c).ReadRequiedString(&sn, "sn").LastError)
+		common.Must(app.GApp().CwmpTable().GetCwmpCpe(sn).CancelParamDiscovery())
+		webserver.PubOpLog(c, fmt.Sprintf("Cancel parameter discovery：%s", sn))
+		return c.JSON(http.StatusOK, web.RestSucc("success"))
+	})
+
+	// Children of a tree path, the roots when path is empty
+	webserver.GET("/admin/supervise/paramtree/tree", func(c echo.Context) error {
+		var sn, path string
+		common.Must(web.NewParamReader(c).
+			ReadRequiedString(&sn, "sn").
+			ReadString(&path, "path").LastError)
+		var nodes []models.NetCpeParamNode
+		common.Must(app.GDB().Where("sn = ? and parent = ?", sn, path).Order("path asc").Find(&nodes).Error)
+
+		var leafs []string
+		for _, node := range nodes {
+			if !node.Object {
+				leafs = append(leafs, node.Path)
+			}
+		}
+		var params []models.NetCpeParam
+		if len(leafs) > 0 {
+			app.GDB().Where("sn = ? and name in ?", sn, leafs).Find(&params)
+		}
+		var pmap = make(map[string]models.NetCpeParam)
+		for _, p := range params {
+			pmap[p.Name] = p
+		}
+
+		var result = make([]paramTreeNode, 0, len(nodes))
+		for _, node := range nodes {
+			p := pmap[node.Path]
+			result = append(result, paramTreeNode{NetCpeParamNode: node, Value: p.Value, Type: p.Type})
+		}
+		return c.JSON(http.StatusOK, web.RestResult(result))
+	})
+}
@@ -0,0 +1,336 @@
+package supervise
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ca17/teamsacs/app"
+	"github.com/ca17/teamsacs/common"
+	"github.com/ca17/teamsacs/common/web"
+	"github.com/ca17/teamsacs/events"
+	"github.com/ca17/teamsacs/models"
+	"github.com/ca17/teamsacs/webserver"
+	"github.com/labstack/echo/v4"
+	"gorm.io/gorm"
+)
+
+// devices pushed or checked at the same time
+const policyConcurrency = 10
+
+func initPolicyRouter() {
+
+	webserver.GET("/admin/supervise/policy/query", func(c echo.Context) error {
+		prequery := web.NewPreQuery(c).
+			DefaultOrderBy("updated_at desc").
+			QueryField("status", "status").
+			KeyFields("name", "remark")
+
+		result, err := web.QueryPageResult[models.NetPolicy](c, app.GDB(), prequery)
+		if err != nil {
+			return c.JSON(http.StatusOK, common.EmptyList)
+		}
+		return c.JSON(http.StatusOK, result)
+	})
+
+	webserver.POST("/admin/supervise/policy/add", func(c echo.Context) error {
+		form := new(models.NetPolicy)
+		common.Must(c.Bind(form))
+		if err := app.CheckPolicy(*form); err != nil {
+			return c.JSON(http.StatusOK, web.RestError(err.Error()))
+		}
+		form.ID = common.UUIDint64()
+		form.Status = common.IfEmptyStr(form.Status, common.ENABLED)
+		common.Must(app.GDB().Create(form).Error)
+		webserver.PubOpLog(c, fmt.Sprintf("Create policy %s", form.Name))
+		return c.JSON(http.StatusOK, web.RestSucc("success"))
+	})
+
+	webserver.POST("/admin/supervise/policy/update", func(c echo.Context) error {
+		form := new(models.NetPolicy)
+		common.Must(c.Bind(form))
+		if err := app.CheckPolicy(*form); err != nil {
+			return c.JSON(http.StatusOK, web.RestError(err.Error()))
+		}
+		common.Must(app.GDB().Model(&models.NetPolicy{}).Where("id = ?", form.ID).Updates(map[string]interface{}{
+			"name": form.Name, "status": form.Status, "remark": form.Remark, "updated_at": time.Now(),
+		}).Error)
+		webserver.PubOpLog(c, fmt.Sprintf("Update policy %s", form.Name))
+		return c.JSON(http.StatusOK, web.RestSucc("success"))
+	})
+
+	// The entries already pushed stay on the devices, push an empty policy first to remove them
+	webserver.GET("/admin/supervise/policy/delete", func(c echo.Context) error {
+		ids := strings.Split(c.QueryParam("ids"), ",")
+		common.Must(app.GDB().Transaction(func(tx *gorm.DB) error {
+			for _, m := range []interface{}{models.NetPolicyRule{}, models.NetPolicyBinding{}, models.NetPolicyDevice{}} {
+				if err := tx.Where("policy_id in ?", ids).Delete(m).Error; err != nil {
+					return err
+				}
+			}
+			return tx.Delete(models.NetPolicy{}, ids).Error
+		}))
+		webserver.PubOpLog(c, fmt.Sprintf("Delete policies %s", c.QueryParam("ids")))
+		return c.JSON(http.StatusOK, web.RestSucc("success"))
+	})
+
+	webserver.GET("/admin/supervise/policy/rule/query", func(c echo.Context) error {
+		var policyId int64
+		common.Must(web.NewParamReader(c).ReadInt64(&policyId, "policy_id", 0).LastError)
+		rules, err := app.GApp().PolicyRules(policyId)
+		common.Must(err)
+		return c.JSON(http.StatusOK, rules)
+	})
+
+	webserver.POST("/admin/supervise/policy/rule/add", func(c echo.Context) error {
+		form := new(models.NetPolicyRule)
+		common.Must(c.Bind(form))
+		if err := app.CheckPolicyRule(*form); err != nil {
+			return c.JSON(http.StatusOK, web.RestError(err.Error()))
+		}
+		var count int64
+		app.GDB().Model(&models.NetPolicy{}).Where("id = ?", form.PolicyId).Count(&count)
+		if count == 0 {
+			return c.JSON(http.StatusOK, web.RestError("Policy not found"))
+		}
+		form.ID = common.UUIDint64()
+		common.Must(app.GDB().Create(form).Error)
+		webserver.PubOpLog(c, fmt.Sprintf("Add %s rule to policy %d", form.Kind, form.PolicyId))
+		return c.JSON(http.StatusOK, web.RestSucc("success"))
+	})
+
+	webserver.POST("/admin/supervise/policy/rule/update", func(c echo.Context) error {
+		form := new(models.NetPolicyRule)
+		common.Must(c.Bind(form))
+		if err := app.CheckPolicyRule(*form); err != nil {
+			return c.JSON(http.StatusOK, web.RestError(err.Error()))
+		}
+		common.Must(app.GDB().Omit("created_at").Save(form).Error)
+		webserver.PubOpLog(c, fmt.Sprintf("Update %s rule %d of policy %d", form.Kind, form.ID, form.PolicyId))
+		return c.JSON(http.StatusOK, web.RestSucc("success"))
+	})
+
+	webserver.GET("/admin/supervise/policy/rule/delete", func(c echo.Context) error {
+		ids := c.QueryParam("ids")
+		common.Must(app.GDB().Delete(models.NetPolicyRule{}, strings.Split(ids, ",")).Error)
+		webserver.PubOpLog(c, fmt.Sprintf("Delete policy rules %s", ids))
+		return c.JSON(http.StatusOK, web.RestSucc("success"))
+	})
+
+	webserver.GET("/admin/supervise/policy/binding/query", func(c echo.Context) error {
+		var bindings []models.NetPolicyBinding
+		common.Must(app.GDB().Where("policy_id = ?", c.QueryParam("policy_id")).Order("created_at asc").Find(&bindings).Error)
+		return c.JSON(http.StatusOK, bindings)
+	})
+
+	// Assign the policy to a device or a device group
+	webserver.POST("/admin/supervise/policy/binding/add", func(c echo.Context) error {
+		form := new(models.NetPolicyBinding)
+		common.Must(c.Bind(form))
+		var count int64
+		switch form.TargetType {
+		case "device":
+			app.GDB().Model(&models.NetCpe{}).Where("id = ?", form.TargetId).Count(&count)
+		case "group":
+			app.GDB().Model(&models.NetCpeGroup{}).Where("id = ?", form.TargetId).Count(&count)
+		default:
+			return c.JSON(http.StatusOK, web.RestError("target_type must be device or group"))
+		}
+		if count == 0 {
+			return c.JSON(http.StatusOK, web.RestError("Target not found"))
+		}
+		app.GDB().Model(&models.NetPolicyBinding{}).
+			Where("policy_id = ? and target_type = ? and target_id = ?", form.PolicyId, form.TargetType, form.TargetId).Count(&count)
+		if count > 0 {
+			return c.JSON(http.StatusOK, web.RestError("The policy is already assigned to the target"))
+		}
+		form.ID = common.UUIDint64()
+		form.CreatedAt = time.Now()
+		common.Must(app.GDB().Create(form).Error)
+		webserver.PubOpLog(c, fmt.Sprintf("Assign policy %d to %s %d", form.PolicyId, form.TargetType, form.TargetId))
+		return c.JSON(http.StatusOK, web.RestSucc("success"))
+	})
+
+	webserver.GET("/admin/supervise/policy/binding/delete", func(c echo.Context) error {
+		ids := c.QueryParam("ids")
+		common.Must(app.GDB().Delete(models.NetPolicyBinding{}, strings.Split(ids, ",")).Error)
+		webserver.PubOpLog(c, fmt.Sprintf("Delete policy assignments %s", ids))
+		return c.JSON(http.StatusOK, web.RestSucc("success"))
+	})
+
+	// Push and drift state of the policy on its devices
+	webserver.GET("/admin/supervise/policy/devices", func(c echo.Context) error {
+		prequery := web.NewPreQuery(c).
+			DefaultOrderBy("sn asc").
+			QueryField("policy_id", "policy_id").
+			QueryField("push_status", "push_status").
+			QueryField("drift_status", "drift_status").
+			KeyFields("sn", "push_error", "drift_detail")
+
+		result, err := web.QueryPageResult[models.NetPolicyDevice](c, app.GDB(), prequery)
+		if err != nil {
+			return c.JSON(http.StatusOK, common.EmptyList)
+		}
+		return c.JSON(http.StatusOK, result)
+	})
+
+	// Policy rendered for a device, the RouterOS script or the TR-181 parameters
+	webserver.GET("/admin/supervise/policy/render", func(c echo.Context) error {
+		policy, rules, err := loadPolicy(c.QueryParam("policy_id"))
+		if err != nil {
+			return c.JSON(http.StatusOK, web.RestError(err.Error()))
+		}
+		var dev models.NetCpe
+		if err = app.GDB().Where("id = ?", c.QueryParam("devid")).First(&dev).Error; err != nil {
+			return c.JSON(http.StatusOK, web.RestError("Device not found"))
+		}
+		method, content, err := renderPolicy(policy, rules, dev)
+		if err != nil {
+			return c.JSON(http.StatusOK, web.RestError(err.Error()))
+		}
+		return c.JSON(http.StatusOK, web.RestResult(map[string]string{"method": method, "content": content}))
+	})
+
+	// Push the policy to its devices or to the given devices of the policy
+	webserver.POST("/admin/supervise/policy/push", func(c echo.Context) error {
+		policy, rules, err := loadPolicy(c.FormValue("policy_id"))
+		if err != nil {
+			return c.JSON(http.StatusOK, web.RestError(err.Error()))
+		}
+		if policy.Status != common.ENABLED {
+			return c.JSON(http.StatusOK, web.RestError("The policy is disabled"))
+		}
+		devs, err := policyTargets(policy.ID, c.FormValue("devids"))
+		if err != nil {
+			return c.JSON(http.StatusOK, web.RestError(err.Error()))
+		}
+		// the config script downloads are looked up by session, one session per device
+		go eachPolicyDevice(devs, func(dev models.NetCpe) {
+			pushPolicy(policy, rules, dev, common.UUID())
+		})
+		webserver.PubOpLog(c, fmt.Sprintf("Push policy %s to %d devices", policy.Name, len(devs)))
+		return c.JSON(http.StatusOK, web.RestSucc(fmt.Sprintf("Pushing to %d devices, the sessions are kept in the device state", len(devs))))
+	})
+
+	// Drift check of the policy, the results are kept as the device state of the policy
+	webserver.POST("/admin/supervise/policy/drift", func(c echo.Context) error {
+		policy, rules, err := loadPolicy(c.FormValue("policy_id"))
+		if err != nil {
+			return c.JSON(http.StatusOK, web.RestError(err.Error()))
+		}
+		devs, err := policyTargets(policy.ID, c.FormValue("devids"))
+		if err != nil {
+			return c.JSON(http.StatusOK, web.RestError(err.Error()))
+		}
+		go eachPolicyDevice(devs, func(dev models.NetCpe) {
+			app.GApp().CheckPolicyDrift(policy, rules, dev)
+		})
+		return c.JSON(http.StatusOK, web.RestSucc(fmt.Sprintf("Checking %d devices", len(devs))))
+	})
+}
+
+func loadPolicy(id string) (models.NetPolicy, []models.NetPolicyRule, error) {
+	var policy models.NetPolicy
+	if err := app.GDB().Where("id = ?", id).First(&policy).Error; err != nil {
+		return policy, nil, fmt.Errorf("Policy not found")
+	}
+	rules, err := app.GApp().PolicyRules(policy.ID)
+	return policy, rules, err
+}
+
+// policyTargets Devices of the policy, only the given ones when devids is not empty
+func policyTargets(policyId int64, devids string) ([]models.NetCpe, error) {
+	devs, err := app.GApp().PolicyDevices(policyId)
+	if err != nil {
+		return nil, err
+	}
+	if devids != "" {
+		var selected = make(map[string]bool)
+		for _, id := range strings.Split(devids, ",") {
+			selected[id] = true
+		}
+		var result []models.NetCpe
+		for _, dev := range devs {
+			if selected[fmt.Sprint(dev.ID)] {
+				result = append(result, dev)
+			}
+		}
+		devs = result
+	}
+	if len(devs) == 0 {
+		return nil, fmt.Errorf("No device is assigned to the policy")
+	}
+	return devs, nil
+}
+
+func eachPolicyDevice(devs []models.NetCpe, fn func(dev models.NetCpe)) {
+	sem := make(chan struct{}, policyConcurrency)
+	var wg sync.WaitGroup
+	for _, dev := range devs {
+		sem <- struct{}{}
+		wg.Add(1)
+		go func(dev models.NetCpe) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			fn(dev)
+		}(dev)
+	}
+	wg.Wait()
+}
+
+// renderPolicy Policy for the device, the content is the text pushed or shown
+func renderPolicy(policy models.NetPolicy, rules []models.NetPolicyRule, dev models.NetCpe) (string, string, error) {
+	method, err := app.GApp().PolicyMethod(dev)
+	if err != nil {
+		return "", "", err
+	}
+	if method == app.PolicyMethodRouteros {
+		return method, app.RenderRouterosPolicy(policy, rules), nil
+	}
+	items, err := app.GApp().PolicyInstances(policy.ID, dev.Sn)
+	if err != nil {
+		return method, "", err
+	}
+	instances := make(map[int64]int)
+	for _, item := range items {
+		instances[item.RuleId] = item.Instance
+	}
+	params, err := app.RenderTr181Policy(rules, instances)
+	if err != nil {
+		return method, "", err
+	}
+	return method, app.Tr181PolicyContent(params), nil
+}
+
+// pushPolicy Push the policy to the device, a RouterOS script download for Mikrotik
+// devices, AddObject, DeleteObject and SetParameterValues for the TR-181 devices
+func pushPolicy(policy models.NetPolicy, rules []models.NetPolicyRule, dev models.NetCpe, session string) {
+	method, content, err := renderPolicy(policy, rules, dev)
+	if err == nil {
+		switch method {
+		case app.PolicyMethodRouteros:
+			err = sendCwmpConfig(models.CwmpConfig{
+				ID:      fmt.Sprintf("policy-%d", policy.ID),
+				Name:    "Policy " + policy.Name,
+				Level:   "major",
+				Timeout: 120,
+				Content: content,
+			}, dev, session)
+		default:
+			err = app.GApp().PushTr181Policy(policy, rules, dev, session)
+			if err == nil {
+				go connectDeviceAuth(session, dev)
+			}
+		}
+	}
+	app.GApp().UpdatePolicyPush(policy.ID, dev.Sn, method, app.PolicyDigest(content), session, err)
+	if err != nil {
+		events.PubSuperviseLog(dev.ID, session, "error", fmt.Sprintf("Push policy %s: %s", policy.Name, err.Error()))
+		return
+	}
+	events.PubSuperviseLog(dev.ID, session, "info", fmt.Sprintf("Policy %s pushed", policy.Name))
+}
@@ -0,0 +1,79 @@
+package supervise
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/ca17/teamsacs/app"
+	"github.com/ca17/teamsacs/common"
+	"github.com/ca17/teamsacs/common/web"
+	"github.com/ca17/teamsacs/models"
+	"github.com/ca17/teamsacs/webserver"
+	"github.com/labstack/echo/v4"
+)
+
+func initRadiusRouter() {
+
+	webserver.GET("/admin/supervise/radius/nas/query", func(c echo.Context) error {
+		var data []models.NetRadiusNas
+		common.Must(app.GDB().Order("ipaddr").Find(&data).Error)
+		return c.JSON(http.StatusOK, data)
+	})
+
+	// The secret is written only, an empty value keeps the stored one
+	saveNas := func(c echo.Context, create bool) error {
+		form := new(models.NetRadiusNas)
+		common.Must(c.Bind(form))
+		common.MustNotEmpty("NAS address", form.Ipaddr)
+		if create {
+			form.ID = 0
+		} else if form.ID == 0 {
+			return c.JSON(http.StatusOK, web.RestError("NAS ID is required"))
+		}
+		if err := app.GApp().SaveRadiusNas(form, c.FormValue("secret")); err != nil {
+			return c.JSON(http.StatusOK, web.RestError(err.Error()))
+		}
+		webserver.PubOpLog(c, fmt.Sprintf("Save RADIUS NAS %s", form.Ipaddr))
+		return c.JSON(http.StatusOK, web.RestSucc("success"))
+	}
+
+	webserver.POST("/admin/supervise/radius/nas/add", func(c echo.Context) error {
+		return saveNas(c, true)
+	})
+
+	webserver.POST("/admin/supervise/radius/nas/update", func(c echo.Context) error {
+		return saveNas(c, false)
+	})
+
+	webserver.GET("/admin/supervise/radius/nas/delete", func(c echo.Context) error {
+		ids := c.QueryParam("ids")
+		common.Must(app.GDB().Delete(models.NetRadiusNas{}, strings.Split(ids, ",")).Error)
+		webserver.PubOpLog(c, fmt.Sprintf("Delete RADIUS NAS %s", ids))
+		return c.JSON(http.StatusOK, web.RestSucc("success"))
+	})
+
+	webserver.GET("/admin/supervise/radius/session/query", func(c echo.Context) error {
+		prequery := web.NewPreQuery(c).
+			DefaultOrderBy("update_time desc").
+			QueryField("sn", "sn").
+			QueryField("status", "status").
+			QueryField("nas_ipaddr", "nas_ipaddr").
+			KeyFields("username", "sn", "framed_ip", "calling_station_id", "acct_session_id")
+
+		result, err := web.QueryPageResult[models.NetRadiusSession](c, app.GDB(), prequery)
+		if err != nil {
+			return c.JSON(http.StatusOK, common.EmptyList)
+		}
+		return c.JSON(http.StatusOK, result)
+	})
+
+	// Last session of the device, an empty object when the NAS never reported it
+	webserver.GET("/admin/supervise/radius/session/device", func(c echo.Context) error {
+		sess, ok := app.GApp().DeviceRadiusSession(c.QueryParam("sn"))
+		if !ok {
+			return c.JSON(http.StatusOK, map[string]interface{}{})
+		}
+		return c.JSON(http.StatusOK, sess)
+	})
+}
@@ -0,0 +1,142 @@
+package supervise
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/ca17/teamsacs/app"
+	"github.com/ca17/teamsacs/common"
+	"github.com/ca17/teamsacs/common/web"
+	"github.com/ca17/teamsacs/models"
+	"github.com/ca17/teamsacs/webserver"
+	"github.com/labstack/echo/v4"
+)
+
+func initSubscriberRouter() {
+
+	webserver.GET("/admin/supervise/subscriber/query", func(c echo.Context) error {
+		prequery := web.NewPreQuery(c).
+			DefaultOrderBy("updated_at desc").
+			QueryField("status", "status").
+			QueryField("service_plan", "service_plan").
+			QueryField("provision_status", "provision_status").
+			QueryField("odp_id", "odp_id").
+			KeyFields("account_id", "name", "phone", "address", "pon_sn", "cpe_sn", "pppoe_username")
+
+		result, err := web.QueryPageResult[models.NetSubscriber](c, app.GDB(), prequery)
+		if err != nil {
+			return c.JSON(http.StatusOK, common.EmptyList)
+		}
+		return c.JSON(http.StatusOK, result)
+	})
+
+	// The passwords are written only, empty values keep the stored ones
+	saveSubscriber := func(c echo.Context, create bool) error {
+		form := new(models.NetSubscriber)
+		common.Must(c.Bind(form))
+		common.MustNotEmpty("Account ID", form.AccountId)
+		if create {
+			form.ID = 0
+		} else if form.ID == 0 {
+			return c.JSON(http.StatusOK, web.RestError("Subscriber ID is required"))
+		}
+		if err := checkSubscriberOdp(form); err != nil {
+			return c.JSON(http.StatusOK, web.RestError(err.Error()))
+		}
+		err := app.GApp().SaveSubscriber(form, c.FormValue("pppoe_password"), c.FormValue("wifi_password"))
+		if err != nil {
+			return c.JSON(http.StatusOK, web.RestError(err.Error()))
+		}
+		webserver.PubOpLog(c, fmt.Sprintf("Save subscriber %s", form.AccountId))
+		return c.JSON(http.StatusOK, web.RestSucc("success"))
+	}
+
+	webserver.POST("/admin/supervise/subscriber/add", func(c echo.Context) error {
+		return saveSubscriber(c, true)
+	})
+
+	webserver.POST("/admin/supervise/subscriber/update", func(c echo.Context) error {
+		return saveSubscriber(c, false)
+	})
+
+	webserver.GET("/admin/supervise/subscriber/delete", func(c echo.Context) error {
+		ids := c.QueryParam("ids")
+		common.Must(app.GDB().Delete(models.NetSubscriber{}, strings.Split(ids, ",")).Error)
+		webserver.PubOpLog(c, fmt.Sprintf("Delete subscribers %s", ids))
+		return c.JSON(http.StatusOK, web.RestSucc("success"))
+	})
+
+	// Link a subscriber to a device by hand, for devices already bootstrapped
+	webserver.POST("/admin/supervise/subscriber/link", func(c echo.Context) error {
+		sub, dev, err := subscriberDevice(c.FormValue("id"), c.FormValue("devid"))
+		if err != nil {
+			return c.JSON(http.StatusOK, web.RestError(err.Error()))
+		}
+		var count int64
+		app.GDB().Model(&models.NetSubscriber{}).Where("cpe_sn = ? and id <> ?", dev.Sn, sub.ID).Count(&count)
+		if count > 0 {
+			return c.JSON(http.StatusOK, web.RestError("The device is linked to another subscriber"))
+		}
+		common.Must(app.GApp().LinkSubscriber(&sub, dev))
+		webserver.PubOpLog(c, fmt.Sprintf("Link subscriber %s to %s", sub.AccountId, dev.Sn))
+		return c.JSON(http.StatusOK, web.RestSucc("success"))
+	})
+
+	// Apply the WAN and WiFi settings of the subscriber to the linked device now
+	webserver.POST("/admin/supervise/subscriber/provision", func(c echo.Context) error {
+		var sub models.NetSubscriber
+		if err := app.GDB().Where("id = ?", c.FormValue("id")).First(&sub).Error; err != nil {
+			return c.JSON(http.StatusOK, web.RestError("Subscriber not found"))
+		}
+		var dev models.NetCpe
+		if sub.CpeSn == "" || app.GDB().Where("sn = ?", sub.CpeSn).First(&dev).Error != nil {
+			return c.JSON(http.StatusOK, web.RestError("The subscriber is not linked to a device"))
+		}
+		cpe := app.GApp().CwmpTable().GetCwmpCpe(dev.Sn)
+		session, err := cpe.PushSubscriberConfig(sub, 5000, true)
+		if err != nil {
+			return c.JSON(http.StatusOK, web.RestError(err.Error()))
+		}
+		go connectDeviceAuth(session, dev)
+		webserver.PubOpLog(c, fmt.Sprintf("Provision subscriber %s on %s", sub.AccountId, dev.Sn))
+		return c.JSON(http.StatusOK, web.RestSucc("The settings are sent, the status is updated when the device applies them"))
+	})
+}
+
+// checkSubscriberOdp The ODP port is in the ODP capacity and not used by another subscriber
+func checkSubscriberOdp(sub *models.NetSubscriber) error {
+	if sub.OdpId == 0 {
+		sub.OdpPort = 0
+		return nil
+	}
+	var odp models.OdpDevice
+	if err := app.GDB().Where("id = ?", sub.OdpId).First(&odp).Error; err != nil {
+		return fmt.Errorf("ODP not found")
+	}
+	if sub.OdpPort <= 0 {
+		return nil
+	}
+	if odp.Capacity > 0 && sub.OdpPort > odp.Capacity {
+		return fmt.Errorf("ODP %s has %d ports", odp.Name, odp.Capacity)
+	}
+	var count int64
+	app.GDB().Model(&models.NetSubscriber{}).
+		Where("odp_id = ? and odp_port = ? and id <> ?", sub.OdpId, sub.OdpPort, sub.ID).Count(&count)
+	if count > 0 {
+		return fmt.Errorf("port %d of ODP %s is used by another subscriber", sub.OdpPort, odp.Name)
+	}
+	return nil
+}
+
+func subscriberDevice(id, devid string) (models.NetSubscriber, models.NetCpe, error) {
+	var sub models.NetSubscriber
+	var dev models.NetCpe
+	if err := app.GDB().Where("id = ?", id).First(&sub).Error; err != nil {
+		return sub, dev, fmt.Errorf("Subscriber not found")
+	}
+	if err := app.GDB().Where("id = ?", devid).First(&dev).Error; err != nil {
+		return sub, dev, fmt.Errorf("Device not found")
+	}
+	return sub, dev, nil
+}
@@ -28,6 +28,7 @@ func InitRouter() {
 		var opts = make([]web.JsonOptions, 0)
 		opts = append(opts, web.JsonOptions{Id: "cwmp", Value: "TR069 Preset"})
 		opts = append(opts, web.JsonOptions{Id: "cwmpconfig", Value: "TR069 Config"})
+		opts = append(opts, web.JsonOptions{Id: "mikrotikapi", Value: "RouterOS API"})
 		return c.JSON(http.StatusOK, opts)
 	})
 
@@ -51,7 +52,8 @@ func InitRouter() {
 				log.Error(err)
 			}
 			for _, sdata := range data {
-				if !app.GApp().MatchDevice(dev, sdata.Oui, sdata.ProductClass, sdata.SoftwareVersion) {
+				if !app.GApp().MatchDevice(dev, sdata.Oui, sdata.ProductClass, sdata.SoftwareVersion) ||
+					!app.GApp().MatchDeviceGroup(dev.Sn, sdata.DeviceGroup) {
 					continue
 				}
 				actions = append(actions, SuperviseAction{
@@ -63,6 +65,10 @@ func InitRouter() {
 			}
 		case "cwmp":
 			actions = append(actions, cwmpCmds...)
+		case "mikrotikapi":
+			if app.IsMikrotik(dev) {
+				actions = append(actions, mikrotikApiCmds...)
+			}
 		}
 
 		return c.JSON(http.StatusOK, actions)
@@ -70,10 +76,23 @@ func InitRouter() {
 
 	webserver.POST("/admin/superviselog/firmware/update", func(c echo.Context) error {
 		var devids, session, firmwareid string
+		var groupid int64
 		common.Must(web.NewParamReader(c).
-			ReadRequiedString(&devids, "devids").
+			ReadString(&devids, "devids").
+			ReadInt64(&groupid, "groupid", 0).
 			ReadRequiedString(&session, "session").
 			ReadRequiedString(&firmwareid, "firmwareid").LastError)
+		// Target all devices of a device group
+		if devids == "" && groupid > 0 {
+			query, err := app.GApp().DeviceGroupQuery(groupid)
+			if err != nil {
+				return c.JSON(http.StatusOK, web.RestError(err.Error()))
+			}
+			var ids []string
+			common.Must(query.Pluck("net_cpe.id", &ids).Error)
+			devids = strings.Join(ids, ",")
+		}
+		common.MustNotEmpty("devids", devids)
 		return execCwmpUpdateFirmware(c, strings.Split(devids, ","), firmwareid, session)
 	})
 
@@ -90,6 +109,8 @@ func InitRouter() {
 			return execCwmp(c, id, deviceId, session)
 		case "cwmpconfig":
 			return execCwmpConfig(c, id, deviceId, session)
+		case "mikrotikapi":
+			return execMikrotikApi(c, id, deviceId, session)
 		}
 		return c.JSON(200, web.RestError("unsupported action type "+stype))
 	})
@@ -141,6 +162,30 @@ func InitRouter() {
 		}
 	})
 
+	// Single parameter write, validated against the stored type and writability
+	webserver.POST("/admin/supervise/param/set", func(c echo.Context) error {
+		var devid, name string
+		common.Must(web.NewParamReader(c).
+			ReadRequiedString(&devid, "devid").
+			ReadRequiedString(&name, "name").LastError)
+		value := c.FormValue("value")
+		vtype := c.FormValue("type")
+
+		var dev models.NetCpe
+		err := app.GDB().Where("id=?", devid).First(&dev).Error
+		if err != nil {
+			return c.JSON(http.StatusOK, web.RestError("Device not found"))
+		}
+
+		path, err := cwmpSetParameterValue(dev, name, vtype, value)
+		if err != nil {
+			return c.JSON(http.StatusOK, web.RestError(err.Error()))
+		}
+
+		webserver.PubOpLog(c, fmt.Sprintf("Set parameter for %s: %s=%s", dev.Sn, path, value))
+		return c.JSON(200, web.RestSucc("Parameter command sent, will take effect after device applies changes"))
+	})
+
 	// WiFi settings edit endpoint
 	webserver.POST("/admin/supervise/wifi/set", func(c echo.Context) error {
 		var devid string
@@ -312,37 +357,31 @@ func InitRouter() {
 		return c.JSON(200, web.RestSucc("Web credentials push command sent"))
 	})
 
-	// Push web credentials to ALL online devices
+	// Push web credentials to ALL online devices, run as a bulk job
 	webserver.POST("/admin/supervise/webcreds/pushall", func(c echo.Context) error {
 		var devices []models.NetCpe
-		err := app.GDB().Where("cwmp_status = ?", "online").Find(&devices).Error
+		err := app.GDB().Model(&models.NetCpe{}).Select("id", "sn").
+			Where("cwmp_status = ?", "online").Order("sn asc").Find(&devices).Error
 		if err != nil {
 			return c.JSON(http.StatusOK, web.RestError("Failed to query devices"))
 		}
-
-		pushed := 0
-		for _, dev := range devices {
-			session := "pushall-" + common.UUID()
-			cpe := app.GApp().CwmpTable().GetCwmpCpe(dev.Sn)
-
-			// Push web credentials
-			err := cpe.PushWebCredentials(session+"-creds", 1000, false)
-			if err != nil {
-				log.Errorf("PushWebCredentials failed for %s: %s", dev.Sn, err.Error())
-			}
-
-			// Push periodic inform settings
-			err = cpe.PushPeriodicInform(session+"-inform", 1000, false)
-			if err != nil {
-				log.Errorf("PushPeriodicInform failed for %s: %s", dev.Sn, err.Error())
-			}
-
-			go connectDeviceAuth(session, dev)
-			pushed++
+		if len(devices) == 0 {
+			return c.JSON(http.StatusOK, web.RestError("No online device"))
 		}
-
-		webserver.PubOpLog(c, fmt.Sprintf("Push settings to all devices: %d/%d", pushed, len(devices)))
-		return c.JSON(200, web.RestSucc(fmt.Sprintf("Settings pushed to %d devices", pushed)))
+		job := &models.CwmpBulkJob{
+			Name:        "Push settings to all devices " + time.Now().Format("2006-01-02 15:04"),
+			ActionType:  "webcreds",
+			ActionId:    "webcreds",
+			TargetType:  "filter",
+			Target:      `cwmp_status = "online"`,
+			Concurrency: 20,
+			Operator:    webserver.GetCurrUser(c).Username,
+		}
+		if err = createBulkJob(job, devices); err != nil {
+			return c.JSON(http.StatusOK, web.RestError(err.Error()))
+		}
+		webserver.PubOpLog(c, fmt.Sprintf("Push settings to all devices: bulk job %d on %d devices", job.ID, len(devices)))
+		return c.JSON(200, web.RestSucc(fmt.Sprintf("Bulk job created for %d devices", len(devices))))
 	})
 
 	// OLT SNMP integration
@@ -351,4 +390,25 @@ func InitRouter() {
 	// ODC & ODP management
 	initOdcOdpRouter()
 
+	// Parameter tree discovery
+	initParamTreeRouter()
+
+	// Bulk jobs
+	initBulkRouter()
+
+	// RouterOS API management
+	initMikrotikApiRouter()
+
+	// Firewall, routing and address list policies
+	initPolicyRouter()
+
+	// Subscribers and zero touch provisioning
+	initSubscriberRouter()
+
+	// RADIUS accounting NAS and PPPoE sessions
+	initRadiusRouter()
+
+	// GIS export and coordinates import of the network plant
+	initGisRouter()
+
 }
@@ -0,0 +1,45 @@
+package vparams
+
+import (
+	"net/http"
+
+	"github.com/ca17/teamsacs/app"
+	"github.com/ca17/teamsacs/common"
+	"github.com/ca17/teamsacs/common/datamodel"
+	"github.com/ca17/teamsacs/common/web"
+	"github.com/ca17/teamsacs/webserver"
+	"github.com/labstack/echo/v4"
+)
+
+func initDataModelRouter() {
+
+	// Schema definitions of one data model, model=TR-098|TR-181
+	webserver.GET("/admin/cwmp/datamodel/schema", func(c echo.Context) error {
+		model := c.QueryParam("model")
+		if datamodel.RootOf(model) == "" {
+			return c.JSON(http.StatusOK, web.RestError("model must be TR-098 or TR-181"))
+		}
+		return c.JSON(http.StatusOK, web.RestResult(app.DataModelSchema().Parameters(model)))
+	})
+
+	// Translate paths into the data model of a device or the given model
+	webserver.GET("/admin/cwmp/datamodel/translate", func(c echo.Context) error {
+		var path string
+		common.Must(web.NewParamReader(c).ReadRequiedString(&path, "path").LastError)
+		model := c.QueryParam("model")
+		if sn := c.QueryParam("sn"); sn != "" {
+			model = app.GApp().CwmpTable().GetCwmpCpe(sn).GetDataModel()
+		}
+		if datamodel.RootOf(model) == "" {
+			return c.JSON(http.StatusOK, web.RestError("unknown data model"))
+		}
+		result, ok := app.DataModelSchema().Translate(path, model)
+		unmapped := !ok && datamodel.ModelOf(path) != model
+		return c.JSON(http.StatusOK, web.RestResult(map[string]interface{}{
+			"path":       common.If(unmapped, "", result),
+			"model":      model,
+			"translated": ok,
+			"unmapped":   unmapped,
+		}))
+	})
+}
@@ -0,0 +1,111 @@
+package vparams
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/ca17/teamsacs/app"
+	"github.com/ca17/teamsacs/common"
+	"github.com/ca17/teamsacs/common/web"
+	"github.com/ca17/teamsacs/models"
+	"github.com/ca17/teamsacs/webserver"
+	"github.com/labstack/echo/v4"
+)
+
+func InitRouter() {
+
+	initDataModelRouter()
+
+	webserver.GET("/admin/cwmp/vparams", func(c echo.Context) error {
+		return c.Render(http.StatusOK, "cwmp_vparams", nil)
+	})
+
+	webserver.GET("/admin/cwmp/vparams/query", func(c echo.Context) error {
+		prequery := web.NewPreQuery(c).
+			DefaultOrderBy("name asc").
+			KeyFields("name", "manufacturer", "product_class", "get_paths", "set_paths", "remark")
+
+		result, err := web.QueryPageResult[models.CwmpVirtualParam](c, app.GDB(), prequery)
+		if err != nil {
+			return c.JSON(http.StatusOK, common.EmptyList)
+		}
+		return c.JSON(http.StatusOK, result)
+	})
+
+	webserver.GET("/admin/cwmp/vparams/options", func(c echo.Context) error {
+		var names []string
+		common.Must(app.GDB().Model(&models.CwmpVirtualParam{}).Distinct("name").Order("name").Pluck("name", &names).Error)
+		var opts = make([]web.JsonOptions, 0)
+		for _, name := range names {
+			opts = append(opts, web.JsonOptions{Id: name, Value: name})
+		}
+		return c.JSON(http.StatusOK, opts)
+	})
+
+	webserver.POST("/admin/cwmp/vparams/add", func(c echo.Context) error {
+		form := new(models.CwmpVirtualParam)
+		common.Must(c.Bind(form))
+		form.ID = common.UUIDint64()
+		common.Must(checkVirtualParam(form))
+		form.CreatedAt = time.Now()
+		form.UpdatedAt = time.Now()
+		common.Must(app.GDB().Create(form).Error)
+		common.Must(app.GApp().ReloadVirtualParams())
+		webserver.PubOpLog(c, fmt.Sprintf("Create virtual parameter：%v", form))
+		return c.JSON(http.StatusOK, web.RestSucc("success"))
+	})
+
+	webserver.POST("/admin/cwmp/vparams/update", func(c echo.Context) error {
+		form := new(models.CwmpVirtualParam)
+		common.Must(c.Bind(form))
+		common.Must(checkVirtualParam(form))
+		form.UpdatedAt = time.Now()
+		form.Edited = true
+		common.Must(app.GDB().Save(form).Error)
+		common.Must(app.GApp().ReloadVirtualParams())
+		webserver.PubOpLog(c, fmt.Sprintf("Update virtual parameter：%v", form))
+		return c.JSON(http.StatusOK, web.RestSucc("success"))
+	})
+
+	webserver.GET("/admin/cwmp/vparams/delete", func(c echo.Context) error {
+		ids := c.QueryParam("ids")
+		common.Must(app.GDB().Delete(models.CwmpVirtualParam{}, strings.Split(ids, ",")).Error)
+		common.Must(app.GApp().ReloadVirtualParams())
+		webserver.PubOpLog(c, fmt.Sprintf("Delete virtual parameter：%s", ids))
+		return c.JSON(http.StatusOK, web.RestSucc("success"))
+	})
+
+	// Resolved virtual parameter values of a device
+	webserver.GET("/admin/cwmp/vparams/device", func(c echo.Context) error {
+		var sn string
+		common.Must(web.NewParamReader(c).ReadRequiedString(&sn, "sn").LastError)
+		var dev models.NetCpe
+		err := app.GDB().Where("sn = ?", sn).First(&dev).Error
+		if err != nil {
+			return c.JSON(http.StatusOK, web.RestError("Device not found"))
+		}
+		cpe := app.GApp().CwmpTable().GetCwmpCpe(sn)
+		if cpe.Manufacturer == "" {
+			cpe.Manufacturer = dev.Manufacturer
+			cpe.ProductClass = dev.ProductClass
+		}
+		return c.JSON(http.StatusOK, web.RestResult(cpe.ResolveVirtualParams(app.GApp().GetCpeParamMap(sn))))
+	})
+
+}
+
+func checkVirtualParam(form *models.CwmpVirtualParam) error {
+	common.MustNotEmpty("Name", form.Name)
+	if !app.IsVirtualParam(form.Name) {
+		return fmt.Errorf("name must start with %s", app.VirtualParamPrefix)
+	}
+	if form.Manufacturer == "" {
+		form.Manufacturer = "any"
+	}
+	if form.ProductClass == "" {
+		form.ProductClass = "any"
+	}
+	return nil
+}
@@ -14,14 +14,29 @@ const (
 	EventCwmpSuperviseStatus = "EventCwmpSuperviseStatus"
 )
 
+// Forwarder Sends the supervise events to the other ACS instances of the cluster
+var Forwarder func(topic string, args ...interface{})
+
+func publish(topic string, args ...interface{}) {
+	Supervisor.Publish(topic, args...)
+	if Forwarder != nil {
+		Forwarder(topic, args...)
+	}
+}
+
+// PublishRemote Publish locally an event received from another ACS instance
+func PublishRemote(topic string, args ...interface{}) {
+	Supervisor.Publish(topic, args...)
+}
+
 func PubSuperviseLog(devid int64, session, level, message string) {
-	Supervisor.Publish(EventSuperviseLog, devid, session, level, message)
+	publish(EventSuperviseLog, devid, session, level, message)
 }
 
 func PubSuperviseStatus(devid int64, action, message string) {
-	Supervisor.Publish(EventSuperviseStatus, devid, action, message)
+	publish(EventSuperviseStatus, devid, action, message)
 }
 
 func PubEventCwmpSuperviseStatus(sn, session, level, message string) {
-	Supervisor.Publish(EventCwmpSuperviseStatus, sn, session, level, message)
+	publish(EventCwmpSuperviseStatus, sn, session, level, message)
 }
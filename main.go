@@ -1,10 +1,14 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
 	"os"
+	"os/signal"
 	"runtime"
+	"syscall"
+	"time"
 	_ "time/tzdata"
 
 	"github.com/ca17/teamsacs/app"
@@ -23,6 +27,9 @@ var (
 	g errgroup.Group
 )
 
+// shutdownTimeout Time given to the CWMP sessions in progress to finish
+const shutdownTimeout = 30 * time.Second
+
 // 命令行定义
 var (
 	h         = flag.Bool("h", false, "help usage")
@@ -82,8 +89,6 @@ func main() {
 	oltPoller := snmp.NewOLTPoller(5)
 	go oltPoller.Start()
 
-	defer app.Release()
-
 	// 管理服务启动
 	g.Go(func() error {
 		webserver.Init()
@@ -96,7 +101,44 @@ func main() {
 		return tr069.Listen()
 	})
 
-	if err := g.Wait(); err != nil {
-		log.Fatal(err)
+	g.Go(func() error {
+		return tr069.ListenStun()
+	})
+
+	g.Go(func() error {
+		return tr069.ListenXmpp()
+	})
+
+	g.Go(func() error {
+		return tr069.ListenRadiusAcct()
+	})
+
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGTERM, syscall.SIGINT)
+	errc := make(chan error, 1)
+	go func() {
+		errc <- g.Wait()
+	}()
+
+	select {
+	case err := <-errc:
+		if err != nil {
+			app.Release()
+			log.Fatal(err)
+		}
+	case sig := <-sigs:
+		log.Infof("Received signal %s, shutting down", sig)
+		ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+		if err := tr069.Shutdown(ctx); err != nil {
+			log.Errorf("Tr069 server shutdown error %s", err.Error())
+		}
+		if err := webserver.Shutdown(ctx); err != nil {
+			log.Errorf("Management server shutdown error %s", err.Error())
+		}
+		// the listeners write to the database, they are stopped before it is released
+		tr069.CloseListeners(ctx)
 	}
+	oltPoller.Stop()
+	app.Release()
 }
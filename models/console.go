@@ -0,0 +1,51 @@
+package models
+
+import "time"
+
+// NetCpeSsh SSH access of a device
+type NetCpeSsh struct {
+	ID         int64     `json:"id,string"`
+	Sn         string    `gorm:"uniqueIndex" json:"sn"`
+	Address    string    `json:"address"`            // device address, the ConnectionRequestURL host when empty
+	Port       int       `json:"port"`               // default 22
+	Username   string    `json:"username"`           // SSH account
+	Password   string    `json:"-"`                  // encrypted
+	PrivateKey string    `gorm:"type:text" json:"-"` // encrypted PEM private key
+	LastStatus string    `json:"last_status"`
+	LastError  string    `json:"last_error"`
+	LastTime   time.Time `json:"last_time"`
+	CreatedAt  time.Time `json:"created_at"`
+	UpdatedAt  time.Time `json:"updated_at"`
+
+	// HostKey SSH server key pinned on the first connection, authorized_keys format
+	HostKey string `gorm:"type:text" json:"host_key"`
+	// KeyPassphrase encrypted passphrase of an encrypted private key
+	KeyPassphrase string `json:"-"`
+}
+
+// ConsoleCommandSet Command set run over SSH, the content is a template of the
+// device variables like the TR069 config scripts, one command per line
+type ConsoleCommandSet struct {
+	ID        int64     `json:"id,string" form:"id"`
+	Name      string    `json:"name" form:"name"`
+	Content   string    `gorm:"type:text" json:"content" form:"content"`
+	Timeout   int       `json:"timeout" form:"timeout"` // seconds per command
+	Remark    string    `json:"remark" form:"remark"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// ConsoleRun Commands run on one device and their output
+type ConsoleRun struct {
+	ID        int64     `json:"id,string"`
+	JobId     int64     `gorm:"index" json:"job_id,string"` // bulk job ID, 0 for the ad-hoc runs
+	Session   string    `gorm:"index" json:"session"`
+	Sn        string    `gorm:"index" json:"sn"`
+	Commands  string    `gorm:"type:text" json:"commands"`
+	Output    string    `gorm:"type:text" json:"output"`
+	Status    string    `gorm:"index" json:"status"` // running | success | failure
+	LastError string    `json:"last_error"`
+	Operator  string    `json:"operator"`
+	StartTime time.Time `json:"start_time"`
+	EndTime   time.Time `json:"end_time"`
+}
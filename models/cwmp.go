@@ -15,6 +15,7 @@ type CwmpConfig struct {
 	ProductClass    string    `json:"product_class" form:"product_class"`
 	Oui             string    `json:"oui" form:"oui"`
 	TaskTags        string    `gorm:"index" json:"task_tags" form:"task_tags"` // task label
+	DeviceGroup     int64     `json:"device_group,string" form:"device_group"` // device group ID, 0 for all
 	Content         string    `json:"content" form:"content"`                  // script content
 	TargetFilename  string    `json:"target_filename" form:"target_filename"`
 	Timeout         int64     `json:"timeout" form:"timeout"` // Execution Timeout Seconds
@@ -49,6 +50,41 @@ type CwmpEventData struct {
 	Message cwmp.Message `json:"message"`
 }
 
+// CwmpQueueItem RPC waiting for the next CWMP session of a CPE, shared by all ACS instances
+type CwmpQueueItem struct {
+	ID        int64     `json:"id,string"`
+	Sn        string    `gorm:"index" json:"sn"`
+	Hp        bool      `json:"hp"` // high priority queue
+	Session   string    `json:"session"`
+	Name      string    `json:"name"`                     // cwmp message name
+	MessageId string    `json:"message_id"`               // cwmp message ID
+	Content   string    `gorm:"type:text" json:"content"` // cwmp message xml
+	CreatedAt time.Time `gorm:"index" json:"created_at"`
+
+	// Encrypted The content holds secrets and is encrypted with the connection request key
+	Encrypted bool `json:"encrypted"`
+}
+
+// CwmpCpeSession CWMP session of a CPE shared by all ACS instances,
+// the requests following the Inform may reach another instance
+type CwmpCpeSession struct {
+	Sn            string    `gorm:"primaryKey" json:"sn"`
+	Session       string    `json:"session"`                 // session cookie value
+	Inform        string    `gorm:"type:text" json:"inform"` // json of the Inform opening the session
+	DiscoveryRpcs int       `json:"discovery_rpcs"`          // discovery requests sent in the session
+	UpdatedAt     time.Time `json:"updated_at"`
+}
+
+// CwmpPendingSet SetParameterValues sent to a CPE and waiting for the response,
+// shared by all ACS instances. Password values are not kept.
+type CwmpPendingSet struct {
+	ID        string    `gorm:"primaryKey" json:"id"` // cwmp message ID
+	Sn        string    `gorm:"index" json:"sn"`
+	Source    string    `json:"source"`                  // spv | preset
+	Params    string    `gorm:"type:text" json:"params"` // json name: {type, value}
+	CreatedAt time.Time `gorm:"index" json:"created_at"`
+}
+
 // CwmpFactoryReset factory settings script
 type CwmpFactoryReset struct {
 	ID              int64     `json:"id,string" form:"id"` // 主键 ID
@@ -57,6 +93,7 @@ type CwmpFactoryReset struct {
 	SoftwareVersion string    `json:"software_version" form:"software_version"`
 	ProductClass    string    `json:"product_class" form:"product_class"`
 	Oui             string    `json:"oui" form:"oui"`
+	DeviceGroup     int64     `json:"device_group,string" form:"device_group"` // device group ID, 0 for all
 	Content         string    `json:"content" form:"content"`
 	CreatedAt       time.Time `json:"created_at"`
 	UpdatedAt       time.Time `json:"updated_at"`
@@ -70,6 +107,7 @@ type CwmpFirmwareConfig struct {
 	SoftwareVersion string    `json:"software_version" form:"software_version"`
 	ProductClass    string    `json:"product_class" form:"product_class"`
 	Oui             string    `json:"oui" form:"oui"`
+	DeviceGroup     int64     `json:"device_group,string" form:"device_group"` // device group ID, 0 for all
 	Content         string    `json:"content" form:"content"`
 	CreatedAt       time.Time `json:"created_at"`
 	UpdatedAt       time.Time `json:"updated_at"`
@@ -85,6 +123,7 @@ type CwmpPreset struct {
 	Interval    int       `json:"interval" form:"interval"`
 	Content     string    `json:"content" form:"content"`
 	TaskTags    string    `gorm:"index" json:"task_tags" form:"task_tags"` // 任务标签
+	DeviceGroup int64     `json:"device_group,string" form:"device_group"` // 设备分组 ID, 0 为全部
 	CreatedAt   time.Time `json:"created_at"`
 	UpdatedAt   time.Time `json:"updated_at"`
 }
@@ -159,3 +198,59 @@ type CwmpPresetParameterValue struct {
 	Type  string `yaml:"type"`
 	Value string `yaml:"value"`
 }
+
+// CwmpVirtualParam maps a vendor neutral parameter name to device specific paths.
+// Name may contain an {i} placeholder (e.g. VirtualParameters.WifiSSID.{i}) which
+// is substituted into GetPaths and SetPaths.
+type CwmpVirtualParam struct {
+	ID           int64     `json:"id,string" form:"id"`                         // primary key ID
+	Name         string    `gorm:"index" json:"name" form:"name"`               // VirtualParameters.xxx
+	Manufacturer string    `json:"manufacturer" form:"manufacturer"`            // manufacturer keywords, comma separated, any
+	ProductClass string    `json:"product_class" form:"product_class"`          // product class list, comma separated, any
+	Priority     int       `json:"priority" form:"priority"`                    // lower value wins on equal match
+	GetPaths     string    `gorm:"type:text" json:"get_paths" form:"get_paths"` // candidate read paths, one per line
+	SetPaths     string    `gorm:"type:text" json:"set_paths" form:"set_paths"` // candidate write paths, one per line
+	ValueType    string    `json:"value_type" form:"value_type"`                // xsd type, e.g. xsd:string
+	Remark       string    `json:"remark" form:"remark"`                        // remark
+	CreatedAt    time.Time `json:"created_at"`
+	UpdatedAt    time.Time `json:"updated_at"`
+
+	// Edited set by an admin update, the builtin sync keeps the row then
+	Edited bool `json:"edited"`
+}
+
+// CwmpBulkJob runs one supervise action on a set of devices
+type CwmpBulkJob struct {
+	ID          int64     `json:"id,string" form:"id"`                   // primary key ID
+	Name        string    `json:"name" form:"name"`                      // job name
+	ActionType  string    `json:"action_type" form:"action_type"`        // cwmp | cwmpconfig | console
+	ActionId    string    `json:"action_id" form:"action_id"`            // cwmp action sid, config script ID or console command set ID
+	TargetType  string    `json:"target_type" form:"target_type"`        // list | group | filter
+	Target      string    `gorm:"type:text" json:"target" form:"target"` // device IDs, group ID or filter expression
+	Rate        int       `json:"rate" form:"rate"`                      // devices started per minute, 0 unlimited
+	Concurrency int       `json:"concurrency" form:"concurrency"`        // devices executed at the same time
+	Status      string    `gorm:"index" json:"status"`                   // running | paused | cancel | done
+	Total       int64     `json:"total"`
+	Success     int64     `json:"success"`
+	Failure     int64     `json:"failure"`
+	Operator    string    `json:"operator"`
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+
+	// Runner the ACS instance running the job, HeartbeatAt is refreshed while it runs
+	Runner      string    `json:"runner"`
+	HeartbeatAt time.Time `json:"heartbeat_at"`
+}
+
+// CwmpBulkJobItem result of a bulk job on one device
+type CwmpBulkJobItem struct {
+	ID        int64     `json:"id,string"`                  // primary key ID
+	JobId     int64     `gorm:"index" json:"job_id,string"` // bulk job ID
+	CpeId     int64     `json:"cpe_id,string"`
+	Sn        string    `json:"sn"`
+	Status    string    `gorm:"index" json:"status"` // pending | running | success | failure | cancel
+	Session   string    `gorm:"index" json:"session"`
+	Message   string    `json:"message"`
+	ExecTime  time.Time `json:"exec_time"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
@@ -36,7 +36,10 @@ type NetCpe struct {
 	CPUUsage        int64  `json:"cpu_usage" form:"cpu_usage"`                                   // CPE Percentage
 	CwmpStatus      string `gorm:"index"  json:"cwmp_status"`                                    // cwmp status
 	CwmpUrl         string `json:"cwmp_url"`
+	UdpConnReqAddr  string `json:"udp_connreq_addr"` // TR-111 UDPConnectionRequestAddress learned by STUN
+	XmppJid         string `json:"xmpp_jid"`         // ConnReqJabberID for XMPP connection requests
 	FactoryresetId  string `json:"factoryreset_id" form:"factoryreset_id"`
+	DataModel       string `gorm:"index" json:"data_model" form:"data_model"` // TR-098 | TR-181
 	// ONT-specific fields
 	PonSnHex       string    `json:"pon_sn_hex" form:"pon_sn_hex"`                    // PON Serial Number (HEX)
 	FiberRxPower   string    `json:"fiber_rx_power" form:"fiber_rx_power"`            // Optical RX Power (dBm)
@@ -50,10 +53,62 @@ type NetCpe struct {
 	CwmpLastInform time.Time `json:"cwmp_last_inform" `                               // CWMP last inform time
 	Remark         string    `json:"remark" form:"remark"`                            // Remark
 	OdpID          int64     `gorm:"index" json:"odp_id,string" form:"odp_id"`        // ODP assignment
+	OdpPort        int       `json:"odp_port" form:"odp_port"`                        // ODP port of the drop cable
+	DropLength     int       `json:"drop_length" form:"drop_length"`                  // Drop cable length in meters
+	Latitude       string    `json:"latitude" form:"latitude"`                        // Installation location
+	Longitude      string    `json:"longitude" form:"longitude"`
 	CreatedAt      time.Time `json:"created_at" `
 	UpdatedAt      time.Time `json:"updated_at"`
 }
 
+// NetCpeConnReq Connection request credentials pushed to a device and connection request health
+type NetCpeConnReq struct {
+	ID              int64     `json:"id,string"`
+	Sn              string    `gorm:"uniqueIndex" json:"sn"`
+	Username        string    `json:"username"`                     // confirmed by the device
+	Password        string    `json:"-"`                            // confirmed by the device, encrypted
+	PendingUsername string    `json:"pending_username"`             // pushed or waiting to be pushed
+	PendingPassword string    `json:"-"`                            // encrypted
+	PendingSession  string    `gorm:"index" json:"pending_session"` // SetParameterValues session of the push
+	ConfirmedAt     time.Time `json:"confirmed_at"`                 // last SetParameterValuesResponse confirmation
+	Method          string    `json:"method"`                       // last connection request method http | udp | xmpp
+	LastStatus      string    `gorm:"index" json:"last_status"`     // success | failure
+	LastError       string    `json:"last_error"`                   // last failure reason
+	LastLatency     int64     `json:"last_latency"`                 // milliseconds
+	AvgLatency      int64     `json:"avg_latency"`                  // average milliseconds of successful requests
+	Success         int64     `json:"success"`                      // successful connection requests
+	Failure         int64     `json:"failure"`                      // failed connection requests
+	LastTime        time.Time `json:"last_time"`                    // last connection request time
+	CreatedAt       time.Time `json:"created_at"`
+	UpdatedAt       time.Time `json:"updated_at"`
+}
+
+// NetCpeApi RouterOS API access of a Mikrotik device
+type NetCpeApi struct {
+	ID         int64     `json:"id,string"`
+	Sn         string    `gorm:"uniqueIndex" json:"sn"`
+	Address    string    `json:"address"`  // device address, the ConnectionRequestURL host when empty
+	Port       int       `json:"port"`     // 8728 | 8729 with TLS
+	UseTls     bool      `json:"use_tls"`  // api-ssl service
+	Username   string    `json:"username"` // API account
+	Password   string    `json:"-"`        // encrypted
+	LastStatus string    `json:"last_status"`
+	LastError  string    `json:"last_error"`
+	LastTime   time.Time `json:"last_time"`
+	CreatedAt  time.Time `json:"created_at"`
+	UpdatedAt  time.Time `json:"updated_at"`
+}
+
+// NetCpeGroup dynamic device group, devices are selected by a filter expression
+type NetCpeGroup struct {
+	ID         int64     `json:"id,string" form:"id"`
+	Name       string    `json:"name" form:"name"`
+	Expression string    `gorm:"type:text" json:"expression" form:"expression"` // e.g. manufacturer = "ZTE" AND node = "Jakarta"
+	Remark     string    `json:"remark" form:"remark"`
+	CreatedAt  time.Time `json:"created_at"`
+	UpdatedAt  time.Time `json:"updated_at"`
+}
+
 // NetCpeParam CPE 参数
 type NetCpeParam struct {
 	ID        string    `gorm:"primaryKey" json:"string"` // primaryKey ID
@@ -61,12 +116,42 @@ type NetCpeParam struct {
 	Tag       string    `gorm:"index" json:"tag" `
 	Name      string    `gorm:"index" json:"name" `
 	Value     string    `json:"value" `
+	Type      string    `json:"type"` // xsd type
 	Remark    string    `json:"remark"`
 	Writable  string    `json:"writable"`
 	CreatedAt time.Time `json:"created_at"`
 	UpdatedAt time.Time `json:"updated_at"`
 }
 
+// NetCpeParamHistory CPE parameter value change record
+type NetCpeParamHistory struct {
+	ID        int64     `json:"id,string"` // primaryKey ID
+	Sn        string    `gorm:"index" json:"sn"`
+	Name      string    `gorm:"index" json:"name"`
+	OldValue  string    `json:"old_value"`
+	NewValue  string    `json:"new_value"`
+	Type      string    `json:"type"`
+	Source    string    `gorm:"index" json:"source"` // inform | gpv | spv | preset
+	Session   string    `json:"session"`
+	CreatedAt time.Time `gorm:"index" json:"created_at"`
+}
+
+// NetCpeParamNode CPE parameter tree node found by GetParameterNames discovery
+type NetCpeParamNode struct {
+	ID        string    `gorm:"primaryKey" json:"id"` // md5(sn+path)
+	Sn        string    `gorm:"index" json:"sn"`
+	Path      string    `json:"path"`
+	Parent    string    `gorm:"index" json:"parent"`
+	Object    bool      `json:"object"`
+	Writable  string    `json:"writable"`
+	Depth     int       `json:"depth"`
+	Status    string    `gorm:"index" json:"status"` // pending | running | done | failure
+	Session   string    `json:"session"`
+	Attempts  int       `json:"attempts"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
 type NetCpeTaskQue struct {
 	ID     int64  `json:"id,string"` // primaryKey ID
 	Sn     string `json:"sn"`        // devise serial number
@@ -18,6 +18,11 @@ type OdcDevice struct {
 	Remark    string    `json:"remark" form:"remark"`
 	CreatedAt time.Time `json:"created_at"`
 	UpdatedAt time.Time `json:"updated_at"`
+
+	// Optical path
+	SplitRatio   int     `json:"split_ratio" form:"split_ratio"`     // splitter outputs, the capacity when 0
+	SplitterLoss float64 `json:"splitter_loss" form:"splitter_loss"` // insertion loss dB, the typical loss of the ratio when 0
+	FeederLength int     `json:"feeder_length" form:"feeder_length"` // feeder cable meters from the OLT PON port
 }
 
 // OdpDevice represents an Optical Distribution Point
@@ -34,4 +39,24 @@ type OdpDevice struct {
 	Remark    string    `json:"remark" form:"remark"`
 	CreatedAt time.Time `json:"created_at"`
 	UpdatedAt time.Time `json:"updated_at"`
+
+	// Optical path
+	OdcPort      int     `json:"odc_port" form:"odc_port"`           // ODC splitter output
+	CableLength  int     `json:"cable_length" form:"cable_length"`   // distribution cable meters from the ODC
+	SplitterLoss float64 `json:"splitter_loss" form:"splitter_loss"` // insertion loss dB, the typical loss of the capacity when 0
+}
+
+// NetCpeOptical Optical budget check of an ONU, the RX power expected from the
+// fibre path against the measured one
+type NetCpeOptical struct {
+	ID         int64     `json:"id,string"`
+	Sn         string    `gorm:"uniqueIndex" json:"sn"`
+	OdpId      int64     `gorm:"index" json:"odp_id,string"`
+	OdpPort    int       `json:"odp_port"`
+	ExpectedRx float64   `json:"expected_rx"`         // dBm
+	MeasuredRx float64   `json:"measured_rx"`         // dBm
+	Deviation  float64   `json:"deviation"`           // measured - expected, dB
+	Status     string    `gorm:"index" json:"status"` // normal | deviated | unknown
+	Detail     string    `json:"detail"`
+	CheckTime  time.Time `json:"check_time"`
 }
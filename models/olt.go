@@ -13,6 +13,9 @@ type OltDevice struct {
 	SNMPCommunity string    `json:"snmp_community" form:"snmp_community"`
 	Manufacturer  string    `json:"manufacturer" form:"manufacturer"` // ZTE
 	Model         string    `json:"model" form:"model"`               // C620, C320
+	PonTxPower    float64   `json:"pon_tx_power" form:"pon_tx_power"` // PON port launch power dBm, 0 uses the default
+	Latitude      string    `json:"latitude" form:"latitude"`
+	Longitude     string    `json:"longitude" form:"longitude"`
 	Status        string    `gorm:"index" json:"status" form:"status"`
 	SysName       string    `json:"sys_name"`
 	SysDescr      string    `json:"sys_descr"`
@@ -20,6 +23,8 @@ type OltDevice struct {
 	LastPollAt    time.Time `json:"last_poll_at"`
 	CreatedAt     time.Time `json:"created_at"`
 	UpdatedAt     time.Time `json:"updated_at"`
+
+	SNMPWriteCommunity string `json:"-" form:"snmp_write_community"` // ONU actions, the read community when empty, never sent to the UI
 }
 
 // OltOnuData stores ONU data polled from OLT via SNMP
@@ -39,3 +44,27 @@ type OltOnuData struct {
 	CreatedAt    time.Time `json:"created_at"`
 	UpdatedAt    time.Time `json:"updated_at"`
 }
+
+// OltPortData stores the PON and uplink port state polled from OLT via SNMP,
+// the rates are computed from the counters of the previous poll
+type OltPortData struct {
+	ID          int64     `gorm:"primaryKey;autoIncrement" json:"id,string"`
+	OltID       int64     `gorm:"uniqueIndex:idx_olt_port" json:"olt_id,string"`
+	IfIndex     int       `gorm:"uniqueIndex:idx_olt_port" json:"if_index"`
+	Name        string    `json:"name"`              // e.g. gpon_olt-1/2/9, xgei-1/21/1
+	Kind        string    `gorm:"index" json:"kind"` // pon | uplink
+	OperStatus  string    `json:"oper_status"`       // up | down
+	SpeedMbps   int       `json:"speed_mbps"`        // ifHighSpeed
+	InOctets    int64     `json:"-"`                 // ifHCInOctets of the last poll
+	OutOctets   int64     `json:"-"`                 // ifHCOutOctets of the last poll
+	InBps       float64   `json:"in_bps"`            // upstream on PON ports
+	OutBps      float64   `json:"out_bps"`           // downstream on PON ports
+	Utilisation float64   `json:"utilisation"`       // busiest direction, percent of the speed
+	TxPower     float64   `json:"tx_power"`          // PON module dBm
+	RxPower     float64   `json:"rx_power"`          // PON module dBm
+	OnuCount    int       `json:"onu_count"`         // ONUs registered on the PON port
+	OnuOnline   int       `json:"onu_online"`        // ONUs in working state
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+	CountersAt  time.Time `json:"-"` // time of InOctets and OutOctets
+}
@@ -0,0 +1,78 @@
+package models
+
+import "time"
+
+// NetPolicy Firewall, routing and address list policy pushed to the bound devices
+type NetPolicy struct {
+	ID        int64     `json:"id,string" form:"id"`
+	Name      string    `json:"name" form:"name"`
+	Status    string    `gorm:"index" json:"status" form:"status"` // enabled | disabled
+	Remark    string    `json:"remark" form:"remark"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// NetPolicyRule Rule of a policy, the fields used depend on the kind
+type NetPolicyRule struct {
+	ID           int64     `json:"id,string" form:"id"`
+	PolicyId     int64     `gorm:"index" json:"policy_id,string" form:"policy_id"`
+	Kind         string    `json:"kind" form:"kind"`   // filter | nat | route | address_list
+	Sort         int       `json:"sort" form:"sort"`   // rule order in the kind
+	Chain        string    `json:"chain" form:"chain"` // filter, nat: input | forward | output | srcnat | dstnat
+	Action       string    `json:"action" form:"action"`
+	Protocol     string    `json:"protocol" form:"protocol"` // tcp | udp | icmp, empty for any
+	SrcAddress   string    `json:"src_address" form:"src_address"`
+	DstAddress   string    `json:"dst_address" form:"dst_address"` // route: destination network
+	SrcPort      string    `json:"src_port" form:"src_port"`
+	DstPort      string    `json:"dst_port" form:"dst_port"`
+	InInterface  string    `json:"in_interface" form:"in_interface"`
+	OutInterface string    `json:"out_interface" form:"out_interface"`
+	ToAddresses  string    `json:"to_addresses" form:"to_addresses"` // nat target address
+	ToPorts      string    `json:"to_ports" form:"to_ports"`         // nat target port
+	Gateway      string    `json:"gateway" form:"gateway"`           // route gateway
+	Distance     int       `json:"distance" form:"distance"`         // route distance, the TR-181 metric
+	ListName     string    `json:"list_name" form:"list_name"`       // address_list: list name, the address is SrcAddress
+	Disabled     bool      `json:"disabled" form:"disabled"`
+	Comment      string    `json:"comment" form:"comment"`
+	CreatedAt    time.Time `json:"created_at"`
+	UpdatedAt    time.Time `json:"updated_at"`
+}
+
+// NetPolicyBinding Device or device group a policy is assigned to
+type NetPolicyBinding struct {
+	ID         int64     `json:"id,string"`
+	PolicyId   int64     `gorm:"index" json:"policy_id,string" form:"policy_id"`
+	TargetType string    `json:"target_type" form:"target_type"`    // device | group
+	TargetId   int64     `json:"target_id,string" form:"target_id"` // device ID or device group ID
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+// NetPolicyDevice Push and drift state of a policy on a device
+type NetPolicyDevice struct {
+	ID          int64     `json:"id,string"`
+	PolicyId    int64     `gorm:"uniqueIndex:idx_policy_device" json:"policy_id,string"`
+	Sn          string    `gorm:"uniqueIndex:idx_policy_device" json:"sn"`
+	Method      string    `json:"method"`                   // routeros | tr181
+	Digest      string    `json:"digest"`                   // md5 of the rendered policy last pushed
+	Session     string    `json:"session"`                  // push session
+	PushStatus  string    `gorm:"index" json:"push_status"` // pushed | failure
+	PushError   string    `json:"push_error"`
+	PushTime    time.Time `json:"push_time"`
+	DriftStatus string    `gorm:"index" json:"drift_status"` // insync | drift | unknown
+	DriftDetail string    `gorm:"type:text" json:"drift_detail"`
+	CheckTime   time.Time `json:"check_time"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
+// NetPolicyInstance TR-181 object instance created on a device for a policy rule,
+// Instance is 0 until the device answers the AddObject
+type NetPolicyInstance struct {
+	ID        int64     `json:"id,string"`
+	PolicyId  int64     `gorm:"uniqueIndex:idx_policy_instance" json:"policy_id,string"`
+	Sn        string    `gorm:"uniqueIndex:idx_policy_instance" json:"sn"`
+	RuleId    int64     `gorm:"uniqueIndex:idx_policy_instance" json:"rule_id,string"`
+	Object    string    `json:"object"` // table of the rule kind, Device.NAT.PortMapping.
+	Instance  int       `json:"instance"`
+	Session   string    `gorm:"index" json:"session"` // ID of the AddObject or DeleteObject in progress
+	UpdatedAt time.Time `json:"updated_at"`
+}
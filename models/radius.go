@@ -0,0 +1,35 @@
+package models
+
+import "time"
+
+// NetRadiusNas NAS sending RADIUS accounting, identified by the source address
+type NetRadiusNas struct {
+	ID        int64     `json:"id,string" form:"id"`
+	Name      string    `json:"name" form:"name"`
+	Ipaddr    string    `gorm:"uniqueIndex" json:"ipaddr" form:"ipaddr"`
+	Secret    string    `json:"-"`                    // encrypted shared secret
+	Status    string    `json:"status" form:"status"` // enabled | disabled
+	Remark    string    `json:"remark" form:"remark"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// NetRadiusSession PPPoE session reported by the RADIUS accounting of a NAS
+type NetRadiusSession struct {
+	ID               int64     `json:"id,string"`
+	NasIpaddr        string    `gorm:"uniqueIndex:idx_radius_session" json:"nas_ipaddr"`
+	AcctSessionId    string    `gorm:"uniqueIndex:idx_radius_session" json:"acct_session_id"`
+	Username         string    `gorm:"index" json:"username"`
+	Sn               string    `gorm:"index" json:"sn"` // device with the PPPoE username, empty when unknown
+	FramedIp         string    `json:"framed_ip"`
+	CallingStationId string    `json:"calling_station_id"` // CPE MAC address
+	NasPortId        string    `json:"nas_port_id"`
+	Status           string    `gorm:"index" json:"status"` // online | offline
+	SessionTime      int       `json:"session_time"`        // seconds
+	InputOctets      int64     `json:"input_octets"`        // from the CPE
+	OutputOctets     int64     `json:"output_octets"`       // to the CPE
+	TerminateCause   string    `json:"terminate_cause"`
+	StartTime        time.Time `json:"start_time"`
+	UpdateTime       time.Time `gorm:"index" json:"update_time"`
+	StopTime         time.Time `json:"stop_time"`
+}
@@ -0,0 +1,30 @@
+package models
+
+import "time"
+
+// NetSubscriber Subscriber service record, linked to the CPE by the PON serial number
+type NetSubscriber struct {
+	ID               int64     `json:"id,string" form:"id"`
+	AccountId        string    `gorm:"uniqueIndex" json:"account_id" form:"account_id"` // customer account ID
+	Name             string    `json:"name" form:"name"`
+	Phone            string    `json:"phone" form:"phone"`
+	Address          string    `json:"address" form:"address"`
+	ServicePlan      string    `gorm:"index" json:"service_plan" form:"service_plan"`
+	Status           string    `gorm:"index" json:"status" form:"status"` // enabled | disabled
+	PonSn            string    `gorm:"index" json:"pon_sn" form:"pon_sn"` // vendor form, e.g. ZTEGC1234567
+	CpeSn            string    `gorm:"index" json:"cpe_sn"`               // linked device SN
+	OdpId            int64     `gorm:"index" json:"odp_id,string" form:"odp_id"`
+	OdpPort          int       `json:"odp_port" form:"odp_port"`
+	PppoeUsername    string    `json:"pppoe_username" form:"pppoe_username"`
+	PppoePassword    string    `json:"-"`                      // encrypted
+	VlanId           int       `json:"vlan_id" form:"vlan_id"` // WAN VLAN, 0 keeps the device VLAN
+	WifiSsid         string    `json:"wifi_ssid" form:"wifi_ssid"`
+	WifiPassword     string    `json:"-"`                              // encrypted
+	ProvisionStatus  string    `gorm:"index" json:"provision_status"`  // pushed | success | failure
+	ProvisionSession string    `gorm:"index" json:"provision_session"` // SetParameterValues session prefix
+	ProvisionError   string    `json:"provision_error"`
+	ProvisionTime    time.Time `json:"provision_time"`
+	Remark           string    `json:"remark" form:"remark"`
+	CreatedAt        time.Time `json:"created_at"`
+	UpdatedAt        time.Time `json:"updated_at"`
+}
@@ -47,18 +47,45 @@ var Tables = []interface{}{
 	// Network
 	&NetNode{},
 	&NetCpe{},
+	&NetCpeGroup{},
+	&NetCpeConnReq{},
+	&NetCpeApi{},
+	&NetCpeSsh{},
 	&NetCpeParam{},
+	&NetCpeParamHistory{},
+	&NetCpeParamNode{},
+	&NetSubscriber{},
 	// Cwmp
 	&CwmpConfigSession{},
+	&CwmpQueueItem{},
+	&CwmpPendingSet{},
+	&CwmpCpeSession{},
 	&CwmpConfig{},
 	&CwmpFactoryReset{},
 	&CwmpFirmwareConfig{},
 	&CwmpPreset{},
 	&CwmpPresetTask{},
+	&CwmpVirtualParam{},
+	&CwmpBulkJob{},
+	&CwmpBulkJobItem{},
+	// Console
+	&ConsoleCommandSet{},
+	&ConsoleRun{},
+	// Policy
+	&NetPolicy{},
+	&NetPolicyRule{},
+	&NetPolicyBinding{},
+	&NetPolicyDevice{},
+	&NetPolicyInstance{},
+	// Radius
+	&NetRadiusNas{},
+	&NetRadiusSession{},
 	// OLT
 	&OltDevice{},
 	&OltOnuData{},
+	&OltPortData{},
 	// ODC & ODP
 	&OdcDevice{},
 	&OdpDevice{},
+	&NetCpeOptical{},
 }
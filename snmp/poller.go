@@ -2,10 +2,13 @@ package snmp
 
 import (
 	"log"
+	"math"
+	"strings"
 	"time"
 
 	"github.com/ca17/teamsacs/app"
 	"github.com/ca17/teamsacs/models"
+	"gorm.io/gorm/clause"
 )
 
 // OLTPoller runs background SNMP polling for all OLTs
@@ -53,6 +56,10 @@ func (p *OLTPoller) Stop() {
 }
 
 func (p *OLTPoller) pollAll() {
+	// the OLTs are polled by the cluster leader only
+	if !app.GApp().IsLeader() {
+		return
+	}
 	var olts []models.OltDevice
 	if err := app.GDB().Where("status != ?", "disabled").Find(&olts).Error; err != nil {
 		log.Printf("[OLTPoller] Failed to fetch OLTs: %v", err)
@@ -66,11 +73,16 @@ func (p *OLTPoller) pollAll() {
 }
 
 func (p *OLTPoller) pollOLT(olt models.OltDevice) {
+	start := time.Now()
+	defer func() {
+		app.PromOltPollDuration.WithLabelValues(olt.Name).Observe(time.Since(start).Seconds())
+	}()
 	drv := NewZTEDriverWithModel(olt.IPAddress, olt.SNMPPort, olt.SNMPCommunity, olt.Model)
 
 	// Test connection and update sys info
 	info, err := drv.TestConnection()
 	if err != nil {
+		app.PromOltPollErrors.WithLabelValues(olt.Name).Inc()
 		log.Printf("[OLTPoller] %s (%s) offline: %v", olt.Name, olt.IPAddress, err)
 		app.GDB().Model(&olt).Updates(map[string]interface{}{
 			"status":       "offline",
@@ -90,14 +102,33 @@ func (p *OLTPoller) pollOLT(olt models.OltDevice) {
 	// Poll ONUs
 	onus, err := drv.PollONUs()
 	if err != nil {
+		app.PromOltPollErrors.WithLabelValues(olt.Name).Inc()
 		log.Printf("[OLTPoller] %s ONU poll failed: %v", olt.Name, err)
-		return
+	} else {
+		p.saveONUs(olt, onus)
 	}
 
+	p.pollPorts(olt, drv, onus, err == nil)
+}
+
+func (p *OLTPoller) saveONUs(olt models.OltDevice, onus []ONUData) {
 	log.Printf("[OLTPoller] %s: %d ONUs polled", olt.Name, len(onus))
 
-	// Upsert ONU data
+	// the known states are reset, their ONUs may have moved to another state
+	phases := make(map[string]float64)
+	for _, state := range phaseStateMap {
+		phases[state] = 0
+	}
 	for _, onu := range onus {
+		phases[onu.PhaseState]++
+	}
+	for phase, count := range phases {
+		app.PromOltOnus.WithLabelValues(olt.Name, phase).Set(count)
+	}
+
+	// Upsert ONU data, the serial numbers are stored upper case
+	for _, onu := range onus {
+		onu.SerialNumber = strings.ToUpper(onu.SerialNumber)
 		data := models.OltOnuData{
 			OltID:        olt.ID,
 			SerialNumber: onu.SerialNumber,
@@ -133,3 +164,125 @@ func (p *OLTPoller) pollOLT(olt models.OltDevice) {
 		}
 	}
 }
+
+// pollPorts updates the PON and uplink ports, the rates come from the counter
+// deltas since the previous poll. The ONU counts are kept when the ONU poll failed.
+func (p *OLTPoller) pollPorts(olt models.OltDevice, drv *ZTEDriver, onus []ONUData, onusPolled bool) {
+	ports, err := drv.PollPorts()
+	if err != nil {
+		app.PromOltPollErrors.WithLabelValues(olt.Name).Inc()
+		log.Printf("[OLTPoller] %s port poll failed: %v", olt.Name, err)
+		return
+	}
+
+	onuCount := make(map[int]int)
+	onuOnline := make(map[int]int)
+	for _, onu := range onus {
+		onuCount[onu.IfIndex]++
+		if onu.PhaseState == "working" {
+			onuOnline[onu.IfIndex]++
+		}
+	}
+
+	var prevs []models.OltPortData
+	app.GDB().Where("olt_id = ?", olt.ID).Find(&prevs)
+	prevMap := make(map[int]models.OltPortData)
+	for _, prev := range prevs {
+		prevMap[prev.IfIndex] = prev
+	}
+
+	now := time.Now()
+	seen := make([]int, 0, len(ports))
+	for _, port := range ports {
+		seen = append(seen, port.IfIndex)
+		data := models.OltPortData{
+			OltID:      olt.ID,
+			IfIndex:    port.IfIndex,
+			Name:       port.Name,
+			Kind:       port.Kind,
+			OperStatus: port.OperStatus,
+			SpeedMbps:  port.SpeedMbps,
+			InOctets:   int64(port.InOctets),
+			OutOctets:  int64(port.OutOctets),
+			TxPower:    port.TxPower,
+			RxPower:    port.RxPower,
+			OnuCount:   onuCount[port.IfIndex],
+			OnuOnline:  onuOnline[port.IfIndex],
+			CreatedAt:  now,
+			UpdatedAt:  now,
+		}
+		prev, hasPrev := prevMap[port.IfIndex]
+		if hasPrev && !onusPolled {
+			data.OnuCount, data.OnuOnline = prev.OnuCount, prev.OnuOnline
+		}
+		hasRate := false
+		if port.HasCounters {
+			data.CountersAt = now
+			if hasPrev {
+				data.InBps, data.OutBps, data.Utilisation, hasRate = portRates(prev, port, now)
+			}
+		} else if hasPrev {
+			// the counter walk failed, the next poll computes the rate from the previous counters
+			data.InOctets, data.OutOctets, data.CountersAt = prev.InOctets, prev.OutOctets, prev.CountersAt
+			data.InBps, data.OutBps, data.Utilisation = prev.InBps, prev.OutBps, prev.Utilisation
+		}
+
+		columns := []string{"name", "kind", "oper_status", "speed_mbps", "in_octets", "out_octets", "in_bps",
+			"out_bps", "utilisation", "tx_power", "rx_power", "onu_count", "onu_online", "updated_at", "counters_at"}
+		if err := app.GDB().Clauses(clause.OnConflict{
+			Columns:   []clause.Column{{Name: "olt_id"}, {Name: "if_index"}},
+			DoUpdates: clause.AssignmentColumns(columns),
+		}).Create(&data).Error; err != nil {
+			log.Printf("[OLTPoller] Failed to upsert port %s: %v", port.Name, err)
+			continue
+		}
+
+		if !hasPrev {
+			continue
+		}
+		values := make(map[string]float64)
+		if hasRate {
+			values["in_bps"] = data.InBps
+			values["out_bps"] = data.OutBps
+			values["utilisation"] = data.Utilisation
+		}
+		if port.HasOptics {
+			values["tx_power"] = port.TxPower
+			values["rx_power"] = port.RxPower
+		}
+		if port.Kind == PortKindPon {
+			values["onu_online"] = float64(data.OnuOnline)
+		}
+		if len(values) == 0 {
+			continue
+		}
+		if err := app.GApp().AddOltPortMetrics(olt.ID, port.Name, now.Unix(), values); err != nil {
+			log.Printf("[OLTPoller] Failed to store port %s metrics: %v", port.Name, err)
+		}
+	}
+
+	// ports removed from the OLT
+	if len(seen) > 0 {
+		app.GDB().Where("olt_id = ? and if_index not in ?", olt.ID, seen).Delete(&models.OltPortData{})
+	}
+	log.Printf("[OLTPoller] %s: %d ports polled", olt.Name, len(ports))
+}
+
+// portRates Rates in bit/s and the utilisation in percent from the counters of
+// the previous poll. Counters going back mean a reset or a wrap, the rate waits
+// for the next poll then.
+func portRates(prev models.OltPortData, port PortStats, now time.Time) (inBps, outBps, utilisation float64, ok bool) {
+	if prev.CountersAt.IsZero() {
+		return 0, 0, 0, false
+	}
+	secs := now.Sub(prev.CountersAt).Seconds()
+	if secs <= 0 || port.InOctets < uint64(prev.InOctets) || port.OutOctets < uint64(prev.OutOctets) {
+		return 0, 0, 0, false
+	}
+	inBps = float64(port.InOctets-uint64(prev.InOctets)) * 8 / secs
+	outBps = float64(port.OutOctets-uint64(prev.OutOctets)) * 8 / secs
+	if port.SpeedMbps > 0 {
+		utilisation = math.Round(math.Max(inBps, outBps)/(float64(port.SpeedMbps)*1e6)*10000) / 100
+	}
+	return inBps, outBps, utilisation, true
+}
@@ -0,0 +1,68 @@
+package snmp
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ca17/teamsacs/models"
+)
+
+func TestPortRates(t *testing.T) {
+	now := time.Now()
+	prev := models.OltPortData{InOctets: 1000000, OutOctets: 2000000, CountersAt: now.Add(-10 * time.Second)}
+	tests := []struct {
+		name     string
+		prev     models.OltPortData
+		port     PortStats
+		wantIn   float64
+		wantOut  float64
+		wantUtil float64
+		wantOk   bool
+	}{
+		{
+			name:     "rates",
+			prev:     prev,
+			port:     PortStats{InOctets: 1000000 + 12500000, OutOctets: 2000000 + 125000000, SpeedMbps: 1000},
+			wantIn:   10e6,
+			wantOut:  100e6,
+			wantUtil: 10,
+			wantOk:   true,
+		},
+		{
+			name:   "no speed",
+			prev:   prev,
+			port:   PortStats{InOctets: 1000000 + 1250, OutOctets: 2000000},
+			wantIn: 1000,
+			wantOk: true,
+		},
+		{
+			name: "in counter wrap",
+			prev: prev,
+			port: PortStats{InOctets: 10, OutOctets: 3000000, SpeedMbps: 1000},
+		},
+		{
+			name: "out counter reset",
+			prev: prev,
+			port: PortStats{InOctets: 2000000, OutOctets: 0, SpeedMbps: 1000},
+		},
+		{
+			name: "no previous counters",
+			prev: models.OltPortData{InOctets: 0, OutOctets: 0},
+			port: PortStats{InOctets: 1 << 40, OutOctets: 1 << 40, SpeedMbps: 1000},
+		},
+		{
+			name: "same poll time",
+			prev: models.OltPortData{InOctets: 1, OutOctets: 1, CountersAt: now},
+			port: PortStats{InOctets: 2, OutOctets: 2},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			in, out, util, ok := portRates(tt.prev, tt.port, now)
+			if ok != tt.wantOk || in != tt.wantIn || out != tt.wantOut || util != tt.wantUtil {
+				t.Errorf("portRates() = %v, %v, %v, %v, want %v, %v, %v, %v",
+					in, out, util, ok, tt.wantIn, tt.wantOut, tt.wantUtil, tt.wantOk)
+			}
+		})
+	}
+}
@@ -18,6 +18,12 @@ const (
 	oidSysUptime = ".1.3.6.1.2.1.1.3.0"
 
 	oidIfName = ".1.3.6.1.2.1.31.1.1.1.1"
+
+	// IF-MIB interface counters, the 64-bit HC counters of ifXTable
+	oidIfOperStatus  = ".1.3.6.1.2.1.2.2.1.8"
+	oidIfHCInOctets  = ".1.3.6.1.2.1.31.1.1.1.6"
+	oidIfHCOutOctets = ".1.3.6.1.2.1.31.1.1.1.10"
+	oidIfHighSpeed   = ".1.3.6.1.2.1.31.1.1.1.15"
 )
 
 // C6xx / ZXAN OIDs (C620, C650, etc.)
@@ -29,6 +35,9 @@ const (
 	oidC6xxOnuPhaseState      = ".1.3.6.1.4.1.3902.1082.500.10.2.3.8.1.4"
 	oidC6xxOnuLastOnlineTime  = ".1.3.6.1.4.1.3902.1082.500.10.2.3.8.1.5"
 	oidC6xxOnuLastOfflineTime = ".1.3.6.1.4.1.3902.1082.500.10.2.3.8.1.6"
+	// PON module optics by PON port ifIndex, in 0.001 dBm
+	oidC6xxPonTxPower = ".1.3.6.1.4.1.3902.1082.30.40.2.4.1.3"
+	oidC6xxPonRxPower = ".1.3.6.1.4.1.3902.1082.30.40.2.4.1.2"
 )
 
 // C3xx OIDs (C320, C300, etc.)
@@ -40,6 +49,9 @@ const (
 	oidC3xxOnuPhaseState      = ".1.3.6.1.4.1.3902.1012.3.28.2.1.4"
 	oidC3xxOnuLastOnlineTime  = ".1.3.6.1.4.1.3902.1012.3.28.2.1.8"
 	oidC3xxOnuLastOfflineTime = ".1.3.6.1.4.1.3902.1012.3.28.2.1.9"
+	// PON module optics by PON port ifIndex, in 0.001 dBm
+	oidC3xxPonTxPower = ".1.3.6.1.4.1.3902.1015.3.1.13.1.4"
+	oidC3xxPonRxPower = ".1.3.6.1.4.1.3902.1015.3.1.13.1.10"
 )
 
 // PhaseState values
@@ -67,6 +79,29 @@ type ONUData struct {
 	OfflineTime  string
 }
 
+// Port kinds
+const (
+	PortKindPon    = "pon"
+	PortKindUplink = "uplink"
+)
+
+// PortStats holds the counters and optics of a PON or uplink port
+type PortStats struct {
+	IfIndex    int
+	Name       string
+	Kind       string // pon | uplink
+	OperStatus string // up | down
+	SpeedMbps  int
+	InOctets   uint64
+	OutOctets  uint64
+	TxPower    float64 // dBm, PON ports only
+	RxPower    float64 // dBm, PON ports only
+	HasOptics  bool
+
+	// HasCounters both HC counters were read, the octets are zero otherwise
+	HasCounters bool
+}
+
 // OLTInfo holds basic OLT system info
 type OLTInfo struct {
 	SysName  string
@@ -83,6 +118,8 @@ type oidSet struct {
 	onuPhaseState      string
 	onuLastOnlineTime  string
 	onuLastOfflineTime string
+	ponTxPower         string
+	ponRxPower         string
 }
 
 // ZTEDriver SNMP driver for ZTE OLTs (C3xx and C6xx families)
@@ -123,6 +160,8 @@ func (d *ZTEDriver) getOIDs() oidSet {
 			onuPhaseState:      oidC3xxOnuPhaseState,
 			onuLastOnlineTime:  oidC3xxOnuLastOnlineTime,
 			onuLastOfflineTime: oidC3xxOnuLastOfflineTime,
+			ponTxPower:         oidC3xxPonTxPower,
+			ponRxPower:         oidC3xxPonRxPower,
 		}
 	}
 	return oidSet{
@@ -133,6 +172,8 @@ func (d *ZTEDriver) getOIDs() oidSet {
 		onuPhaseState:      oidC6xxOnuPhaseState,
 		onuLastOnlineTime:  oidC6xxOnuLastOnlineTime,
 		onuLastOfflineTime: oidC6xxOnuLastOfflineTime,
+		ponTxPower:         oidC6xxPonTxPower,
+		ponRxPower:         oidC6xxPonRxPower,
 	}
 }
 
@@ -207,8 +248,7 @@ func (d *ZTEDriver) PollONUs() ([]ONUData, error) {
 	} else {
 		for _, pdu := range results {
 			name := pduToString(pdu)
-			lower := strings.ToLower(name)
-			if strings.Contains(lower, "gpon") || strings.Contains(lower, "pon_olt") || strings.Contains(lower, "pon-olt") || strings.Contains(lower, "gpon-olt") {
+			if portKind(name) == PortKindPon {
 				ifIndex := extractLastOID(pdu.Name)
 				ponPortMap[ifIndex] = name
 			}
@@ -342,8 +382,101 @@ func (d *ZTEDriver) PollONUs() ([]ONUData, error) {
 	return onus, nil
 }
 
+// PollPorts polls the IF-MIB counters of the PON and uplink ports
+// and the PON module optics
+func (d *ZTEDriver) PollPorts() ([]PortStats, error) {
+	snmp := d.newSNMP()
+	if err := snmp.Connect(); err != nil {
+		return nil, fmt.Errorf("SNMP connect failed: %v", err)
+	}
+	defer snmp.Conn.Close()
+
+	results, err := snmp.WalkAll(oidIfName)
+	if err != nil {
+		return nil, fmt.Errorf("ifName walk failed: %v", err)
+	}
+	ports := make(map[int]*PortStats)
+	for _, pdu := range results {
+		name := pduToString(pdu)
+		if kind := portKind(name); kind != "" {
+			ifIndex := extractLastOID(pdu.Name)
+			ports[ifIndex] = &PortStats{IfIndex: ifIndex, Name: name, Kind: kind, OperStatus: "down"}
+		}
+	}
+
+	walk := func(oid string, fn func(port *PortStats, pdu gosnmp.SnmpPDU)) {
+		results, err := snmp.WalkAll(oid)
+		if err != nil {
+			log.Printf("[ZTE] Warning: %s walk failed on %s: %v", oid, d.target, err)
+			return
+		}
+		for _, pdu := range results {
+			if port, ok := ports[extractLastOID(pdu.Name)]; ok {
+				fn(port, pdu)
+			}
+		}
+	}
+	walk(oidIfOperStatus, func(port *PortStats, pdu gosnmp.SnmpPDU) {
+		if pduToInt(pdu) == 1 {
+			port.OperStatus = "up"
+		}
+	})
+	walk(oidIfHighSpeed, func(port *PortStats, pdu gosnmp.SnmpPDU) {
+		port.SpeedMbps = pduToInt(pdu)
+	})
+	// a port gets its counters only when both walks returned it
+	inSeen := make(map[int]bool)
+	walk(oidIfHCInOctets, func(port *PortStats, pdu gosnmp.SnmpPDU) {
+		port.InOctets = gosnmp.ToBigInt(pdu.Value).Uint64()
+		inSeen[port.IfIndex] = true
+	})
+	walk(oidIfHCOutOctets, func(port *PortStats, pdu gosnmp.SnmpPDU) {
+		port.OutOctets = gosnmp.ToBigInt(pdu.Value).Uint64()
+		port.HasCounters = inSeen[port.IfIndex]
+	})
+	oids := d.getOIDs()
+	walk(oids.ponTxPower, func(port *PortStats, pdu gosnmp.SnmpPDU) {
+		if v, ok := opticalDbm(pdu); ok && port.Kind == PortKindPon {
+			port.TxPower, port.HasOptics = v, true
+		}
+	})
+	walk(oids.ponRxPower, func(port *PortStats, pdu gosnmp.SnmpPDU) {
+		if v, ok := opticalDbm(pdu); ok && port.Kind == PortKindPon {
+			port.RxPower, port.HasOptics = v, true
+		}
+	})
+
+	stats := make([]PortStats, 0, len(ports))
+	for _, port := range ports {
+		stats = append(stats, *port)
+	}
+	return stats, nil
+}
+
 // --- Helpers ---
 
+// portKind PON or uplink port by the interface name, empty for the other interfaces
+func portKind(name string) string {
+	lower := strings.ToLower(name)
+	switch {
+	case strings.Contains(lower, "gpon") || strings.Contains(lower, "pon_olt") || strings.Contains(lower, "pon-olt"):
+		return PortKindPon
+	case strings.HasPrefix(lower, "xgei") || strings.HasPrefix(lower, "gei") || strings.HasPrefix(lower, "smartgroup"):
+		return PortKindUplink
+	default:
+		return ""
+	}
+}
+
+// opticalDbm Optical level in 0.001 dBm, the out of range values mean no module
+func opticalDbm(pdu gosnmp.SnmpPDU) (float64, bool) {
+	raw := pduToInt64(pdu)
+	if raw < -50000 || raw > 20000 {
+		return 0, false
+	}
+	return float64(raw) / 1000, true
+}
+
 func extractLastOID(oid string) int {
 	parts := strings.Split(oid, ".")
 	if len(parts) == 0 {
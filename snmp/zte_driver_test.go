@@ -0,0 +1,58 @@
+package snmp
+
+import (
+	"testing"
+
+	"github.com/gosnmp/gosnmp"
+)
+
+func TestPortKind(t *testing.T) {
+	tests := []struct {
+		name string
+		want string
+	}{
+		{"gpon_olt-1/2/9", PortKindPon},
+		{"gpon-olt_1/2/1", PortKindPon},
+		{"GPON0/1", PortKindPon},
+		{"pon-olt_1/1/1", PortKindPon},
+		{"xgei-1/21/1", PortKindUplink},
+		{"gei_1/19/2", PortKindUplink},
+		{"smartgroup1", PortKindUplink},
+		{"vlan100", ""},
+		{"mng1", ""},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := portKind(tt.name); got != tt.want {
+				t.Errorf("portKind(%q) = %q, want %q", tt.name, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestOpticalDbm(t *testing.T) {
+	tests := []struct {
+		name   string
+		value  interface{}
+		want   float64
+		wantOk bool
+	}{
+		{"tx power", 3250, 3.25, true},
+		{"rx power", -21500, -21.5, true},
+		{"zero", 0, 0, true},
+		{"upper bound", 20000, 20, true},
+		{"lower bound", -50000, -50, true},
+		{"no module", -80000, 0, false},
+		{"invalid", 65535, 0, false},
+		{"counter type", uint(2500), 2.5, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := opticalDbm(gosnmp.SnmpPDU{Type: gosnmp.Integer, Value: tt.value})
+			if ok != tt.wantOk || got != tt.want {
+				t.Errorf("opticalDbm(%v) = %v, %v, want %v, %v", tt.value, got, ok, tt.want, tt.wantOk)
+			}
+		})
+	}
+}
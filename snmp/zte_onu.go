@@ -0,0 +1,337 @@
+package snmp
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/gosnmp/gosnmp"
+)
+
+// ONU management OIDs, indexed by PON ifIndex.onuId like the ONU tables.
+// The actions need a community with write access on the OLT.
+
+// C6xx / ZXAN ONU management OIDs
+const (
+	oidC6xxOnuRowStatus  = ".1.3.6.1.4.1.3902.1082.500.10.2.3.2.1.10" // registration table
+	oidC6xxOnuAdminState = ".1.3.6.1.4.1.3902.1082.500.10.2.3.3.1.14"
+	oidC6xxOnuReboot     = ".1.3.6.1.4.1.3902.1082.500.20.2.1.2.1.1"
+	oidC6xxOnuDistance   = ".1.3.6.1.4.1.3902.1082.500.10.2.3.10.1.2"
+	oidC6xxOnuOltRxPower = ".1.3.6.1.4.1.3902.1082.500.1.2.4.2.1.2"
+)
+
+// C3xx ONU management OIDs
+const (
+	oidC3xxOnuRowStatus  = ".1.3.6.1.4.1.3902.1012.3.28.1.1.9"
+	oidC3xxOnuAdminState = ".1.3.6.1.4.1.3902.1012.3.28.1.1.15"
+	oidC3xxOnuReboot     = ".1.3.6.1.4.1.3902.1012.3.50.11.3.1.1"
+	oidC3xxOnuDistance   = ".1.3.6.1.4.1.3902.1012.3.11.4.1.2"
+	oidC3xxOnuOltRxPower = ".1.3.6.1.4.1.3902.1015.1010.11.2.1.2"
+)
+
+// ONU registration OIDs, the writable columns of the ONU registration table
+// whose row status creates and deletes the ONU. The C6xx type, SN and name walked by the poller are a read-only view, the
+// C3xx poller walks the registration table itself.
+const (
+	oidC6xxOnuCfgType         = ".1.3.6.1.4.1.3902.1082.500.10.2.3.2.1.2"
+	oidC6xxOnuCfgSerialNumber = ".1.3.6.1.4.1.3902.1082.500.10.2.3.2.1.3"
+	oidC6xxOnuCfgName         = ".1.3.6.1.4.1.3902.1082.500.10.2.3.2.1.4"
+	oidC3xxOnuCfgType         = ".1.3.6.1.4.1.3902.1012.3.28.1.1.1"
+	oidC3xxOnuCfgSerialNumber = ".1.3.6.1.4.1.3902.1012.3.28.1.1.5"
+	oidC3xxOnuCfgName         = ".1.3.6.1.4.1.3902.1012.3.28.1.1.3"
+)
+
+// Re-registration timing, the OLT releases the ONU ID asynchronously
+const (
+	onuReleaseTimeout  = 15 * time.Second
+	onuReleaseInterval = 500 * time.Millisecond
+	onuRegisterRetries = 3
+	onuRegisterBackoff = 2 * time.Second
+)
+
+// RowStatus and admin state values
+const (
+	rowStatusCreateAndGo = 4
+	rowStatusDestroy     = 6
+	adminStateActivate   = 1
+	adminStateDeactivate = 2
+)
+
+// ONU actions
+const (
+	OnuActionReboot     = "reboot"
+	OnuActionDeactivate = "deactivate"
+	OnuActionActivate   = "activate"
+	OnuActionDelete     = "delete"
+	OnuActionReregister = "reregister"
+)
+
+var OnuActions = []string{OnuActionReboot, OnuActionDeactivate, OnuActionActivate, OnuActionDelete, OnuActionReregister}
+
+// ReregisterServiceWarning The OLT removes the service config of the ONU with
+// its registration, a reregistered ONU carries no traffic until it is restored
+const ReregisterServiceWarning = "the service-port, VLAN and gemport config of the ONU is removed, provision the ONU services again"
+
+// ONUConfig identifies a registered ONU, the SN, type and name are
+// needed to register it again
+type ONUConfig struct {
+	IfIndex      int
+	OnuID        int
+	SerialNumber string
+	Type         string
+	Name         string
+}
+
+// ONULink holds the OLT side view of the ONU optical link
+type ONULink struct {
+	Distance   int     `json:"distance"`     // meters, from the ranging
+	OltRxPower float64 `json:"olt_rx_power"` // dBm received by the OLT from the ONU
+	HasRx      bool    `json:"has_rx"`
+}
+
+type onuOidSet struct {
+	rowStatus  string
+	adminState string
+	reboot     string
+	distance   string
+	oltRxPower string
+
+	// registration columns
+	cfgType         string
+	cfgSerialNumber string
+	cfgName         string
+}
+
+func (d *ZTEDriver) getOnuOIDs() onuOidSet {
+	if d.isC3xx() {
+		return onuOidSet{
+			rowStatus:  oidC3xxOnuRowStatus,
+			adminState: oidC3xxOnuAdminState,
+			reboot:     oidC3xxOnuReboot,
+			distance:   oidC3xxOnuDistance,
+			oltRxPower: oidC3xxOnuOltRxPower,
+
+			cfgType:         oidC3xxOnuCfgType,
+			cfgSerialNumber: oidC3xxOnuCfgSerialNumber,
+			cfgName:         oidC3xxOnuCfgName,
+		}
+	}
+	return onuOidSet{
+		rowStatus:  oidC6xxOnuRowStatus,
+		adminState: oidC6xxOnuAdminState,
+		reboot:     oidC6xxOnuReboot,
+		distance:   oidC6xxOnuDistance,
+		oltRxPower: oidC6xxOnuOltRxPower,
+
+		cfgType:         oidC6xxOnuCfgType,
+		cfgSerialNumber: oidC6xxOnuCfgSerialNumber,
+		cfgName:         oidC6xxOnuCfgName,
+	}
+}
+
+// RunONUAction runs one of the OnuActions on the ONU
+func (d *ZTEDriver) RunONUAction(onu ONUConfig, action string) error {
+	switch action {
+	case OnuActionReboot:
+		return d.RebootONU(onu.IfIndex, onu.OnuID)
+	case OnuActionDeactivate:
+		return d.SetONUAdminState(onu.IfIndex, onu.OnuID, false)
+	case OnuActionActivate:
+		return d.SetONUAdminState(onu.IfIndex, onu.OnuID, true)
+	case OnuActionDelete:
+		return d.DeleteONU(onu.IfIndex, onu.OnuID)
+	case OnuActionReregister:
+		return d.ReregisterONU(onu)
+	default:
+		return fmt.Errorf("unknown ONU action %s", action)
+	}
+}
+
+// RebootONU restarts the ONU through OMCI
+func (d *ZTEDriver) RebootONU(ifIndex, onuId int) error {
+	return d.set(gosnmp.SnmpPDU{Name: onuOID(d.getOnuOIDs().reboot, ifIndex, onuId), Type: gosnmp.Integer, Value: 1})
+}
+
+// SetONUAdminState activates or deactivates the ONU, a deactivated ONU stays
+// registered but carries no traffic
+func (d *ZTEDriver) SetONUAdminState(ifIndex, onuId int, enabled bool) error {
+	state := adminStateDeactivate
+	if enabled {
+		state = adminStateActivate
+	}
+	return d.set(gosnmp.SnmpPDU{Name: onuOID(d.getOnuOIDs().adminState, ifIndex, onuId), Type: gosnmp.Integer, Value: state})
+}
+
+// DeleteONU removes the ONU registration, the ONU shows up as unconfigured afterwards
+func (d *ZTEDriver) DeleteONU(ifIndex, onuId int) error {
+	return d.set(gosnmp.SnmpPDU{Name: onuOID(d.getOnuOIDs().rowStatus, ifIndex, onuId), Type: gosnmp.Integer, Value: rowStatusDestroy})
+}
+
+// ReregisterONU deletes the ONU and registers it again with the same ID,
+// serial number, type and name. The registration row is read before the delete
+// so an OLT refusing the registration columns leaves the ONU in place. The
+// registration is retried once the OLT released the ID, the error tells the
+// ONU is deleted when all attempts failed. The service-port, VLAN and gemport
+// config deleted with the ONU is not restored, see ReregisterServiceWarning.
+func (d *ZTEDriver) ReregisterONU(onu ONUConfig) error {
+	sn, err := snBytes(onu.SerialNumber)
+	if err != nil {
+		return err
+	}
+	if onu.Type == "" {
+		return fmt.Errorf("ONU %s has no type", onu.SerialNumber)
+	}
+	if err = d.checkONURegistration(onu.IfIndex, onu.OnuID, sn); err != nil {
+		return fmt.Errorf("ONU %s not deleted, %v", onu.SerialNumber, err)
+	}
+	if err = d.DeleteONU(onu.IfIndex, onu.OnuID); err != nil {
+		return fmt.Errorf("delete failed: %v", err)
+	}
+	if err = d.waitONUReleased(onu.IfIndex, onu.OnuID); err != nil {
+		log.Printf("[ZTE] Warning: ONU %s: %v", onu.SerialNumber, err)
+	}
+
+	oids := d.getOnuOIDs()
+	for attempt := 1; ; attempt++ {
+		err = d.set(
+			gosnmp.SnmpPDU{Name: onuOID(oids.cfgType, onu.IfIndex, onu.OnuID), Type: gosnmp.OctetString, Value: onu.Type},
+			gosnmp.SnmpPDU{Name: onuOID(oids.cfgSerialNumber, onu.IfIndex, onu.OnuID), Type: gosnmp.OctetString, Value: sn},
+			gosnmp.SnmpPDU{Name: onuOID(oids.rowStatus, onu.IfIndex, onu.OnuID), Type: gosnmp.Integer, Value: rowStatusCreateAndGo},
+		)
+		if err == nil || attempt >= onuRegisterRetries {
+			break
+		}
+		log.Printf("[ZTE] Warning: ONU %s register attempt %d failed: %v", onu.SerialNumber, attempt, err)
+		time.Sleep(onuRegisterBackoff)
+	}
+	if err != nil {
+		return fmt.Errorf("register failed, the ONU is deleted, register %s type %s on %d:%d again: %v",
+			onu.SerialNumber, onu.Type, onu.IfIndex, onu.OnuID, err)
+	}
+	if onu.Name != "" {
+		err = d.set(gosnmp.SnmpPDU{Name: onuOID(oids.cfgName, onu.IfIndex, onu.OnuID), Type: gosnmp.OctetString, Value: onu.Name})
+		if err != nil {
+			log.Printf("[ZTE] Warning: ONU %s name not restored: %v", onu.SerialNumber, err)
+		}
+	}
+	log.Printf("[ZTE] Warning: ONU %s reregistered, %s", onu.SerialNumber, ReregisterServiceWarning)
+	return nil
+}
+
+// checkONURegistration The registration row of the ONU is readable and holds its serial number
+func (d *ZTEDriver) checkONURegistration(ifIndex, onuId int, sn []byte) error {
+	snmp := d.newSNMP()
+	if err := snmp.Connect(); err != nil {
+		return fmt.Errorf("SNMP connect failed: %v", err)
+	}
+	defer snmp.Conn.Close()
+
+	oids := d.getOnuOIDs()
+	result, err := snmp.Get([]string{onuOID(oids.cfgType, ifIndex, onuId), onuOID(oids.cfgSerialNumber, ifIndex, onuId)})
+	if err != nil {
+		return fmt.Errorf("registration read failed: %v", err)
+	}
+	for _, v := range result.Variables {
+		if v.Type == gosnmp.NoSuchObject || v.Type == gosnmp.NoSuchInstance {
+			return fmt.Errorf("the OLT has no registration column %s", v.Name)
+		}
+	}
+	if got, ok := result.Variables[1].Value.([]byte); !ok || !bytes.Equal(got, sn) {
+		return fmt.Errorf("registration %d:%d holds another serial number", ifIndex, onuId)
+	}
+	return nil
+}
+
+// waitONUReleased Wait for the OLT to remove the registration row of the deleted ONU
+func (d *ZTEDriver) waitONUReleased(ifIndex, onuId int) error {
+	snmp := d.newSNMP()
+	if err := snmp.Connect(); err != nil {
+		return fmt.Errorf("SNMP connect failed: %v", err)
+	}
+	defer snmp.Conn.Close()
+
+	oid := onuOID(d.getOnuOIDs().rowStatus, ifIndex, onuId)
+	deadline := time.Now().Add(onuReleaseTimeout)
+	for time.Now().Before(deadline) {
+		result, err := snmp.Get([]string{oid})
+		if err == nil && len(result.Variables) > 0 &&
+			(result.Variables[0].Type == gosnmp.NoSuchObject || result.Variables[0].Type == gosnmp.NoSuchInstance) {
+			return nil
+		}
+		time.Sleep(onuReleaseInterval)
+	}
+	return fmt.Errorf("ONU ID %d:%d not released in %s", ifIndex, onuId, onuReleaseTimeout)
+}
+
+// ReadONULink reads the ONU distance and the RX power measured by the OLT
+func (d *ZTEDriver) ReadONULink(ifIndex, onuId int) (*ONULink, error) {
+	snmp := d.newSNMP()
+	if err := snmp.Connect(); err != nil {
+		return nil, fmt.Errorf("SNMP connect failed: %v", err)
+	}
+	defer snmp.Conn.Close()
+
+	oids := d.getOnuOIDs()
+	distanceOid, rxOid := onuOID(oids.distance, ifIndex, onuId), onuOID(oids.oltRxPower, ifIndex, onuId)
+	result, err := snmp.Get([]string{distanceOid, rxOid})
+	if err != nil {
+		return nil, fmt.Errorf("SNMP get failed: %v", err)
+	}
+	link := &ONULink{}
+	for _, v := range result.Variables {
+		if v.Type == gosnmp.NoSuchObject || v.Type == gosnmp.NoSuchInstance {
+			continue
+		}
+		switch v.Name {
+		case distanceOid:
+			link.Distance = pduToInt(v)
+		case rxOid:
+			link.OltRxPower, link.HasRx = opticalDbm(v)
+		}
+	}
+	return link, nil
+}
+
+func (d *ZTEDriver) set(pdus ...gosnmp.SnmpPDU) error {
+	snmp := d.newSNMP()
+	// no retries, the actions are not idempotent
+	snmp.Retries = 0
+	if err := snmp.Connect(); err != nil {
+		return fmt.Errorf("SNMP connect failed: %v", err)
+	}
+	defer snmp.Conn.Close()
+
+	result, err := snmp.Set(pdus)
+	if err != nil {
+		return fmt.Errorf("SNMP set failed: %v", err)
+	}
+	if result.Error != gosnmp.NoError {
+		return fmt.Errorf("SNMP set failed: %s", result.Error)
+	}
+	return nil
+}
+
+func onuOID(base string, ifIndex, onuId int) string {
+	return fmt.Sprintf("%s.%d.%d", base, ifIndex, onuId)
+}
+
+// snBytes The 8 byte GPON serial number of "ZTEGC0FFEE12" (vendor and hex)
+// or "5A544547C0FFEE12" (all hex), the reverse of pduToHexSN
+func snBytes(sn string) ([]byte, error) {
+	sn = strings.TrimSpace(sn)
+	switch len(sn) {
+	case 12:
+		b, err := hex.DecodeString(sn[4:])
+		if err == nil {
+			return append([]byte(sn[:4]), b...), nil
+		}
+	case 16:
+		if b, err := hex.DecodeString(sn); err == nil {
+			return b, nil
+		}
+	}
+	return nil, fmt.Errorf("invalid GPON serial number %s", sn)
+}
@@ -0,0 +1,63 @@
+package tr069
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/ca17/teamsacs/app"
+	"github.com/ca17/teamsacs/common/zaplog/log"
+	"github.com/labstack/echo/v4"
+	"github.com/spf13/cast"
+)
+
+const deviceMetricMaxBody = 64 * 1024
+
+// DeviceMetric Metrics pushed by the device scripts with the TeamsacsApiToken,
+// the token is bound to the device sn of the payload
+func (s *Tr069Server) DeviceMetric(c echo.Context) error {
+	token := strings.TrimSpace(strings.TrimPrefix(c.Request().Header.Get("Authorization"), "Bearer "))
+	if token == "" {
+		return c.String(http.StatusUnauthorized, "missing token")
+	}
+	body, err := io.ReadAll(io.LimitReader(c.Request().Body, deviceMetricMaxBody))
+	if err != nil {
+		return c.String(http.StatusBadRequest, "bad request")
+	}
+	var payload map[string]interface{}
+	if err = json.Unmarshal(body, &payload); err != nil {
+		return c.String(http.StatusBadRequest, "invalid json data")
+	}
+	sn := cast.ToString(payload[app.DeviceMetricSn])
+	if err = app.GApp().CheckDeviceApiToken(token, sn); err != nil {
+		log.Errorf("device %s metric token error: %s", sn, err.Error())
+		return c.String(http.StatusUnauthorized, "invalid token")
+	}
+
+	// the device clock may not be synchronized, keep the server time when too far
+	now := time.Now()
+	timestamp := now.Unix()
+	if ts, err := cast.ToInt64E(payload[app.DeviceMetricTimestamp]); err == nil && ts > 0 &&
+		ts > now.Add(-time.Hour).Unix() && ts < now.Add(time.Minute).Unix() {
+		timestamp = ts
+	}
+
+	values := make(map[string]float64)
+	for name, v := range payload {
+		if name == app.DeviceMetricSn || name == app.DeviceMetricTimestamp {
+			continue
+		}
+		fv, err := cast.ToFloat64E(v)
+		if err != nil {
+			continue
+		}
+		values[name] = fv
+	}
+	if err = app.GApp().AddDeviceMetrics(sn, timestamp, values); err != nil {
+		log.Errorf("device %s add metrics error: %s", sn, err.Error())
+		return c.String(http.StatusBadRequest, err.Error())
+	}
+	return c.NoContent(http.StatusNoContent)
+}
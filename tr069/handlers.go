@@ -1,11 +1,13 @@
 package tr069
 
 import (
+	"bytes"
 	"fmt"
 	"io"
 	"net/http"
 	"os"
 	"path"
+	"strconv"
 	"strings"
 	"time"
 
@@ -27,6 +29,7 @@ func (s *Tr069Server) initRouter() {
 	s.root.Add(http.MethodGet, "/cwmpfiles/download/:filename", s.Tr069FirmwareDownload)
 	s.root.Add(http.MethodPut, "/cwmpupload/:session/:token/:filename", s.Tr069Upload)
 	s.root.Add(http.MethodPost, "/cwmpupload/:session/:token/:filename", s.Tr069Upload)
+	s.root.Add(http.MethodPost, "/api/v1/device/metric", s.DeviceMetric)
 }
 
 func (s *Tr069Server) Tr069Upload(c echo.Context) error {
@@ -117,6 +120,7 @@ func (s *Tr069Server) Tr069Index(c echo.Context) error {
 	cookie, _ := c.Cookie(Tr069CookieName)
 	if cookie != nil {
 		log.Info2(fmt.Sprintf("cwmp cooike session sn = %s", cookie.Value), zap.String("namespace", "tr069"))
+		s.touchSession(cookie.Value)
 	}
 
 	requestBody, err := io.ReadAll(c.Request().Body)
@@ -149,11 +153,11 @@ func (s *Tr069Server) Tr069Index(c echo.Context) error {
 			if lastestSn == "" {
 				return c.String(http.StatusUnauthorized, "no cookie sn")
 			}
-			cpe := app.GApp().CwmpTable().GetCwmpCpe(lastestSn)
-			if cpe.LastInform == nil {
+			// the Inform may have been received by another instance
+			lastInform = app.GApp().CwmpTable().GetCwmpCpe(lastestSn).SessionInform(s.GetSessionCookie(c))
+			if lastInform == nil {
 				return c.String(http.StatusUnauthorized, "no cookie cpe data")
 			}
-			lastInform = cpe.LastInform
 		}
 
 		log.Info2(fmt.Sprintf("recv CPE %s Message: %s ", msg.GetName(), msg.GetID()),
@@ -175,9 +179,23 @@ func (s *Tr069Server) Tr069Index(c echo.Context) error {
 				zap.String("ipaddr", c.RealIP()),
 				zap.String("metrics", app.MetricsTr069Inform),
 			)
+			if s.draining.Load() {
+				return s.rejectInform(c)
+			}
 			return s.processInform(c, lastInform, msg)
 		case "TransferComplete":
 			return s.processTransferComplete(c, msg)
+		case "Fault":
+			fault := msg.(*cwmp.Fault)
+			lastestSn := s.GetLatestCookieSn(c)
+			app.PromCwmpRpcFaults.WithLabelValues(common.IfEmptyStr(s.lastRpc(lastestSn), "unknown"),
+				strconv.Itoa(fault.FaultCode)).Inc()
+			if lastestSn != "" {
+				events.PubEventCwmpSuperviseStatus(lastestSn, msg.GetID(), "error",
+					fmt.Sprintf("Recv Cwmp Fault %d %s", fault.FaultCode, fault.FaultString))
+			}
+			app.GApp().FailSubscriberProvision(msg.GetID(), fmt.Sprintf("%d %s", fault.FaultCode, fault.FaultString))
+			app.GApp().ClearPolicyObject(msg.GetID())
 		case "GetRPCMethods":
 			gm := msg.(*cwmp.GetRPCMethods)
 			resp := new(cwmp.GetRPCMethodsResponse)
@@ -188,7 +206,8 @@ func (s *Tr069Server) Tr069Index(c echo.Context) error {
 			gm := msg.(*cwmp.GetParameterValuesResponse)
 			lastestSn := s.GetLatestCookieSn(c)
 			if lastestSn != "" {
-				app.GApp().CwmpTable().GetCwmpCpe(lastestSn).OnParamsUpdate(gm.Values)
+				app.GApp().CwmpTable().GetCwmpCpe(lastestSn).OnParamsUpdate(gm.Values, gm.Types, gm.ID)
+				app.GApp().CwmpTable().GetCwmpCpe(lastestSn).OnPolicyDriftValues(gm)
 				events.PubEventCwmpSuperviseStatus(lastestSn, msg.GetID(), "info",
 					fmt.Sprintf("Recv Cwmp %s Message %s", msg.GetName(), common.ToJson(msg)))
 			}
@@ -225,6 +244,7 @@ func (s *Tr069Server) Tr069Index(c echo.Context) error {
 						zap.String("namespace", "tr069"), zap.Error(err))
 				}
 				cpe := app.GApp().CwmpTable().GetCwmpCpe(lastestSn)
+				cpe.OnSetParameterValuesResponse(msg.(*cwmp.SetParameterValuesResponse))
 				// Chain: Check channel FIRST for immediate pending commands (WiFi/WAN params)
 				qmsg, qerr := cpe.RecvCwmpEventData(50, true)
 				if qerr != nil {
@@ -245,9 +265,46 @@ func (s *Tr069Server) Tr069Index(c echo.Context) error {
 					return xmlCwmpMessage(c, []byte(ptask.Request))
 				}
 			}
+		case "AddObjectResponse", "DeleteObjectResponse":
+			lastestSn := s.GetLatestCookieSn(c)
+			if lastestSn != "" {
+				events.PubEventCwmpSuperviseStatus(lastestSn, msg.GetID(), "info",
+					fmt.Sprintf("Recv Cwmp %s Message %s", msg.GetName(), common.ToJson(msg)))
+				cpe := app.GApp().CwmpTable().GetCwmpCpe(lastestSn)
+				if aor, ok := msg.(*cwmp.AddObjectResponse); ok {
+					// the new policy rule instance is set right away
+					if next := cpe.OnAddObjectResponse(aor); next != nil {
+						events.PubEventCwmpSuperviseStatus(lastestSn, next.GetID(), "info",
+							fmt.Sprintf("Send Cwmp %s Message %s", next.GetName(), common.ToJson(next)))
+						return xmlCwmpMessage(c, next.CreateXML())
+					}
+				} else {
+					app.GApp().ClearPolicyObject(msg.GetID())
+				}
+				qmsg, qerr := cpe.RecvCwmpEventData(50, true)
+				if qerr != nil {
+					qmsg, _ = cpe.RecvCwmpEventData(50, false)
+				}
+				if qmsg != nil {
+					if qmsg.Session != "" {
+						events.PubEventCwmpSuperviseStatus(lastestSn, qmsg.Session, "info",
+							fmt.Sprintf("Send Cwmp %s Message %s", qmsg.Message.GetName(), common.ToJson(qmsg.Message)))
+					}
+					return xmlCwmpMessage(c, qmsg.Message.CreateXML())
+				}
+			}
 		case "GetParameterNamesResponse":
 			gm := msg.(*cwmp.GetParameterNamesResponse)
 			lastestSn := s.GetLatestCookieSn(c)
+			if lastestSn != "" && strings.HasPrefix(msg.GetID(), app.DiscoverySessionPrefix) {
+				// Parameter tree discovery: save this level and continue with the next object
+				cpe := app.GApp().CwmpTable().GetCwmpCpe(lastestSn)
+				cpe.OnDiscoveryResponse(gm)
+				if next := cpe.NextDiscoveryRequest(); next != nil {
+					return xmlCwmpMessage(c, next.CreateXML())
+				}
+				return s.finishSession(c)
+			}
 			if lastestSn != "" && msg.GetID() != "" {
 				if strings.HasPrefix(msg.GetID(), "bootstrap-session") {
 					go app.GApp().CwmpTable().GetCwmpCpe(lastestSn).ProcessParameterNamesResponse(gm)
@@ -275,7 +332,7 @@ func (s *Tr069Server) Tr069Index(c echo.Context) error {
 		lastestSn := s.GetLatestCookieSn(c)
 		log.Infof("Empty POST handler: sn=%s", lastestSn)
 		if lastestSn == "" {
-			return noContentResp(c)
+			return s.finishSession(c)
 		}
 
 		cpe := app.GApp().CwmpTable().GetCwmpCpe(lastestSn)
@@ -301,6 +358,11 @@ func (s *Tr069Server) Tr069Index(c echo.Context) error {
 			}
 			return xmlCwmpMessage(c, msg.Message.CreateXML())
 		}
+
+		// 参数树发现任务
+		if next := cpe.NextDiscoveryRequest(); next != nil {
+			return xmlCwmpMessage(c, next.CreateXML())
+		}
 	}
 
 	// for {
@@ -312,7 +374,7 @@ func (s *Tr069Server) Tr069Index(c echo.Context) error {
 	// 	}
 	// }
 
-	return noContentResp(c)
+	return s.finishSession(c)
 }
 
 // 处理 CPE -> ACS TransferComplete 事件
@@ -344,19 +406,37 @@ func (s *Tr069Server) processTransferComplete(c echo.Context, msg cwmp.Message)
 // 处理 CPE -> ACS Inform 事件
 func (s *Tr069Server) processInform(c echo.Context, lastInform *cwmp.Inform, msg cwmp.Message) error {
 	lastInform = msg.(*cwmp.Inform)
-	s.SetLatestInformByCookie(c, lastInform.Sn)
+	for event := range lastInform.Events {
+		app.PromCwmpInforms.WithLabelValues(event, lastInform.Manufacturer).Inc()
+	}
+	// Processed by the worker pool, rejected when it is overloaded
+	task := app.InformTask{Ip: c.RealIP(), Inform: lastInform}
+	if !app.GApp().SubmitInform(c.Request().Context(), task) {
+		log.Info2("inform rejected, worker pool overloaded",
+			zap.String("namespace", "tr069"),
+			zap.String("sn", lastInform.Sn),
+			zap.String("metrics", app.MetricsTr069InformRejected),
+		)
+		return s.rejectInform(c)
+	}
+	var session string
+	if lastInform.Sn != "" {
+		session = app.GApp().CwmpTable().GetCwmpCpe(lastInform.Sn).StartSession(lastInform)
+	}
+	s.SetLatestInformByCookie(c, lastInform.Sn, session)
+	s.beginSession(lastInform.Sn)
 	// response
 	resp := new(cwmp.InformResponse)
 	resp.ID = lastInform.ID
 	resp.MaxEnvelopes = lastInform.MaxEnvelopes
 	response := resp.CreateXML()
 
-	go s.processInformEvent(c, lastInform)
-
 	return xmlCwmpMessage(c, response)
 }
 
-func (s *Tr069Server) processInformEvent(c echo.Context, lastInform *cwmp.Inform) {
+// processInformEvent Run by the inform worker pool
+func (s *Tr069Server) processInformEvent(task app.InformTask) {
+	lastInform := task.Inform
 	defer func() {
 		if err := recover(); err != nil {
 			err2, ok := err.(error)
@@ -367,10 +447,16 @@ func (s *Tr069Server) processInformEvent(c echo.Context, lastInform *cwmp.Inform
 		}
 	}()
 	cpe := app.GApp().CwmpTable().GetCwmpCpe(lastInform.Sn)
-	cpe.CheckRegister(c.RealIP(), lastInform)
+	registered := cpe.IsRegister
+	cpe.CheckRegister(task.Ip, lastInform)
 	cpe.UpdateStatus(lastInform)
 	// 通知系统更新数据
-	cpe.NotifyDataUpdate(false)
+	// bootstrap and first registration read the device record, the update is written now
+	first := lastInform.IsEvent(cwmp.EventBootStrap) || (!registered && cpe.IsRegister)
+	cpe.NotifyDataUpdate(first)
+	if first {
+		app.GApp().FlushCpeUpdate(cpe.Sn)
+	}
 
 	switch {
 	// 首次接入下发认证配置
@@ -387,6 +473,18 @@ func (s *Tr069Server) processInformEvent(c echo.Context, lastInform *cwmp.Inform
 		if err != nil {
 			log.Error2("PushWebCredentials error", zap.String("namespace", "tr069"), zap.Error(err))
 		}
+		err = cpe.PushStunConfig("stun-session-"+common.UUID(), 1000, false)
+		if err != nil {
+			log.Error2("PushStunConfig error", zap.String("namespace", "tr069"), zap.Error(err))
+		}
+		err = cpe.PushXmppConfig("xmpp-session-"+common.UUID(), 1000, false)
+		if err != nil {
+			log.Error2("PushXmppConfig error", zap.String("namespace", "tr069"), zap.Error(err))
+		}
+		err = cpe.ProvisionSubscriber(1000, false)
+		if err != nil {
+			log.Error2("ProvisionSubscriber error", zap.String("namespace", "tr069"), zap.Error(err))
+		}
 	case lastInform.IsEvent(cwmp.EventBoot) && lastInform.RetryCount == 0:
 		err := cpe.ActiveCwmpSchedEventTask()
 		if err != nil {
@@ -404,6 +502,14 @@ func (s *Tr069Server) processInformEvent(c echo.Context, lastInform *cwmp.Inform
 		if err != nil {
 			log.Error2("PushWebCredentials error", zap.String("namespace", "tr069"), zap.Error(err))
 		}
+		err = cpe.PushStunConfig("stun-session-"+common.UUID(), 1000, false)
+		if err != nil {
+			log.Error2("PushStunConfig error", zap.String("namespace", "tr069"), zap.Error(err))
+		}
+		err = cpe.PushXmppConfig("xmpp-session-"+common.UUID(), 1000, false)
+		if err != nil {
+			log.Error2("PushXmppConfig error", zap.String("namespace", "tr069"), zap.Error(err))
+		}
 	case lastInform.IsEvent(cwmp.EventPeriodic) && lastInform.RetryCount == 0:
 		err := cpe.CreateCwmpPresetEventTask(app.PeriodicEvent, "")
 		if err != nil {
@@ -434,33 +540,23 @@ func (s *Tr069Server) processInformEvent(c echo.Context, lastInform *cwmp.Inform
 		)
 	}
 
-	// Auto-fetch WiFi SSIDs and WAN info after every Inform (not included in Inform params)
-	// Detect data model from Inform params to avoid requesting non-existent paths
-	go func() {
-		var paramNames []string
-		// Check if device uses TR-098 (InternetGatewayDevice) or TR-181 (Device) data model
-		for paramName := range lastInform.Params {
-			if strings.HasPrefix(paramName, "InternetGatewayDevice.") {
-				paramNames = []string{
-					"InternetGatewayDevice.DeviceInfo.",
-					"InternetGatewayDevice.LANDevice.1.WLANConfiguration.",
-					"InternetGatewayDevice.LANDevice.1.Hosts.",
-					"InternetGatewayDevice.WANDevice.",
-				}
-				break
-			}
-			if strings.HasPrefix(paramName, "Device.") {
-				paramNames = []string{
-					"Device.WiFi.",
-					"Device.IP.",
-					"Device.PPP.",
-				}
-				break
-			}
-		}
-		if len(paramNames) == 0 {
-			return
+	if !lastInform.IsEvent(cwmp.EventBootStrap) {
+		if err := cpe.RetryProvisionSubscriber(1000, false); err != nil {
+			log.Error2("RetryProvisionSubscriber error", zap.String("namespace", "tr069"), zap.Error(err))
 		}
+	}
+
+	// Auto-fetch WiFi SSIDs and WAN info after every Inform (not included in Inform params)
+	// Paths are written against TR-181 and translated to the device data model
+	if cpe.GetDataModel() != "" {
+		paramNames := cpe.TranslatePaths([]string{
+			"Device.DeviceInfo.",
+			"Device.WiFi.",
+			"Device.Hosts.",
+			"Device.IP.",
+			"Device.PPP.",
+			"InternetGatewayDevice.WANDevice.",
+		})
 		session := "auto-fetch-" + common.UUID()
 		_ = cpe.SendCwmpEventData(models.CwmpEventData{
 			Session: session,
@@ -472,10 +568,16 @@ func (s *Tr069Server) processInformEvent(c echo.Context, lastInform *cwmp.Inform
 				ParameterNames: paramNames,
 			},
 		}, 3000, false)
-	}()
+	}
 }
 
 func xmlCwmpMessage(c echo.Context, response []byte) error {
+	if name := rpcName(response); name != "" {
+		app.PromCwmpRpcSent.WithLabelValues(name).Inc()
+		if server != nil {
+			server.sentRpc(server.GetLatestCookieSn(c), name)
+		}
+	}
 	c.Response().Header().Set(echo.HeaderContentType, echo.MIMEApplicationXMLCharsetUTF8)
 	c.Response().Header().Set("Connection", "keep-alive")
 	if app.GConfig().Tr069.Debug {
@@ -485,6 +587,28 @@ func xmlCwmpMessage(c echo.Context, response []byte) error {
 	return c.XMLBlob(200, response)
 }
 
+// rpcName Name of the first element of the SOAP body
+func rpcName(data []byte) string {
+	i := bytes.Index(data, []byte("Body>"))
+	if i < 0 {
+		return ""
+	}
+	data = data[i+5:]
+	if i = bytes.IndexByte(data, '<'); i < 0 {
+		return ""
+	}
+	data = data[i+1:]
+	end := bytes.IndexAny(data, " />\r\n\t")
+	if end < 0 {
+		return ""
+	}
+	name := string(data[:end])
+	if i = strings.IndexByte(name, ':'); i >= 0 {
+		name = name[i+1:]
+	}
+	return name
+}
+
 func noContentResp(c echo.Context) error {
 	c.Response().Header().Set("Connection", "keep-alive")
 	c.Response().Header().Set("Content-Length", "0")
@@ -0,0 +1,73 @@
+package tr069
+
+import (
+	"context"
+	"io"
+	"sync"
+	"sync/atomic"
+
+	"github.com/ca17/teamsacs/common/zaplog/log"
+)
+
+// listeners STUN, XMPP and RADIUS listeners, closed before the database is released
+var listeners = struct {
+	sync.Mutex
+	closers []io.Closer
+	closed  atomic.Bool
+	// held by the requests in progress, they may still write to the database
+	running sync.RWMutex
+}{}
+
+// addListener Register a listener closed by CloseListeners, false when the server is stopping
+func addListener(c io.Closer) bool {
+	listeners.Lock()
+	defer listeners.Unlock()
+	if listeners.closed.Load() {
+		return false
+	}
+	listeners.closers = append(listeners.closers, c)
+	return true
+}
+
+// listenersClosed The read error of a listener comes from CloseListeners
+func listenersClosed() bool {
+	return listeners.closed.Load()
+}
+
+// beginRequest Start processing a request, false once the listeners are closed
+func beginRequest() bool {
+	listeners.running.RLock()
+	if listeners.closed.Load() {
+		listeners.running.RUnlock()
+		return false
+	}
+	return true
+}
+
+func endRequest() {
+	listeners.running.RUnlock()
+}
+
+// CloseListeners Close the STUN, XMPP and RADIUS listeners and wait for the
+// requests in progress, or for the context to expire
+func CloseListeners(ctx context.Context) {
+	listeners.Lock()
+	listeners.closed.Store(true)
+	closers := listeners.closers
+	listeners.closers = nil
+	listeners.Unlock()
+	for _, c := range closers {
+		_ = c.Close()
+	}
+	done := make(chan struct{})
+	go func() {
+		listeners.running.Lock()
+		listeners.running.Unlock()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-ctx.Done():
+		log.Error("Listeners shutdown timeout, requests in progress not finished")
+	}
+}
@@ -0,0 +1,82 @@
+package tr069
+
+import (
+	"fmt"
+	"net"
+
+	"github.com/ca17/teamsacs/app"
+	"github.com/ca17/teamsacs/common/radius"
+	"github.com/ca17/teamsacs/common/zaplog/log"
+)
+
+// ListenRadiusAcct Start the RADIUS accounting server, the BRAS reports the
+// PPPoE sessions so the device page shows whether the CPE WAN is authenticated
+func ListenRadiusAcct() error {
+	cfg := app.GConfig().Radius
+	if cfg.AcctPort == 0 {
+		return nil
+	}
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.ParseIP(cfg.Host), Port: cfg.AcctPort})
+	if err != nil {
+		log.Errorf("Error starting RADIUS accounting server %s", err.Error())
+		return err
+	}
+	defer conn.Close()
+	if !addListener(conn) {
+		return nil
+	}
+	log.Infof("Start RADIUS accounting server %s:%d", cfg.Host, cfg.AcctPort)
+
+	buf := make([]byte, 4096)
+	for {
+		n, addr, err := conn.ReadFromUDP(buf)
+		if err != nil {
+			if ne, ok := err.(net.Error); ok && ne.Timeout() {
+				continue
+			}
+			if listenersClosed() {
+				log.Info("RADIUS accounting server stopped")
+				return nil
+			}
+			return fmt.Errorf("RADIUS accounting server read error %w", err)
+		}
+		data := make([]byte, n)
+		copy(data, buf[:n])
+		if !beginRequest() {
+			return nil
+		}
+		go func() {
+			defer endRequest()
+			resp := handleRadiusAcct(data, addr)
+			if resp != nil {
+				if _, err := conn.WriteToUDP(resp, addr); err != nil {
+					log.Errorf("RADIUS accounting server write to %s error %s", addr, err.Error())
+				}
+			}
+		}()
+	}
+}
+
+// handleRadiusAcct The response is only sent once the request is recorded,
+// the NAS retransmits the request otherwise
+func handleRadiusAcct(data []byte, addr *net.UDPAddr) []byte {
+	p, err := radius.Parse(data)
+	if err != nil || p.Code != radius.CodeAccountingRequest {
+		return nil
+	}
+	nasip := addr.IP.String()
+	secret, ok := app.GApp().RadiusNasSecret(nasip)
+	if !ok {
+		log.Warnf("RADIUS accounting from unknown NAS %s", nasip)
+		return nil
+	}
+	if err = p.VerifyRequest(secret); err != nil {
+		log.Warnf("RADIUS accounting from %s: %s", nasip, err.Error())
+		return nil
+	}
+	if err = app.GApp().UpdateRadiusAccounting(nasip, p); err != nil {
+		log.Errorf("RADIUS accounting from %s: %s", nasip, err.Error())
+		return nil
+	}
+	return radius.NewAccountingResponse(p, secret).Encode()
+}
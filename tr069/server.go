@@ -9,6 +9,7 @@ import (
 	"path"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/ca17/teamsacs/app"
@@ -29,13 +30,19 @@ const Tr069Session = "tr069_session"
 const Tr069CookieName = "tr069_cookie"
 
 type Tr069Server struct {
-	root     *echo.Echo
-	sesslock sync.Mutex
+	root       *echo.Echo
+	sesslock   sync.Mutex
+	tlsServer  *http.Server
+	draining   atomic.Bool
+	activeLock sync.Mutex
+	active     map[string]*cwmpSession // CWMP sessions in progress by sn
 }
 
 func Listen() error {
 	server = NewTr069Server()
 	server.initRouter()
+	app.GApp().StartInformPool(server.processInformEvent)
+	go server.expireSessions()
 	return server.Start()
 }
 
@@ -43,6 +50,7 @@ func NewTr069Server() *Tr069Server {
 	s := new(Tr069Server)
 	s.root = echo.New()
 	s.sesslock = sync.Mutex{}
+	s.active = make(map[string]*cwmpSession)
 	s.root.Pre(middleware.RemoveTrailingSlash())
 	s.root.Use(func(next echo.HandlerFunc) echo.HandlerFunc {
 		return func(c echo.Context) error {
@@ -71,7 +79,8 @@ func NewTr069Server() *Tr069Server {
 		Skipper: func(c echo.Context) bool {
 			rpath := c.Request().RequestURI
 			if strings.HasPrefix(rpath, "/cwmpfiles") ||
-				strings.HasPrefix(rpath, "/cwmpupload") {
+				strings.HasPrefix(rpath, "/cwmpupload") ||
+				strings.HasPrefix(rpath, "/api/") {
 				return true
 			} else {
 				return false
@@ -122,6 +131,7 @@ func (s *Tr069Server) startTlsServer() error {
 			ClientAuth: tls.VerifyClientCertIfGiven,
 		},
 	}
+	s.tlsServer = ss
 	return ss.ListenAndServeTLS(serverCert, serverKey)
 }
 
@@ -133,6 +143,9 @@ func (s *Tr069Server) Start() (err error) {
 	} else {
 		err = s.root.Start(fmt.Sprintf("%s:%d", app.GConfig().Tr069.Host, app.GConfig().Tr069.Port))
 	}
+	if err == http.ErrServerClosed {
+		return nil
+	}
 	if err != nil {
 		log.Errorf("Error starting Tr069 API server %s", err.Error())
 	}
@@ -147,10 +160,26 @@ func (s *Tr069Server) GetLatestCookieSn(c echo.Context) string {
 	return cookie.Value
 }
 
-func (s *Tr069Server) SetLatestInformByCookie(c echo.Context, sn string) {
+// SetLatestInformByCookie The CPE sends back the SN and the session of its Inform,
+// the next requests of the session are served from the saved Inform by any instance
+func (s *Tr069Server) SetLatestInformByCookie(c echo.Context, sn, session string) {
 	cookie := new(http.Cookie)
 	cookie.Name = Tr069CookieName
 	cookie.Value = sn
 	cookie.Expires = time.Now().Add(24 * time.Hour)
 	c.SetCookie(cookie)
+	scookie := new(http.Cookie)
+	scookie.Name = Tr069Session
+	scookie.Value = session
+	scookie.Expires = time.Now().Add(24 * time.Hour)
+	c.SetCookie(scookie)
+}
+
+// GetSessionCookie Session of the Inform, empty for CPEs that only send back the first cookie
+func (s *Tr069Server) GetSessionCookie(c echo.Context) string {
+	cookie, err := c.Cookie(Tr069Session)
+	if err != nil {
+		return ""
+	}
+	return cookie.Value
 }
@@ -0,0 +1,157 @@
+package tr069
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/ca17/teamsacs/app"
+	"github.com/ca17/teamsacs/common/zaplog/log"
+	"github.com/labstack/echo/v4"
+)
+
+// sessionIdleTimeout A CWMP session without request for this time is considered over
+const sessionIdleTimeout = 30 * time.Second
+
+// cwmpSession CWMP session in progress on this instance, only used for the session
+// metrics: the state needed by the requests is in the database (see CwmpCpe.StartSession)
+type cwmpSession struct {
+	start   time.Time
+	last    time.Time // last request
+	lastRpc string    // last RPC sent to the CPE
+}
+
+// beginSession A CPE opened a CWMP session with an Inform
+func (s *Tr069Server) beginSession(sn string) {
+	if sn == "" {
+		return
+	}
+	s.activeLock.Lock()
+	defer s.activeLock.Unlock()
+	if _, ok := s.active[sn]; ok {
+		// the previous session was not closed by the ACS
+		s.endSession(sn)
+	}
+	s.active[sn] = &cwmpSession{start: time.Now(), last: time.Now()}
+	app.PromCwmpSessionsActive.Inc()
+}
+
+// touchSession A request of a CWMP session in progress
+func (s *Tr069Server) touchSession(sn string) {
+	s.activeLock.Lock()
+	defer s.activeLock.Unlock()
+	s.touchSessionLocked(sn)
+}
+
+// sentRpc Remember the RPC sent in the session, a Fault answer refers to it
+func (s *Tr069Server) sentRpc(sn, name string) {
+	s.activeLock.Lock()
+	defer s.activeLock.Unlock()
+	if sess, ok := s.active[sn]; ok {
+		sess.lastRpc = name
+	}
+}
+
+// lastRpc The RPC the CPE answers to
+func (s *Tr069Server) lastRpc(sn string) string {
+	s.activeLock.Lock()
+	defer s.activeLock.Unlock()
+	if sess, ok := s.active[sn]; ok {
+		return sess.lastRpc
+	}
+	return ""
+}
+
+// endSession must be called with activeLock held
+func (s *Tr069Server) endSession(sn string) {
+	sess, ok := s.active[sn]
+	if !ok {
+		return
+	}
+	delete(s.active, sn)
+	app.PromCwmpSessionsActive.Dec()
+	app.PromCwmpSessionDuration.Observe(sess.last.Sub(sess.start).Seconds())
+}
+
+// finishSession The ACS has no more requests, the empty response ends the CWMP session
+func (s *Tr069Server) finishSession(c echo.Context) error {
+	sn := s.GetLatestCookieSn(c)
+	s.activeLock.Lock()
+	s.touchSessionLocked(sn)
+	s.endSession(sn)
+	s.activeLock.Unlock()
+	return noContentResp(c)
+}
+
+func (s *Tr069Server) touchSessionLocked(sn string) {
+	if sess, ok := s.active[sn]; ok {
+		sess.last = time.Now()
+	}
+}
+
+// expireSessions End the sessions the CPEs left without the final empty response
+func (s *Tr069Server) expireSessions() {
+	ticker := time.NewTicker(sessionIdleTimeout / 3)
+	defer ticker.Stop()
+	for range ticker.C {
+		s.activeSessions()
+	}
+}
+
+func (s *Tr069Server) activeSessions() int {
+	s.activeLock.Lock()
+	defer s.activeLock.Unlock()
+	for sn, sess := range s.active {
+		if time.Since(sess.last) > sessionIdleTimeout {
+			s.endSession(sn)
+		}
+	}
+	return len(s.active)
+}
+
+// rejectInform Informs are answered with 503 while the server shuts down or
+// is overloaded, the CPE retries later, possibly on another ACS instance
+func (s *Tr069Server) rejectInform(c echo.Context) error {
+	c.Response().Header().Set("Retry-After", strconv.Itoa(app.GApp().InformRetryAfter()))
+	return c.NoContent(http.StatusServiceUnavailable)
+}
+
+// Shutdown Stop accepting Informs and wait for the CWMP sessions in progress,
+// the server is closed when they are over or when the context expires
+func Shutdown(ctx context.Context) error {
+	if server == nil {
+		return nil
+	}
+	return server.Shutdown(ctx)
+}
+
+func (s *Tr069Server) Shutdown(ctx context.Context) error {
+	s.draining.Store(true)
+	ticker := time.NewTicker(time.Millisecond * 200)
+	defer ticker.Stop()
+wait:
+	for n := s.activeSessions(); n > 0; n = s.activeSessions() {
+		select {
+		case <-ctx.Done():
+			log.Errorf("Tr069 server shutdown timeout, %d CWMP sessions not finished", n)
+			break wait
+		case <-ticker.C:
+		}
+	}
+	var err error
+	if s.tlsServer != nil {
+		err = s.tlsServer.Shutdown(ctx)
+	} else {
+		err = s.root.Shutdown(ctx)
+	}
+	if err != nil {
+		if s.tlsServer != nil {
+			_ = s.tlsServer.Close()
+		} else {
+			_ = s.root.Close()
+		}
+	}
+	log.Info("Tr069 server stopped")
+	return err
+}
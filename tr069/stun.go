@@ -0,0 +1,71 @@
+package tr069
+
+import (
+	"fmt"
+	"net"
+
+	"github.com/ca17/teamsacs/app"
+	"github.com/ca17/teamsacs/common/stun"
+	"github.com/ca17/teamsacs/common/zaplog/log"
+)
+
+// ListenStun Start the TR-111 STUN server, CPEs behind NAT keep their UDP
+// binding open with Binding Requests and the ACS learns the public address
+func ListenStun() error {
+	port := app.GConfig().Tr069.StunPort
+	if port == 0 || app.GApp().GetTr069SettingsStringValue(app.ConfigCpeStunEnable) != "enabled" {
+		return nil
+	}
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.ParseIP(app.GConfig().Tr069.Host), Port: port})
+	if err != nil {
+		log.Errorf("Error starting STUN server %s", err.Error())
+		return err
+	}
+	defer conn.Close()
+	if !addListener(conn) {
+		return nil
+	}
+	log.Infof("Start STUN server %s:%d", app.GConfig().Tr069.Host, port)
+
+	buf := make([]byte, 1500)
+	for {
+		n, addr, err := conn.ReadFromUDP(buf)
+		if err != nil {
+			if ne, ok := err.(net.Error); ok && ne.Timeout() {
+				continue
+			}
+			if listenersClosed() {
+				log.Info("STUN server stopped")
+				return nil
+			}
+			return fmt.Errorf("STUN server read error %w", err)
+		}
+		if !beginRequest() {
+			return nil
+		}
+		resp := handleStunMessage(buf[:n], addr)
+		endRequest()
+		if resp != nil {
+			if _, err = conn.WriteToUDP(resp, addr); err != nil {
+				log.Errorf("STUN server write to %s error %s", addr, err.Error())
+			}
+		}
+	}
+}
+
+func handleStunMessage(data []byte, addr *net.UDPAddr) []byte {
+	msg, err := stun.Parse(data)
+	if err != nil || msg.Type != stun.BindingRequest {
+		return nil
+	}
+	if msg.IsConnectionRequestBinding() {
+		// the binding is only trusted when signed with the STUN password of the device
+		sn := msg.Username()
+		if !app.GApp().CheckStunIntegrity(sn, data) {
+			log.Debugf("STUN binding request of %s from %s dropped, invalid MESSAGE-INTEGRITY", sn, addr)
+			return nil
+		}
+		app.GApp().UpdateStunBinding(sn, addr, msg.Has(stun.AttrBindingChange))
+	}
+	return stun.NewBindingResponse(msg, addr).Encode()
+}
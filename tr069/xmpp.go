@@ -0,0 +1,72 @@
+package tr069
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+	"path"
+
+	"github.com/ca17/teamsacs/app"
+	"github.com/ca17/teamsacs/assets"
+	"github.com/ca17/teamsacs/common"
+	"github.com/ca17/teamsacs/common/xmpp"
+	"github.com/ca17/teamsacs/common/zaplog/log"
+)
+
+// xmppCertificate The cwmp TLS certificate, the builtin one when none is installed
+func xmppCertificate() (tls.Certificate, error) {
+	serverCert := path.Join(app.GConfig().System.Workdir, "private/cwmp.tls.crt")
+	serverKey := path.Join(app.GConfig().System.Workdir, "private/cwmp.tls.key")
+	if common.FileExists(serverCert) && common.FileExists(serverKey) {
+		return tls.LoadX509KeyPair(serverCert, serverKey)
+	}
+	return tls.X509KeyPair(assets.CwmpCert, assets.CwmpKey)
+}
+
+// ListenXmpp Start the embedded XMPP server for TR-069 Annex K connection requests
+func ListenXmpp() error {
+	port := app.GConfig().Tr069.XmppPort
+	if port == 0 {
+		return nil
+	}
+	domain := app.GApp().XmppDomain()
+	if domain == "" {
+		log.Errorf("XMPP server not started, the TR069 access address is not set")
+		return nil
+	}
+	cert, err := xmppCertificate()
+	if err != nil {
+		log.Errorf("Error loading XMPP server certificate %s", err.Error())
+		return err
+	}
+	address := fmt.Sprintf("%s:%d", app.GConfig().Tr069.Host, port)
+	l, err := net.Listen("tcp", address)
+	if err != nil {
+		log.Errorf("Error starting XMPP server %s", err.Error())
+		return err
+	}
+	defer l.Close()
+	s := &xmpp.Server{
+		Domain:    domain,
+		TLSConfig: &tls.Config{Certificates: []tls.Certificate{cert}},
+		Auth:      app.GApp().XmppAuthenticate,
+		OnOnline:  app.GApp().OnXmppOnline,
+		OnOffline: func(jid string) {
+			// the connections closed on shutdown are not recorded
+			if beginRequest() {
+				defer endRequest()
+				app.GApp().OnXmppOffline(jid)
+			}
+		},
+	}
+	if !addListener(s) {
+		return nil
+	}
+	app.GApp().SetXmppServer(s)
+	log.Infof("Start XMPP server %s domain %s", address, domain)
+	err = s.Serve(l)
+	if err == nil {
+		log.Info("XMPP server stopped")
+	}
+	return err
+}
@@ -1,6 +1,8 @@
 package webserver
 
 import (
+	"context"
+	"crypto/subtle"
 	_ "embed"
 	"fmt"
 	"net/http"
@@ -185,18 +187,35 @@ func (s *AdminServer) Start() error {
 		log.Infof("Prepare to start the TLS management port %s:%d", appconfig.Web.Host, appconfig.Web.TlsPort)
 		err := s.root.StartTLS(fmt.Sprintf("%s:%d", appconfig.Web.Host, appconfig.Web.TlsPort),
 			path.Join(appconfig.GetPrivateDir(), "teamsacs.tls.crt"), path.Join(appconfig.GetPrivateDir(), "teamsacs.tls.key"))
-		if err != nil {
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
 			log.Errorf("Error starting TLS management port %s", err.Error())
 		}
 	}()
 	log.Infof("Start the management server %s:%d", appconfig.Web.Host, appconfig.Web.Port)
 	err := s.root.Start(fmt.Sprintf("%s:%d", appconfig.Web.Host, appconfig.Web.Port))
+	if errors.Is(err, http.ErrServerClosed) {
+		return nil
+	}
 	if err != nil {
 		log.Errorf("Error starting management server %s", err.Error())
 	}
 	return err
 }
 
+// Shutdown Stop the management server, the requests in progress are completed
+// until the context expires
+func Shutdown(ctx context.Context) error {
+	if server == nil {
+		return nil
+	}
+	err := server.root.Shutdown(ctx)
+	if err != nil {
+		_ = server.root.Close()
+	}
+	log.Info("Management server stopped")
+	return err
+}
+
 // ParseJwtToken 解析 Jwt Token
 func (s *AdminServer) ParseJwtToken(tokenstr string) (jwt.MapClaims, error) {
 	config := s.jwtConfig
@@ -286,9 +305,17 @@ func sessionCheck() echo.MiddlewareFunc {
 					return next(c)
 				}
 			}
+			// the scrapers authenticate with the metrics token, the operators with the session
+			metrics := c.Request().URL.Path == "/metrics" || strings.HasPrefix(c.Request().URL.Path, "/metrics/")
+			if metrics && checkMetricsToken(c) {
+				return next(c)
+			}
 			sess, _ := session.Get(UserSession, c)
 			username := sess.Values[UserSessionName]
 			if username == nil || username == "" {
+				if metrics {
+					return c.NoContent(http.StatusUnauthorized)
+				}
 				return c.Redirect(http.StatusTemporaryRedirect, "/reactui/login")
 			}
 			return next(c)
@@ -296,6 +323,20 @@ func sessionCheck() echo.MiddlewareFunc {
 	}
 }
 
+// checkMetricsToken Bearer token of the metrics scrapers, no token is accepted
+// when the MetricsToken setting is empty
+func checkMetricsToken(c echo.Context) bool {
+	token := app.GApp().GetSystemSettingsStringValue(app.ConfigSystemMetricsToken)
+	if token == "" {
+		return false
+	}
+	reqToken := strings.TrimPrefix(c.Request().Header.Get("Authorization"), "Bearer ")
+	if reqToken == "" {
+		reqToken = c.QueryParam("token")
+	}
+	return subtle.ConstantTimeCompare([]byte(token), []byte(reqToken)) == 1
+}
+
 func GetCurrUser(c echo.Context) *models.SysOpr {
 	sess, _ := session.Get(UserSession, c)
 	username := sess.Values[UserSessionName]